@@ -0,0 +1,103 @@
+package acme
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	accountFileName    = "account.json"
+	accountKeyFileName = "account.key"
+)
+
+// Account is a User which has been persisted to disk by SaveAccount and
+// loaded back by LoadAccount.
+type Account struct {
+	Email        string                `json:"email"`
+	Registration *RegistrationResource `json:"registration"`
+	key          crypto.PrivateKey
+}
+
+// GetEmail returns the email address of the account.
+func (a *Account) GetEmail() string {
+	return a.Email
+}
+
+// GetRegistration returns the registration of the account.
+func (a *Account) GetRegistration() *RegistrationResource {
+	return a.Registration
+}
+
+// GetPrivateKey returns the private key of the account.
+func (a *Account) GetPrivateKey() crypto.PrivateKey {
+	return a.key
+}
+
+// SaveAccount writes the registration and the private key of user to dir so
+// that it can be loaded with LoadAccount later on instead of registering a new
+// account. The registration is stored as JSON, the private key PEM encoded.
+// dir is created if it does not exist yet.
+func SaveAccount(dir string, user User) error {
+	if user == nil {
+		return errors.New("acme: cannot save a nil user")
+	}
+
+	keyPem, err := SavePrivateKeyPEM(user.GetPrivateKey())
+	if err != nil {
+		return err
+	}
+
+	jsonBytes, err := json.MarshalIndent(Account{Email: user.GetEmail(), Registration: user.GetRegistration()}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("acme: could not create account directory %s: %v", dir, err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, accountKeyFileName), keyPem, 0600); err != nil {
+		return fmt.Errorf("acme: could not save account key: %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, accountFileName), jsonBytes, 0600); err != nil {
+		return fmt.Errorf("acme: could not save account: %v", err)
+	}
+
+	return nil
+}
+
+// LoadAccount loads an account previously saved to dir with SaveAccount.
+func LoadAccount(dir string) (*Account, error) {
+	keyBytes, err := ioutil.ReadFile(filepath.Join(dir, accountKeyFileName))
+	if err != nil {
+		return nil, fmt.Errorf("acme: could not read account key: %v", err)
+	}
+
+	key, err := LoadPrivateKeyPEM(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("acme: could not parse account key: %v", err)
+	}
+
+	jsonBytes, err := ioutil.ReadFile(filepath.Join(dir, accountFileName))
+	if err != nil {
+		return nil, fmt.Errorf("acme: could not read account: %v", err)
+	}
+
+	var acc Account
+	if err := json.Unmarshal(jsonBytes, &acc); err != nil {
+		return nil, fmt.Errorf("acme: could not parse account: %v", err)
+	}
+
+	if acc.Registration == nil {
+		return nil, errors.New("acme: saved account has no registration")
+	}
+
+	acc.key = key
+	return &acc, nil
+}
@@ -0,0 +1,95 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type testUser struct {
+	email string
+	reg   *RegistrationResource
+	key   crypto.PrivateKey
+}
+
+func (u testUser) GetEmail() string                       { return u.email }
+func (u testUser) GetRegistration() *RegistrationResource { return u.reg }
+func (u testUser) GetPrivateKey() crypto.PrivateKey       { return u.key }
+
+func TestSaveLoadAccount(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+
+	for _, key := range []crypto.Signer{ecKey, rsaKey} {
+		reg := &RegistrationResource{
+			Body: Registration{
+				ID:        42,
+				Key:       *keyAsJWK(key.Public()),
+				Contact:   []string{"mailto:test@test.com"},
+				Agreement: "https://example.com/terms",
+			},
+			URI:         "https://example.com/acme/reg/42",
+			NewAuthzURL: "https://example.com/acme/new-authz",
+			TosURL:      "https://example.com/terms",
+		}
+
+		dir, err := ioutil.TempDir("", "lego-account")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		accDir := filepath.Join(dir, "account")
+		user := testUser{email: "test@test.com", reg: reg, key: key}
+		if err := SaveAccount(accDir, user); err != nil {
+			t.Fatalf("Could not save account: %v", err)
+		}
+
+		acc, err := LoadAccount(accDir)
+		if err != nil {
+			t.Fatalf("Could not load account: %v", err)
+		}
+
+		if acc.GetEmail() != user.email {
+			t.Errorf("Expected email %s but was %s", user.email, acc.GetEmail())
+		}
+		loaded := acc.GetRegistration()
+		if loaded.URI != reg.URI || loaded.NewAuthzURL != reg.NewAuthzURL || loaded.TosURL != reg.TosURL {
+			t.Errorf("Expected registration %+v but was %+v", reg, loaded)
+		}
+		if loaded.Body.ID != reg.Body.ID || loaded.Body.Agreement != reg.Body.Agreement || !reflect.DeepEqual(loaded.Body.Contact, reg.Body.Contact) {
+			t.Errorf("Expected registration body %+v but was %+v", reg.Body, loaded.Body)
+		}
+		if !reflect.DeepEqual(loaded.Body.Key.Key, key.Public()) {
+			t.Errorf("Expected the registration key to match the saved one")
+		}
+		if !reflect.DeepEqual(acc.GetPrivateKey(), key) {
+			t.Errorf("Expected the loaded %T key to match the saved one", key)
+		}
+	}
+}
+
+func TestLoadAccountMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lego-account")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := LoadAccount(dir); err == nil {
+		t.Error("Expected an error loading an account from an empty directory but got none")
+	}
+}
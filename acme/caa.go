@@ -0,0 +1,112 @@
+package acme
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// checkCAA makes sure the CAA records of domain permit one of identities to
+// issue a certificate for it. It looks up the relevant record set by
+// climbing the DNS tree from domain, as described in RFC 6844. A domain
+// without any CAA records up to the root may be issued for by any CA.
+func checkCAA(domain string, identities []string, nameservers []string) error {
+	wildcard := strings.HasPrefix(domain, "*.")
+	fqdn := ToFqdn(strings.TrimPrefix(domain, "*."))
+
+	for _, index := range dns.Split(fqdn) {
+		name := fqdn[index:]
+
+		in, err := dnsQuery(name, dns.TypeCAA, nameservers, true)
+		if err != nil {
+			return fmt.Errorf("Could not query CAA records for %s: %v", name, err)
+		}
+		if in.Rcode != dns.RcodeNameError && in.Rcode != dns.RcodeSuccess {
+			return fmt.Errorf("Unexpected response code '%s' querying CAA records for %s",
+				dns.RcodeToString[in.Rcode], name)
+		}
+
+		// The resolver follows CNAMEs, so any CAA record in the answer is
+		// part of the relevant record set.
+		var records []*dns.CAA
+		for _, ans := range in.Answer {
+			if caa, ok := ans.(*dns.CAA); ok {
+				records = append(records, caa)
+			}
+		}
+		if len(records) > 0 {
+			return checkCAARecords(name, records, identities, wildcard)
+		}
+	}
+
+	return nil
+}
+
+// checkCAARecords checks whether the relevant CAA record set of a domain,
+// found at name, permits one of identities to issue.
+func checkCAARecords(name string, records []*dns.CAA, identities []string, wildcard bool) error {
+	var issue, issueWild []string
+	for _, caa := range records {
+		switch strings.ToLower(caa.Tag) {
+		case "issue":
+			issue = append(issue, caa.Value)
+		case "issuewild":
+			issueWild = append(issueWild, caa.Value)
+		case "iodef":
+		default:
+			// Unknown properties flagged as critical must prevent issuance.
+			if caa.Flag&128 != 0 {
+				return fmt.Errorf("The CAA records of %s contain the unknown critical property %q", UnFqdn(name), caa.Tag)
+			}
+		}
+	}
+
+	values := issue
+	if wildcard && len(issueWild) > 0 {
+		values = issueWild
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	for _, value := range values {
+		issuer := strings.TrimSpace(strings.SplitN(value, ";", 2)[0])
+		for _, identity := range identities {
+			if strings.EqualFold(issuer, identity) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("The CAA records of %s do not permit %s to issue certificates",
+		UnFqdn(name), strings.Join(identities, ", "))
+}
+
+// checkCAA runs the pre-flight CAA check for all domains, if it is enabled,
+// and returns the ones which the CA may not issue for.
+func (c *Client) checkCAA(domains []string) map[string]error {
+	failures := make(map[string]error)
+	if !c.caaCheck {
+		return failures
+	}
+
+	identities := c.directory.Meta.CAAIdentities
+	if len(identities) == 0 {
+		c.logf("[WARNING] acme: The CA does not advertise its CAA identities; skipping the CAA check")
+		return failures
+	}
+
+	for _, domain := range domains {
+		// CAA records only apply to domain names.
+		if net.ParseIP(domain) != nil {
+			continue
+		}
+		if err := checkCAA(domain, identities, RecursiveNameservers); err != nil {
+			c.logf("[ERROR][%s] acme: CAA check failed: %v", domain, err)
+			failures[domain] = fmt.Errorf("[%s] acme: %v", domain, err)
+		}
+	}
+	return failures
+}
@@ -0,0 +1,153 @@
+package acme
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// startCAAServer starts a fake resolver answering CAA queries from records,
+// which maps owner names to CAA records in presentation format.
+func startCAAServer(t *testing.T, records map[string][]string) (addr string, shutdown func()) {
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Qtype == dns.TypeCAA {
+			for _, record := range records[strings.ToLower(r.Question[0].Name)] {
+				rr, err := dns.NewRR(record)
+				if err != nil {
+					t.Errorf("Invalid CAA record %q: %v", record, err)
+					continue
+				}
+				m.Answer = append(m.Answer, rr)
+			}
+		}
+		w.WriteMsg(m)
+	})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: handler}
+	go server.ActivateAndServe()
+	return pc.LocalAddr().String(), func() { server.Shutdown() }
+}
+
+var caaRecords = map[string][]string{
+	"example.com.": {
+		`example.com. 3600 IN CAA 0 issue "ca.example.net"`,
+		`example.com. 3600 IN CAA 0 iodef "mailto:security@example.com"`,
+	},
+	"allowed.example.com.": {
+		`allowed.example.com. 3600 IN CAA 0 issue "letsencrypt.org; validationmethods=http-01"`,
+	},
+	"wild.example.com.": {
+		`wild.example.com. 3600 IN CAA 0 issue "letsencrypt.org"`,
+		`wild.example.com. 3600 IN CAA 0 issuewild ";"`,
+	},
+	"iodef.example.org.": {
+		`iodef.example.org. 3600 IN CAA 0 iodef "mailto:security@example.org"`,
+	},
+	"critical.example.org.": {
+		`critical.example.org. 3600 IN CAA 128 tbs "unknown"`,
+		`critical.example.org. 3600 IN CAA 0 issue "letsencrypt.org"`,
+	},
+}
+
+func TestCheckCAA(t *testing.T) {
+	addr, shutdown := startCAAServer(t, caaRecords)
+	defer shutdown()
+
+	tests := []struct {
+		domain  string
+		allowed bool
+	}{
+		{"example.com", false},
+		{"www.example.com", false},
+		{"allowed.example.com", true},
+		{"www.allowed.example.com", true},
+		{"wild.example.com", true},
+		{"*.wild.example.com", false},
+		{"*.allowed.example.com", true},
+		{"example.org", true},
+		{"iodef.example.org", true},
+		{"critical.example.org", false},
+	}
+	for _, tt := range tests {
+		err := checkCAA(tt.domain, []string{"letsencrypt.org"}, []string{addr})
+		if tt.allowed && err != nil {
+			t.Errorf("%s: expected issuance to be permitted but got %v", tt.domain, err)
+		}
+		if !tt.allowed && err == nil {
+			t.Errorf("%s: expected issuance not to be permitted", tt.domain)
+		}
+	}
+}
+
+func TestObtainCertificateCAACheck(t *testing.T) {
+	addr, shutdown := startCAAServer(t, caaRecords)
+	defer shutdown()
+	defer func(nameservers []string) { RecursiveNameservers = nameservers }(RecursiveNameservers)
+	RecursiveNameservers = []string{addr}
+
+	ca := newMockCA(t)
+	ca.caaIdentities = []string{"letsencrypt.org"}
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	client.SetCAACheck(true)
+
+	_, failures := client.ObtainCertificate([]string{"allowed.example.com", "www.example.com"}, false, nil, false)
+	if len(failures) != 1 || failures["www.example.com"] == nil {
+		t.Fatalf("Expected the CAA check to fail for www.example.com only but got %v", failures)
+	}
+	if !strings.Contains(failures["www.example.com"].Error(), "do not permit letsencrypt.org") {
+		t.Errorf("Expected a clear CAA error but got %v", failures["www.example.com"])
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if len(ca.identifiers) != 0 {
+		t.Errorf("Expected no authorizations to be requested but got %v", ca.identifiers)
+	}
+}
+
+func TestHandleHTTPErrorCAA(t *testing.T) {
+	for _, problemType := range []string{caaErrorV1, caaErrorV2} {
+		resp := &http.Response{
+			StatusCode: http.StatusForbidden,
+			Header:     http.Header{"Content-Type": []string{"application/problem+json"}},
+			Body:       ioutil.NopCloser(strings.NewReader(`{"type":"` + problemType + `","detail":"CAA record for example.com prevents issuance"}`)),
+		}
+
+		err := handleHTTPError(resp)
+		caaErr, ok := err.(CAAError)
+		if !ok {
+			t.Errorf("%s: expected a CAAError but got %T: %v", problemType, err, err)
+			continue
+		}
+		if caaErr.StatusCode != http.StatusForbidden || caaErr.Detail != "CAA record for example.com prevents issuance" {
+			t.Errorf("%s: unexpected error details %+v", problemType, caaErr.RemoteError)
+		}
+	}
+}
@@ -9,6 +9,8 @@ const (
 	HTTP01 = Challenge("http-01")
 	// TLSSNI01 is the "tls-sni-01" ACME challenge https://github.com/ietf-wg-acme/acme/blob/master/draft-ietf-acme-acme.md#tls-with-server-name-indication-tls-sni
 	// Note: TLSSNI01ChallengeCert returns a certificate to fulfill this challenge
+	// Note: tls-sni-01 is deprecated and no longer offered by Let's Encrypt, the
+	// client falls back to the other challenges if the server does not offer it
 	TLSSNI01 = Challenge("tls-sni-01")
 	// DNS01 is the "dns-01" ACME challenge https://github.com/ietf-wg-acme/acme/blob/master/draft-ietf-acme-acme.md#dns
 	// Note: DNS01Record returns a DNS record which will fulfill this challenge
@@ -4,6 +4,7 @@ package acme
 import (
 	"crypto"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -12,15 +13,33 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 var (
-	// Logger is an optional custom logger.
+	// Logger is an optional custom logger. Clients without a logger of
+	// their own (see SetLogger) write their log entries to it.
 	Logger *log.Logger
+
+	// DirectoryTimeout bounds how long NewClient keeps retrying to fetch
+	// the directory of the CA while the CA is unreachable or fails
+	// transiently. Zero disables the retries.
+	DirectoryTimeout = 30 * time.Second
+
+	// directoryRetryDelay is the delay before the first retry of the
+	// directory fetch. It doubles with every further retry, up to
+	// maxDirectoryRetryDelay.
+	directoryRetryDelay    = time.Second
+	maxDirectoryRetryDelay = 10 * time.Second
+
+	// maxCertPollDelay bounds the Retry-After delay between two polls for
+	// a certificate, unless the poll interval is longer.
+	maxCertPollDelay = time.Minute
 )
 
 const (
@@ -31,8 +50,20 @@ const (
 	// “new-reg”, “new-authz” and “new-cert” endpoints. From the documentation the
 	// limitation is 20 requests per second, but using 20 as value doesn't work but 18 do
 	overallRequestLimit = 18
+
+	// defaultCertPollInterval is how often a certificate which is not issued
+	// yet is polled if the CA does not send a Retry-After header.
+	defaultCertPollInterval = time.Second
+
+	// MaxNamesPerCertificate is the maximum number of names Let's Encrypt
+	// accepts in a single certificate.
+	MaxNamesPerCertificate = 100
 )
 
+// errNoDomains is the error, keyed by the empty domain, for a request for
+// a certificate without any domain.
+var errNoDomains = errors.New("acme: no domains to obtain a certificate for")
+
 // logf writes a log entry. It uses Logger if not
 // nil, otherwise it uses the default log.Logger.
 func logf(format string, args ...interface{}) {
@@ -43,6 +74,28 @@ func logf(format string, args ...interface{}) {
 	}
 }
 
+// StdLogger is implemented by loggers which can receive the log entries of
+// a Client. *log.Logger satisfies it.
+type StdLogger interface {
+	Printf(format string, args ...interface{})
+}
+
+// logFunc writes a log entry. A nil logFunc discards it.
+type logFunc func(format string, args ...interface{})
+
+// loggingProvider is implemented by the challenge servers of this package.
+// A client hands them its logf, so the requests they serve are logged like
+// the rest of its log entries.
+type loggingProvider interface {
+	setLogger(logf logFunc)
+}
+
+func (f logFunc) printf(format string, args ...interface{}) {
+	if f != nil {
+		f(format, args...)
+	}
+}
+
 // User interface is to be implemented by users of this library.
 // It is used by the client type to get user specific information.
 type User interface {
@@ -53,32 +106,91 @@ type User interface {
 
 // Interface for all challenge solvers to implement.
 type solver interface {
-	Solve(challenge challenge, domain string) error
+	Solve(challenge AuthorizationChallenge, domain string) error
 }
 
-type validateFunc func(j *jws, domain, uri string, chlng challenge) error
+type validateFunc func(j *jws, domain, uri string, chlng AuthorizationChallenge) error
 
 // Client is the user-friendy way to ACME
 type Client struct {
-	directory directory
+	directory Directory
 	user      User
 	jws       *jws
 	keyType   KeyType
 	solvers   map[Challenge]solver
+
+	// challengePreference orders the challenge types chooseSolvers prefers
+	// if a domain offers several combinations it can solve.
+	challengePreference []Challenge
+
+	profile   string
+	notBefore time.Time
+	notAfter  time.Time
+	dryRun    bool
+	caaCheck  bool
+	strict    bool
+	maxNames  int
+
+	// csrExtensions are added to the CSRs of the certificates the client
+	// generates, e.g. to request key usages.
+	csrExtensions []pkix.Extension
+
+	challengeTimeout time.Duration
+
+	// certPollInterval and certPollTimeout control how the client polls
+	// for a certificate the CA has not issued yet.
+	certPollInterval time.Duration
+	certPollTimeout  time.Duration
+
+	// forceNewAuthz makes getChallenges replace reused valid
+	// authorizations by new ones.
+	forceNewAuthz bool
+
+	// metrics receives the outcomes of the issuances. When nil, they are
+	// not reported.
+	metrics Metrics
+
+	// http01Family is the network of the built-in HTTP-01 server.
+	http01Family string
+
+	// externalSolver, if set, solves the challenges of the types in
+	// externalChallenges instead of the solvers, and externalCleanUp, if
+	// set, removes them after their validation.
+	externalSolver     ExternalChallengeSolver
+	externalCleanUp    ExternalChallengeCleanUp
+	externalChallenges []Challenge
+
+	// excludedChallenges are the challenge types passed to
+	// ExcludeChallenges, which the external solver must not be given.
+	excludedChallenges []Challenge
+
+	// logger receives the log entries of the client and its solvers. When
+	// nil, they go to Logger or are discarded if that is nil too.
+	logger StdLogger
+
+	// tlsSNINotice makes sure the tls-sni-01 deprecation notice is only
+	// logged once per client.
+	tlsSNINotice sync.Once
+
+	// providerMu serializes the calls the solvers make to sequential
+	// providers.
+	providerMu sync.Mutex
 }
 
 // NewClient creates a new ACME client on behalf of the user. The client will depend on
 // the ACME directory located at caDirURL for the rest of its actions.  A private
 // key of type keyType (see KeyType contants) will be generated when requesting a new
-// certificate if one isn't provided.
+// certificate if one isn't provided. keyType only applies to certificate keys; the
+// account key is always the one returned by user.GetPrivateKey and may be of a
+// different type.
 func NewClient(caDirURL string, user User, keyType KeyType) (*Client, error) {
 	privKey := user.GetPrivateKey()
 	if privKey == nil {
 		return nil, errors.New("private key was nil")
 	}
 
-	var dir directory
-	if _, err := getJSON(caDirURL, &dir); err != nil {
+	dir, err := fetchDirectory(caDirURL)
+	if err != nil {
 		return nil, fmt.Errorf("get directory at '%s': %v", caDirURL, err)
 	}
 
@@ -101,24 +213,242 @@ func NewClient(caDirURL string, user User, keyType KeyType) (*Client, error) {
 	// Add all available solvers with the right index as per ACME
 	// spec to this map. Otherwise they won`t be found.
 	solvers := make(map[Challenge]solver)
-	solvers[HTTP01] = &httpChallenge{jws: jws, validate: validate, provider: &HTTPProviderServer{}}
-	solvers[TLSSNI01] = &tlsSNIChallenge{jws: jws, validate: validate, provider: &TLSProviderServer{}}
+	c := &Client{directory: dir, user: user, jws: jws, keyType: keyType, solvers: solvers, maxNames: MaxNamesPerCertificate}
+	solvers[HTTP01] = &httpChallenge{jws: jws, validate: c.validateChallenge, provider: &HTTPProviderServer{logger: c.logf}, providerMu: &c.providerMu, logf: c.logf}
+	solvers[TLSSNI01] = &tlsSNIChallenge{jws: jws, validate: c.validateChallenge, provider: &TLSProviderServer{}, providerMu: &c.providerMu, logf: c.logf}
+
+	return c, nil
+}
+
+// fetchDirectory gets the directory at uri. Network errors and responses
+// indicating a transient failure (429 or 5xx) are retried until
+// DirectoryTimeout has elapsed, backing off exponentially or as long as
+// the Retry-After header of the response asks for.
+func fetchDirectory(uri string) (Directory, error) {
+	deadline := time.Now().Add(DirectoryTimeout)
+	delay := directoryRetryDelay
+	for {
+		var dir Directory
+		resp, err := httpGet(uri, userAgent())
+		if err != nil && !isNetError(err) {
+			return Directory{}, err
+		}
+		if err == nil {
+			if resp.StatusCode < http.StatusBadRequest {
+				err = json.NewDecoder(resp.Body).Decode(&dir)
+				resp.Body.Close()
+				return dir, err
+			}
+			err = handleHTTPError(resp)
+			resp.Body.Close()
+			if !RetryableResponse(resp) {
+				return Directory{}, err
+			}
+		}
+
+		wait := delay
+		if resp != nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				wait = retryAfter
+			}
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return Directory{}, err
+		}
+		logf("[INFO] acme: Could not get directory at %s: %v; retrying in %v", uri, err, wait)
+		time.Sleep(wait)
+
+		if delay *= 2; delay > maxDirectoryRetryDelay {
+			delay = maxDirectoryRetryDelay
+		}
+	}
+}
+
+// isNetError reports whether err, as returned by an http.Client, is an
+// error of the network, such as a refused connection or a timeout, rather
+// than e.g. an untrusted certificate.
+func isNetError(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// GetDirectory fetches the directory of the CA again and returns it, e.g. to
+// show the current terms of service or the CAA identities of the CA before
+// registering.
+func (c *Client) GetDirectory() (Directory, error) {
+	var dir Directory
+	if _, err := getJSON(c.jws.directoryURL, &dir); err != nil {
+		return Directory{}, fmt.Errorf("get directory at '%s': %v", c.jws.directoryURL, err)
+	}
+	return dir, nil
+}
+
+// SetCertificateKeyType sets the type of the private keys generated for new
+// certificates, independently of the type of the account key. It replaces the
+// key type passed to NewClient.
+func (c *Client) SetCertificateKeyType(keyType KeyType) error {
+	switch keyType {
+	case EC256, EC384, RSA2048, RSA4096, RSA8192:
+		c.keyType = keyType
+		return nil
+	}
+	return fmt.Errorf("Invalid KeyType: %s", keyType)
+}
+
+// SetCertificateProfile sets the certificate profile requested for new
+// certificates, e.g. for short-lived certificates. The profile is only sent
+// if the CA advertises it in the meta data of its directory, it is ignored
+// otherwise. An empty profile requests the default profile of the CA.
+func (c *Client) SetCertificateProfile(profile string) {
+	c.profile = profile
+}
+
+// SetCertificateValidity sets the notBefore and notAfter dates requested for
+// new certificates. A zero time leaves the respective date up to the CA. The
+// CA may ignore the request and issue the certificate with its default validity.
+func (c *Client) SetCertificateValidity(notBefore, notAfter time.Time) error {
+	if !notBefore.IsZero() && !notAfter.IsZero() && !notAfter.After(notBefore) {
+		return fmt.Errorf("notAfter %v is not after notBefore %v", notAfter, notBefore)
+	}
+	c.notBefore = notBefore
+	c.notAfter = notAfter
+	return nil
+}
+
+// SetCertificateKeyUsage sets the key usage and extended key usages, e.g.
+// x509.ExtKeyUsageClientAuth for the certificates of mutual TLS, requested
+// in the CSRs of new certificates. A zero keyUsage and no extKeyUsages
+// request none, leaving them up to the CA. Most public CAs ignore the
+// requested usages, but some private CAs honor them.
+func (c *Client) SetCertificateKeyUsage(keyUsage x509.KeyUsage, extKeyUsages ...x509.ExtKeyUsage) error {
+	extensions, err := keyUsageExtensions(keyUsage, extKeyUsages)
+	if err != nil {
+		return err
+	}
+	c.csrExtensions = extensions
+	return nil
+}
+
+// SetDryRun enables or disables the dry-run mode of the client. In dry-run
+// mode, ObtainCertificate and ObtainCertificateForCSR request authorizations
+// and present and clean up the challenges using the configured providers,
+// but neither ask the CA to validate the challenges nor request the
+// certificate. The challenges which would have been used are returned in
+// the Challenges field of the CertificateResource.
+func (c *Client) SetDryRun(dryRun bool) {
+	c.dryRun = dryRun
+}
+
+// SetChallengeTimeout sets how long the client waits for a single challenge
+// to become valid after asking the server to validate it. A timeout of zero,
+// the default, waits until the server reports the challenge as valid or
+// invalid.
+func (c *Client) SetChallengeTimeout(timeout time.Duration) {
+	c.challengeTimeout = timeout
+}
+
+// SetCertificatePolling sets how often the client polls for a certificate
+// which the CA accepted but did not issue yet, and how long it waits for it
+// in total. The interval, one second by default, only applies if the CA does
+// not send a Retry-After header. Retry-After delays are bounded by a minute,
+// or by the interval if it is longer. A timeout of zero, the default, waits
+// until the certificate is issued.
+func (c *Client) SetCertificatePolling(interval, timeout time.Duration) {
+	c.certPollInterval = interval
+	c.certPollTimeout = timeout
+}
+
+// SetMaxNamesPerCertificate sets the maximum number of names the CA accepts
+// in a single certificate, MaxNamesPerCertificate by default. Requests for more
+// names fail before contacting the CA. Zero removes the limit.
+func (c *Client) SetMaxNamesPerCertificate(max int) {
+	c.maxNames = max
+}
+
+// SetUserAgent sets a User-Agent string, e.g. "MyApp/1.2.3", which is
+// appended to the default User-Agent of the requests the client sends to the
+// CA. Unlike the package level UserAgent, it only applies to this client.
+// DNS providers are not tied to a client and send UserAgentString.
+func (c *Client) SetUserAgent(ua string) {
+	c.jws.clientUserAgent = strings.TrimSpace(ua)
+}
+
+// SetForceNewAuthorizations makes the client deactivate valid
+// authorizations which the server reuses for new-authz requests and request
+// new ones, so every domain is validated again, e.g. after fixing its DNS.
+func (c *Client) SetForceNewAuthorizations(force bool) {
+	c.forceNewAuthz = force
+}
+
+// SetCAACheck enables or disables checking the CAA records of all domains
+// before requesting authorizations for them. If the records do not permit any
+// of the CAA identities the CA advertises in its directory, the domain fails
+// without contacting the CA. The check is disabled by default.
+func (c *Client) SetCAACheck(enabled bool) {
+	c.caaCheck = enabled
+}
+
+// SetStrict enables or disables the strict mode of the client. In strict
+// mode, ObtainCertificates solves the challenges of all domains before it
+// requests any certificate, so that no certificate is issued if the
+// challenge of a single domain fails. ObtainCertificate never issues partial
+// certificates and is not affected.
+func (c *Client) SetStrict(strict bool) {
+	c.strict = strict
+}
+
+// SetChallengePreferenceOrder sets the order in which the client prefers
+// challenge types if a domain can be validated with several of them, e.g.
+// []Challenge{DNS01, HTTP01} to use DNS-01 and fall back to HTTP-01. Types
+// not in order are used only if none of the listed ones can be solved. By
+// default, the order of the combinations offered by the server is kept. Use
+// ExcludeChallenges to never use a challenge type.
+func (c *Client) SetChallengePreferenceOrder(order []Challenge) {
+	c.challengePreference = append([]Challenge(nil), order...)
+}
+
+// SetLogger makes the client write its log entries, such as the creation of
+// authorizations, the solving and validation of challenges and errors, to l.
+// By default, and after passing nil, the entries go to Logger, or are
+// discarded if Logger is nil.
+func (c *Client) SetLogger(l StdLogger) {
+	c.logger = l
+}
+
+// logf writes a log entry to the logger of the client, or to Logger if the
+// client has none.
+func (c *Client) logf(format string, args ...interface{}) {
+	if c.logger != nil {
+		c.logger.Printf(format, args...)
+	} else if Logger != nil {
+		Logger.Printf(format, args...)
+	}
+}
 
-	return &Client{directory: dir, user: user, jws: jws, keyType: keyType, solvers: solvers}, nil
+// setProviderLogger routes the log entries of p to the client, if p is one
+// of the challenge servers of this package.
+func (c *Client) setProviderLogger(p ChallengeProvider) {
+	if lp, ok := p.(loggingProvider); ok {
+		lp.setLogger(c.logf)
+	}
 }
 
 // SetChallengeProvider specifies a custom provider p that can solve the given challenge type.
 func (c *Client) SetChallengeProvider(challenge Challenge, p ChallengeProvider) error {
 	switch challenge {
 	case HTTP01:
-		c.solvers[challenge] = &httpChallenge{jws: c.jws, validate: validate, provider: p}
+		c.solvers[challenge] = &httpChallenge{jws: c.jws, validate: c.validateChallenge, provider: p, providerMu: &c.providerMu, logf: c.logf}
 	case TLSSNI01:
-		c.solvers[challenge] = &tlsSNIChallenge{jws: c.jws, validate: validate, provider: p}
+		c.solvers[challenge] = &tlsSNIChallenge{jws: c.jws, validate: c.validateChallenge, provider: p, providerMu: &c.providerMu, logf: c.logf}
 	case DNS01:
-		c.solvers[challenge] = &dnsChallenge{jws: c.jws, validate: validate, provider: p}
+		c.solvers[challenge] = &dnsChallenge{jws: c.jws, validate: c.validateChallenge, provider: p, providerMu: &c.providerMu, logf: c.logf}
 	default:
 		return fmt.Errorf("Unknown challenge %v", challenge)
 	}
+	c.setProviderLogger(p)
 	return nil
 }
 
@@ -135,9 +465,29 @@ func (c *Client) SetHTTPAddress(iface string) error {
 	}
 
 	if chlng, ok := c.solvers[HTTP01]; ok {
-		chlng.(*httpChallenge).provider = NewHTTPProviderServer(host, port)
+		server := NewHTTPProviderServer(host, port)
+		server.SetNetwork(c.http01Family)
+		server.setLogger(c.logf)
+		chlng.(*httpChallenge).provider = server
+	}
+
+	return nil
+}
+
+// SetHTTP01Family sets the address family the built-in HTTP-01 server
+// listens on: "tcp4", "tcp6" or "tcp" for both, which is the default. It
+// has no effect on custom HTTP providers.
+func (c *Client) SetHTTP01Family(family string) error {
+	if err := (&HTTPProviderServer{}).SetNetwork(family); err != nil {
+		return err
 	}
+	c.http01Family = family
 
+	if chlng, ok := c.solvers[HTTP01]; ok {
+		if server, ok := chlng.(*httpChallenge).provider.(*HTTPProviderServer); ok {
+			server.SetNetwork(family)
+		}
+	}
 	return nil
 }
 
@@ -160,11 +510,13 @@ func (c *Client) SetTLSAddress(iface string) error {
 }
 
 // ExcludeChallenges explicitly removes challenges from the pool for solving.
+// This applies to the external challenge solver too.
 func (c *Client) ExcludeChallenges(challenges []Challenge) {
 	// Loop through all challenges and delete the requested one if found.
 	for _, challenge := range challenges {
 		delete(c.solvers, challenge)
 	}
+	c.excludedChallenges = append(c.excludedChallenges, challenges...)
 }
 
 // Register the current account to the ACME server.
@@ -172,7 +524,7 @@ func (c *Client) Register() (*RegistrationResource, error) {
 	if c == nil || c.user == nil {
 		return nil, errors.New("acme: cannot register a nil client or user")
 	}
-	logf("[INFO] acme: Registering account for %s", c.user.GetEmail())
+	c.logf("[INFO] acme: Registering account for %s", c.user.GetEmail())
 
 	regMsg := registrationMessage{
 		Resource: "new-reg",
@@ -228,7 +580,7 @@ func (c *Client) DeleteRegistration() error {
 	if c == nil || c.user == nil {
 		return errors.New("acme: cannot unregister a nil client or user")
 	}
-	logf("[INFO] acme: Deleting account for %s", c.user.GetEmail())
+	c.logf("[INFO] acme: Deleting account for %s", c.user.GetEmail())
 
 	regMsg := registrationMessage{
 		Resource: "reg",
@@ -253,7 +605,7 @@ func (c *Client) QueryRegistration() (*RegistrationResource, error) {
 		return nil, errors.New("acme: cannot query the registration of a nil client or user")
 	}
 	// Log the URL here instead of the email as the email may not be set
-	logf("[INFO] acme: Querying account for %s", c.user.GetRegistration().URI)
+	c.logf("[INFO] acme: Querying account for %s", c.user.GetRegistration().URI)
 
 	regMsg := registrationMessage{
 		Resource: "reg",
@@ -284,6 +636,47 @@ func (c *Client) QueryRegistration() (*RegistrationResource, error) {
 	return reg, nil
 }
 
+// ListOrders returns the URLs of the orders of the account, following the
+// orders list of the registration across all of its pages. The URL of the
+// list is taken from the registration of the user, or queried from the CA if
+// the registration does not contain it.
+func (c *Client) ListOrders() ([]string, error) {
+	if c == nil || c.user == nil {
+		return nil, errors.New("acme: cannot list the orders of a nil client or user")
+	}
+
+	ordersURL := c.user.GetRegistration().Body.Orders
+	if ordersURL == "" {
+		reg, err := c.QueryRegistration()
+		if err != nil {
+			return nil, err
+		}
+		ordersURL = reg.Body.Orders
+	}
+	if ordersURL == "" {
+		return nil, errors.New("acme: The CA does not list the orders of the account")
+	}
+
+	var orders []string
+	seen := make(map[string]bool)
+	for ordersURL != "" && !seen[ordersURL] {
+		seen[ordersURL] = true
+
+		var page struct {
+			Orders []string `json:"orders"`
+		}
+		hdr, err := fetchJSON(c.jws, ordersURL, &page)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, page.Orders...)
+
+		ordersURL = parseLinks(hdr["Link"])["next"]
+	}
+
+	return orders, nil
+}
+
 // AgreeToTOS updates the Client registration and sends the agreement to
 // the server.
 func (c *Client) AgreeToTOS() error {
@@ -295,6 +688,19 @@ func (c *Client) AgreeToTOS() error {
 	return err
 }
 
+// GetAuthorization fetches the authorization located at authURL and returns
+// its current state as reported by the ACME server, including the status and
+// error details of each of its challenges. No challenge is solved or
+// otherwise acted upon; this is useful to inspect why a validation failed.
+func (c *Client) GetAuthorization(authURL string) (*Authorization, error) {
+	var authz Authorization
+	if _, err := fetchJSON(c.jws, authURL, &authz); err != nil {
+		return nil, err
+	}
+
+	return &authz, nil
+}
+
 // ObtainCertificateForCSR tries to obtain a certificate matching the CSR passed into it.
 // The domains are inferred from the CommonName and SubjectAltNames, if any. The private key
 // for this CSR is not required.
@@ -303,13 +709,25 @@ func (c *Client) AgreeToTOS() error {
 // This function will never return a partial certificate. If one domain in the list fails,
 // the whole certificate will fail.
 func (c *Client) ObtainCertificateForCSR(csr x509.CertificateRequest, bundle bool) (CertificateResource, map[string]error) {
+	return c.obtainCertificateForCSR(csr, bundle, "")
+}
+
+// obtainCertificateForCSR implements ObtainCertificateForCSR. replaces is
+// the ARI id of the certificate the new one replaces, if any.
+func (c *Client) obtainCertificateForCSR(csr x509.CertificateRequest, bundle bool, replaces string) (CertificateResource, map[string]error) {
 	// figure out what domains it concerns
 	// start with the common name
 	domains := []string{csr.Subject.CommonName}
 
-	// loop over the SubjectAltName DNS names
+	// loop over the SubjectAltName DNS names and IP addresses
+	// copy the DNS names so appending the IP addresses cannot write to the
+	// backing array of the caller's CSR
+	sanNames := append([]string(nil), csr.DNSNames...)
+	for _, ip := range csr.IPAddresses {
+		sanNames = append(sanNames, ip.String())
+	}
 DNSNames:
-	for _, sanName := range csr.DNSNames {
+	for _, sanName := range sanNames {
 		for _, existingName := range domains {
 			if existingName == sanName {
 				// duplicate; skip this name
@@ -321,31 +739,56 @@ DNSNames:
 		domains = append(domains, sanName)
 	}
 
+	domains, failures := normalizeDomains(domains)
+	if len(failures) > 0 {
+		c.getMetrics().IncFailed(FailureDomains)
+		return CertificateResource{}, failures
+	}
+
+	if failures := c.checkNameCount(domains); len(failures) > 0 {
+		c.getMetrics().IncFailed(FailureDomains)
+		return CertificateResource{}, failures
+	}
+
+	if failures := c.checkCAA(domains); len(failures) > 0 {
+		c.getMetrics().IncFailed(FailureCAA)
+		return CertificateResource{}, failures
+	}
+
 	if bundle {
-		logf("[INFO][%s] acme: Obtaining bundled SAN certificate given a CSR", strings.Join(domains, ", "))
+		c.logf("[INFO][%s] acme: Obtaining bundled SAN certificate given a CSR", strings.Join(domains, ", "))
 	} else {
-		logf("[INFO][%s] acme: Obtaining SAN certificate given a CSR", strings.Join(domains, ", "))
+		c.logf("[INFO][%s] acme: Obtaining SAN certificate given a CSR", strings.Join(domains, ", "))
 	}
 
 	challenges, failures := c.getChallenges(domains)
 	// If any challenge fails - return. Do not generate partial SAN certificates.
 	if len(failures) > 0 {
+		c.getMetrics().IncFailed(FailureAuthorization)
 		return CertificateResource{}, failures
 	}
 
-	errs := c.solveChallenges(challenges)
+	used, errs := c.solveChallenges(challenges)
 	// If any challenge fails - return. Do not generate partial SAN certificates.
 	if len(errs) > 0 {
+		c.getMetrics().IncFailed(FailureChallenge)
 		return CertificateResource{}, errs
 	}
 
-	logf("[INFO][%s] acme: Validations succeeded; requesting certificates", strings.Join(domains, ", "))
+	if c.dryRun {
+		return c.dryRunResult(challenges, used), failures
+	}
+
+	c.logf("[INFO][%s] acme: Validations succeeded; requesting certificates", strings.Join(domains, ", "))
 
-	cert, err := c.requestCertificateForCsr(challenges, bundle, csr.Raw, nil)
+	cert, err := c.requestCertificateForCsr(challenges, bundle, csr.Raw, nil, replaces)
 	if err != nil {
+		c.getMetrics().IncFailed(FailureCertificate)
 		for _, chln := range challenges {
 			failures[chln.Domain] = err
 		}
+	} else {
+		c.getMetrics().IncIssued()
 	}
 
 	// Add the CSR to the certificate so that it can be used for renewals.
@@ -356,7 +799,11 @@ DNSNames:
 
 // ObtainCertificate tries to obtain a single certificate using all domains passed into it.
 // The first domain in domains is used for the CommonName field of the certificate, all other
-// domains are added using the Subject Alternate Names extension. A new private key is generated
+// domains are added using the Subject Alternate Names extension. Entries which are IP addresses
+// are requested as "ip" identifiers and added to the IP address SANs of the certificate,
+// provided the CA supports issuing for IP addresses.
+// Domains are normalized before use: they are lowercased, a trailing dot is removed and
+// internationalized domain names are converted to punycode. A new private key is generated
 // for every invocation of this function. If you do not want that you can supply your own private key
 // in the privKey parameter. If this parameter is non-nil it will be used instead of generating a new one.
 // If bundle is true, the []byte contains both the issuer certificate and
@@ -364,34 +811,242 @@ DNSNames:
 // This function will never return a partial certificate. If one domain in the list fails,
 // the whole certificate will fail.
 func (c *Client) ObtainCertificate(domains []string, bundle bool, privKey crypto.PrivateKey, mustStaple bool) (CertificateResource, map[string]error) {
+	return c.obtainCertificate(domains, bundle, privKey, mustStaple, "")
+}
+
+// Obtain works like ObtainCertificate, but returns an ObtainResult telling
+// which domains were validated, which failed at which stage and whether the
+// certificate was issued.
+func (c *Client) Obtain(domains []string, bundle bool, privKey crypto.PrivateKey, mustStaple bool) ObtainResult {
+	return c.obtain(domains, bundle, privKey, mustStaple, "")
+}
+
+// obtainCertificate implements ObtainCertificate. replaces is the ARI id of
+// the certificate the new one replaces, if any.
+func (c *Client) obtainCertificate(domains []string, bundle bool, privKey crypto.PrivateKey, mustStaple bool, replaces string) (CertificateResource, map[string]error) {
+	result := c.obtain(domains, bundle, privKey, mustStaple, replaces)
+	return result.Certificate, result.Errors()
+}
+
+// obtain implements Obtain. replaces is the ARI id of the certificate the
+// new one replaces, if any.
+func (c *Client) obtain(domains []string, bundle bool, privKey crypto.PrivateKey, mustStaple bool, replaces string) ObtainResult {
+	result := ObtainResult{Failures: make(map[string]DomainFailure)}
+	fail := func(stage string, failures map[string]error) ObtainResult {
+		c.getMetrics().IncFailed(stage)
+		for domain, err := range failures {
+			result.Failures[domain] = DomainFailure{Stage: stage, Err: err}
+		}
+		return result
+	}
+
+	domains, failures := normalizeDomains(domains)
+	if len(domains) == 0 && len(failures) == 0 {
+		failures[""] = errNoDomains
+	}
+	if len(failures) > 0 {
+		return fail(FailureDomains, failures)
+	}
+
+	if failures := c.checkNameCount(domains); len(failures) > 0 {
+		return fail(FailureDomains, failures)
+	}
+
+	if failures := c.checkCAA(domains); len(failures) > 0 {
+		return fail(FailureCAA, failures)
+	}
+
 	if bundle {
-		logf("[INFO][%s] acme: Obtaining bundled SAN certificate", strings.Join(domains, ", "))
+		c.logf("[INFO][%s] acme: Obtaining bundled SAN certificate", strings.Join(domains, ", "))
 	} else {
-		logf("[INFO][%s] acme: Obtaining SAN certificate", strings.Join(domains, ", "))
+		c.logf("[INFO][%s] acme: Obtaining SAN certificate", strings.Join(domains, ", "))
 	}
 
 	challenges, failures := c.getChallenges(domains)
 	// If any challenge fails - return. Do not generate partial SAN certificates.
 	if len(failures) > 0 {
-		return CertificateResource{}, failures
+		return fail(FailureAuthorization, failures)
 	}
 
-	errs := c.solveChallenges(challenges)
+	used, errs := c.solveChallenges(challenges)
+	for _, authz := range challenges {
+		// In dry-run mode, the challenges are not validated.
+		if _, failed := errs[authz.Domain]; authz.Body.Status == "valid" || (!failed && !c.dryRun) {
+			result.Validated = append(result.Validated, authz.Domain)
+		}
+	}
 	// If any challenge fails - return. Do not generate partial SAN certificates.
 	if len(errs) > 0 {
-		return CertificateResource{}, errs
+		return fail(FailureChallenge, errs)
 	}
 
-	logf("[INFO][%s] acme: Validations succeeded; requesting certificates", strings.Join(domains, ", "))
+	if c.dryRun {
+		result.Certificate = c.dryRunResult(challenges, used)
+		return result
+	}
 
-	cert, err := c.requestCertificate(challenges, bundle, privKey, mustStaple)
+	c.logf("[INFO][%s] acme: Validations succeeded; requesting certificates", strings.Join(domains, ", "))
+
+	cert, err := c.requestCertificate(challenges, bundle, privKey, mustStaple, replaces)
 	if err != nil {
+		failures := make(map[string]error)
 		for _, chln := range challenges {
 			failures[chln.Domain] = err
 		}
+		return fail(FailureCertificate, failures)
 	}
+	c.getMetrics().IncIssued()
 
-	return cert, failures
+	result.Certificate = cert
+	result.Issued = true
+	return result
+}
+
+// ObtainCertificates works like ObtainCertificate, but splits domains into as
+// few certificates as the maximum number of names per certificate allows. The
+// first domain of each chunk is used for the CommonName of its certificate.
+// The certificates which could be obtained are returned even if others failed,
+// unless the client is in strict mode (see SetStrict).
+func (c *Client) ObtainCertificates(domains []string, bundle bool, privKey crypto.PrivateKey, mustStaple bool) ([]CertificateResource, map[string]error) {
+	domains, failures := normalizeDomains(domains)
+	if len(domains) == 0 && len(failures) == 0 {
+		failures[""] = errNoDomains
+	}
+	if len(failures) > 0 {
+		c.getMetrics().IncFailed(FailureDomains)
+		return nil, failures
+	}
+
+	if c.strict {
+		return c.obtainCertificatesStrict(domains, bundle, privKey, mustStaple)
+	}
+
+	var certs []CertificateResource
+	for _, chunk := range SplitDomains(domains, c.maxNames) {
+		cert, errs := c.ObtainCertificate(chunk, bundle, privKey, mustStaple)
+		if len(errs) > 0 {
+			for domain, err := range errs {
+				failures[domain] = err
+			}
+			continue
+		}
+		certs = append(certs, cert)
+	}
+
+	return certs, failures
+}
+
+// obtainCertificatesStrict implements ObtainCertificates in strict mode. The
+// challenges of all domains are solved up front and the certificates are only
+// requested once every domain is validated. If requesting a certificate fails,
+// its domains are returned as failures along with the certificates which were
+// issued, so that no issued certificate is lost.
+func (c *Client) obtainCertificatesStrict(domains []string, bundle bool, privKey crypto.PrivateKey, mustStaple bool) ([]CertificateResource, map[string]error) {
+	if failures := c.checkCAA(domains); len(failures) > 0 {
+		c.getMetrics().IncFailed(FailureCAA)
+		return nil, failures
+	}
+
+	c.logf("[INFO][%s] acme: Obtaining SAN certificates in strict mode", strings.Join(domains, ", "))
+
+	challenges, failures := c.getChallenges(domains)
+	// If any challenge fails - return. Do not generate any certificate.
+	if len(failures) > 0 {
+		c.getMetrics().IncFailed(FailureAuthorization)
+		return nil, failures
+	}
+
+	used, errs := c.solveChallenges(challenges)
+	// If any challenge fails - return. Do not generate any certificate.
+	if len(errs) > 0 {
+		c.getMetrics().IncFailed(FailureChallenge)
+		return nil, errs
+	}
+
+	var certs []CertificateResource
+	for _, chunk := range splitAuthorizations(challenges, c.maxNames) {
+		if c.dryRun {
+			chunkUsed := make(map[string][]Challenge)
+			for _, authz := range chunk {
+				if types, ok := used[authz.Domain]; ok {
+					chunkUsed[authz.Domain] = types
+				}
+			}
+			certs = append(certs, c.dryRunResult(chunk, chunkUsed))
+			continue
+		}
+
+		cert, err := c.requestCertificate(chunk, bundle, privKey, mustStaple, "")
+		if err != nil {
+			c.getMetrics().IncFailed(FailureCertificate)
+			for _, chln := range chunk {
+				failures[chln.Domain] = err
+			}
+			continue
+		}
+		c.getMetrics().IncIssued()
+		certs = append(certs, cert)
+	}
+
+	return certs, failures
+}
+
+// splitAuthorizations splits authz into chunks of at most max authorizations,
+// like SplitDomains does for domains.
+func splitAuthorizations(authz []authorizationResource, max int) [][]authorizationResource {
+	if max <= 0 || len(authz) <= max {
+		return [][]authorizationResource{authz}
+	}
+
+	var chunks [][]authorizationResource
+	for len(authz) > max {
+		chunks = append(chunks, authz[:max:max])
+		authz = authz[max:]
+	}
+	return append(chunks, authz)
+}
+
+// checkNameCount fails if more names are requested than the CA accepts in a
+// single certificate.
+func (c *Client) checkNameCount(domains []string) map[string]error {
+	failures := make(map[string]error)
+	if c.maxNames > 0 && len(domains) > c.maxNames {
+		failures[domains[0]] = fmt.Errorf("[%s] acme: %d names were requested, but the CA accepts at most %d names per certificate. Use ObtainCertificates to obtain several certificates.", domains[0], len(domains), c.maxNames)
+	}
+	return failures
+}
+
+// GetCertificate downloads the certificate at url, usually the CertURL or
+// CertStableURL of a CertificateResource, without requesting a new one, e.g.
+// to fetch it again after it was lost. The certificate is returned PEM
+// encoded.
+func (c *Client) GetCertificate(url string) ([]byte, error) {
+	resp, err := fetch(c.jws, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, handleHTTPError(resp)
+	}
+
+	body, err := ioutil.ReadAll(limitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) == 0 {
+		return nil, fmt.Errorf("acme: The certificate at %s was not issued yet", url)
+	}
+
+	// ACMEv1 CAs serve the certificate DER encoded, some CAs a PEM chain.
+	if _, err := parsePEMBundle(body); err == nil {
+		return body, nil
+	}
+	if _, err := x509.ParseCertificate(body); err != nil {
+		return nil, fmt.Errorf("acme: Could not parse the certificate at %s: %v", url, err)
+	}
+	return pemEncode(derCertificateBytes(body)), nil
 }
 
 // RevokeCertificate takes a PEM encoded certificate or bundle and tries to revoke it at the CA.
@@ -420,22 +1075,32 @@ func (c *Client) RevokeCertificate(certificate []byte) error {
 // If bundle is true, the []byte contains both the issuer certificate and
 // your issued certificate as a bundle.
 // For private key reuse the PrivateKey property of the passed in CertificateResource should be non-nil.
+// If the CA offers renewal information, the request names the certificate it replaces.
 func (c *Client) RenewCertificate(cert CertificateResource, bundle, mustStaple bool) (CertificateResource, error) {
 	// Input certificate is PEM encoded. Decode it here as we may need the decoded
 	// cert later on in the renewal process. The input may be a bundle or a single certificate.
 	certificates, err := parsePEMBundle(cert.Certificate)
 	if err != nil {
+		c.getMetrics().IncFailed(FailureRenewal)
 		return CertificateResource{}, err
 	}
 
 	x509Cert := certificates[0]
 	if x509Cert.IsCA {
+		c.getMetrics().IncFailed(FailureRenewal)
 		return CertificateResource{}, fmt.Errorf("[%s] Certificate bundle starts with a CA certificate", cert.Domain)
 	}
 
 	// This is just meant to be informal for the user.
 	timeLeft := x509Cert.NotAfter.Sub(time.Now().UTC())
-	logf("[INFO][%s] acme: Trying renewal with %d hours remaining", cert.Domain, int(timeLeft.Hours()))
+	c.logf("[INFO][%s] acme: Trying renewal with %d hours remaining", cert.Domain, int(timeLeft.Hours()))
+
+	// Tell CAs offering renewal information which certificate is replaced,
+	// so the renewal is exempt from rate limits during a suggested window.
+	var replaces string
+	if c.directory.RenewalInfoURL != "" {
+		replaces, _ = renewalInfoCertID(x509Cert.AuthorityKeyId, x509Cert.SerialNumber.Bytes())
+	}
 
 	// We always need to request a new certificate to renew.
 	// Start by checking to see if the certificate was based off a CSR, and
@@ -443,9 +1108,10 @@ func (c *Client) RenewCertificate(cert CertificateResource, bundle, mustStaple b
 	if len(cert.CSR) > 0 {
 		csr, err := pemDecodeTox509CSR(cert.CSR)
 		if err != nil {
+			c.getMetrics().IncFailed(FailureRenewal)
 			return CertificateResource{}, err
 		}
-		newCert, failures := c.ObtainCertificateForCSR(*csr, bundle)
+		newCert, failures := c.obtainCertificateForCSR(*csr, bundle, replaces)
 		return newCert, failures[cert.Domain]
 	}
 
@@ -453,6 +1119,7 @@ func (c *Client) RenewCertificate(cert CertificateResource, bundle, mustStaple b
 	if cert.PrivateKey != nil {
 		privKey, err = parsePEMPrivateKey(cert.PrivateKey)
 		if err != nil {
+			c.getMetrics().IncFailed(FailureRenewal)
 			return CertificateResource{}, err
 		}
 	}
@@ -471,28 +1138,57 @@ func (c *Client) RenewCertificate(cert CertificateResource, bundle, mustStaple b
 	} else {
 		domains = append(domains, x509Cert.Subject.CommonName)
 	}
+	for _, ip := range x509Cert.IPAddresses {
+		if ip.String() == x509Cert.Subject.CommonName {
+			continue
+		}
+		domains = append(domains, ip.String())
+	}
 
-	newCert, failures := c.ObtainCertificate(domains, bundle, privKey, mustStaple)
+	newCert, failures := c.obtainCertificate(domains, bundle, privKey, mustStaple, replaces)
 	return newCert, failures[cert.Domain]
 }
 
 // Looks through the challenge combinations to find a solvable match.
-// Then solves the challenges in series and returns.
-func (c *Client) solveChallenges(challenges []authorizationResource) map[string]error {
+// Then solves the challenges in series and returns. A failing domain does not
+// stop the others from being solved, and every solver cleans up its
+// challenge before returning if Present succeeded, so no records are left
+// behind when the issuance fails. Zone lookups, including those of the DNS
+// providers, are cached until all challenges are solved.
+func (c *Client) solveChallenges(challenges []authorizationResource) (map[string][]Challenge, map[string]error) {
+	if c.externalSolver != nil {
+		return c.solveChallengesExternally(challenges)
+	}
+
+	zones := acquireZoneCache()
+	defer releaseZoneCache()
+
 	// loop through the resources, basically through the domains.
+	used := make(map[string][]Challenge)
 	failures := make(map[string]error)
 	for _, authz := range challenges {
 		if authz.Body.Status == "valid" {
 			// Boulder might recycle recent validated authz (see issue #267)
-			logf("[INFO][%s] acme: Authorization already valid; skipping challenge", authz.Domain)
+			c.logf("[INFO][%s] acme: Authorization already valid; skipping challenge", authz.Domain)
 			continue
 		}
 		// no solvers - no solving
 		if solvers := c.chooseSolvers(authz.Body, authz.Domain); solvers != nil {
 			for i, solver := range solvers {
+				used[authz.Domain] = append(used[authz.Domain], authz.Body.Challenges[i].Type)
+
 				// TODO: do not immediately fail if one domain fails to validate.
-				err := solver.Solve(authz.Body.Challenges[i], authz.Domain)
+				c.logf("[INFO][%s] acme: Solving %s challenge", authz.Domain, authz.Body.Challenges[i].Type)
+				start := time.Now()
+				var err error
+				if dns, ok := solver.(*dnsChallenge); ok {
+					err = dns.solve(authz.Body.Challenges[i], authz.Domain, zones)
+				} else {
+					err = solver.Solve(authz.Body.Challenges[i], authz.Domain)
+				}
+				c.getMetrics().ObserveChallengeDuration(authz.Body.Challenges[i].Type, time.Since(start), err == nil)
 				if err != nil {
+					c.logf("[ERROR][%s] acme: Could not solve %s challenge: %v", authz.Domain, authz.Body.Challenges[i].Type, err)
 					failures[authz.Domain] = err
 				}
 			}
@@ -501,28 +1197,104 @@ func (c *Client) solveChallenges(challenges []authorizationResource) map[string]
 		}
 	}
 
-	return failures
+	return used, failures
+}
+
+// dryRunResult logs the challenges used for each domain and returns them in
+// a CertificateResource without a certificate.
+func (c *Client) dryRunResult(challenges []authorizationResource, used map[string][]Challenge) CertificateResource {
+	for _, authz := range challenges {
+		if types, ok := used[authz.Domain]; ok {
+			c.logf("[INFO][%s] acme: Dry run; would use challenges %v", authz.Domain, types)
+		}
+	}
+	if len(challenges) == 0 {
+		return CertificateResource{Challenges: used}
+	}
+	return CertificateResource{Domain: challenges[0].Domain, Challenges: used}
+}
+
+// validateChallenge validates the challenge response using validate, unless
+// the client is in dry-run mode.
+func (c *Client) validateChallenge(j *jws, domain, uri string, chlng AuthorizationChallenge) error {
+	if c.dryRun {
+		c.logf("[INFO][%s] acme: Dry run; skipping validation of %s", domain, chlng.Type)
+		return nil
+	}
+	return validateWithTimeout(j, domain, uri, chlng, c.challengeTimeout, c.logf)
 }
 
 // Checks all combinations from the server and returns an array of
 // solvers which should get executed in series.
-func (c *Client) chooseSolvers(auth authorization, domain string) map[int]solver {
+func (c *Client) chooseSolvers(auth Authorization, domain string) map[int]solver {
+	c.checkTLSSNIOffered(auth, domain)
+
+	var chosen map[int]solver
+	chosenRank := -1
 	for _, combination := range auth.Combinations {
 		solvers := make(map[int]solver)
 		for _, idx := range combination {
 			if solver, ok := c.solvers[auth.Challenges[idx].Type]; ok {
 				solvers[idx] = solver
 			} else {
-				logf("[INFO][%s] acme: Could not find solver for: %s", domain, auth.Challenges[idx].Type)
+				c.logf("[INFO][%s] acme: Could not find solver for: %s", domain, auth.Challenges[idx].Type)
 			}
 		}
 
-		// If we can solve the whole combination, return the solvers
-		if len(solvers) == len(combination) {
-			return solvers
+		// If we can solve the whole combination, consider the solvers. The
+		// first of the equally preferred combinations wins.
+		if len(solvers) != len(combination) {
+			continue
+		}
+		rank := c.combinationRank(auth, combination)
+		if chosen == nil || rank < chosenRank {
+			chosen, chosenRank = solvers, rank
 		}
 	}
-	return nil
+	return chosen
+}
+
+// combinationRank returns the position of the least preferred challenge type
+// of combination in the preference order of the client. Types which are not
+// in the order rank behind all others. Without a preference order, all
+// combinations rank the same, so the order of the server is used.
+func (c *Client) combinationRank(auth Authorization, combination []int) int {
+	if len(c.challengePreference) == 0 {
+		return 0
+	}
+
+	rank := 0
+	for _, idx := range combination {
+		r := len(c.challengePreference)
+		for i, challenge := range c.challengePreference {
+			if auth.Challenges[idx].Type == challenge {
+				r = i
+				break
+			}
+		}
+		if r > rank {
+			rank = r
+		}
+	}
+	return rank
+}
+
+// checkTLSSNIOffered logs a deprecation notice if the tls-sni-01 challenge is
+// enabled but not offered by the server for auth. The other challenges of
+// auth are used in that case.
+func (c *Client) checkTLSSNIOffered(auth Authorization, domain string) {
+	if _, ok := c.solvers[TLSSNI01]; !ok {
+		return
+	}
+	for _, chlng := range auth.Challenges {
+		if chlng.Type == TLSSNI01 {
+			return
+		}
+	}
+
+	c.tlsSNINotice.Do(func() {
+		c.logf("[INFO][%s] acme: The server does not offer the deprecated %s challenge, falling back to the other challenges. Exclude %s to silence this notice.", domain, TLSSNI01, TLSSNI01)
+	})
 }
 
 // Get the challenges needed to proof our identifier to the ACME server.
@@ -535,22 +1307,18 @@ func (c *Client) getChallenges(domains []string) ([]authorizationResource, map[s
 		time.Sleep(delay)
 
 		go func(domain string) {
-			authMsg := authorization{Resource: "new-authz", Identifier: identifier{Type: "dns", Value: domain}}
-			var authz authorization
-			hdr, err := postJSON(c.jws, c.user.GetRegistration().NewAuthzURL, authMsg, &authz)
+			authz, err := c.newAuthorization(domain)
+			if err == nil && c.forceNewAuthz && authz.Body.Status == "valid" {
+				c.logf("[INFO][%s] acme: Deactivating the valid authorization to validate again", domain)
+				if err = c.deactivateAuthorization(authz.AuthURL); err == nil {
+					authz, err = c.newAuthorization(domain)
+				}
+			}
 			if err != nil {
 				errc <- domainError{Domain: domain, Error: err}
 				return
 			}
-
-			links := parseLinks(hdr["Link"])
-			if links["next"] == "" {
-				logf("[ERROR][%s] acme: Server did not provide next link to proceed", domain)
-				errc <- domainError{Domain: domain, Error: errors.New("Server did not provide next link to proceed")}
-				return
-			}
-
-			resc <- authorizationResource{Body: authz, NewCertURL: links["next"], AuthURL: hdr.Get("Location"), Domain: domain}
+			resc <- authz
 		}(domain)
 	}
 
@@ -572,7 +1340,7 @@ func (c *Client) getChallenges(domains []string) ([]authorizationResource, map[s
 		}
 	}
 
-	logAuthz(challenges)
+	c.logAuthz(challenges)
 
 	close(resc)
 	close(errc)
@@ -580,13 +1348,42 @@ func (c *Client) getChallenges(domains []string) ([]authorizationResource, map[s
 	return challenges, failures
 }
 
-func logAuthz(authz []authorizationResource) {
+// newAuthorization requests a new authorization for domain.
+func (c *Client) newAuthorization(domain string) (authorizationResource, error) {
+	authMsg := Authorization{Resource: "new-authz", Identifier: newIdentifier(domain)}
+	var authz Authorization
+	hdr, err := postJSON(c.jws, c.user.GetRegistration().NewAuthzURL, authMsg, &authz)
+	if err != nil {
+		c.logf("[ERROR][%s] acme: Could not create authorization: %v", domain, err)
+		return authorizationResource{}, err
+	}
+
+	links := parseLinks(hdr["Link"])
+	if links["next"] == "" {
+		c.logf("[ERROR][%s] acme: Server did not provide next link to proceed", domain)
+		return authorizationResource{}, errors.New("Server did not provide next link to proceed")
+	}
+
+	return authorizationResource{Body: authz, NewCertURL: links["next"], AuthURL: hdr.Get("Location"), Domain: domain}, nil
+}
+
+// deactivateAuthorization deactivates the authorization at authURL, so the
+// server does not hand it out again for new-authz requests.
+func (c *Client) deactivateAuthorization(authURL string) error {
+	_, err := postJSON(c.jws, authURL, Authorization{Resource: "authz", Status: "deactivated"}, nil)
+	if err != nil {
+		return fmt.Errorf("acme: Could not deactivate authorization %s: %v", authURL, err)
+	}
+	return nil
+}
+
+func (c *Client) logAuthz(authz []authorizationResource) {
 	for _, auth := range authz {
-		logf("[INFO][%s] AuthURL: %s", auth.Domain, auth.AuthURL)
+		c.logf("[INFO][%s] AuthURL: %s", auth.Domain, auth.AuthURL)
 	}
 }
 
-func (c *Client) requestCertificate(authz []authorizationResource, bundle bool, privKey crypto.PrivateKey, mustStaple bool) (CertificateResource, error) {
+func (c *Client) requestCertificate(authz []authorizationResource, bundle bool, privKey crypto.PrivateKey, mustStaple bool, replaces string) (CertificateResource, error) {
 	if len(authz) == 0 {
 		return CertificateResource{}, errors.New("Passed no authorizations to requestCertificate!")
 	}
@@ -607,15 +1404,15 @@ func (c *Client) requestCertificate(authz []authorizationResource, bundle bool,
 	}
 
 	// TODO: should the CSR be customizable?
-	csr, err := generateCsr(privKey, commonName.Domain, san, mustStaple)
+	csr, err := generateCsr(privKey, commonName.Domain, san, mustStaple, c.csrExtensions)
 	if err != nil {
 		return CertificateResource{}, err
 	}
 
-	return c.requestCertificateForCsr(authz, bundle, csr, pemEncode(privKey))
+	return c.requestCertificateForCsr(authz, bundle, csr, pemEncode(privKey), replaces)
 }
 
-func (c *Client) requestCertificateForCsr(authz []authorizationResource, bundle bool, csr []byte, privateKeyPem []byte) (CertificateResource, error) {
+func (c *Client) requestCertificateForCsr(authz []authorizationResource, bundle bool, csr []byte, privateKeyPem []byte, replaces string) (CertificateResource, error) {
 	commonName := authz[0]
 
 	var authURLs []string
@@ -624,7 +1421,23 @@ func (c *Client) requestCertificateForCsr(authz []authorizationResource, bundle
 	}
 
 	csrString := base64.URLEncoding.EncodeToString(csr)
-	jsonBytes, err := json.Marshal(csrMessage{Resource: "new-cert", Csr: csrString, Authorizations: authURLs})
+	msg := csrMessage{Resource: "new-cert", Csr: csrString, Authorizations: authURLs, Replaces: replaces}
+
+	if c.profile != "" {
+		if _, ok := c.directory.Meta.Profiles[c.profile]; ok {
+			msg.Profile = c.profile
+		} else {
+			c.logf("[WARNING][%s] acme: The CA does not advertise the certificate profile %q, ignoring it.", commonName.Domain, c.profile)
+		}
+	}
+	if !c.notBefore.IsZero() {
+		msg.NotBefore = c.notBefore.UTC().Format(time.RFC3339)
+	}
+	if !c.notAfter.IsZero() {
+		msg.NotAfter = c.notAfter.UTC().Format(time.RFC3339)
+	}
+
+	jsonBytes, err := json.Marshal(msg)
 	if err != nil {
 		return CertificateResource{}, err
 	}
@@ -640,6 +1453,19 @@ func (c *Client) requestCertificateForCsr(authz []authorizationResource, bundle
 		PrivateKey: privateKeyPem,
 	}
 
+	interval := c.certPollInterval
+	if interval <= 0 {
+		interval = defaultCertPollInterval
+	}
+	maxWait := maxCertPollDelay
+	if interval > maxWait {
+		maxWait = interval
+	}
+	var deadline time.Time
+	if c.certPollTimeout > 0 {
+		deadline = time.Now().Add(c.certPollTimeout)
+	}
+
 	maxChecks := 1000
 	for i := 0; i < maxChecks; i++ {
 		done, err := c.checkCertResponse(resp, &certRes, bundle)
@@ -653,7 +1479,29 @@ func (c *Client) requestCertificateForCsr(authz []authorizationResource, bundle
 		if i == maxChecks-1 {
 			return CertificateResource{}, fmt.Errorf("polled for certificate %d times; giving up", i)
 		}
-		resp, err = httpGet(certRes.CertURL)
+
+		// The certificate was granted but is not yet issued.
+		// Check retry-after and loop.
+		wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"))
+		if !ok {
+			wait = interval
+		}
+		if wait > maxWait {
+			wait = maxWait
+		}
+		if !deadline.IsZero() {
+			remaining := deadline.Sub(time.Now())
+			if remaining <= 0 {
+				return CertificateResource{}, fmt.Errorf("[%s] acme: Certificate was not issued within %v", certRes.Domain, c.certPollTimeout)
+			}
+			if wait > remaining {
+				wait = remaining
+			}
+		}
+		c.logf("[INFO][%s] acme: Server responded with status %d; retrying after %v", certRes.Domain, resp.StatusCode, wait)
+		time.Sleep(wait)
+
+		resp, err = fetch(c.jws, certRes.CertURL)
 		if err != nil {
 			return CertificateResource{}, err
 		}
@@ -664,11 +1512,10 @@ func (c *Client) requestCertificateForCsr(authz []authorizationResource, bundle
 
 // checkCertResponse checks resp to see if a certificate is contained in the
 // response, and if so, loads it into certRes and returns true. If the cert
-// is not yet ready, it returns false. This function honors the waiting period
-// required by the Retry-After header of the response, if specified. This
-// function may read from resp.Body but does NOT close it. The certRes input
-// should already have the Domain (common name) field populated. If bundle is
-// true, the certificate will be bundled with the issuer's cert.
+// is not yet ready, it returns false. This function may read from resp.Body
+// but does NOT close it. The certRes input should already have the Domain
+// (common name) field populated. If bundle is true, the certificate will be
+// bundled with the issuer's cert.
 func (c *Client) checkCertResponse(resp *http.Response, certRes *CertificateResource, bundle bool) (bool, error) {
 	switch resp.StatusCode {
 	case 201, 202:
@@ -692,34 +1539,29 @@ func (c *Client) checkCertResponse(resp *http.Response, certRes *CertificateReso
 			issuerCert, err := c.getIssuerCertificate(links["up"])
 			if err != nil {
 				// If we fail to acquire the issuer cert, return the issued certificate - do not fail.
-				logf("[WARNING][%s] acme: Could not bundle issuer certificate: %v", certRes.Domain, err)
+				c.logf("[WARNING][%s] acme: Could not bundle issuer certificate: %v", certRes.Domain, err)
 			} else {
-				issuerCert = pemEncode(derCertificateBytes(issuerCert))
-
 				// If bundle is true, we want to return a certificate bundle.
-				// To do this, we append the issuer cert to the issued cert.
+				// To do this, we append the issuer cert to the issued cert,
+				// making sure the chain is ordered from the leaf upwards.
 				if bundle {
-					issuedCert = append(issuedCert, issuerCert...)
+					chain, err := bundleChain(cert, issuerCert)
+					if err != nil {
+						c.logf("[WARNING][%s] acme: Could not verify the certificate chain: %v", certRes.Domain, err)
+						chain = append(issuedCert, pemEncode(derCertificateBytes(issuerCert))...)
+					}
+					issuedCert = chain
 				}
+
+				issuerCert = pemEncode(derCertificateBytes(issuerCert))
 			}
 
 			certRes.Certificate = issuedCert
 			certRes.IssuerCertificate = issuerCert
-			logf("[INFO][%s] Server responded with a certificate.", certRes.Domain)
+			c.logf("[INFO][%s] Server responded with a certificate.", certRes.Domain)
 			return true, nil
 		}
 
-		// The certificate was granted but is not yet issued.
-		// Check retry-after and loop.
-		ra := resp.Header.Get("Retry-After")
-		retryAfter, err := strconv.Atoi(ra)
-		if err != nil {
-			return false, err
-		}
-
-		logf("[INFO][%s] acme: Server responded with status 202; retrying after %ds", certRes.Domain, retryAfter)
-		time.Sleep(time.Duration(retryAfter) * time.Second)
-
 		return false, nil
 	default:
 		return false, handleHTTPError(resp)
@@ -728,8 +1570,8 @@ func (c *Client) checkCertResponse(resp *http.Response, certRes *CertificateReso
 
 // getIssuerCertificate requests the issuer certificate
 func (c *Client) getIssuerCertificate(url string) ([]byte, error) {
-	logf("[INFO] acme: Requesting issuer cert from %s", url)
-	resp, err := httpGet(url)
+	c.logf("[INFO] acme: Requesting issuer cert from %s", url)
+	resp, err := fetch(c.jws, url)
 	if err != nil {
 		return nil, err
 	}
@@ -769,8 +1611,19 @@ func parseLinks(links []string) map[string]string {
 
 // validate makes the ACME server start validating a
 // challenge response, only returning once it is done.
-func validate(j *jws, domain, uri string, chlng challenge) error {
-	var challengeResponse challenge
+func validate(j *jws, domain, uri string, chlng AuthorizationChallenge) error {
+	return validateWithTimeout(j, domain, uri, chlng, 0, logf)
+}
+
+// validateWithTimeout works like validate, but gives up once the challenge
+// has not become valid within timeout. A timeout of zero waits indefinitely.
+// Progress is reported to log.
+func validateWithTimeout(j *jws, domain, uri string, chlng AuthorizationChallenge, timeout time.Duration, log func(format string, args ...interface{})) error {
+	var challengeResponse AuthorizationChallenge
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
 
 	hdr, err := postJSON(j, uri, chlng, &challengeResponse)
 	if err != nil {
@@ -782,11 +1635,12 @@ func validate(j *jws, domain, uri string, chlng challenge) error {
 	for {
 		switch challengeResponse.Status {
 		case "valid":
-			logf("[INFO][%s] The server validated our request", domain)
+			log("[INFO][%s] The server validated our request", domain)
 			return nil
 		case "pending":
 			break
 		case "invalid":
+			log("[ERROR][%s] acme: The server could not validate %s", domain, chlng.Type)
 			return handleChallengeError(challengeResponse)
 		default:
 			return errors.New("The server returned an unexpected state.")
@@ -798,9 +1652,22 @@ func validate(j *jws, domain, uri string, chlng challenge) error {
 			// If it doesn't, we'll just poll hard.
 			ra = 1
 		}
-		time.Sleep(time.Duration(ra) * time.Second)
+		wait := time.Duration(ra) * time.Second
+
+		if !deadline.IsZero() {
+			remaining := deadline.Sub(time.Now())
+			if remaining <= 0 {
+				log("[ERROR][%s] acme: Challenge %s did not become valid within %v", domain, chlng.Type, timeout)
+				return fmt.Errorf("[%s] acme: Challenge %s did not become valid within %v", domain, chlng.Type, timeout)
+			}
+			if wait > remaining {
+				wait = remaining
+			}
+		}
+		log("[INFO][%s] acme: Waiting %v for the server to validate %s", domain, wait, chlng.Type)
+		time.Sleep(wait)
 
-		hdr, err = getJSON(uri, &challengeResponse)
+		hdr, err = fetchJSON(j, uri, &challengeResponse)
 		if err != nil {
 			return err
 		}
@@ -1,14 +1,25 @@
 package acme
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -27,7 +38,7 @@ func TestNewClient(t *testing.T) {
 	}
 
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		data, _ := json.Marshal(directory{NewAuthzURL: "http://test", NewCertURL: "http://test", NewRegURL: "http://test", RevokeCertURL: "http://test"})
+		data, _ := json.Marshal(Directory{NewAuthzURL: "http://test", NewCertURL: "http://test", NewRegURL: "http://test", RevokeCertURL: "http://test"})
 		w.Write(data)
 	}))
 
@@ -52,6 +63,105 @@ func TestNewClient(t *testing.T) {
 	}
 }
 
+func TestNewClientRetriesDirectory(t *testing.T) {
+	defer func(delay time.Duration) { directoryRetryDelay = delay }(directoryRetryDelay)
+	directoryRetryDelay = time.Millisecond
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{email: "test@test.com", regres: new(RegistrationResource), privatekey: key}
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			http.Error(w, "maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		data, _ := json.Marshal(Directory{NewAuthzURL: "http://test", NewCertURL: "http://test", NewRegURL: "http://test", RevokeCertURL: "http://test"})
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	if _, err := NewClient(ts.URL, user, RSA2048); err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected the directory to be requested twice, got %d requests", requests)
+	}
+
+	// Errors of the client are not retried, and neither are transient
+	// failures after DirectoryTimeout.
+	for _, status := range []int{http.StatusNotFound, http.StatusServiceUnavailable} {
+		requests = 0
+		ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			http.Error(w, "unavailable", status)
+		})
+		defer func(timeout time.Duration) { DirectoryTimeout = timeout }(DirectoryTimeout)
+		DirectoryTimeout = 20 * time.Millisecond
+		if _, err := NewClient(ts.URL, user, RSA2048); err == nil {
+			t.Errorf("Expected an error for HTTP %d", status)
+		}
+		if status == http.StatusNotFound && requests != 1 {
+			t.Errorf("Expected HTTP %d not to be retried, got %d requests", status, requests)
+		}
+		if status == http.StatusServiceUnavailable && requests < 2 {
+			t.Errorf("Expected HTTP %d to be retried, got %d requests", status, requests)
+		}
+	}
+}
+
+func TestGetDirectory(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     new(RegistrationResource),
+		privatekey: key,
+	}
+
+	expected := DirectoryMeta{
+		TermsOfService:          "https://example.com/terms",
+		Website:                 "https://example.com",
+		CAAIdentities:           []string{"example.com", "example.org"},
+		ExternalAccountRequired: true,
+	}
+	for _, doc := range []string{
+		`{"new-reg": "http://test", "new-authz": "http://test", "new-cert": "http://test", "revoke-cert": "http://test",
+		  "meta": {"termsOfService": "https://example.com/terms", "website": "https://example.com",
+		           "caaIdentities": ["example.com", "example.org"], "externalAccountRequired": true}}`,
+		`{"new-reg": "http://test", "new-authz": "http://test", "new-cert": "http://test", "revoke-cert": "http://test",
+		  "meta": {"terms-of-service": "https://example.com/terms", "website": "https://example.com",
+		           "caa-identities": ["example.com", "example.org"], "externalAccountRequired": true}}`,
+	} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(doc))
+		}))
+
+		client, err := NewClient(ts.URL, user, RSA2048)
+		if err != nil {
+			t.Fatalf("Could not create client: %v", err)
+		}
+		dir, err := client.GetDirectory()
+		ts.Close()
+		if err != nil {
+			t.Fatalf("Could not get directory: %v", err)
+		}
+
+		if dir.NewRegURL != "http://test" {
+			t.Errorf("Expected new-reg URL %q but got %q", "http://test", dir.NewRegURL)
+		}
+		if !reflect.DeepEqual(dir.Meta, expected) {
+			t.Errorf("Expected meta data %+v but got %+v", expected, dir.Meta)
+		}
+	}
+}
+
 func TestClientOptPort(t *testing.T) {
 	keyBits := 32 // small value keeps test fast
 	key, err := rsa.GenerateKey(rand.Reader, keyBits)
@@ -65,7 +175,7 @@ func TestClientOptPort(t *testing.T) {
 	}
 
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		data, _ := json.Marshal(directory{NewAuthzURL: "http://test", NewCertURL: "http://test", NewRegURL: "http://test", RevokeCertURL: "http://test"})
+		data, _ := json.Marshal(Directory{NewAuthzURL: "http://test", NewCertURL: "http://test", NewRegURL: "http://test", RevokeCertURL: "http://test"})
 		w.Write(data)
 	}))
 
@@ -124,7 +234,7 @@ func TestNotHoldingLockWhileMakingHTTPRequests(t *testing.T) {
 		time.Sleep(250 * time.Millisecond)
 		w.Header().Add("Replay-Nonce", "12345")
 		w.Header().Add("Retry-After", "0")
-		writeJSONResponse(w, &challenge{Type: "http-01", Status: "Valid", URI: "http://example.com/", Token: "token"})
+		writeJSONResponse(w, &AuthorizationChallenge{Type: "http-01", Status: "Valid", URI: "http://example.com/", Token: "token"})
 	}))
 	defer ts.Close()
 
@@ -163,12 +273,12 @@ func TestValidate(t *testing.T) {
 		case "POST":
 			st := statuses[0]
 			statuses = statuses[1:]
-			writeJSONResponse(w, &challenge{Type: "http-01", Status: st, URI: "http://example.com/", Token: "token"})
+			writeJSONResponse(w, &AuthorizationChallenge{Type: "http-01", Status: st, URI: "http://example.com/", Token: "token"})
 
 		case "GET":
 			st := statuses[0]
 			statuses = statuses[1:]
-			writeJSONResponse(w, &challenge{Type: "http-01", Status: st, URI: "http://example.com/", Token: "token"})
+			writeJSONResponse(w, &AuthorizationChallenge{Type: "http-01", Status: st, URI: "http://example.com/", Token: "token"})
 
 		default:
 			http.Error(w, r.Method, http.StatusMethodNotAllowed)
@@ -194,7 +304,7 @@ func TestValidate(t *testing.T) {
 
 	for _, tst := range tsts {
 		statuses = tst.statuses
-		if err := validate(j, "example.com", ts.URL, challenge{Type: "http-01", Token: "token"}); err == nil && tst.want != "" {
+		if err := validate(j, "example.com", ts.URL, AuthorizationChallenge{Type: "http-01", Token: "token"}); err == nil && tst.want != "" {
 			t.Errorf("[%s] validate: got error %v, want something with %q", tst.name, err, tst.want)
 		} else if err != nil && !strings.Contains(err.Error(), tst.want) {
 			t.Errorf("[%s] validate: got error %v, want something with %q", tst.name, err, tst.want)
@@ -202,6 +312,38 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestValidateTimeout(t *testing.T) {
+	var polls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Stub ACME server which never finishes the validation.
+		w.Header().Add("Replay-Nonce", "12345")
+		w.Header().Add("Retry-After", "1")
+		if r.Method == "GET" {
+			polls++
+		}
+		writeJSONResponse(w, &AuthorizationChallenge{Type: "http-01", Status: "pending", URI: "http://example.com/", Token: "token"})
+	}))
+	defer ts.Close()
+
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	j := &jws{privKey: privKey, directoryURL: ts.URL}
+
+	timeout := 300 * time.Millisecond
+	start := time.Now()
+	err := validateWithTimeout(j, "example.com", ts.URL, AuthorizationChallenge{Type: "http-01", Token: "token"}, timeout, logf)
+	elapsed := time.Since(start)
+
+	if err == nil || !strings.Contains(err.Error(), "did not become valid") {
+		t.Errorf("Expected a timeout error but got %v", err)
+	}
+	if elapsed < timeout || elapsed > 2*time.Second {
+		t.Errorf("Expected validation to give up after %v but it took %v", timeout, elapsed)
+	}
+	if polls != 1 {
+		t.Errorf("Expected the challenge to be polled once within the timeout but got %d polls", polls)
+	}
+}
+
 func TestGetChallenges(t *testing.T) {
 	var ts *httptest.Server
 	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -209,9 +351,9 @@ func TestGetChallenges(t *testing.T) {
 		case "GET", "HEAD":
 			w.Header().Add("Replay-Nonce", "12345")
 			w.Header().Add("Retry-After", "0")
-			writeJSONResponse(w, directory{NewAuthzURL: ts.URL, NewCertURL: ts.URL, NewRegURL: ts.URL, RevokeCertURL: ts.URL})
+			writeJSONResponse(w, Directory{NewAuthzURL: ts.URL, NewCertURL: ts.URL, NewRegURL: ts.URL, RevokeCertURL: ts.URL})
 		case "POST":
-			writeJSONResponse(w, authorization{})
+			writeJSONResponse(w, Authorization{})
 		}
 	}))
 	defer ts.Close()
@@ -239,6 +381,259 @@ func TestGetChallenges(t *testing.T) {
 	}
 }
 
+func TestGetAuthorization(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/directory":
+			writeJSONResponse(w, Directory{NewAuthzURL: ts.URL, NewCertURL: ts.URL, NewRegURL: ts.URL, RevokeCertURL: ts.URL})
+		case "/authz/1":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{
+				"identifier": {"type": "dns", "value": "example.com"},
+				"status": "invalid",
+				"expires": "2016-08-01T12:00:00Z",
+				"challenges": [{
+					"type": "http-01",
+					"status": "invalid",
+					"uri": "` + ts.URL + `/challenge/1",
+					"token": "token",
+					"error": {
+						"type": "urn:acme:error:unauthorized",
+						"detail": "Invalid response from http://example.com/.well-known/acme-challenge/token",
+						"status": 403
+					},
+					"validationRecord": [{
+						"url": "http://example.com/.well-known/acme-challenge/token",
+						"hostname": "example.com",
+						"port": "80",
+						"addressesResolved": ["192.0.2.1"],
+						"addressUsed": "192.0.2.1"
+					}]
+				}, {
+					"type": "dns-01",
+					"status": "pending",
+					"uri": "` + ts.URL + `/challenge/2",
+					"token": "token"
+				}],
+				"combinations": [[0], [1]]
+			}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     new(RegistrationResource),
+		privatekey: key,
+	}
+
+	client, err := NewClient(ts.URL+"/directory", user, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	authz, err := client.GetAuthorization(ts.URL + "/authz/1")
+	if err != nil {
+		t.Fatalf("Could not get authorization: %v", err)
+	}
+
+	if authz.Identifier.Value != "example.com" {
+		t.Errorf("Expected identifier to be example.com but was %s", authz.Identifier.Value)
+	}
+	if authz.Status != "invalid" {
+		t.Errorf("Expected status to be invalid but was %s", authz.Status)
+	}
+	if expected, actual := 2, len(authz.Challenges); actual != expected {
+		t.Fatalf("Expected %d challenge(s), got %d", expected, actual)
+	}
+
+	failed := authz.Challenges[0]
+	if failed.Type != HTTP01 || failed.Status != "invalid" {
+		t.Errorf("Expected an invalid http-01 challenge but got %s with status %s", failed.Type, failed.Status)
+	}
+	if failed.Error.Type != "urn:acme:error:unauthorized" {
+		t.Errorf("Expected error type urn:acme:error:unauthorized but was %s", failed.Error.Type)
+	}
+	if failed.Error.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected error status %d but was %d", http.StatusForbidden, failed.Error.StatusCode)
+	}
+	if !strings.Contains(failed.Error.Detail, "Invalid response") {
+		t.Errorf("Expected error detail to contain the reason but was %q", failed.Error.Detail)
+	}
+	if expected, actual := 1, len(failed.ValidationRecords); actual != expected {
+		t.Fatalf("Expected %d validation record(s), got %d", expected, actual)
+	}
+	if failed.ValidationRecords[0].UsedAddress != "192.0.2.1" {
+		t.Errorf("Expected used address 192.0.2.1 but was %s", failed.ValidationRecords[0].UsedAddress)
+	}
+
+	if pending := authz.Challenges[1]; pending.Type != DNS01 || pending.Status != "pending" {
+		t.Errorf("Expected a pending dns-01 challenge but got %s with status %s", pending.Type, pending.Status)
+	}
+
+	if _, err := client.GetAuthorization(ts.URL + "/authz/unknown"); err == nil {
+		t.Error("Expected an error for an unknown authorization but got none")
+	}
+}
+
+func TestGetChallengesMixedIdentifiers(t *testing.T) {
+	ca := newMockCA(t)
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	domains := []string{"example.com", "192.0.2.1", "www.example.com", "2001:db8::1"}
+	challenges, failures := client.getChallenges(domains)
+	if len(failures) > 0 {
+		t.Fatalf("Expected no failures but got %v", failures)
+	}
+	if expected, actual := len(domains), len(challenges); actual != expected {
+		t.Fatalf("Expected %d authorization(s), got %d", expected, actual)
+	}
+
+	identifiers := make(map[string]string)
+	ca.mu.Lock()
+	for _, ident := range ca.identifiers {
+		identifiers[ident.Value] = ident.Type
+	}
+	ca.mu.Unlock()
+
+	expected := map[string]string{
+		"example.com":     "dns",
+		"192.0.2.1":       "ip",
+		"www.example.com": "dns",
+		"2001:db8::1":     "ip",
+	}
+	for value, typ := range expected {
+		if got := identifiers[value]; got != typ {
+			t.Errorf("Expected identifier %s to be of type %q but was %q", value, typ, got)
+		}
+	}
+}
+
+func TestObtainCertificateKeyTypes(t *testing.T) {
+	ca := newMockCA(t)
+	defer ca.Close()
+
+	accountKey, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: accountKey,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	if err := client.SetCertificateKeyType(EC256); err != nil {
+		t.Fatalf("Could not set certificate key type: %v", err)
+	}
+	if err := client.SetCertificateKeyType(KeyType("P521")); err == nil {
+		t.Error("Expected an error setting an unsupported key type but got none")
+	}
+
+	cert, failures := client.ObtainCertificate([]string{"example.com"}, false, nil, false)
+	if len(failures) > 0 {
+		t.Fatalf("Expected no failures but got %v", failures)
+	}
+
+	leaf, err := pemDecodeTox509(cert.Certificate)
+	if err != nil {
+		t.Fatalf("Could not parse certificate: %v", err)
+	}
+	if leaf.PublicKeyAlgorithm != x509.ECDSA {
+		t.Errorf("Expected an ECDSA certificate but got %v", leaf.PublicKeyAlgorithm)
+	}
+
+	privKey, err := parsePEMPrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("Could not parse certificate key: %v", err)
+	}
+	if _, ok := privKey.(*ecdsa.PrivateKey); !ok {
+		t.Errorf("Expected an ECDSA certificate key but got %T", privKey)
+	}
+
+	if _, ok := client.jws.privKey.(*rsa.PrivateKey); !ok {
+		t.Errorf("Expected the account key to remain RSA but got %T", client.jws.privKey)
+	}
+}
+
+func TestObtainCertificateNormalizesDomains(t *testing.T) {
+	ca := newMockCA(t)
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	cert, failures := client.ObtainCertificate([]string{"Example.COM.", "例え.テスト"}, false, nil, false)
+	if len(failures) > 0 {
+		t.Fatalf("Expected no failures but got %v", failures)
+	}
+	if cert.Domain != "example.com" {
+		t.Errorf("Expected certificate domain example.com but was %s", cert.Domain)
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if len(ca.identifiers) != 2 {
+		t.Fatalf("Expected 2 authorizations but got %d", len(ca.identifiers))
+	}
+	values := map[string]bool{}
+	for _, ident := range ca.identifiers {
+		values[ident.Value] = true
+	}
+	for _, domain := range []string{"example.com", "xn--r8jz45g.xn--zckzah"} {
+		if !values[domain] {
+			t.Errorf("Expected an authorization for %s but got %v", domain, ca.identifiers)
+		}
+	}
+
+	csr := ca.csrs[0]
+	if csr.Subject.CommonName != "example.com" {
+		t.Errorf("Expected CSR common name example.com but was %s", csr.Subject.CommonName)
+	}
+	if len(csr.DNSNames) != 1 || csr.DNSNames[0] != "xn--r8jz45g.xn--zckzah" {
+		t.Errorf("Expected CSR DNS names [xn--r8jz45g.xn--zckzah] but got %v", csr.DNSNames)
+	}
+}
+
 // writeJSONResponse marshals the body as JSON and writes it to the response.
 func writeJSONResponse(w http.ResponseWriter, body interface{}) {
 	bs, err := json.Marshal(body)
@@ -254,7 +649,7 @@ func writeJSONResponse(w http.ResponseWriter, body interface{}) {
 }
 
 // stubValidate is like validate, except it does nothing.
-func stubValidate(j *jws, domain, uri string, chlng challenge) error {
+func stubValidate(j *jws, domain, uri string, chlng AuthorizationChallenge) error {
 	return nil
 }
 
@@ -267,3 +662,1025 @@ type mockUser struct {
 func (u mockUser) GetEmail() string                       { return u.email }
 func (u mockUser) GetRegistration() *RegistrationResource { return u.regres }
 func (u mockUser) GetPrivateKey() crypto.PrivateKey       { return u.privatekey }
+
+func TestObtainCertificateProfile(t *testing.T) {
+	ca := newMockCA(t)
+	ca.profiles = map[string]string{"shortlived": "Certificates valid for 6 days"}
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	notBefore := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := notBefore.Add(6 * 24 * time.Hour)
+	if err := client.SetCertificateValidity(notAfter, notBefore); err == nil {
+		t.Error("Expected an error setting notAfter before notBefore but got none")
+	}
+	if err := client.SetCertificateValidity(notBefore, notAfter); err != nil {
+		t.Fatalf("Could not set certificate validity: %v", err)
+	}
+
+	for _, profile := range []string{"shortlived", "unknown"} {
+		client.SetCertificateProfile(profile)
+		if _, failures := client.ObtainCertificate([]string{"example.com"}, false, nil, false); len(failures) > 0 {
+			t.Fatalf("Expected no failures but got %v", failures)
+		}
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if len(ca.certRequests) != 2 {
+		t.Fatalf("Expected 2 certificate requests but got %d", len(ca.certRequests))
+	}
+	if ca.certRequests[0].Profile != "shortlived" {
+		t.Errorf("Expected profile shortlived but was %q", ca.certRequests[0].Profile)
+	}
+	if ca.certRequests[1].Profile != "" {
+		t.Errorf("Expected the unadvertised profile to be ignored but was %q", ca.certRequests[1].Profile)
+	}
+	for _, msg := range ca.certRequests {
+		if msg.NotBefore != "2030-01-01T00:00:00Z" || msg.NotAfter != "2030-01-07T00:00:00Z" {
+			t.Errorf("Expected validity 2030-01-01T00:00:00Z - 2030-01-07T00:00:00Z but was %s - %s", msg.NotBefore, msg.NotAfter)
+		}
+	}
+}
+
+// recordingProvider is a ChallengeProvider which records the domains it was
+// asked to present and clean up challenges for.
+type recordingProvider struct {
+	mu      sync.Mutex
+	present []string
+	cleanUp []string
+}
+
+func (p *recordingProvider) Present(domain, token, keyAuth string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.present = append(p.present, domain)
+	return nil
+}
+
+func (p *recordingProvider) CleanUp(domain, token, keyAuth string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cleanUp = append(p.cleanUp, domain)
+	return nil
+}
+
+// failingProvider is a recordingProvider whose Present fails for the domain
+// fail.
+type failingProvider struct {
+	recordingProvider
+	fail string
+}
+
+func (p *failingProvider) Present(domain, token, keyAuth string) error {
+	p.recordingProvider.Present(domain, token, keyAuth)
+	if domain == p.fail {
+		return fmt.Errorf("Could not present %s", domain)
+	}
+	return nil
+}
+
+func TestObtainCertificateCleansUpAfterFailure(t *testing.T) {
+	ca := newMockCA(t)
+	ca.pending = true
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	provider := &failingProvider{fail: "c.example.com"}
+	if err := client.SetChallengeProvider(HTTP01, provider); err != nil {
+		t.Fatalf("Could not set challenge provider: %v", err)
+	}
+
+	cert, failures := client.ObtainCertificate([]string{"a.example.com", "b.example.com", "c.example.com"}, false, nil, false)
+	if cert.Certificate != nil {
+		t.Error("Expected no certificate to be issued")
+	}
+	if _, ok := failures["c.example.com"]; !ok || len(failures) != 1 {
+		t.Errorf("Expected c.example.com to fail but got %v", failures)
+	}
+
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	sort.Strings(provider.present)
+	sort.Strings(provider.cleanUp)
+	if expected := []string{"a.example.com", "b.example.com", "c.example.com"}; !reflect.DeepEqual(provider.present, expected) {
+		t.Errorf("Expected challenges to be presented for %v but got %v", expected, provider.present)
+	}
+	if expected := []string{"a.example.com", "b.example.com"}; !reflect.DeepEqual(provider.cleanUp, expected) {
+		t.Errorf("Expected challenges to be cleaned up for %v but got %v", expected, provider.cleanUp)
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if len(ca.certRequests) != 0 {
+		t.Errorf("Expected no certificate request but got %d", len(ca.certRequests))
+	}
+}
+
+func TestObtainCertificateDryRun(t *testing.T) {
+	ca := newMockCA(t)
+	ca.pending = true
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	provider := &recordingProvider{}
+	if err := client.SetChallengeProvider(HTTP01, provider); err != nil {
+		t.Fatalf("Could not set challenge provider: %v", err)
+	}
+	client.SetDryRun(true)
+
+	cert, failures := client.ObtainCertificate([]string{"example.com", "www.example.com"}, true, nil, false)
+	if len(failures) > 0 {
+		t.Fatalf("Expected no failures but got %v", failures)
+	}
+	if cert.Certificate != nil || cert.PrivateKey != nil {
+		t.Error("Expected no certificate and private key in dry-run mode")
+	}
+	expected := map[string][]Challenge{"example.com": {HTTP01}, "www.example.com": {HTTP01}}
+	if !reflect.DeepEqual(cert.Challenges, expected) {
+		t.Errorf("Expected challenges %v but got %v", expected, cert.Challenges)
+	}
+	if len(provider.present) != 2 || len(provider.cleanUp) != 2 {
+		t.Errorf("Expected 2 presented and cleaned up challenges but got %v and %v", provider.present, provider.cleanUp)
+	}
+
+	// An empty list of domains is rejected, also in strict mode.
+	if _, failures := client.ObtainCertificate(nil, true, nil, false); failures[""] != errNoDomains {
+		t.Errorf("Expected %v for no domains but got %v", errNoDomains, failures)
+	}
+	client.SetStrict(true)
+	if certs, failures := client.ObtainCertificates([]string{}, true, nil, false); len(certs) != 0 || failures[""] != errNoDomains {
+		t.Errorf("Expected %v for no domains in strict mode but got %v and %v", errNoDomains, certs, failures)
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if len(ca.validations) != 0 {
+		t.Errorf("Expected no challenge validation in dry-run mode but got %d", len(ca.validations))
+	}
+	if len(ca.certRequests) != 0 {
+		t.Errorf("Expected no certificate request in dry-run mode but got %d", len(ca.certRequests))
+	}
+}
+
+func TestObtainCertificateValidatesChallenges(t *testing.T) {
+	ca := newMockCA(t)
+	ca.pending = true
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	if err := client.SetChallengeProvider(HTTP01, &recordingProvider{}); err != nil {
+		t.Fatalf("Could not set challenge provider: %v", err)
+	}
+
+	cert, failures := client.ObtainCertificate([]string{"example.com"}, false, nil, false)
+	if len(failures) > 0 {
+		t.Fatalf("Expected no failures but got %v", failures)
+	}
+	if cert.Certificate == nil || cert.Challenges != nil {
+		t.Error("Expected a certificate and no dry-run challenges")
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if len(ca.validations) != 1 || len(ca.certRequests) != 1 {
+		t.Errorf("Expected 1 validation and 1 certificate request but got %d and %d", len(ca.validations), len(ca.certRequests))
+	}
+}
+
+func TestObtainCertificateForceNewAuthorizations(t *testing.T) {
+	ca := newMockCA(t)
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	provider := &recordingProvider{}
+	if err := client.SetChallengeProvider(HTTP01, provider); err != nil {
+		t.Fatalf("Could not set challenge provider: %v", err)
+	}
+	client.SetForceNewAuthorizations(true)
+
+	_, failures := client.ObtainCertificate([]string{"example.com"}, false, nil, false)
+	if len(failures) > 0 {
+		t.Fatalf("Expected no failures but got %v", failures)
+	}
+	if len(provider.present) != 1 {
+		t.Errorf("Expected the challenge to be solved again but got %v", provider.present)
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if len(ca.identifiers) != 2 {
+		t.Errorf("Expected a second new-authz request but got %d", len(ca.identifiers))
+	}
+	if !reflect.DeepEqual(ca.deactivations, []string{"example.com"}) {
+		t.Errorf("Expected the reused authorization to be deactivated but got %v", ca.deactivations)
+	}
+	if len(ca.validations) != 1 {
+		t.Errorf("Expected 1 validation but got %d", len(ca.validations))
+	}
+}
+
+func TestObtainCertificateWithoutTLSSNI(t *testing.T) {
+	ca := newMockCA(t)
+	ca.pending = true
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	if _, ok := client.solvers[TLSSNI01]; !ok {
+		t.Fatal("Expected the tls-sni-01 solver to be enabled by default")
+	}
+	provider := &recordingProvider{}
+	if err := client.SetChallengeProvider(HTTP01, provider); err != nil {
+		t.Fatalf("Could not set challenge provider: %v", err)
+	}
+
+	var logs bytes.Buffer
+	client.SetLogger(log.New(&logs, "", 0))
+
+	cert, failures := client.ObtainCertificate([]string{"example.com", "www.example.com"}, false, nil, false)
+	if len(failures) > 0 {
+		t.Fatalf("Expected no failures but got %v", failures)
+	}
+	if cert.Certificate == nil {
+		t.Error("Expected a certificate to be issued")
+	}
+	if len(provider.present) != 2 {
+		t.Errorf("Expected the http-01 challenge to be used for both domains but got %v", provider.present)
+	}
+	if n := strings.Count(logs.String(), "deprecated tls-sni-01"); n != 1 {
+		t.Errorf("Expected the deprecation notice to be logged once but got it %d times", n)
+	}
+}
+
+// recordingLogger is a StdLogger which records the formatted log entries.
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func TestClientSetLogger(t *testing.T) {
+	ca := newMockCA(t)
+	ca.pending = true
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	if err := client.SetChallengeProvider(HTTP01, &recordingProvider{}); err != nil {
+		t.Fatalf("Could not set challenge provider: %v", err)
+	}
+
+	var global bytes.Buffer
+	Logger = log.New(&global, "", 0)
+	defer func() { Logger = nil }()
+
+	logger := &recordingLogger{}
+	client.SetLogger(logger)
+
+	if _, failures := client.ObtainCertificate([]string{"example.com"}, false, nil, false); len(failures) > 0 {
+		t.Fatalf("Expected no failures but got %v", failures)
+	}
+
+	logs := strings.Join(logger.lines, "\n")
+	for _, expected := range []string{
+		"[INFO][example.com] AuthURL: " + ca.URL + "/authz/1",
+		"[INFO][example.com] acme: Solving http-01 challenge",
+		"[INFO][example.com] acme: Trying to solve HTTP-01",
+		"[INFO][example.com] The server validated our request",
+		"[INFO][example.com] acme: Validations succeeded; requesting certificates",
+		"[INFO][example.com] Server responded with a certificate.",
+	} {
+		if !strings.Contains(logs, expected) {
+			t.Errorf("Expected log entry %q but got:\n%s", expected, logs)
+		}
+	}
+	for _, line := range logger.lines {
+		if strings.Contains(global.String(), line) {
+			t.Errorf("Expected client log entry %q not to be written to the package Logger", line)
+		}
+	}
+}
+
+func TestClientLoggerChallengeServers(t *testing.T) {
+	ca := newMockCA(t)
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	logger := &recordingLogger{}
+	client.SetLogger(logger)
+
+	provider := NewCombined443Provider("127.0.0.1", "0")
+	if err := client.SetChallengeProvider(HTTP01, provider); err != nil {
+		t.Fatalf("Could not set challenge provider: %v", err)
+	}
+	if err := provider.Present("example.com", "token", "keyAuth"); err != nil {
+		t.Fatalf("Could not present challenge: %v", err)
+	}
+	addr := provider.Addr().String()
+	httpClient := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{ServerName: "example.com", InsecureSkipVerify: true},
+	}}
+	resp, err := httpClient.Get("https://" + addr + HTTP01ChallengePath("token"))
+	if err != nil {
+		t.Fatalf("Could not fetch the key authorization: %v", err)
+	}
+	resp.Body.Close()
+	if err := provider.CleanUp("example.com", "token", "keyAuth"); err != nil {
+		t.Fatalf("Could not clean up challenge: %v", err)
+	}
+
+	if err := client.SetHTTPAddress("127.0.0.1:0"); err != nil {
+		t.Fatalf("Could not set HTTP address: %v", err)
+	}
+	client.solvers[HTTP01].(*httpChallenge).provider.(*HTTPProviderServer).logf("[INFO] HTTP-01 server")
+
+	logs := strings.Join(logger.lines, "\n")
+	for _, expected := range []string{
+		"[WARN] Received request for domain " + addr,
+		"[INFO] HTTP-01 server",
+	} {
+		if !strings.Contains(logs, expected) {
+			t.Errorf("Expected log entry %q but got:\n%s", expected, logs)
+		}
+	}
+
+	// Without a logger of its own the client writes to the package Logger.
+	var global bytes.Buffer
+	Logger = log.New(&global, "", 0)
+	defer func() { Logger = nil }()
+	client.SetLogger(nil)
+	client.logf("[INFO] acme: No client logger")
+	if global.String() != "[INFO] acme: No client logger\n" {
+		t.Errorf("Expected the log entry to be written to the package Logger but got %q", global.String())
+	}
+}
+
+func TestObtainCertificateTooManyNames(t *testing.T) {
+	ca := newMockCA(t)
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	var domains []string
+	for i := 0; i <= MaxNamesPerCertificate; i++ {
+		domains = append(domains, fmt.Sprintf("www%d.example.com", i))
+	}
+
+	_, failures := client.ObtainCertificate(domains, false, nil, false)
+	if len(failures) != 1 || failures["www0.example.com"] == nil {
+		t.Fatalf("Expected a single failure for www0.example.com but got %v", failures)
+	}
+	if msg := failures["www0.example.com"].Error(); !strings.Contains(msg, "101 names were requested, but the CA accepts at most 100 names per certificate") {
+		t.Errorf("Expected a descriptive error but got %q", msg)
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if len(ca.identifiers) != 0 {
+		t.Errorf("Expected no authorizations to be requested but got %d", len(ca.identifiers))
+	}
+}
+
+func TestObtainCertificates(t *testing.T) {
+	ca := newMockCA(t)
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	client.SetMaxNamesPerCertificate(2)
+
+	certs, failures := client.ObtainCertificates([]string{"a.example.com", "b.example.com", "c.example.com"}, false, nil, false)
+	if len(failures) > 0 {
+		t.Fatalf("Expected no failures but got %v", failures)
+	}
+	if len(certs) != 2 || certs[0].Domain != "a.example.com" || certs[1].Domain != "c.example.com" {
+		t.Fatalf("Expected certificates for a.example.com and c.example.com but got %d", len(certs))
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if len(ca.csrs) != 2 {
+		t.Fatalf("Expected 2 certificate requests but got %d", len(ca.csrs))
+	}
+	expected := [][]string{{"a.example.com", "b.example.com"}, {"c.example.com"}}
+	for i, csr := range ca.csrs {
+		names := append([]string{csr.Subject.CommonName}, csr.DNSNames...)
+		if !reflect.DeepEqual(names, expected[i]) {
+			t.Errorf("Expected certificate %d for %v but got %v", i, expected[i], names)
+		}
+	}
+}
+
+func TestObtainCertificatesStrict(t *testing.T) {
+	ca := newMockCA(t)
+	ca.pending = true
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	client.SetMaxNamesPerCertificate(2)
+	client.SetStrict(true)
+	if err := client.SetChallengeProvider(HTTP01, &failingProvider{fail: "c.example.com"}); err != nil {
+		t.Fatalf("Could not set challenge provider: %v", err)
+	}
+
+	certs, failures := client.ObtainCertificates([]string{"a.example.com", "b.example.com", "c.example.com"}, false, nil, false)
+	if len(certs) != 0 {
+		t.Errorf("Expected no certificates but got %d", len(certs))
+	}
+	if _, ok := failures["c.example.com"]; !ok || len(failures) != 1 {
+		t.Errorf("Expected c.example.com to fail but got %v", failures)
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if len(ca.csrs) != 0 {
+		t.Errorf("Expected no certificate requests but got %d", len(ca.csrs))
+	}
+}
+
+func TestObtainCertificatesStrictKeepsIssuedCertificates(t *testing.T) {
+	ca := newMockCA(t)
+	ca.rejectName = "c.example.com"
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	client.SetMaxNamesPerCertificate(2)
+	client.SetStrict(true)
+
+	certs, failures := client.ObtainCertificates([]string{"a.example.com", "b.example.com", "c.example.com", "d.example.com", "e.example.com"}, false, nil, false)
+	if len(certs) != 2 || certs[0].Domain != "a.example.com" || certs[1].Domain != "e.example.com" {
+		t.Errorf("Expected the certificates for a.example.com and e.example.com but got %d", len(certs))
+	}
+	if len(failures) != 2 || failures["c.example.com"] == nil || failures["d.example.com"] == nil {
+		t.Errorf("Expected c.example.com and d.example.com to fail but got %v", failures)
+	}
+}
+
+func TestObtainCertificateForCSRKeepsDNSNames(t *testing.T) {
+	ca := newMockCA(t)
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	client.SetMaxNamesPerCertificate(1)
+
+	// Spare capacity in the DNS names must not receive the IP addresses.
+	dnsNames := make([]string, 1, 4)
+	dnsNames[0] = "b.example.com"
+	csr := x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: "a.example.com"},
+		DNSNames:    dnsNames,
+		IPAddresses: []net.IP{net.ParseIP("192.0.2.1")},
+	}
+	if _, failures := client.ObtainCertificateForCSR(csr, false); len(failures) == 0 {
+		t.Error("Expected too many names to fail")
+	}
+	if spare := dnsNames[:2][1]; spare != "" {
+		t.Errorf("Expected the DNS names of the CSR to be left alone but %q was appended", spare)
+	}
+}
+
+func TestObtainCertificatePollsForCertificate(t *testing.T) {
+	ca := newMockCA(t)
+	ca.processing = 2
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	client.SetCertificatePolling(10*time.Millisecond, time.Second)
+
+	cert, failures := client.ObtainCertificate([]string{"example.com"}, false, nil, false)
+	if len(failures) > 0 {
+		t.Fatalf("Expected no failures but got %v", failures)
+	}
+	if cert.Certificate == nil {
+		t.Error("Expected a certificate to be issued")
+	}
+	if cert.CertURL != ca.URL+"/cert/2" {
+		t.Errorf("Expected the certificate URL %s/cert/2 but got %s", ca.URL, cert.CertURL)
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if ca.certPolls != 2 {
+		t.Errorf("Expected the certificate to be polled twice but got %d polls", ca.certPolls)
+	}
+}
+
+func TestObtainCertificatePollRetryAfterBounded(t *testing.T) {
+	defer func(delay time.Duration) { maxCertPollDelay = delay }(maxCertPollDelay)
+	maxCertPollDelay = 10 * time.Millisecond
+
+	ca := newMockCA(t)
+	ca.processing = 2
+	ca.retryAfter = "3600"
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	client.SetCertificatePolling(time.Millisecond, 0)
+
+	start := time.Now()
+	cert, failures := client.ObtainCertificate([]string{"example.com"}, false, nil, false)
+	if len(failures) > 0 {
+		t.Fatalf("Expected no failures but got %v", failures)
+	}
+	if cert.Certificate == nil {
+		t.Error("Expected a certificate to be issued")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the Retry-After of an hour to be bounded but polling took %v", elapsed)
+	}
+}
+
+func TestObtainCertificatePollTimeout(t *testing.T) {
+	ca := newMockCA(t)
+	ca.processing = 1000
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	client.SetCertificatePolling(10*time.Millisecond, 100*time.Millisecond)
+
+	cert, failures := client.ObtainCertificate([]string{"example.com"}, false, nil, false)
+	if cert.Certificate != nil {
+		t.Error("Expected no certificate to be returned")
+	}
+	if err := failures["example.com"]; err == nil || !strings.Contains(err.Error(), "was not issued within") {
+		t.Errorf("Expected a timeout error but got %v", failures)
+	}
+}
+
+func TestGetCertificate(t *testing.T) {
+	ca := newMockCA(t)
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	cert, failures := client.ObtainCertificate([]string{"example.com"}, false, nil, false)
+	if len(failures) > 0 {
+		t.Fatalf("Expected no failures but got %v", failures)
+	}
+	if cert.CertURL == "" {
+		t.Fatal("Expected the certificate URL to be set")
+	}
+
+	certPem, err := client.GetCertificate(cert.CertURL)
+	if err != nil {
+		t.Fatalf("Could not download the certificate: %v", err)
+	}
+	if !bytes.Equal(certPem, cert.Certificate) {
+		t.Errorf("Expected the downloaded certificate to equal the issued one but got %s", certPem)
+	}
+
+	if _, err := client.GetCertificate(ca.URL + "/cert/99"); err == nil {
+		t.Error("Expected an error for an unknown certificate URL")
+	}
+}
+
+func TestChooseSolversPreferenceOrder(t *testing.T) {
+	ca := newMockCA(t)
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	for _, challenge := range []Challenge{HTTP01, DNS01} {
+		if err := client.SetChallengeProvider(challenge, &recordingProvider{}); err != nil {
+			t.Fatalf("Could not set %s provider: %v", challenge, err)
+		}
+	}
+
+	auth := Authorization{
+		Identifier: Identifier{Type: "dns", Value: "example.com"},
+		Challenges: []AuthorizationChallenge{
+			{Type: HTTP01, Token: "token1"},
+			{Type: TLSSNI01, Token: "token2"},
+			{Type: DNS01, Token: "token3"},
+		},
+		Combinations: [][]int{{0}, {1}, {2}},
+	}
+
+	chosenIndex := func() int {
+		solvers := client.chooseSolvers(auth, "example.com")
+		if len(solvers) != 1 {
+			t.Fatalf("Expected a single solver but got %d", len(solvers))
+		}
+		for idx := range solvers {
+			return idx
+		}
+		return -1
+	}
+
+	if idx := chosenIndex(); idx != 0 {
+		t.Errorf("Expected the first combination of the server to be chosen but got %d", idx)
+	}
+
+	client.SetChallengePreferenceOrder([]Challenge{DNS01, HTTP01})
+	if idx := chosenIndex(); idx != 2 {
+		t.Errorf("Expected the preferred %s challenge to be chosen but got %d", DNS01, idx)
+	}
+
+	client.ExcludeChallenges([]Challenge{DNS01})
+	if idx := chosenIndex(); idx != 0 {
+		t.Errorf("Expected %s to be used when %s is excluded but got %d", HTTP01, DNS01, idx)
+	}
+}
+
+func TestListOrders(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "12345")
+		switch {
+		case r.URL.Path == "/directory":
+			writeJSONResponse(w, Directory{NewAuthzURL: ts.URL, NewCertURL: ts.URL, NewRegURL: ts.URL, RevokeCertURL: ts.URL})
+		case r.URL.Path == "/orders" && r.URL.Query().Get("cursor") == "":
+			w.Header().Add("Link", fmt.Sprintf("<%s/orders?cursor=2>;rel=\"next\"", ts.URL))
+			writeJSONResponse(w, map[string][]string{"orders": {ts.URL + "/order/1", ts.URL + "/order/2"}})
+		case r.URL.Path == "/orders" && r.URL.Query().Get("cursor") == "2":
+			writeJSONResponse(w, map[string][]string{"orders": {ts.URL + "/order/3"}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{Body: Registration{Orders: ts.URL + "/orders"}},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ts.URL+"/directory", user, RSA2048)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	orders, err := client.ListOrders()
+	if err != nil {
+		t.Fatalf("Could not list the orders: %v", err)
+	}
+	expected := []string{ts.URL + "/order/1", ts.URL + "/order/2", ts.URL + "/order/3"}
+	if !reflect.DeepEqual(orders, expected) {
+		t.Errorf("Expected orders %v but got %v", expected, orders)
+	}
+}
+
+func TestCertificateValiditySerialization(t *testing.T) {
+	ca := newMockCA(t)
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	if _, failures := client.ObtainCertificate([]string{"example.com"}, false, nil, false); len(failures) > 0 {
+		t.Fatalf("Expected no failures but got %v", failures)
+	}
+
+	// Only notAfter is set, in a zone other than UTC.
+	notAfter := time.Date(2030, 1, 7, 2, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	if err := client.SetCertificateValidity(time.Time{}, notAfter); err != nil {
+		t.Fatalf("Could not set certificate validity: %v", err)
+	}
+	if _, failures := client.ObtainCertificate([]string{"example.com"}, false, nil, false); len(failures) > 0 {
+		t.Fatalf("Expected no failures but got %v", failures)
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if len(ca.certRequests) != 2 {
+		t.Fatalf("Expected 2 certificate requests but got %d", len(ca.certRequests))
+	}
+
+	for i, expected := range []string{
+		`{"resource":"new-cert","csr":"","authorizations":null}`,
+		`{"resource":"new-cert","csr":"","authorizations":null,"notAfter":"2030-01-07T00:00:00Z"}`,
+	} {
+		msg := ca.certRequests[i]
+		msg.Csr, msg.Authorizations = "", nil
+		serialized, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(serialized) != expected {
+			t.Errorf("Expected certificate request %d to be serialized as %s but got %s", i, expected, serialized)
+		}
+	}
+}
+
+func TestObtainResult(t *testing.T) {
+	ca := newMockCA(t)
+	ca.pending = true
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	if err := client.SetChallengeProvider(HTTP01, &failingProvider{fail: "c.example.com"}); err != nil {
+		t.Fatalf("Could not set challenge provider: %v", err)
+	}
+
+	result := client.Obtain([]string{"a.example.com", "b.example.com", "c.example.com"}, false, nil, false)
+	if result.Issued || result.Certificate.Certificate != nil {
+		t.Error("Expected no certificate to be issued")
+	}
+	if expected := []string{"a.example.com", "b.example.com"}; !reflect.DeepEqual(result.Validated, expected) {
+		t.Errorf("Expected %v to be validated but got %v", expected, result.Validated)
+	}
+	failure, ok := result.Failures["c.example.com"]
+	if !ok || len(result.Failures) != 1 {
+		t.Fatalf("Expected c.example.com to fail but got %v", result.Failures)
+	}
+	if failure.Stage != FailureChallenge || failure.Err == nil {
+		t.Errorf("Expected c.example.com to fail solving its challenge but got %+v", failure)
+	}
+	if failure.Problem() != nil {
+		t.Errorf("Expected no problem from the CA for a failed Present but got %v", failure.Problem())
+	}
+	if errs := result.Errors(); len(errs) != 1 || errs["c.example.com"] != failure.Err {
+		t.Errorf("Expected the errors to hold the failure of c.example.com but got %v", errs)
+	}
+
+	result = client.Obtain([]string{"a.example.com", "b.example.com"}, false, nil, false)
+	if !result.Issued || result.Certificate.Certificate == nil || len(result.Failures) != 0 {
+		t.Errorf("Expected the certificate to be issued but got %+v", result)
+	}
+	if expected := []string{"a.example.com", "b.example.com"}; !reflect.DeepEqual(result.Validated, expected) {
+		t.Errorf("Expected %v to be validated but got %v", expected, result.Validated)
+	}
+}
+
+func TestDomainFailureProblem(t *testing.T) {
+	problem := RemoteError{StatusCode: 403, Type: "urn:acme:error:unauthorized", Detail: "Invalid response"}
+	for _, err := range []error{problem, CAAError{problem}, challengeError{RemoteError: problem}} {
+		if got := (DomainFailure{Stage: FailureChallenge, Err: err}).Problem(); got == nil || *got != problem {
+			t.Errorf("Expected problem %v for %T but got %v", problem, err, got)
+		}
+	}
+	if got := (DomainFailure{Stage: FailureChallenge, Err: errors.New("no solver")}).Problem(); got != nil {
+		t.Errorf("Expected no problem for a local error but got %v", got)
+	}
+}
@@ -0,0 +1,185 @@
+package acme
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Combined443Provider implements ChallengeProvider for hosts where only port
+// 443 is reachable. It answers `tls-alpn-01` validations, which negotiate
+// the ACMETLS1Protocol, with the challenge certificate and serves the key
+// authorization at `HTTP01ChallengePath(token)` over HTTPS to all other
+// connections, e.g. `http-01` validations redirected to HTTPS.
+//
+// The server is started by the first call to Present and stopped once all
+// challenges are cleaned up, so several challenges may be presented at once.
+type Combined443Provider struct {
+	iface string
+	port  string
+
+	mu         sync.Mutex
+	challenges map[string]combinedChallenge
+	listener   net.Listener
+	done       chan bool
+
+	// logger receives the log entries of the provider. When nil, they go
+	// to the package logger.
+	logger logFunc
+}
+
+type combinedChallenge struct {
+	token   string
+	keyAuth string
+	cert    tls.Certificate
+}
+
+// NewCombined443Provider creates a new Combined443Provider on the selected
+// interface and port. Setting iface and / or port to an empty string will make
+// the server fall back to the "any" interface and port 443 respectively.
+func NewCombined443Provider(iface, port string) *Combined443Provider {
+	return &Combined443Provider{iface: iface, port: port}
+}
+
+// Present makes the keyAuth available both as a `tls-alpn-01` certificate and
+// at `HTTP01ChallengePath(token)`, starting the server if it is not running.
+func (s *Combined443Provider) Present(domain, token, keyAuth string) error {
+	cert, err := TLSALPN01ChallengeCert(domain, keyAuth)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener == nil {
+		if s.port == "" {
+			s.port = "443"
+		}
+
+		tlsConf := &tls.Config{
+			GetCertificate: s.getCertificate,
+			NextProtos:     []string{ACMETLS1Protocol, "http/1.1"},
+		}
+		s.listener, err = tls.Listen("tcp", net.JoinHostPort(s.iface, s.port), tlsConf)
+		if err != nil {
+			return fmt.Errorf("Could not start HTTPS server for challenge -> %v", err)
+		}
+
+		s.done = make(chan bool)
+		go s.serve(s.listener)
+	}
+
+	if s.challenges == nil {
+		s.challenges = make(map[string]combinedChallenge)
+	}
+	s.challenges[strings.ToLower(domain)] = combinedChallenge{token: token, keyAuth: keyAuth, cert: cert}
+	return nil
+}
+
+// CleanUp removes the challenge for domain and stops the server once no
+// challenges are left.
+func (s *Combined443Provider) CleanUp(domain, token, keyAuth string) error {
+	s.mu.Lock()
+	delete(s.challenges, strings.ToLower(domain))
+	if len(s.challenges) > 0 || s.listener == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	listener, done := s.listener, s.done
+	s.listener = nil
+	s.mu.Unlock()
+
+	listener.Close()
+	<-done
+	return nil
+}
+
+// Addr returns the address the server is listening on, or nil if it is not
+// running.
+func (s *Combined443Provider) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+func (s *Combined443Provider) challenge(domain string) (combinedChallenge, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chlng, ok := s.challenges[strings.ToLower(domain)]
+	return chlng, ok
+}
+
+// getCertificate returns the challenge certificate of the requested domain.
+// It is served for plain HTTPS connections as well, as there is no other
+// certificate for the domain.
+func (s *Combined443Provider) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	chlng, ok := s.challenge(hello.ServerName)
+	if !ok {
+		return nil, fmt.Errorf("No challenge for domain %q", hello.ServerName)
+	}
+	return &chlng.cert, nil
+}
+
+func (s *Combined443Provider) serve(listener net.Listener) {
+	httpServer := &http.Server{
+		Handler: http.HandlerFunc(s.serveHTTP),
+		// The validation of a tls-alpn-01 challenge is complete once the
+		// handshake is done, so the connection is closed right away.
+		TLSNextProto: map[string]func(*http.Server, *tls.Conn, http.Handler){
+			ACMETLS1Protocol: func(_ *http.Server, conn *tls.Conn, _ http.Handler) {
+				s.logf("[INFO][%s] Served TLS-ALPN-01 certificate", conn.ConnectionState().ServerName)
+				conn.Close()
+			},
+		},
+	}
+	// Once httpServer is shut down we don't want any lingering
+	// connections, so disable KeepAlives.
+	httpServer.SetKeepAlivesEnabled(false)
+	httpServer.Serve(listener)
+	s.done <- true
+}
+
+func (s *Combined443Provider) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	chlng, ok := s.challenge(host)
+	if !ok || r.URL.Path != HTTP01ChallengePath(chlng.token) || (r.Method != "GET" && r.Method != "HEAD") {
+		s.logf("[WARN] Received request for domain %s with method %s but the domain did not match any challenge. Please ensure your are passing the HOST header properly.", r.Host, r.Method)
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/plain")
+	w.Header().Set("Content-Length", strconv.Itoa(len(chlng.keyAuth)))
+	if r.Method == "HEAD" {
+		return
+	}
+	w.Write([]byte(chlng.keyAuth))
+	s.logf("[INFO][%s] Served key authentication", host)
+}
+
+// setLogger makes the provider write its log entries to logf.
+func (s *Combined443Provider) setLogger(logf logFunc) {
+	s.logger = logf
+}
+
+// logf writes a log entry to the logger of the provider, or to the package
+// logger if it has none.
+func (s *Combined443Provider) logf(format string, args ...interface{}) {
+	if s.logger != nil {
+		s.logger(format, args...)
+	} else {
+		logf(format, args...)
+	}
+}
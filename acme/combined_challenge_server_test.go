@@ -0,0 +1,87 @@
+package acme
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/asn1"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestCombined443Provider(t *testing.T) {
+	provider := NewCombined443Provider("127.0.0.1", "0")
+	if err := provider.Present("example.com", "token", "keyAuth"); err != nil {
+		t.Fatalf("Could not present challenge: %v", err)
+	}
+	addr := provider.Addr().String()
+
+	// A tls-alpn-01 validation.
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		ServerName:         "example.com",
+		NextProtos:         []string{ACMETLS1Protocol},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatalf("Could not complete the ALPN handshake: %v", err)
+	}
+	state := conn.ConnectionState()
+	conn.Close()
+
+	if state.NegotiatedProtocol != ACMETLS1Protocol {
+		t.Errorf("Expected the protocol %s to be negotiated but got %q", ACMETLS1Protocol, state.NegotiatedProtocol)
+	}
+	if count := len(state.PeerCertificates); count != 1 {
+		t.Fatalf("Expected the challenge server to return exactly one certificate but got %d", count)
+	}
+	cert := state.PeerCertificates[0]
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != "example.com" {
+		t.Errorf("Expected the certificate to be valid for example.com only but got %v", cert.DNSNames)
+	}
+
+	digest := sha256.Sum256([]byte("keyAuth"))
+	var found bool
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(idPeAcmeIdentifier) {
+			continue
+		}
+		found = true
+		var value []byte
+		if _, err := asn1.Unmarshal(ext.Value, &value); err != nil {
+			t.Fatalf("Could not parse the acmeIdentifier extension: %v", err)
+		}
+		if !ext.Critical || !bytes.Equal(value, digest[:]) {
+			t.Errorf("Expected a critical acmeIdentifier extension with the key authorization digest but got %+v", ext)
+		}
+	}
+	if !found {
+		t.Error("Expected the certificate to have an acmeIdentifier extension")
+	}
+
+	// An http-01 validation over HTTPS.
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{ServerName: "example.com", InsecureSkipVerify: true},
+	}}
+	req, err := http.NewRequest("GET", "https://"+addr+HTTP01ChallengePath("token"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "example.com"
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Could not fetch the key authorization: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "keyAuth" {
+		t.Errorf("Expected status %d and body %q but got %d and %q", http.StatusOK, "keyAuth", resp.StatusCode, body)
+	}
+
+	if err := provider.CleanUp("example.com", "token", "keyAuth"); err != nil {
+		t.Fatalf("Could not clean up challenge: %v", err)
+	}
+	if provider.Addr() != nil {
+		t.Error("Expected the server to be stopped after the last CleanUp")
+	}
+}
@@ -0,0 +1,84 @@
+package acme
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// CredentialsFileEnv is the environment variable naming a file that DNS
+// providers read their credentials from if they are not set in the
+// environment. This keeps secrets out of the environment, e.g. when they are
+// mounted into a container as a file.
+const CredentialsFileEnv = "LEGO_CREDENTIALS_FILE"
+
+// LoadCredentials returns the values of the environment variables names.
+// Variables that are unset or empty are looked up in the file named by
+// CredentialsFileEnv, if any. The file is either a JSON object with string
+// values or an INI style file of `NAME = value` lines; sections, comments
+// starting with # or ; and quotes around values are ignored. Names missing
+// from both are returned as empty strings.
+func LoadCredentials(names ...string) (map[string]string, error) {
+	values := make(map[string]string, len(names))
+	var missing bool
+	for _, name := range names {
+		values[name] = os.Getenv(name)
+		if values[name] == "" {
+			missing = true
+		}
+	}
+
+	path := os.Getenv(CredentialsFileEnv)
+	if !missing || path == "" {
+		return values, nil
+	}
+
+	file, err := readCredentialsFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read credentials file %s: %v", path, err)
+	}
+	for _, name := range names {
+		if values[name] == "" {
+			values[name] = file[name]
+		}
+	}
+	return values, nil
+}
+
+// readCredentialsFile parses the JSON or INI credentials file at path.
+func readCredentialsFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, err
+		}
+		return values, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' || line[0] == '[' {
+			continue
+		}
+		idx := strings.Index(line, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("line %d: expected NAME = value", lineNo)
+		}
+		value := strings.TrimSpace(line[idx+1:])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[strings.TrimSpace(line[:idx])] = value
+	}
+	return values, scanner.Err()
+}
@@ -0,0 +1,76 @@
+package acme
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lego-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"credentials.ini":  "# CloudFlare\n[cloudflare]\nCLOUDFLARE_EMAIL = file@example.com\nCLOUDFLARE_API_KEY=\"file-key\"\n; unused\nOTHER = x\n",
+		"credentials.json": `{"CLOUDFLARE_EMAIL": "file@example.com", "CLOUDFLARE_API_KEY": "file-key", "OTHER": "x"}`,
+	}
+
+	for _, v := range []string{CredentialsFileEnv, "CLOUDFLARE_EMAIL", "CLOUDFLARE_API_KEY"} {
+		defer os.Setenv(v, os.Getenv(v))
+		os.Unsetenv(v)
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		os.Setenv(CredentialsFileEnv, path)
+
+		// The environment takes precedence over the file.
+		os.Setenv("CLOUDFLARE_EMAIL", "env@example.com")
+		values, err := LoadCredentials("CLOUDFLARE_EMAIL", "CLOUDFLARE_API_KEY", "MISSING")
+		if err != nil {
+			t.Fatalf("%s: could not load credentials: %v", name, err)
+		}
+		expected := map[string]string{
+			"CLOUDFLARE_EMAIL":   "env@example.com",
+			"CLOUDFLARE_API_KEY": "file-key",
+			"MISSING":            "",
+		}
+		if !reflect.DeepEqual(values, expected) {
+			t.Errorf("%s: expected %v but got %v", name, expected, values)
+		}
+
+		os.Unsetenv("CLOUDFLARE_EMAIL")
+		values, err = LoadCredentials("CLOUDFLARE_EMAIL")
+		if err != nil || values["CLOUDFLARE_EMAIL"] != "file@example.com" {
+			t.Errorf("%s: expected the email from the file but got %v, %v", name, values, err)
+		}
+	}
+
+	os.Setenv(CredentialsFileEnv, filepath.Join(dir, "missing"))
+	if _, err := LoadCredentials("CLOUDFLARE_EMAIL"); err == nil {
+		t.Error("Expected an error for a missing credentials file")
+	}
+
+	// The file is not needed if the environment has all values.
+	os.Setenv("CLOUDFLARE_EMAIL", "env@example.com")
+	if _, err := LoadCredentials("CLOUDFLARE_EMAIL"); err != nil {
+		t.Errorf("Expected no error without reading the file but got %v", err)
+	}
+
+	invalid := filepath.Join(dir, "invalid.ini")
+	if err := ioutil.WriteFile(invalid, []byte("CLOUDFLARE_EMAIL\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(CredentialsFileEnv, invalid)
+	if _, err := LoadCredentials("CLOUDFLARE_API_KEY"); err == nil {
+		t.Error("Expected an error for an invalid credentials file")
+	}
+}
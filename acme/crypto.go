@@ -16,6 +16,7 @@ import (
 	"io"
 	"io/ioutil"
 	"math/big"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -55,6 +56,25 @@ var (
 	ocspMustStapleFeature  = []byte{0x30, 0x03, 0x02, 0x01, 0x05}
 )
 
+// Extensions requesting key usages in a CSR
+var (
+	keyUsageExtensionOID    = asn1.ObjectIdentifier{2, 5, 29, 15}
+	extKeyUsageExtensionOID = asn1.ObjectIdentifier{2, 5, 29, 37}
+
+	extKeyUsageOIDs = map[x509.ExtKeyUsage]asn1.ObjectIdentifier{
+		x509.ExtKeyUsageAny:             {2, 5, 29, 37, 0},
+		x509.ExtKeyUsageServerAuth:      {1, 3, 6, 1, 5, 5, 7, 3, 1},
+		x509.ExtKeyUsageClientAuth:      {1, 3, 6, 1, 5, 5, 7, 3, 2},
+		x509.ExtKeyUsageCodeSigning:     {1, 3, 6, 1, 5, 5, 7, 3, 3},
+		x509.ExtKeyUsageEmailProtection: {1, 3, 6, 1, 5, 5, 7, 3, 4},
+		x509.ExtKeyUsageIPSECEndSystem:  {1, 3, 6, 1, 5, 5, 7, 3, 5},
+		x509.ExtKeyUsageIPSECTunnel:     {1, 3, 6, 1, 5, 5, 7, 3, 6},
+		x509.ExtKeyUsageIPSECUser:       {1, 3, 6, 1, 5, 5, 7, 3, 7},
+		x509.ExtKeyUsageTimeStamping:    {1, 3, 6, 1, 5, 5, 7, 3, 8},
+		x509.ExtKeyUsageOCSPSigning:     {1, 3, 6, 1, 5, 5, 7, 3, 9},
+	}
+)
+
 // GetOCSPForCert takes a PEM encoded cert or cert bundle returning the raw OCSP response,
 // the parsed response, and an error, if any. The returned []byte can be passed directly
 // into the OCSPStaple property of a tls.Certificate. If the bundle only contains the
@@ -68,10 +88,17 @@ func GetOCSPForCert(bundle []byte) ([]byte, *ocsp.Response, error) {
 	}
 
 	// We expect the certificate slice to be ordered downwards the chain.
-	// SRV CRT -> CA. We need to pull the leaf and issuer certs out of it,
+	// SRV CRT -> CA. Bundles which are not ordered that way are reordered
+	// if they form a valid chain. We need to pull the leaf and issuer certs out of it,
 	// which should always be the first two certificates. If there's no
 	// OCSP server listed in the leaf cert, there's nothing to do. And if
 	// we have only one certificate so far, we need to get the issuer cert.
+	if len(certificates) > 1 {
+		if ordered, err := orderChain(certificates); err == nil {
+			certificates = ordered
+		}
+	}
+
 	issuedCert := certificates[0]
 	if len(issuedCert.OCSPServer) == 0 {
 		return nil, nil, errors.New("no OCSP server specified in cert")
@@ -82,7 +109,7 @@ func GetOCSPForCert(bundle []byte) ([]byte, *ocsp.Response, error) {
 			return nil, nil, errors.New("no issuing certificate URL")
 		}
 
-		resp, err := httpGet(issuedCert.IssuingCertificateURL[0])
+		resp, err := httpGet(issuedCert.IssuingCertificateURL[0], userAgent())
 		if err != nil {
 			return nil, nil, err
 		}
@@ -111,13 +138,21 @@ func GetOCSPForCert(bundle []byte) ([]byte, *ocsp.Response, error) {
 	}
 
 	reader := bytes.NewReader(ocspReq)
-	req, err := httpPost(issuedCert.OCSPServer[0], "application/ocsp-request", reader)
+	req, err := httpPost(issuedCert.OCSPServer[0], "application/ocsp-request", reader, userAgent())
 	if err != nil {
 		return nil, nil, err
 	}
 	defer req.Body.Close()
 
+	if req.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("OCSP responder %s returned status %d", issuedCert.OCSPServer[0], req.StatusCode)
+	}
+
 	ocspResBytes, err := ioutil.ReadAll(limitReader(req.Body, 1024*1024))
+	if err != nil {
+		return nil, nil, err
+	}
+
 	ocspRes, err := ocsp.ParseResponse(ocspResBytes, issuerCert)
 	if err != nil {
 		return nil, nil, err
@@ -183,14 +218,119 @@ func parsePEMBundle(bundle []byte) ([]*x509.Certificate, error) {
 	return certificates, nil
 }
 
+// orderChain orders certs so that they form a chain starting with the leaf
+// certificate, followed by its issuer and so on, each certificate being signed
+// by the next one. An error is returned if certs do not form exactly one chain.
+func orderChain(certs []*x509.Certificate) ([]*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("acme: no certificates to order")
+	}
+
+	// The leaf is the only certificate which did not sign any of the others.
+	var leaf *x509.Certificate
+	for _, candidate := range certs {
+		signsOther := false
+		for _, cert := range certs {
+			if cert != candidate && cert.CheckSignatureFrom(candidate) == nil {
+				signsOther = true
+				break
+			}
+		}
+		if signsOther {
+			continue
+		}
+		if leaf != nil {
+			return nil, errors.New("acme: certificates do not form a single chain")
+		}
+		leaf = candidate
+	}
+	if leaf == nil {
+		return nil, errors.New("acme: could not find the leaf certificate of the chain")
+	}
+
+	chain := []*x509.Certificate{leaf}
+	for len(chain) < len(certs) {
+		current := chain[len(chain)-1]
+
+		var issuer *x509.Certificate
+		for _, cert := range certs {
+			if cert != current && current.CheckSignatureFrom(cert) == nil {
+				issuer = cert
+				break
+			}
+		}
+		if issuer == nil {
+			return nil, fmt.Errorf("acme: could not find the issuer of %q in the chain", current.Subject.CommonName)
+		}
+		chain = append(chain, issuer)
+	}
+
+	return chain, nil
+}
+
+// bundleChain parses the DER encoded certificates, orders them using
+// orderChain and returns them as a PEM encoded bundle.
+func bundleChain(certs ...[]byte) ([]byte, error) {
+	var parsed []*x509.Certificate
+	for _, der := range certs {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, cert)
+	}
+
+	chain, err := orderChain(parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	var bundle []byte
+	for _, cert := range chain {
+		bundle = append(bundle, pemEncode(derCertificateBytes(cert.Raw))...)
+	}
+	return bundle, nil
+}
+
+// LoadPrivateKeyPEM parses a PEM encoded RSA or ECDSA private key, e.g. to
+// return it from User.GetPrivateKey. RSA keys may be encoded as PKCS #1 or
+// PKCS #8, ECDSA keys as SEC 1 or PKCS #8.
+func LoadPrivateKeyPEM(data []byte) (crypto.PrivateKey, error) {
+	return parsePEMPrivateKey(data)
+}
+
+// SavePrivateKeyPEM PEM encodes an RSA or ECDSA private key so that it can
+// be loaded with LoadPrivateKeyPEM. RSA keys are encoded as PKCS #1, ECDSA
+// keys as SEC 1.
+func SavePrivateKeyPEM(key crypto.PrivateKey) ([]byte, error) {
+	switch key.(type) {
+	case *ecdsa.PrivateKey, *rsa.PrivateKey:
+		return pemEncode(key), nil
+	}
+	return nil, fmt.Errorf("acme: unsupported private key type %T", key)
+}
+
 func parsePEMPrivateKey(key []byte) (crypto.PrivateKey, error) {
-	keyBlock, _ := pem.Decode(key)
+	keyBlock, err := pemDecode(key)
+	if err != nil {
+		return nil, err
+	}
 
 	switch keyBlock.Type {
 	case "RSA PRIVATE KEY":
 		return x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
 	case "EC PRIVATE KEY":
 		return x509.ParseECPrivateKey(keyBlock.Bytes)
+	case "PRIVATE KEY":
+		privKey, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch privKey.(type) {
+		case *ecdsa.PrivateKey, *rsa.PrivateKey:
+			return privKey, nil
+		}
+		return nil, fmt.Errorf("Unsupported PKCS #8 private key type %T", privKey)
 	default:
 		return nil, errors.New("Unknown PEM header value")
 	}
@@ -214,15 +354,66 @@ func generatePrivateKey(keyType KeyType) (crypto.PrivateKey, error) {
 	return nil, fmt.Errorf("Invalid KeyType: %s", keyType)
 }
 
-func generateCsr(privateKey crypto.PrivateKey, domain string, san []string, mustStaple bool) ([]byte, error) {
+// keyUsageExtensions returns the CSR extensions requesting keyUsage and
+// extKeyUsages. A zero keyUsage or no extKeyUsages omit the respective
+// extension.
+func keyUsageExtensions(keyUsage x509.KeyUsage, extKeyUsages []x509.ExtKeyUsage) ([]pkix.Extension, error) {
+	var extensions []pkix.Extension
+
+	if keyUsage != 0 {
+		// Bit i of keyUsage is the i-th bit of the ASN.1 bit string,
+		// counting from the most significant bit of the first byte.
+		var bits asn1.BitString
+		for i := 0; i < 16; i++ {
+			if keyUsage&(1<<uint(i)) != 0 {
+				bits.BitLength = i + 1
+			}
+		}
+		bits.Bytes = make([]byte, (bits.BitLength+7)/8)
+		for i := 0; i < bits.BitLength; i++ {
+			if keyUsage&(1<<uint(i)) != 0 {
+				bits.Bytes[i/8] |= 0x80 >> uint(i%8)
+			}
+		}
+		value, err := asn1.Marshal(bits)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, pkix.Extension{Id: keyUsageExtensionOID, Critical: true, Value: value})
+	}
+
+	if len(extKeyUsages) > 0 {
+		var oids []asn1.ObjectIdentifier
+		for _, usage := range extKeyUsages {
+			oid, ok := extKeyUsageOIDs[usage]
+			if !ok {
+				return nil, fmt.Errorf("unsupported extended key usage %d", usage)
+			}
+			oids = append(oids, oid)
+		}
+		value, err := asn1.Marshal(oids)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, pkix.Extension{Id: extKeyUsageExtensionOID, Value: value})
+	}
+
+	return extensions, nil
+}
+
+func generateCsr(privateKey crypto.PrivateKey, domain string, san []string, mustStaple bool, extensions []pkix.Extension) ([]byte, error) {
 	template := x509.CertificateRequest{
 		Subject: pkix.Name{
 			CommonName: domain,
 		},
 	}
 
-	if len(san) > 0 {
-		template.DNSNames = san
+	for _, name := range san {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
 	}
 
 	if mustStaple {
@@ -231,6 +422,7 @@ func generateCsr(privateKey crypto.PrivateKey, domain string, san []string, must
 			Value: ocspMustStapleFeature,
 		})
 	}
+	template.ExtraExtensions = append(template.ExtraExtensions, extensions...)
 
 	return x509.CreateCertificateRequest(rand.Reader, &template, privateKey)
 }
@@ -2,10 +2,26 @@ package acme
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
 	"time"
+
+	"golang.org/x/crypto/ocsp"
 )
 
 func TestGeneratePrivateKey(t *testing.T) {
@@ -24,7 +40,7 @@ func TestGenerateCSR(t *testing.T) {
 		t.Fatal("Error generating private key:", err)
 	}
 
-	csr, err := generateCsr(key, "fizz.buzz", nil, true)
+	csr, err := generateCsr(key, "fizz.buzz", nil, true, nil)
 	if err != nil {
 		t.Error("Error generating CSR:", err)
 	}
@@ -33,6 +49,280 @@ func TestGenerateCSR(t *testing.T) {
 	}
 }
 
+func TestGenerateCSRWithIPAddresses(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Error generating private key:", err)
+	}
+
+	csrBytes, err := generateCsr(key, "fizz.buzz", []string{"www.fizz.buzz", "192.0.2.1", "2001:db8::1"}, false, nil)
+	if err != nil {
+		t.Fatal("Error generating CSR:", err)
+	}
+
+	csr, err := x509.ParseCertificateRequest(csrBytes)
+	if err != nil {
+		t.Fatal("Error parsing CSR:", err)
+	}
+
+	if len(csr.DNSNames) != 1 || csr.DNSNames[0] != "www.fizz.buzz" {
+		t.Errorf("Expected DNS names [www.fizz.buzz] but got %v", csr.DNSNames)
+	}
+	if len(csr.IPAddresses) != 2 || !csr.IPAddresses[0].Equal(net.ParseIP("192.0.2.1")) || !csr.IPAddresses[1].Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("Expected IP addresses [192.0.2.1 2001:db8::1] but got %v", csr.IPAddresses)
+	}
+}
+
+func TestGenerateCSRWithKeyUsage(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Error generating private key:", err)
+	}
+
+	keyUsage := x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	extensions, err := keyUsageExtensions(keyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth})
+	if err != nil {
+		t.Fatal("Error marshaling key usages:", err)
+	}
+	csrBytes, err := generateCsr(key, "fizz.buzz", nil, false, extensions)
+	if err != nil {
+		t.Fatal("Error generating CSR:", err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrBytes)
+	if err != nil {
+		t.Fatal("Error parsing CSR:", err)
+	}
+
+	// The key usage must be encoded as in certificates.
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), KeyUsage: keyUsage}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Error creating certificate:", err)
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		t.Fatal("Error parsing certificate:", err)
+	}
+	var wantKeyUsage []byte
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(keyUsageExtensionOID) {
+			wantKeyUsage = ext.Value
+		}
+	}
+
+	var gotKeyUsage []byte
+	var gotExtKeyUsages []asn1.ObjectIdentifier
+	for _, ext := range csr.Extensions {
+		switch {
+		case ext.Id.Equal(keyUsageExtensionOID):
+			gotKeyUsage = ext.Value
+		case ext.Id.Equal(extKeyUsageExtensionOID):
+			if _, err := asn1.Unmarshal(ext.Value, &gotExtKeyUsages); err != nil {
+				t.Fatal("Error parsing extended key usages:", err)
+			}
+		}
+	}
+	if !bytes.Equal(gotKeyUsage, wantKeyUsage) {
+		t.Errorf("Expected key usage %x but got %x", wantKeyUsage, gotKeyUsage)
+	}
+	wantExtKeyUsages := []asn1.ObjectIdentifier{{1, 3, 6, 1, 5, 5, 7, 3, 1}, {1, 3, 6, 1, 5, 5, 7, 3, 2}}
+	if !reflect.DeepEqual(gotExtKeyUsages, wantExtKeyUsages) {
+		t.Errorf("Expected extended key usages %v but got %v", wantExtKeyUsages, gotExtKeyUsages)
+	}
+
+	if _, err := keyUsageExtensions(0, []x509.ExtKeyUsage{x509.ExtKeyUsage(-1)}); err == nil {
+		t.Error("Expected an error for an unsupported extended key usage")
+	}
+}
+
+// createTestChain creates a chain of n certificates, starting with a self
+// signed root and ending with a leaf certificate.
+func createTestChain(t *testing.T, n int) []*x509.Certificate {
+	var chain []*x509.Certificate
+	var parent *x509.Certificate
+	var parentKey *ecdsa.PrivateKey
+	for i := 0; i < n; i++ {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal("Error generating private key:", err)
+		}
+
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(int64(i + 1)),
+			Subject:               pkix.Name{CommonName: fmt.Sprintf("cert %d", i)},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  i < n-1,
+		}
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal("Error creating certificate:", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal("Error parsing certificate:", err)
+		}
+
+		chain = append(chain, cert)
+		parent, parentKey = cert, key
+	}
+	return chain
+}
+
+func TestOrderChain(t *testing.T) {
+	chain := createTestChain(t, 4)
+	root, int1, int2, leaf := chain[0], chain[1], chain[2], chain[3]
+
+	ordered, err := orderChain([]*x509.Certificate{int1, leaf, root, int2})
+	if err != nil {
+		t.Fatal("Error ordering chain:", err)
+	}
+
+	expected := []*x509.Certificate{leaf, int2, int1, root}
+	if len(ordered) != len(expected) {
+		t.Fatalf("Expected %d certificates but got %d", len(expected), len(ordered))
+	}
+	for i := range expected {
+		if ordered[i] != expected[i] {
+			t.Errorf("Expected %q at position %d but got %q", expected[i].Subject.CommonName, i, ordered[i].Subject.CommonName)
+		}
+	}
+}
+
+func TestOrderChainErrors(t *testing.T) {
+	chain := createTestChain(t, 3)
+	other := createTestChain(t, 2)
+
+	if _, err := orderChain(nil); err == nil {
+		t.Error("Expected an error ordering an empty chain but got none")
+	}
+	if _, err := orderChain([]*x509.Certificate{chain[2], chain[0]}); err == nil {
+		t.Error("Expected an error ordering a chain with a missing intermediate but got none")
+	}
+	if _, err := orderChain([]*x509.Certificate{chain[2], chain[1], other[1]}); err == nil {
+		t.Error("Expected an error ordering unrelated certificates but got none")
+	}
+}
+
+func TestBundleChain(t *testing.T) {
+	chain := createTestChain(t, 3)
+
+	bundle, err := bundleChain(chain[1].Raw, chain[2].Raw)
+	if err != nil {
+		t.Fatal("Error bundling chain:", err)
+	}
+
+	expected := append(pemEncode(derCertificateBytes(chain[2].Raw)), pemEncode(derCertificateBytes(chain[1].Raw))...)
+	if !bytes.Equal(bundle, expected) {
+		t.Error("Expected the bundle to start with the leaf followed by its issuer")
+	}
+}
+
+func TestGetOCSPForCert(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Error generating private key:", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "OCSP test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal("Error creating certificate:", err)
+	}
+	caCert, err := x509.ParseCertificate(caDer)
+	if err != nil {
+		t.Fatal("Error parsing certificate:", err)
+	}
+
+	revokedAt := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp, err := ocsp.CreateResponse(caCert, caCert, ocsp.Response{
+			Status:       ocsp.Revoked,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Hour),
+			NextUpdate:   time.Now().Add(time.Hour),
+			RevokedAt:    revokedAt,
+		}, caKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	}))
+	defer ts.Close()
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Error generating private key:", err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "ocsp.example.com"},
+		DNSNames:     []string{"ocsp.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		OCSPServer:   []string{ts.URL},
+	}
+	leafDer, err := x509.CreateCertificate(rand.Reader, leafTemplate, caCert, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal("Error creating certificate:", err)
+	}
+
+	// The issuer comes first to make sure the bundle gets reordered.
+	bundle := append(pemEncode(derCertificateBytes(caDer)), pemEncode(derCertificateBytes(leafDer))...)
+
+	raw, resp, err := GetOCSPForCert(bundle)
+	if err != nil {
+		t.Fatal("Error getting OCSP response:", err)
+	}
+	if len(raw) == 0 {
+		t.Error("Expected the raw OCSP response to be returned")
+	}
+	if resp.Status != ocsp.Revoked {
+		t.Errorf("Expected OCSP status %d but got %d", ocsp.Revoked, resp.Status)
+	}
+	if resp.SerialNumber.Cmp(leafTemplate.SerialNumber) != 0 {
+		t.Errorf("Expected serial number %v but got %v", leafTemplate.SerialNumber, resp.SerialNumber)
+	}
+	if !resp.RevokedAt.Equal(revokedAt) {
+		t.Errorf("Expected revocation time %v but got %v", revokedAt, resp.RevokedAt)
+	}
+}
+
+func TestGetOCSPForCertNoResponder(t *testing.T) {
+	chain := createTestChain(t, 2)
+	bundle := append(pemEncode(derCertificateBytes(chain[1].Raw)), pemEncode(derCertificateBytes(chain[0].Raw))...)
+
+	if _, _, err := GetOCSPForCert(bundle); err == nil {
+		t.Error("Expected an error for a certificate without OCSP server but got none")
+	}
+}
+
 func TestPEMEncode(t *testing.T) {
 	buf := bytes.NewBufferString("TestingRSAIsSoMuchFun")
 
@@ -52,6 +342,78 @@ func TestPEMEncode(t *testing.T) {
 	}
 }
 
+func TestPrivateKeyPEMRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal("Error generating RSA key:", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Error generating ECDSA key:", err)
+	}
+
+	pkcs8 := func(key crypto.PrivateKey) []byte {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal("Error encoding PKCS #8 key:", err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	}
+	savePEM := func(key crypto.PrivateKey) []byte {
+		data, err := SavePrivateKeyPEM(key)
+		if err != nil {
+			t.Fatal("Error saving key:", err)
+		}
+		return data
+	}
+
+	tests := []struct {
+		name   string
+		key    crypto.PrivateKey
+		data   []byte
+		header string
+	}{
+		{"RSA PKCS #1", rsaKey, savePEM(rsaKey), "RSA PRIVATE KEY"},
+		{"RSA PKCS #8", rsaKey, pkcs8(rsaKey), "PRIVATE KEY"},
+		{"ECDSA SEC 1", ecKey, savePEM(ecKey), "EC PRIVATE KEY"},
+		{"ECDSA PKCS #8", ecKey, pkcs8(ecKey), "PRIVATE KEY"},
+	}
+	for _, test := range tests {
+		block, _ := pem.Decode(test.data)
+		if block == nil || block.Type != test.header {
+			t.Errorf("%s: Expected a %s block, got %v", test.name, test.header, block)
+			continue
+		}
+
+		key, err := LoadPrivateKeyPEM(test.data)
+		if err != nil {
+			t.Errorf("%s: Error loading key: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(key, test.key) {
+			t.Errorf("%s: Expected the loaded key to equal the original key", test.name)
+		}
+
+		// Keys loaded from PKCS #8 are saved in their native encoding.
+		if _, err := LoadPrivateKeyPEM(savePEM(key)); err != nil {
+			t.Errorf("%s: Error loading saved key: %v", test.name, err)
+		}
+	}
+}
+
+func TestPrivateKeyPEMErrors(t *testing.T) {
+	if _, err := SavePrivateKeyPEM("not a key"); err == nil {
+		t.Error("Expected an error saving an unsupported key but got none")
+	}
+	if _, err := LoadPrivateKeyPEM([]byte("not PEM")); err == nil {
+		t.Error("Expected an error loading non-PEM data but got none")
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{1}})
+	if _, err := LoadPrivateKeyPEM(certPEM); err == nil {
+		t.Error("Expected an error loading a certificate but got none")
+	}
+}
+
 func TestPEMCertExpiration(t *testing.T) {
 	privKey, err := generatePrivateKey(RSA2048)
 	if err != nil {
@@ -5,9 +5,9 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"log"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/miekg/dns"
@@ -18,11 +18,105 @@ type preCheckDNSFunc func(fqdn, value string) (bool, error)
 
 var (
 	// PreCheckDNS checks DNS propagation before notifying ACME that
-	// the DNS challenge is ready.
-	PreCheckDNS preCheckDNSFunc = checkDNSPropagation
-	fqdnToZone                  = map[string]string{}
+	// the DNS challenge is ready. When nil, the default check waits for
+	// the record on all authoritative nameservers of its zone. Setting it
+	// replaces the check for all providers, including those with their own
+	// nameservers.
+	PreCheckDNS preCheckDNSFunc
 )
 
+// zoneCache remembers the SOA lookups of findZoneByFqdn while the challenges
+// of an issuance are solved, so the SANs of a certificate do not repeat the
+// lookups for their shared zones. It maps the nameservers queried and a
+// domain to the name of the domain if it is a zone apex and to "" otherwise.
+// A nil *zoneCache caches nothing.
+type zoneCache struct {
+	mu    sync.Mutex
+	zones map[string]string
+}
+
+func newZoneCache() *zoneCache {
+	return &zoneCache{zones: map[string]string{}}
+}
+
+// activeZones holds the zone cache of the issuances whose challenges are
+// being solved. FindZoneByFqdn uses it too, so the lookups of the DNS
+// providers are shared with the solver. It is created when the first
+// issuance starts solving its challenges and dropped when the last one is
+// done, so changes to the zones are seen by the next issuance.
+var activeZones struct {
+	mu    sync.Mutex
+	cache *zoneCache
+	users int
+}
+
+// acquireZoneCache returns the active zone cache, creating it if no issuance
+// is using one. Every call must be paired with a call to releaseZoneCache.
+func acquireZoneCache() *zoneCache {
+	activeZones.mu.Lock()
+	defer activeZones.mu.Unlock()
+	if activeZones.users == 0 {
+		activeZones.cache = newZoneCache()
+	}
+	activeZones.users++
+	return activeZones.cache
+}
+
+// releaseZoneCache drops the active zone cache once no issuance uses it.
+func releaseZoneCache() {
+	activeZones.mu.Lock()
+	defer activeZones.mu.Unlock()
+	activeZones.users--
+	if activeZones.users == 0 {
+		activeZones.cache = nil
+	}
+}
+
+// activeZoneCache returns the active zone cache, or nil if no issuance is
+// solving its challenges.
+func activeZoneCache() *zoneCache {
+	activeZones.mu.Lock()
+	defer activeZones.mu.Unlock()
+	return activeZones.cache
+}
+
+// get returns the cached zone for domain looked up using nameservers, "" if
+// domain is known not to be a zone apex, and whether the domain was cached
+// at all.
+func (c *zoneCache) get(domain string, nameservers []string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	zone, ok := c.zones[zoneCacheKey(domain, nameservers)]
+	return zone, ok
+}
+
+// put records the result of the SOA lookup for domain using nameservers.
+func (c *zoneCache) put(domain string, nameservers []string, zone string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.zones[zoneCacheKey(domain, nameservers)] = zone
+}
+
+// clear forgets all cached lookups.
+func (c *zoneCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.zones = map[string]string{}
+}
+
+func zoneCacheKey(domain string, nameservers []string) string {
+	return strings.Join(nameservers, ",") + " " + strings.ToLower(domain)
+}
+
 const defaultResolvConf = "/etc/resolv.conf"
 
 var defaultNameservers = []string{
@@ -35,6 +129,35 @@ var RecursiveNameservers = getNameservers(defaultResolvConf, defaultNameservers)
 // DNSTimeout is used to override the default DNS timeout of 10 seconds.
 var DNSTimeout = 10 * time.Second
 
+// AuthoritativePreCheck makes the DNS propagation check query the
+// authoritative nameservers for the challenge record only. The recursive
+// nameservers are only asked about the zone of its parent domain, so they
+// never cache a negative answer for the challenge record. CNAME records at
+// the challenge name are not followed in this mode, and the challenge name
+// must not be the apex of its own zone.
+var AuthoritativePreCheck = false
+
+// VerifyPresentedRecord makes the DNS challenge read back the TXT record
+// after Present and wait until it holds the expected value, before the
+// propagation is checked. The record is read from providers implementing
+// ChallengeProviderRecords, and from the authoritative nameservers
+// otherwise.
+var VerifyPresentedRecord = false
+
+// authoritativeNsAddr returns the address to query the authoritative
+// nameserver ns on.
+var authoritativeNsAddr = func(ns string) string {
+	return net.JoinHostPort(ns, "53")
+}
+
+// DNS01RecordName, if set, rewrites the name of the TXT record for the
+// dns-01 challenge of domain. It receives the domain and the default fqdn
+// `_acme-challenge.<domain>.` and returns the fqdn to use instead, e.g. a
+// fixed validation record which the challenge names of all domains are
+// delegated to with a CNAME. The rewritten name is used by DNS01Record, so
+// providers and the propagation check both see it.
+var DNS01RecordName func(domain, fqdn string) string
+
 // getNameservers attempts to get systems nameservers before falling back to the defaults
 func getNameservers(path string, defaults []string) []string {
 	config, err := dns.ClientConfigFromFile(path)
@@ -54,14 +177,26 @@ func getNameservers(path string, defaults []string) []string {
 	return systemNameservers
 }
 
-// DNS01Record returns a DNS record which will fulfill the `dns-01` challenge
+// DNS01Record returns a DNS record which will fulfill the `dns-01` challenge.
+// Internationalized domain names are converted to punycode, so the returned
+// fqdn is always ASCII and can be passed to DNS provider APIs as is. The
+// value is the unpadded base64url encoded SHA-256 digest of keyAuth, which is
+// what the TXT record has to contain; providers which need to look up or
+// remove their records should use this helper rather than hashing keyAuth
+// themselves.
 func DNS01Record(domain, keyAuth string) (fqdn string, value string, ttl int) {
 	keyAuthShaBytes := sha256.Sum256([]byte(keyAuth))
 	// base64URL encoding without padding
 	keyAuthSha := base64.URLEncoding.EncodeToString(keyAuthShaBytes[:sha256.Size])
 	value = strings.TrimRight(keyAuthSha, "=")
 	ttl = 120
+	if name, err := normalizeDomain(domain); err == nil {
+		domain = name
+	}
 	fqdn = fmt.Sprintf("_acme-challenge.%s.", domain)
+	if DNS01RecordName != nil {
+		fqdn = ToFqdn(DNS01RecordName(domain, fqdn))
+	}
 	return
 }
 
@@ -70,10 +205,24 @@ type dnsChallenge struct {
 	jws      *jws
 	validate validateFunc
 	provider ChallengeProvider
+
+	// providerMu serializes the calls to sequential providers. It is
+	// shared by the solvers of a client.
+	providerMu *sync.Mutex
+
+	// logf writes the log entries of the solver to the logger of its
+	// client.
+	logf logFunc
 }
 
-func (s *dnsChallenge) Solve(chlng challenge, domain string) error {
-	logf("[INFO][%s] acme: Trying to solve DNS-01", domain)
+func (s *dnsChallenge) Solve(chlng AuthorizationChallenge, domain string) error {
+	return s.solve(chlng, domain, nil)
+}
+
+// solve works like Solve, but caches the zone lookups of the propagation
+// checks in zones, which is shared by the challenges of an issuance.
+func (s *dnsChallenge) solve(chlng AuthorizationChallenge, domain string, zones *zoneCache) error {
+	s.logf.printf("[INFO][%s] acme: Trying to solve DNS-01", domain)
 
 	if s.provider == nil {
 		return errors.New("No DNS Provider configured")
@@ -85,43 +234,126 @@ func (s *dnsChallenge) Solve(chlng challenge, domain string) error {
 		return err
 	}
 
-	err = s.provider.Present(domain, chlng.Token, keyAuth)
+	err = presentChallenge(s.providerMu, s.provider, domain, chlng.Token, keyAuth)
 	if err != nil {
 		return fmt.Errorf("Error presenting token: %s", err)
 	}
 	defer func() {
-		err := s.provider.CleanUp(domain, chlng.Token, keyAuth)
+		err := cleanUpChallenge(s.providerMu, s.provider, domain, chlng.Token, keyAuth)
 		if err != nil {
-			log.Printf("Error cleaning up %s: %v ", domain, err)
+			s.logf.printf("[ERROR][%s] acme: Error cleaning up: %v", domain, err)
 		}
 	}()
 
 	fqdn, value, _ := DNS01Record(domain, keyAuth)
 
-	logf("[INFO][%s] Checking DNS record propagation using %+v", domain, RecursiveNameservers)
+	nameservers := RecursiveNameservers
+	if ns := providerNameservers(s.provider); len(ns) > 0 {
+		nameservers = ns
+	}
+	preCheck := PreCheckDNS
+	if preCheck == nil {
+		preCheck = func(fqdn, value string) (bool, error) {
+			return checkDNSPropagationNameservers(fqdn, value, nameservers, zones)
+		}
+	}
+
+	timeout, interval := providerTimeout(s.provider)
 
-	var timeout, interval time.Duration
-	switch provider := s.provider.(type) {
-	case ChallengeProviderTimeout:
-		timeout, interval = provider.Timeout()
-	default:
-		timeout, interval = 60*time.Second, 2*time.Second
+	if VerifyPresentedRecord {
+		s.logf.printf("[INFO][%s] Verifying the presented DNS record %s", domain, fqdn)
+		err = WaitFor(timeout, interval, func() (bool, error) {
+			return verifyPresentedRecord(s.providerMu, s.provider, fqdn, value, nameservers, zones)
+		})
+		if err != nil {
+			return fmt.Errorf("[%s] acme: Could not read back the presented DNS record %s: %v", domain, fqdn, err)
+		}
 	}
 
+	s.logf.printf("[INFO][%s] Checking DNS record propagation using %+v", domain, nameservers)
+
 	err = WaitFor(timeout, interval, func() (bool, error) {
-		return PreCheckDNS(fqdn, value)
+		return preCheck(fqdn, value)
 	})
 	if err != nil {
 		return err
 	}
 
-	return s.validate(s.jws, domain, chlng.URI, challenge{Resource: "challenge", Type: chlng.Type, Token: chlng.Token, KeyAuthorization: keyAuth})
+	return s.validate(s.jws, domain, chlng.URI, AuthorizationChallenge{Resource: "challenge", Type: chlng.Type, Token: chlng.Token, KeyAuthorization: keyAuth})
+}
+
+// providerTimeout returns the timeout and interval to use when checking the
+// propagation of the records created by provider. Providers report their own
+// values by implementing ChallengeProviderTimeout, all others get the defaults.
+func providerTimeout(provider ChallengeProvider) (timeout, interval time.Duration) {
+	if p, ok := provider.(ChallengeProviderTimeout); ok {
+		return p.Timeout()
+	}
+	return 60 * time.Second, 2 * time.Second
+}
+
+// providerNameservers returns the recursive nameservers to use when checking
+// the propagation of the records created by provider, or nil if the provider
+// does not implement ChallengeProviderNameservers.
+func providerNameservers(provider ChallengeProvider) []string {
+	if p, ok := provider.(ChallengeProviderNameservers); ok {
+		return p.Nameservers()
+	}
+	return nil
+}
+
+// verifyPresentedRecord reports whether the TXT record fqdn holds value. It
+// asks the provider if it implements ChallengeProviderRecords and the
+// authoritative nameservers, looked up using nameservers, otherwise.
+func verifyPresentedRecord(mu *sync.Mutex, provider ChallengeProvider, fqdn, value string, nameservers []string, zones *zoneCache) (bool, error) {
+	p, ok := provider.(ChallengeProviderRecords)
+	if !ok {
+		return checkDNSPropagationNameservers(fqdn, value, nameservers, zones)
+	}
+
+	values, err := getRecord(mu, p, fqdn)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range values {
+		if v == value {
+			return true, nil
+		}
+	}
+	return false, fmt.Errorf("the provider returned %q instead of %q", values, value)
+}
+
+// WaitForDNSPropagation waits until all authoritative nameservers of fqdn
+// return a TXT record for it with expectedValue, checking every interval
+// until timeout elapses. The authoritative nameservers are looked up using
+// the given recursive nameservers, or RecursiveNameservers if there are
+// none. This is the check the DNS-01 solver runs before it asks the CA to
+// validate a challenge, so custom providers and tools can reuse it.
+func WaitForDNSPropagation(fqdn, expectedValue string, timeout, interval time.Duration, nameservers []string) error {
+	if len(nameservers) == 0 {
+		nameservers = RecursiveNameservers
+	}
+	fqdn = ToFqdn(fqdn)
+	zones := newZoneCache()
+	return WaitFor(timeout, interval, func() (bool, error) {
+		return checkDNSPropagationNameservers(fqdn, expectedValue, nameservers, zones)
+	})
 }
 
 // checkDNSPropagation checks if the expected TXT record has been propagated to all authoritative nameservers.
 func checkDNSPropagation(fqdn, value string) (bool, error) {
+	return checkDNSPropagationNameservers(fqdn, value, RecursiveNameservers, activeZoneCache())
+}
+
+// checkDNSPropagationNameservers works like checkDNSPropagation, but uses the
+// given recursive nameservers and caches the zone lookups in zones.
+func checkDNSPropagationNameservers(fqdn, value string, nameservers []string, zones *zoneCache) (bool, error) {
+	if AuthoritativePreCheck {
+		return checkDNSPropagationAuthoritative(fqdn, value, nameservers, zones)
+	}
+
 	// Initial attempt to resolve at the recursive NS
-	r, err := dnsQuery(fqdn, dns.TypeTXT, RecursiveNameservers, true)
+	r, err := dnsQuery(fqdn, dns.TypeTXT, nameservers, true)
 	if err != nil {
 		return false, err
 	}
@@ -137,7 +369,24 @@ func checkDNSPropagation(fqdn, value string) (bool, error) {
 		}
 	}
 
-	authoritativeNss, err := lookupNameservers(fqdn)
+	authoritativeNss, err := lookupNameservers(fqdn, nameservers, zones)
+	if err != nil {
+		return false, err
+	}
+
+	return checkAuthoritativeNss(fqdn, value, authoritativeNss)
+}
+
+// checkDNSPropagationAuthoritative checks if the expected TXT record has been
+// propagated to all authoritative nameservers, which are looked up from the
+// parent domain of fqdn using the given recursive nameservers.
+func checkDNSPropagationAuthoritative(fqdn, value string, nameservers []string, zones *zoneCache) (bool, error) {
+	labels := dns.Split(fqdn)
+	if len(labels) < 2 {
+		return false, fmt.Errorf("%s has no parent domain", fqdn)
+	}
+
+	authoritativeNss, err := lookupNameservers(fqdn[labels[1]:], nameservers, zones)
 	if err != nil {
 		return false, err
 	}
@@ -148,7 +397,7 @@ func checkDNSPropagation(fqdn, value string) (bool, error) {
 // checkAuthoritativeNss queries each of the given nameservers for the expected TXT record.
 func checkAuthoritativeNss(fqdn, value string, nameservers []string) (bool, error) {
 	for _, ns := range nameservers {
-		r, err := dnsQuery(fqdn, dns.TypeTXT, []string{net.JoinHostPort(ns, "53")}, false)
+		r, err := dnsQuery(fqdn, dns.TypeTXT, []string{authoritativeNsAddr(ns)}, false)
 		if err != nil {
 			return false, err
 		}
@@ -205,16 +454,18 @@ func dnsQuery(fqdn string, rtype uint16, nameservers []string, recursive bool) (
 	return
 }
 
-// lookupNameservers returns the authoritative nameservers for the given fqdn.
-func lookupNameservers(fqdn string) ([]string, error) {
+// lookupNameservers returns the authoritative nameservers for the given fqdn,
+// querying the given recursive nameservers and caching the zone lookups in
+// zones.
+func lookupNameservers(fqdn string, nameservers []string, zones *zoneCache) ([]string, error) {
 	var authoritativeNss []string
 
-	zone, err := FindZoneByFqdn(fqdn, RecursiveNameservers)
+	zone, err := findZoneByFqdn(fqdn, nameservers, zones)
 	if err != nil {
 		return nil, fmt.Errorf("Could not determine the zone: %v", err)
 	}
 
-	r, err := dnsQuery(zone, dns.TypeNS, RecursiveNameservers, true)
+	r, err := dnsQuery(zone, dns.TypeNS, nameservers, true)
 	if err != nil {
 		return nil, err
 	}
@@ -233,12 +484,14 @@ func lookupNameservers(fqdn string) ([]string, error) {
 
 // FindZoneByFqdn determines the zone apex for the given fqdn by recursing up the
 // domain labels until the nameserver returns a SOA record in the answer section.
+// While challenges are being solved, the lookups are cached until the
+// issuance is done.
 func FindZoneByFqdn(fqdn string, nameservers []string) (string, error) {
-	// Do we have it cached?
-	if zone, ok := fqdnToZone[fqdn]; ok {
-		return zone, nil
-	}
+	return findZoneByFqdn(fqdn, nameservers, activeZoneCache())
+}
 
+// findZoneByFqdn works like FindZoneByFqdn, but uses and fills zones.
+func findZoneByFqdn(fqdn string, nameservers []string, zones *zoneCache) (string, error) {
 	labelIndexes := dns.Split(fqdn)
 	for _, index := range labelIndexes {
 		domain := fqdn[index:]
@@ -247,6 +500,14 @@ func FindZoneByFqdn(fqdn string, nameservers []string) (string, error) {
 			break
 		}
 
+		// Do we have it cached?
+		if zone, ok := zones.get(domain, nameservers); ok {
+			if zone != "" {
+				return zone, nil
+			}
+			continue
+		}
+
 		in, err := dnsQuery(domain, dns.TypeSOA, nameservers, true)
 		if err != nil {
 			return "", err
@@ -258,16 +519,22 @@ func FindZoneByFqdn(fqdn string, nameservers []string) (string, error) {
 				dns.RcodeToString[in.Rcode], domain)
 		}
 
-		// Check if we got a SOA RR in the answer section
+		// Check if we got a SOA RR in the answer section. If domain is a
+		// CNAME the answer may hold the SOA of the zone it points to,
+		// which does not contain fqdn, so only accept one for domain.
+		var zone string
 		if in.Rcode == dns.RcodeSuccess {
 			for _, ans := range in.Answer {
-				if soa, ok := ans.(*dns.SOA); ok {
-					zone := soa.Hdr.Name
-					fqdnToZone[fqdn] = zone
-					return zone, nil
+				if soa, ok := ans.(*dns.SOA); ok && strings.EqualFold(soa.Hdr.Name, domain) {
+					zone = soa.Hdr.Name
+					break
 				}
 			}
 		}
+		zones.put(domain, nameservers, zone)
+		if zone != "" {
+			return zone, nil
+		}
 	}
 
 	return "", fmt.Errorf("Could not find the start of authority")
@@ -281,9 +548,10 @@ func isTLD(domain string) bool {
 	return false
 }
 
-// ClearFqdnCache clears the cache of fqdn to zone mappings. Primarily used in testing.
+// ClearFqdnCache clears the zone lookups cached for the issuances whose
+// challenges are being solved. Primarily used in testing.
 func ClearFqdnCache() {
-	fqdnToZone = map[string]string{}
+	activeZoneCache().clear()
 }
 
 // ToFqdn converts the name into a fqdn appending a trailing dot.
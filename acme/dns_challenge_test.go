@@ -4,14 +4,18 @@ import (
 	"bufio"
 	"crypto/rand"
 	"crypto/rsa"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"reflect"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/miekg/dns"
 )
 
 var lookupNameserversTestsOK = []struct {
@@ -108,7 +112,7 @@ func TestDNSValidServerResponse(t *testing.T) {
 	manualProvider, _ := NewDNSProviderManual()
 	jws := &jws{privKey: privKey, directoryURL: ts.URL}
 	solver := &dnsChallenge{jws: jws, validate: validate, provider: manualProvider}
-	clientChallenge := challenge{Type: "dns01", Status: "pending", URI: ts.URL, Token: "http8"}
+	clientChallenge := AuthorizationChallenge{Type: "dns01", Status: "pending", URI: ts.URL, Token: "http8"}
 
 	go func() {
 		time.Sleep(time.Second * 2)
@@ -122,8 +126,233 @@ func TestDNSValidServerResponse(t *testing.T) {
 	}
 }
 
+type timeoutProvider struct {
+	timeout, interval time.Duration
+}
+
+func (*timeoutProvider) Present(domain, token, keyAuth string) error { return nil }
+func (*timeoutProvider) CleanUp(domain, token, keyAuth string) error { return nil }
+func (p *timeoutProvider) Timeout() (timeout, interval time.Duration) {
+	return p.timeout, p.interval
+}
+
+func TestProviderTimeout(t *testing.T) {
+	manualProvider, _ := NewDNSProviderManual()
+	if timeout, interval := providerTimeout(manualProvider); timeout != 60*time.Second || interval != 2*time.Second {
+		t.Errorf("Expected the default timeout and interval but got %v and %v", timeout, interval)
+	}
+
+	provider := &timeoutProvider{timeout: 30 * time.Minute, interval: 30 * time.Second}
+	if timeout, interval := providerTimeout(provider); timeout != provider.timeout || interval != provider.interval {
+		t.Errorf("Expected %v and %v but got %v and %v", provider.timeout, provider.interval, timeout, interval)
+	}
+}
+
+func TestDNSSolveUsesProviderTimeout(t *testing.T) {
+	savedPreCheckDNS := PreCheckDNS
+	defer func() { PreCheckDNS = savedPreCheckDNS }()
+
+	var checks int
+	PreCheckDNS = func(fqdn, value string) (bool, error) {
+		checks++
+		return false, nil
+	}
+
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	provider := &timeoutProvider{timeout: 200 * time.Millisecond, interval: 50 * time.Millisecond}
+	solver := &dnsChallenge{jws: &jws{privKey: privKey}, validate: stubValidate, provider: provider}
+
+	start := time.Now()
+	err := solver.Solve(AuthorizationChallenge{Type: DNS01, Token: "dns1"}, "example.com")
+	if err == nil || !strings.Contains(err.Error(), "Time limit exceeded") {
+		t.Fatalf("Expected the propagation check to time out but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Expected the provider timeout to be used but waited %v", elapsed)
+	}
+	if checks < 2 {
+		t.Errorf("Expected the provider interval to be used but only checked %d time(s)", checks)
+	}
+}
+
+// fqdnProvider records the challenge names it is asked to create.
+type fqdnProvider struct {
+	fqdns []string
+}
+
+func (p *fqdnProvider) Present(domain, token, keyAuth string) error {
+	fqdn, _, _ := DNS01Record(domain, keyAuth)
+	p.fqdns = append(p.fqdns, fqdn)
+	return nil
+}
+func (*fqdnProvider) CleanUp(domain, token, keyAuth string) error { return nil }
+
+func TestDNSSolveRewritesRecordName(t *testing.T) {
+	defer func(preCheck preCheckDNSFunc) { PreCheckDNS = preCheck }(PreCheckDNS)
+	var checked []string
+	PreCheckDNS = func(fqdn, value string) (bool, error) {
+		checked = append(checked, fqdn)
+		return true, nil
+	}
+
+	defer func() { DNS01RecordName = nil }()
+	DNS01RecordName = func(domain, fqdn string) string {
+		if domain == "example.org" {
+			return fqdn
+		}
+		return "_acme-challenge.validation.example.com"
+	}
+
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	provider := &fqdnProvider{}
+	solver := &dnsChallenge{jws: &jws{privKey: privKey}, validate: stubValidate, provider: provider}
+
+	for _, domain := range []string{"example.com", "www.example.net", "example.org"} {
+		if err := solver.Solve(AuthorizationChallenge{Type: DNS01, Token: "dns1"}, domain); err != nil {
+			t.Fatalf("%s: could not solve the challenge: %v", domain, err)
+		}
+	}
+
+	expected := []string{
+		"_acme-challenge.validation.example.com.",
+		"_acme-challenge.validation.example.com.",
+		"_acme-challenge.example.org.",
+	}
+	if !reflect.DeepEqual(provider.fqdns, expected) {
+		t.Errorf("Expected the provider to get the names %v but got %v", expected, provider.fqdns)
+	}
+	if !reflect.DeepEqual(checked, expected) {
+		t.Errorf("Expected the propagation of %v to be checked but got %v", expected, checked)
+	}
+}
+
+// recordsProvider stores the presented values unless it lies about
+// success.
+type recordsProvider struct {
+	lie     bool
+	records map[string][]string
+}
+
+func (p *recordsProvider) Present(domain, token, keyAuth string) error {
+	if !p.lie {
+		fqdn, value, _ := DNS01Record(domain, keyAuth)
+		p.records[fqdn] = append(p.records[fqdn], value)
+	}
+	return nil
+}
+func (p *recordsProvider) CleanUp(domain, token, keyAuth string) error { return nil }
+func (p *recordsProvider) GetRecord(fqdn string) ([]string, error)     { return p.records[fqdn], nil }
+func (p *recordsProvider) Timeout() (timeout, interval time.Duration) {
+	return 100 * time.Millisecond, 20 * time.Millisecond
+}
+
+func TestDNSSolveVerifiesPresentedRecord(t *testing.T) {
+	defer func(preCheck preCheckDNSFunc) { PreCheckDNS = preCheck }(PreCheckDNS)
+	PreCheckDNS = func(fqdn, value string) (bool, error) { return true, nil }
+
+	defer func() { VerifyPresentedRecord = false }()
+	VerifyPresentedRecord = true
+
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+
+	provider := &recordsProvider{records: map[string][]string{}}
+	solver := &dnsChallenge{jws: &jws{privKey: privKey}, validate: stubValidate, provider: provider}
+	if err := solver.Solve(AuthorizationChallenge{Type: DNS01, Token: "dns1"}, "example.com"); err != nil {
+		t.Errorf("Expected the stored record to be verified, got %v", err)
+	}
+
+	provider = &recordsProvider{lie: true, records: map[string][]string{}}
+	solver = &dnsChallenge{jws: &jws{privKey: privKey}, validate: stubValidate, provider: provider}
+	err := solver.Solve(AuthorizationChallenge{Type: DNS01, Token: "dns1"}, "example.com")
+	if err == nil || !strings.Contains(err.Error(), "Could not read back") {
+		t.Errorf("Expected the missing record to fail the challenge, got %v", err)
+	}
+}
+
+type nameserversProvider struct {
+	nameservers []string
+}
+
+func (*nameserversProvider) Present(domain, token, keyAuth string) error { return nil }
+func (*nameserversProvider) CleanUp(domain, token, keyAuth string) error { return nil }
+func (p *nameserversProvider) Nameservers() []string                     { return p.nameservers }
+func (p *nameserversProvider) Timeout() (timeout, interval time.Duration) {
+	return 300 * time.Millisecond, 50 * time.Millisecond
+}
+
+func TestDNSSolveUsesProviderNameservers(t *testing.T) {
+	var mu sync.Mutex
+	queried := map[uint16]bool{}
+
+	// Fake resolver which knows the zone example.com but no nameservers for it.
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		mu.Lock()
+		queried[r.Question[0].Qtype] = true
+		mu.Unlock()
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Qtype == dns.TypeSOA && r.Question[0].Name == "example.com." {
+			soa, _ := dns.NewRR("example.com. 3600 IN SOA ns.example.com. admin.example.com. 1 3600 600 86400 60")
+			m.Answer = append(m.Answer, soa)
+		}
+		w.WriteMsg(m)
+	})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: handler}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	// Other tests replace PreCheckDNS, so start with the default check.
+	defer func(preCheck preCheckDNSFunc) { PreCheckDNS = preCheck }(PreCheckDNS)
+	PreCheckDNS = nil
+
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	provider := &nameserversProvider{nameservers: []string{pc.LocalAddr().String()}}
+	solver := &dnsChallenge{jws: &jws{privKey: privKey}, validate: stubValidate, provider: provider}
+
+	err = solver.Solve(AuthorizationChallenge{Type: DNS01, Token: "dns1"}, "nameservers.example.com")
+	if err == nil || !strings.Contains(err.Error(), "Could not determine authoritative nameservers") {
+		t.Errorf("Expected the nameserver lookup at the fake resolver to fail but got %v", err)
+	}
+
+	mu.Lock()
+	for _, qtype := range []uint16{dns.TypeTXT, dns.TypeSOA, dns.TypeNS} {
+		if !queried[qtype] {
+			t.Errorf("Expected the provider nameservers to be queried for %s", dns.TypeToString[qtype])
+		}
+	}
+	queried = map[uint16]bool{}
+	mu.Unlock()
+
+	// A custom PreCheckDNS takes precedence over the provider nameservers.
+	preCheckCalled := false
+	PreCheckDNS = func(fqdn, value string) (bool, error) {
+		preCheckCalled = true
+		return true, nil
+	}
+
+	err = solver.Solve(AuthorizationChallenge{Type: DNS01, Token: "dns1"}, "nameservers.example.com")
+	if err != nil {
+		t.Errorf("Expected the custom PreCheckDNS to succeed but got %v", err)
+	}
+	if !preCheckCalled {
+		t.Error("Expected PreCheckDNS to be used for a provider with its own nameservers")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(queried) != 0 {
+		t.Errorf("Expected the provider nameservers not to be queried but got %v", queried)
+	}
+}
+
 func TestPreCheckDNS(t *testing.T) {
-	ok, err := PreCheckDNS("acme-staging.api.letsencrypt.org", "fe01=")
+	ok, err := checkDNSPropagation("acme-staging.api.letsencrypt.org", "fe01=")
 	if err != nil || !ok {
 		t.Errorf("preCheckDNS failed for acme-staging.api.letsencrypt.org")
 	}
@@ -131,7 +360,7 @@ func TestPreCheckDNS(t *testing.T) {
 
 func TestLookupNameserversOK(t *testing.T) {
 	for _, tt := range lookupNameserversTestsOK {
-		nss, err := lookupNameservers(tt.fqdn)
+		nss, err := lookupNameservers(tt.fqdn, RecursiveNameservers, nil)
 		if err != nil {
 			t.Fatalf("#%s: got %q; want nil", tt.fqdn, err)
 		}
@@ -147,7 +376,7 @@ func TestLookupNameserversOK(t *testing.T) {
 
 func TestLookupNameserversErr(t *testing.T) {
 	for _, tt := range lookupNameserversTestsErr {
-		_, err := lookupNameservers(tt.fqdn)
+		_, err := lookupNameservers(tt.fqdn, RecursiveNameservers, nil)
 		if err == nil {
 			t.Fatalf("#%s: expected %q (error); got <nil>", tt.fqdn, tt.error)
 		}
@@ -171,6 +400,149 @@ func TestFindZoneByFqdn(t *testing.T) {
 	}
 }
 
+func TestFindZoneByFqdnPerZone(t *testing.T) {
+	soas := map[string]string{
+		"example.com.": "example.com. 3600 IN SOA ns.example.com. admin.example.com. 1 3600 600 86400 60",
+		"example.net.": "example.net. 3600 IN SOA ns.example.net. admin.example.net. 1 3600 600 86400 60",
+	}
+	// Fake resolver for the zones example.com and example.net, where
+	// www.example.net is a CNAME to the apex of the zone example.org.
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		name := strings.ToLower(r.Question[0].Name)
+		if soa, ok := soas[name]; ok {
+			rr, _ := dns.NewRR(soa)
+			m.Answer = append(m.Answer, rr)
+		} else if name == "www.example.net." {
+			cname, _ := dns.NewRR("www.example.net. 3600 IN CNAME example.org.")
+			soa, _ := dns.NewRR("example.org. 3600 IN SOA ns.example.org. admin.example.org. 1 3600 600 86400 60")
+			m.Answer = append(m.Answer, cname, soa)
+		}
+		w.WriteMsg(m)
+	})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: handler}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	nameservers := []string{pc.LocalAddr().String()}
+	for fqdn, zone := range map[string]string{
+		"_acme-challenge.example.com.":     "example.com.",
+		"_acme-challenge.www.example.com.": "example.com.",
+		"_acme-challenge.example.net.":     "example.net.",
+		"_acme-challenge.www.example.net.": "example.net.",
+		"_acme-challenge.WWW.Example.NET.": "example.net.",
+	} {
+		res, err := FindZoneByFqdn(fqdn, nameservers)
+		if err != nil {
+			t.Errorf("FindZoneByFqdn failed for %s: %v", fqdn, err)
+		}
+		if res != zone {
+			t.Errorf("%s: got %s; want %s", fqdn, res, zone)
+		}
+	}
+}
+
+func TestFindZoneByFqdnCache(t *testing.T) {
+	var mu sync.Mutex
+	var queries int
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		mu.Lock()
+		queries++
+		mu.Unlock()
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if strings.ToLower(r.Question[0].Name) == "example.com." {
+			soa, _ := dns.NewRR("example.com. 3600 IN SOA ns.example.com. admin.example.com. 1 3600 600 86400 60")
+			m.Answer = append(m.Answer, soa)
+		} else {
+			m.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(m)
+	})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: handler}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	nameservers := []string{pc.LocalAddr().String()}
+	lookup := func(zones *zoneCache, nameservers []string) int {
+		mu.Lock()
+		queries = 0
+		mu.Unlock()
+		for _, fqdn := range []string{
+			"_acme-challenge.a.example.com.",
+			"_acme-challenge.b.example.com.",
+			"_acme-challenge.c.example.com.",
+			"_acme-challenge.a.example.com.",
+		} {
+			zone, err := findZoneByFqdn(fqdn, nameservers, zones)
+			if err != nil || zone != "example.com." {
+				t.Errorf("%s: expected zone example.com. but got %q, %v", fqdn, zone, err)
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return queries
+	}
+
+	if n := lookup(nil, nameservers); n != 12 {
+		t.Errorf("Expected 12 queries without the cache but got %d", n)
+	}
+
+	zones := newZoneCache()
+	if n := lookup(zones, nameservers); n != 7 {
+		t.Errorf("Expected 7 queries with the cache but got %d", n)
+	}
+	if n := lookup(zones, nameservers); n != 0 {
+		t.Errorf("Expected no queries with a filled cache but got %d", n)
+	}
+
+	// Lookups using other nameservers do not share the cached answers.
+	if n := lookup(zones, append(nameservers, nameservers[0])); n != 7 {
+		t.Errorf("Expected 7 queries using other nameservers but got %d", n)
+	}
+
+	// Every issuance starts with an empty cache.
+	if n := lookup(newZoneCache(), nameservers); n != 7 {
+		t.Errorf("Expected 7 queries with a new cache but got %d", n)
+	}
+
+	// FindZoneByFqdn, used by the DNS providers, shares the cache of the
+	// issuances solving their challenges.
+	if n := lookup(activeZoneCache(), nameservers); n != 12 {
+		t.Errorf("Expected 12 queries outside of an issuance but got %d", n)
+	}
+	zones = acquireZoneCache()
+	if n := lookup(activeZoneCache(), nameservers); n != 7 {
+		t.Errorf("Expected 7 queries during an issuance but got %d", n)
+	}
+	if acquireZoneCache() != zones {
+		t.Errorf("Expected overlapping issuances to share the zone cache")
+	}
+	releaseZoneCache()
+	if n := lookup(activeZoneCache(), nameservers); n != 0 {
+		t.Errorf("Expected no queries while an issuance is still solving but got %d", n)
+	}
+	ClearFqdnCache()
+	if n := lookup(activeZoneCache(), nameservers); n != 7 {
+		t.Errorf("Expected 7 queries after ClearFqdnCache but got %d", n)
+	}
+	releaseZoneCache()
+	if activeZoneCache() != nil {
+		t.Errorf("Expected the zone cache to be dropped after the last issuance")
+	}
+}
+
 func TestCheckAuthoritativeNss(t *testing.T) {
 	for _, tt := range checkAuthoritativeNssTests {
 		ok, _ := checkAuthoritativeNss(tt.fqdn, tt.value, tt.ns)
@@ -204,3 +576,197 @@ func TestResolveConfServers(t *testing.T) {
 		}
 	}
 }
+
+func TestDNS01RecordFqdn(t *testing.T) {
+	tests := []struct {
+		domain string
+		fqdn   string
+	}{
+		{"example.com", "_acme-challenge.example.com."},
+		{"Example.COM.", "_acme-challenge.example.com."},
+		{"例え.テスト", "_acme-challenge.xn--r8jz45g.xn--zckzah."},
+		{"www.bücher.example", "_acme-challenge.www.xn--bcher-kva.example."},
+	}
+
+	for _, tt := range tests {
+		fqdn, _, _ := DNS01Record(tt.domain, "keyAuth")
+		if fqdn != tt.fqdn {
+			t.Errorf("#%s: expected %q; got %q", tt.domain, tt.fqdn, fqdn)
+		}
+	}
+}
+
+func TestDNS01RecordValue(t *testing.T) {
+	tests := []struct {
+		keyAuth string
+		value   string
+	}{
+		{"", "47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU"},
+		{"abc", "ungWv48Bz-pBQUDeXa4iI7ADYaOWF3qctBD_YfIAFa0"},
+		{
+			"evaGxfADs6pSRb2LAv9IZf17Dt3juxGJ-PCt92wr-oA.nP1qzpXGymHBrUEepNY9HCsQk7K8KhOypzEt62jcerQ",
+			"NGwKoXBgCT8JhEa0bK7AwfSqHyu_ZWeugV07fLGIVq0",
+		},
+	}
+
+	for _, tt := range tests {
+		_, value, ttl := DNS01Record("example.com", tt.keyAuth)
+		if value != tt.value {
+			t.Errorf("#%q: expected value %q; got %q", tt.keyAuth, tt.value, value)
+		}
+		if ttl != 120 {
+			t.Errorf("#%q: expected ttl 120; got %d", tt.keyAuth, ttl)
+		}
+	}
+}
+
+func TestAuthoritativePreCheck(t *testing.T) {
+	var mu sync.Mutex
+	var recursiveQueries []string
+	var authoritativeQueries []*dns.Msg
+
+	// Fake resolver for the zone example.com, served by ns.example.com.
+	recursive := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		mu.Lock()
+		recursiveQueries = append(recursiveQueries, r.Question[0].Name)
+		mu.Unlock()
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Name == "example.com." {
+			switch r.Question[0].Qtype {
+			case dns.TypeSOA:
+				soa, _ := dns.NewRR("example.com. 3600 IN SOA ns.example.com. admin.example.com. 1 3600 600 86400 60")
+				m.Answer = append(m.Answer, soa)
+			case dns.TypeNS:
+				ns, _ := dns.NewRR("example.com. 3600 IN NS ns.example.com.")
+				m.Answer = append(m.Answer, ns)
+			}
+		}
+		w.WriteMsg(m)
+	})
+	// Fake authoritative nameserver holding the challenge record.
+	authoritative := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		mu.Lock()
+		authoritativeQueries = append(authoritativeQueries, r)
+		mu.Unlock()
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+		if r.Question[0].Name == "_acme-challenge.example.com." && r.Question[0].Qtype == dns.TypeTXT {
+			txt, _ := dns.NewRR(`_acme-challenge.example.com. 120 IN TXT "value"`)
+			m.Answer = append(m.Answer, txt)
+		}
+		w.WriteMsg(m)
+	})
+
+	var addrs []string
+	for _, handler := range []dns.Handler{recursive, authoritative} {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := &dns.Server{PacketConn: pc, Handler: handler}
+		go server.ActivateAndServe()
+		defer server.Shutdown()
+		addrs = append(addrs, pc.LocalAddr().String())
+	}
+
+	defer func(nameservers []string) { RecursiveNameservers = nameservers }(RecursiveNameservers)
+	RecursiveNameservers = addrs[:1]
+	defer func(nsAddr func(string) string) { authoritativeNsAddr = nsAddr }(authoritativeNsAddr)
+	authoritativeNsAddr = func(ns string) string { return addrs[1] }
+	AuthoritativePreCheck = true
+	defer func() { AuthoritativePreCheck = false }()
+
+	ok, err := checkDNSPropagation("_acme-challenge.example.com.", "value")
+	if !ok || err != nil {
+		t.Fatalf("Expected the record to be found at the authoritative nameserver but got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, name := range recursiveQueries {
+		if name == "_acme-challenge.example.com." {
+			t.Errorf("Expected the recursive nameserver not to be queried for the challenge record but got %v", recursiveQueries)
+			break
+		}
+	}
+	if len(authoritativeQueries) != 1 {
+		t.Fatalf("Expected a single query at the authoritative nameserver but got %d", len(authoritativeQueries))
+	}
+	if q := authoritativeQueries[0]; q.RecursionDesired || q.Question[0].Qtype != dns.TypeTXT {
+		t.Errorf("Expected a non-recursive TXT query at the authoritative nameserver but got %v", q)
+	}
+}
+
+func TestWaitForDNSPropagation(t *testing.T) {
+	var mu sync.Mutex
+	var txtQueries int
+
+	// Fake resolver for the zone example.com, served by ns.example.com.
+	recursive := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Name == "example.com." {
+			switch r.Question[0].Qtype {
+			case dns.TypeSOA:
+				soa, _ := dns.NewRR("example.com. 3600 IN SOA ns.example.com. admin.example.com. 1 3600 600 86400 60")
+				m.Answer = append(m.Answer, soa)
+			case dns.TypeNS:
+				ns, _ := dns.NewRR("example.com. 3600 IN NS ns.example.com.")
+				m.Answer = append(m.Answer, ns)
+			}
+		}
+		w.WriteMsg(m)
+	})
+	// Fake authoritative nameserver which only returns the challenge
+	// record from the third query on.
+	authoritative := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+		if r.Question[0].Name == "_acme-challenge.example.com." && r.Question[0].Qtype == dns.TypeTXT {
+			mu.Lock()
+			txtQueries++
+			propagated := txtQueries > 2
+			mu.Unlock()
+			if propagated {
+				txt, _ := dns.NewRR(`_acme-challenge.example.com. 120 IN TXT "value"`)
+				m.Answer = append(m.Answer, txt)
+			}
+		}
+		w.WriteMsg(m)
+	})
+
+	var addrs []string
+	for _, handler := range []dns.Handler{recursive, authoritative} {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := &dns.Server{PacketConn: pc, Handler: handler}
+		go server.ActivateAndServe()
+		defer server.Shutdown()
+		addrs = append(addrs, pc.LocalAddr().String())
+	}
+
+	defer func(nsAddr func(string) string) { authoritativeNsAddr = nsAddr }(authoritativeNsAddr)
+	authoritativeNsAddr = func(ns string) string { return addrs[1] }
+
+	err := WaitForDNSPropagation("_acme-challenge.example.com", "value", 5*time.Second, 10*time.Millisecond, addrs[:1])
+	if err != nil {
+		t.Fatalf("Expected the record to propagate but got %v", err)
+	}
+	mu.Lock()
+	if txtQueries != 3 {
+		t.Errorf("Expected the record to be queried 3 times but got %d queries", txtQueries)
+	}
+	mu.Unlock()
+
+	err = WaitForDNSPropagation("_acme-challenge.example.com.", "other", 100*time.Millisecond, 10*time.Millisecond, addrs[:1])
+	if err == nil || !strings.Contains(err.Error(), "did not return the expected TXT record") {
+		t.Errorf("Expected a timeout naming the missing record but got %v", err)
+	}
+}
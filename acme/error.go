@@ -11,6 +11,16 @@ import (
 const (
 	tosAgreementError = "Must agree to subscriber agreement before any further actions"
 	invalidNonceError = "JWS has invalid anti-replay nonce"
+
+	// badNonceErrorV1 and badNonceErrorV2 are the problem types of errors
+	// returned for an invalid nonce.
+	badNonceErrorV1 = "urn:acme:error:badNonce"
+	badNonceErrorV2 = "urn:ietf:params:acme:error:badNonce"
+
+	// caaErrorV1 and caaErrorV2 are the problem types of errors returned
+	// if the CAA records of a domain forbid the CA to issue for it.
+	caaErrorV1 = "urn:acme:error:caa"
+	caaErrorV2 = "urn:ietf:params:acme:error:caa"
 )
 
 // RemoteError is the base type for all errors specific to the ACME protocol.
@@ -37,6 +47,12 @@ type NonceError struct {
 	RemoteError
 }
 
+// CAAError represents the error which is returned if the CAA records
+// of a domain do not permit the CA to issue a certificate for it.
+type CAAError struct {
+	RemoteError
+}
+
 type domainError struct {
 	Domain string
 	Error  error
@@ -44,7 +60,7 @@ type domainError struct {
 
 type challengeError struct {
 	RemoteError
-	records []validationRecord
+	records []ValidationRecord
 }
 
 func (c challengeError) Error() string {
@@ -82,13 +98,23 @@ func handleHTTPError(resp *http.Response) error {
 		return TOSError{errorDetail}
 	}
 
-	if errorDetail.StatusCode == http.StatusBadRequest && strings.HasPrefix(errorDetail.Detail, invalidNonceError) {
+	if errorDetail.StatusCode == http.StatusBadRequest && isBadNonce(errorDetail) {
 		return NonceError{errorDetail}
 	}
 
+	if errorDetail.Type == caaErrorV1 || errorDetail.Type == caaErrorV2 {
+		return CAAError{errorDetail}
+	}
+
 	return errorDetail
 }
 
-func handleChallengeError(chlng challenge) error {
+// isBadNonce reports whether err was caused by an invalid nonce.
+func isBadNonce(err RemoteError) bool {
+	return err.Type == badNonceErrorV1 || err.Type == badNonceErrorV2 ||
+		strings.HasPrefix(err.Detail, invalidNonceError)
+}
+
+func handleChallengeError(chlng AuthorizationChallenge) error {
 	return challengeError{chlng.Error, chlng.ValidationRecords}
 }
@@ -0,0 +1,141 @@
+package acme
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ExternalChallenge is a challenge handed to an ExternalChallengeSolver.
+type ExternalChallenge struct {
+	Domain  string
+	Type    Challenge
+	Token   string
+	KeyAuth string
+
+	uri string
+}
+
+// ExternalChallengeSolver solves challenges outside of lego, e.g. by
+// managing DNS records out-of-band. It is called once per issuance with
+// all challenges to solve, and signals completion by returning: once it
+// returns nil the challenges are expected to be in place, and the CA is
+// asked to validate them.
+type ExternalChallengeSolver func(challenges []ExternalChallenge) error
+
+// ExternalChallengeCleanUp is called with the challenges handed to an
+// ExternalChallengeSolver once the CA finished validating them, whether
+// they were valid or not, so that they can be removed again. It is not
+// called if the solver failed. Its error is logged.
+type ExternalChallengeCleanUp func(challenges []ExternalChallenge) error
+
+// SetExternalChallengeSolver makes the client hand the challenges to solve
+// instead of presenting them with its challenge providers. For each domain
+// the first combination offered by the CA which only consists of the given
+// challenge types, less the ones excluded with ExcludeChallenges, is used.
+// cleanUp may be nil. A nil solve restores the challenge providers.
+func (c *Client) SetExternalChallengeSolver(solve ExternalChallengeSolver, cleanUp ExternalChallengeCleanUp, challenges ...Challenge) error {
+	if solve != nil && len(challenges) == 0 {
+		return errors.New("acme: no challenge types given for the external challenge solver")
+	}
+	c.externalSolver = solve
+	c.externalCleanUp = cleanUp
+	c.externalChallenges = challenges
+	return nil
+}
+
+// solveChallengesExternally solves the challenges with the external
+// solver. It works like solveChallenges, but nothing is handed to the
+// solver unless solvable challenges were found for all domains.
+func (c *Client) solveChallengesExternally(challenges []authorizationResource) (map[string][]Challenge, map[string]error) {
+	used := make(map[string][]Challenge)
+	failures := make(map[string]error)
+	var external []ExternalChallenge
+	for _, authz := range challenges {
+		if authz.Body.Status == "valid" {
+			c.logf("[INFO][%s] acme: Authorization already valid; skipping challenge", authz.Domain)
+			continue
+		}
+		combination := c.chooseExternalChallenges(authz.Body)
+		if combination == nil {
+			failures[authz.Domain] = fmt.Errorf("[%s] acme: Could not determine solvers", authz.Domain)
+			continue
+		}
+		for _, idx := range combination {
+			chlng := authz.Body.Challenges[idx]
+			keyAuth, err := getKeyAuthorization(chlng.Token, c.jws.privKey)
+			if err != nil {
+				failures[authz.Domain] = err
+				break
+			}
+			used[authz.Domain] = append(used[authz.Domain], chlng.Type)
+			external = append(external, ExternalChallenge{Domain: authz.Domain, Type: chlng.Type, Token: chlng.Token, KeyAuth: keyAuth, uri: chlng.URI})
+		}
+	}
+	if len(failures) > 0 || len(external) == 0 {
+		return used, failures
+	}
+
+	c.logf("[INFO] acme: Handing %d challenges to the external solver", len(external))
+	start := time.Now()
+	if err := c.externalSolver(external); err != nil {
+		for _, chlng := range external {
+			failures[chlng.Domain] = fmt.Errorf("[%s] acme: External challenge solver failed: %v", chlng.Domain, err)
+		}
+		return used, failures
+	}
+
+	if c.externalCleanUp != nil {
+		defer func() {
+			if err := c.externalCleanUp(external); err != nil {
+				c.logf("[ERROR] acme: Error cleaning up external challenges: %v", err)
+			}
+		}()
+	}
+
+	for _, chlng := range external {
+		err := c.validateChallenge(c.jws, chlng.Domain, chlng.uri, AuthorizationChallenge{Resource: "challenge", Type: chlng.Type, Token: chlng.Token, KeyAuthorization: chlng.KeyAuth})
+		c.getMetrics().ObserveChallengeDuration(chlng.Type, time.Since(start), err == nil)
+		if err != nil {
+			c.logf("[ERROR][%s] acme: Could not solve %s challenge: %v", chlng.Domain, chlng.Type, err)
+			failures[chlng.Domain] = err
+		}
+	}
+
+	return used, failures
+}
+
+// chooseExternalChallenges returns the indexes of the challenges of the
+// first combination in auth which the external solver handles and which
+// were not excluded.
+func (c *Client) chooseExternalChallenges(auth Authorization) []int {
+	var chosen []int
+	chosenRank := -1
+	for _, combination := range auth.Combinations {
+		solvable := true
+		for _, idx := range combination {
+			challenge := auth.Challenges[idx].Type
+			if !containsChallenge(c.externalChallenges, challenge) || containsChallenge(c.excludedChallenges, challenge) {
+				solvable = false
+				break
+			}
+		}
+		if !solvable {
+			continue
+		}
+		rank := c.combinationRank(auth, combination)
+		if chosen == nil || rank < chosenRank {
+			chosen, chosenRank = combination, rank
+		}
+	}
+	return chosen
+}
+
+func containsChallenge(challenges []Challenge, challenge Challenge) bool {
+	for _, c := range challenges {
+		if c == challenge {
+			return true
+		}
+	}
+	return false
+}
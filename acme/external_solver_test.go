@@ -0,0 +1,178 @@
+package acme
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func newExternalSolverClient(t *testing.T, ca *mockCA) *Client {
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	return client
+}
+
+func TestObtainCertificateExternalSolver(t *testing.T) {
+	ca := newMockCA(t)
+	ca.pending = true
+	defer ca.Close()
+
+	client := newExternalSolverClient(t, ca)
+	provider := &recordingProvider{}
+	if err := client.SetChallengeProvider(HTTP01, provider); err != nil {
+		t.Fatalf("Could not set challenge provider: %v", err)
+	}
+
+	var calls, cleanUps [][]ExternalChallenge
+	err := client.SetExternalChallengeSolver(func(challenges []ExternalChallenge) error {
+		ca.mu.Lock()
+		defer ca.mu.Unlock()
+		if len(ca.validations) != 0 {
+			t.Error("Expected the challenges to be validated after the solver returned")
+		}
+		calls = append(calls, challenges)
+		return nil
+	}, func(challenges []ExternalChallenge) error {
+		ca.mu.Lock()
+		defer ca.mu.Unlock()
+		if len(ca.validations) != 2 {
+			t.Error("Expected the challenges to be cleaned up after their validation")
+		}
+		cleanUps = append(cleanUps, challenges)
+		return errors.New("records not removed")
+	}, HTTP01)
+	if err != nil {
+		t.Fatalf("Could not set external challenge solver: %v", err)
+	}
+
+	cert, failures := client.ObtainCertificate([]string{"example.com", "www.example.com"}, false, nil, false)
+	if len(failures) > 0 {
+		t.Fatalf("Expected no failures but got %v", failures)
+	}
+	if cert.Certificate == nil {
+		t.Error("Expected a certificate")
+	}
+	if len(provider.present) != 0 {
+		t.Errorf("Expected the challenge provider not to be used but got %v", provider.present)
+	}
+	if len(calls) != 1 || len(calls[0]) != 2 {
+		t.Fatalf("Expected the solver to be called once with 2 challenges but got %v", calls)
+	}
+	// An error of the clean up is only logged.
+	if len(cleanUps) != 1 || !reflect.DeepEqual(cleanUps[0], calls[0]) {
+		t.Errorf("Expected the challenges of the solver to be cleaned up once but got %v", cleanUps)
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if len(ca.validations) != 2 || len(ca.certRequests) != 1 {
+		t.Fatalf("Expected 2 validations and 1 certificate request but got %d and %d", len(ca.validations), len(ca.certRequests))
+	}
+	for i, chlng := range calls[0] {
+		if chlng.Type != HTTP01 || chlng.Token == "" {
+			t.Errorf("Unexpected challenge %+v", chlng)
+		}
+		keyAuth, err := getKeyAuthorization(chlng.Token, client.jws.privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chlng.KeyAuth != keyAuth || ca.validations[i].KeyAuthorization != keyAuth {
+			t.Errorf("Expected key authorization %q to be handed out and validated", keyAuth)
+		}
+	}
+}
+
+func TestObtainCertificateExternalSolverFails(t *testing.T) {
+	ca := newMockCA(t)
+	ca.pending = true
+	defer ca.Close()
+
+	client := newExternalSolverClient(t, ca)
+	cleanedUp := false
+	client.SetExternalChallengeSolver(func(challenges []ExternalChallenge) error {
+		return errors.New("records not created")
+	}, func(challenges []ExternalChallenge) error {
+		cleanedUp = true
+		return nil
+	}, HTTP01)
+
+	_, failures := client.ObtainCertificate([]string{"example.com"}, false, nil, false)
+	if len(failures) != 1 {
+		t.Errorf("Expected a failure for example.com but got %v", failures)
+	}
+	if cleanedUp {
+		t.Error("Expected no clean up after the solver failed")
+	}
+
+	// Without a supported challenge type the solver is not called.
+	called := false
+	client.SetExternalChallengeSolver(func(challenges []ExternalChallenge) error {
+		called = true
+		return nil
+	}, nil, DNS01)
+
+	_, failures = client.ObtainCertificate([]string{"example.com"}, false, nil, false)
+	if len(failures) != 1 || called {
+		t.Errorf("Expected a failure without calling the solver but got %v", failures)
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if len(ca.validations) != 0 || len(ca.certRequests) != 0 {
+		t.Errorf("Expected no validation and no certificate request but got %d and %d", len(ca.validations), len(ca.certRequests))
+	}
+}
+
+func TestSetExternalChallengeSolverWithoutChallenges(t *testing.T) {
+	ca := newMockCA(t)
+	defer ca.Close()
+
+	client := newExternalSolverClient(t, ca)
+	err := client.SetExternalChallengeSolver(func(challenges []ExternalChallenge) error { return nil }, nil)
+	if err == nil {
+		t.Error("Expected an error without challenge types")
+	}
+	if client.externalSolver != nil {
+		t.Error("Expected the external solver not to be set")
+	}
+
+	// The challenge providers are restored without challenge types.
+	if err := client.SetExternalChallengeSolver(nil, nil); err != nil {
+		t.Errorf("Expected no error but got %v", err)
+	}
+}
+
+func TestObtainCertificateExternalSolverExcludedChallenges(t *testing.T) {
+	ca := newMockCA(t)
+	ca.pending = true
+	defer ca.Close()
+
+	client := newExternalSolverClient(t, ca)
+	called := false
+	client.SetExternalChallengeSolver(func(challenges []ExternalChallenge) error {
+		called = true
+		return nil
+	}, nil, HTTP01)
+	client.ExcludeChallenges([]Challenge{HTTP01})
+
+	_, failures := client.ObtainCertificate([]string{"example.com"}, false, nil, false)
+	if len(failures) != 1 || called {
+		t.Errorf("Expected a failure without calling the solver but got %v", failures)
+	}
+}
@@ -0,0 +1,120 @@
+package acme
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// CertificateFiles names the files WriteCertificateFiles writes the parts of
+// a CertificateResource to. Empty paths are skipped.
+type CertificateFiles struct {
+	// Leaf receives the certificate for the domains only.
+	Leaf string
+	// Chain receives the issuer certificates.
+	Chain string
+	// FullChain receives the certificate followed by its issuers.
+	FullChain string
+	// PrivateKey receives the private key of the certificate.
+	PrivateKey string
+
+	// CertMode is the mode of the certificate files, 0644 if zero.
+	CertMode os.FileMode
+	// KeyMode is the mode of the private key file, 0600 if zero.
+	KeyMode os.FileMode
+}
+
+// WriteCertificateFiles writes the leaf certificate, its chain, the full
+// chain and the private key of cert to the files named in files. Existing
+// files are overwritten and their mode is changed to the configured one
+// before anything is written, so a private key is never readable with the
+// permissions of an older file.
+func WriteCertificateFiles(cert CertificateResource, files CertificateFiles) error {
+	if len(cert.Certificate) == 0 {
+		return errors.New("acme: no certificate to write")
+	}
+
+	leafCert, issuers, err := certificateChain(cert)
+	if err != nil {
+		return err
+	}
+	leaf := pemEncode(derCertificateBytes(leafCert.Raw))
+	var chain []byte
+	for _, issuer := range issuers {
+		chain = append(chain, pemEncode(derCertificateBytes(issuer.Raw))...)
+	}
+
+	certMode := files.CertMode
+	if certMode == 0 {
+		certMode = 0644
+	}
+	keyMode := files.KeyMode
+	if keyMode == 0 {
+		keyMode = 0600
+	}
+
+	if files.Chain != "" && len(chain) == 0 {
+		return errors.New("acme: the certificate has no chain to write")
+	}
+	if files.PrivateKey != "" && len(cert.PrivateKey) == 0 {
+		return errors.New("acme: the certificate has no private key to write")
+	}
+
+	outputs := []struct {
+		path string
+		data []byte
+		mode os.FileMode
+	}{
+		{files.Leaf, leaf, certMode},
+		{files.Chain, chain, certMode},
+		{files.FullChain, append(append([]byte(nil), leaf...), chain...), certMode},
+		{files.PrivateKey, cert.PrivateKey, keyMode},
+	}
+	for _, out := range outputs {
+		if out.path == "" {
+			continue
+		}
+		if err := writeFileMode(out.path, out.data, out.mode); err != nil {
+			return fmt.Errorf("acme: could not write %s: %v", out.path, err)
+		}
+	}
+	return nil
+}
+
+// certificateChain returns the leaf certificate of cert and its issuers.
+// Bundled certificates carry their chain, others have it in
+// IssuerCertificate.
+func certificateChain(cert CertificateResource) (*x509.Certificate, []*x509.Certificate, error) {
+	certs, err := parsePEMBundle(cert.Certificate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("acme: could not parse certificate: %v", err)
+	}
+	if len(certs) > 1 || len(cert.IssuerCertificate) == 0 {
+		return certs[0], certs[1:], nil
+	}
+
+	issuers, err := parsePEMBundle(cert.IssuerCertificate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("acme: could not parse issuer certificate: %v", err)
+	}
+	return certs[0], issuers, nil
+}
+
+// writeFileMode writes data to the file at path, creating it if needed, and
+// sets its mode to mode before writing.
+func writeFileMode(path string, data []byte, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -1,10 +1,14 @@
 package acme
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"runtime"
 	"strings"
@@ -17,6 +21,59 @@ var UserAgent string
 // HTTPClient is an HTTP client with a reasonable timeout value.
 var HTTPClient = http.Client{Timeout: 10 * time.Second}
 
+// ProviderHTTPClient is the HTTP client shared by the DNS providers which
+// talk to REST APIs. It reuses connections across requests and bounds every
+// request by a timeout, so a stalled API cannot hang a challenge. Replace it
+// or change its fields before using the providers to configure them all.
+var ProviderHTTPClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	},
+}
+
+// SetCACertificates makes HTTPClient, which talks to the CA, trust the
+// certificates in roots instead of the system roots. This allows to use
+// a test CA such as Pebble, which serves its directory with a certificate
+// issued by its own root. If insecureSkipVerify is true, the certificate
+// of the CA is not verified at all, which must only be done for testing.
+// Call it before NewClient, after replacing HTTPClient if at all.
+func SetCACertificates(roots *x509.CertPool, insecureSkipVerify bool) {
+	HTTPClient.Transport = &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{
+			RootCAs:            roots,
+			InsecureSkipVerify: insecureSkipVerify,
+		},
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+}
+
+// LoadCACertificates reads the PEM encoded certificates in files into a
+// pool for SetCACertificates.
+func LoadCACertificates(files ...string) (*x509.CertPool, error) {
+	roots := x509.NewCertPool()
+	for _, file := range files {
+		pemBytes, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if !roots.AppendCertsFromPEM(pemBytes) {
+			return nil, fmt.Errorf("no certificates found in %s", file)
+		}
+	}
+	return roots, nil
+}
+
 const (
 	// defaultGoUserAgent is the Go HTTP package user agent string. Too
 	// bad it isn't exported. If it changes, we should update it here, too.
@@ -26,15 +83,15 @@ const (
 	ourUserAgent = "xenolf-acme"
 )
 
-// httpHead performs a HEAD request with a proper User-Agent string.
+// httpHead performs a HEAD request with the User-Agent string ua.
 // The response body (resp.Body) is already closed when this function returns.
-func httpHead(url string) (resp *http.Response, err error) {
+func httpHead(url, ua string) (resp *http.Response, err error) {
 	req, err := http.NewRequest("HEAD", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to head %q: %v", url, err)
 	}
 
-	req.Header.Set("User-Agent", userAgent())
+	req.Header.Set("User-Agent", ua)
 
 	resp, err = HTTPClient.Do(req)
 	if err != nil {
@@ -44,27 +101,27 @@ func httpHead(url string) (resp *http.Response, err error) {
 	return resp, err
 }
 
-// httpPost performs a POST request with a proper User-Agent string.
+// httpPost performs a POST request with the User-Agent string ua.
 // Callers should close resp.Body when done reading from it.
-func httpPost(url string, bodyType string, body io.Reader) (resp *http.Response, err error) {
+func httpPost(url string, bodyType string, body io.Reader, ua string) (resp *http.Response, err error) {
 	req, err := http.NewRequest("POST", url, body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to post %q: %v", url, err)
 	}
 	req.Header.Set("Content-Type", bodyType)
-	req.Header.Set("User-Agent", userAgent())
+	req.Header.Set("User-Agent", ua)
 
 	return HTTPClient.Do(req)
 }
 
-// httpGet performs a GET request with a proper User-Agent string.
+// httpGet performs a GET request with the User-Agent string ua.
 // Callers should close resp.Body when done reading from it.
-func httpGet(url string) (resp *http.Response, err error) {
+func httpGet(url, ua string) (resp *http.Response, err error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get %q: %v", url, err)
 	}
-	req.Header.Set("User-Agent", userAgent())
+	req.Header.Set("User-Agent", ua)
 
 	return HTTPClient.Do(req)
 }
@@ -72,7 +129,32 @@ func httpGet(url string) (resp *http.Response, err error) {
 // getJSON performs an HTTP GET request and parses the response body
 // as JSON, into the provided respBody object.
 func getJSON(uri string, respBody interface{}) (http.Header, error) {
-	resp, err := httpGet(uri)
+	resp, err := httpGet(uri, userAgent())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get json %q: %v", uri, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return resp.Header, handleHTTPError(resp)
+	}
+
+	return resp.Header, json.NewDecoder(resp.Body).Decode(respBody)
+}
+
+// fetch requests the resource at url with a GET request carrying the
+// User-Agent of j, if any. Callers should close resp.Body when done reading
+// from it.
+func fetch(j *jws, url string) (*http.Response, error) {
+	if j == nil {
+		return httpGet(url, userAgent())
+	}
+	return httpGet(url, j.userAgent())
+}
+
+// fetchJSON works like getJSON, but requests the resource using fetch.
+func fetchJSON(j *jws, uri string, respBody interface{}) (http.Header, error) {
+	resp, err := fetch(j, uri)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get json %q: %v", uri, err)
 	}
@@ -146,3 +228,10 @@ func userAgent() string {
 	ua := fmt.Sprintf("%s (%s; %s) %s %s", defaultGoUserAgent, runtime.GOOS, runtime.GOARCH, ourUserAgent, UserAgent)
 	return strings.TrimSpace(ua)
 }
+
+// UserAgentString returns the User-Agent string sent with the requests of
+// the acme package, including UserAgent. DNS providers send it to their
+// APIs as well, so the requests can be attributed to the application.
+func UserAgentString() string {
+	return userAgent()
+}
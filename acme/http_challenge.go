@@ -2,13 +2,21 @@ package acme
 
 import (
 	"fmt"
-	"log"
+	"sync"
 )
 
 type httpChallenge struct {
 	jws      *jws
 	validate validateFunc
 	provider ChallengeProvider
+
+	// providerMu serializes the calls to sequential providers. It is
+	// shared by the solvers of a client.
+	providerMu *sync.Mutex
+
+	// logf writes the log entries of the solver to the logger of its
+	// client.
+	logf logFunc
 }
 
 // HTTP01ChallengePath returns the URL path for the `http-01` challenge
@@ -16,9 +24,9 @@ func HTTP01ChallengePath(token string) string {
 	return "/.well-known/acme-challenge/" + token
 }
 
-func (s *httpChallenge) Solve(chlng challenge, domain string) error {
+func (s *httpChallenge) Solve(chlng AuthorizationChallenge, domain string) error {
 
-	logf("[INFO][%s] acme: Trying to solve HTTP-01", domain)
+	s.logf.printf("[INFO][%s] acme: Trying to solve HTTP-01", domain)
 
 	// Generate the Key Authorization for the challenge
 	keyAuth, err := getKeyAuthorization(chlng.Token, s.jws.privKey)
@@ -26,16 +34,16 @@ func (s *httpChallenge) Solve(chlng challenge, domain string) error {
 		return err
 	}
 
-	err = s.provider.Present(domain, chlng.Token, keyAuth)
+	err = presentChallenge(s.providerMu, s.provider, domain, chlng.Token, keyAuth)
 	if err != nil {
 		return fmt.Errorf("[%s] error presenting token: %v", domain, err)
 	}
 	defer func() {
-		err := s.provider.CleanUp(domain, chlng.Token, keyAuth)
+		err := cleanUpChallenge(s.providerMu, s.provider, domain, chlng.Token, keyAuth)
 		if err != nil {
-			log.Printf("[%s] error cleaning up: %v", domain, err)
+			s.logf.printf("[ERROR][%s] acme: Error cleaning up: %v", domain, err)
 		}
 	}()
 
-	return s.validate(s.jws, domain, chlng.URI, challenge{Resource: "challenge", Type: chlng.Type, Token: chlng.Token, KeyAuthorization: keyAuth})
+	return s.validate(s.jws, domain, chlng.URI, AuthorizationChallenge{Resource: "challenge", Type: chlng.Type, Token: chlng.Token, KeyAuthorization: keyAuth})
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -11,10 +12,16 @@ import (
 // It may be instantiated without using the NewHTTPProviderServer function if
 // you want only to use the default values.
 type HTTPProviderServer struct {
-	iface    string
-	port     string
-	done     chan bool
-	listener net.Listener
+	iface      string
+	port       string
+	network    string
+	pathPrefix string
+	done       chan bool
+	listener   net.Listener
+
+	// logger receives the log entries of the server. When nil, they go to
+	// the package logger.
+	logger logFunc
 }
 
 // NewHTTPProviderServer creates a new HTTPProviderServer on the selected interface and port.
@@ -24,14 +31,44 @@ func NewHTTPProviderServer(iface, port string) *HTTPProviderServer {
 	return &HTTPProviderServer{iface: iface, port: port}
 }
 
+// SetPathPrefix makes the server serve the tokens below prefix, i.e. at
+// `prefix + HTTP01ChallengePath(token)`. This is useful if the server is
+// running behind a reverse proxy which forwards the challenge requests to a
+// path other than the root.
+func (s *HTTPProviderServer) SetPathPrefix(prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	s.pathPrefix = prefix
+}
+
+// SetNetwork sets the address family the server listens on: "tcp4" for
+// IPv4 only, "tcp6" for IPv6 only, or "tcp" (the default) for both. Let's
+// Encrypt validates over IPv6 if the domain has an AAAA record, so the
+// server should accept IPv6 connections in that case.
+func (s *HTTPProviderServer) SetNetwork(network string) error {
+	switch network {
+	case "", "tcp", "tcp4", "tcp6":
+		s.network = network
+		return nil
+	}
+	return fmt.Errorf("unsupported network %q for the HTTP challenge server", network)
+}
+
 // Present starts a web server and makes the token available at `HTTP01ChallengePath(token)` for web requests.
 func (s *HTTPProviderServer) Present(domain, token, keyAuth string) error {
 	if s.port == "" {
 		s.port = "80"
 	}
 
+	network := s.network
+	if network == "" {
+		network = "tcp"
+	}
+
 	var err error
-	s.listener, err = net.Listen("tcp", net.JoinHostPort(s.iface, s.port))
+	s.listener, err = net.Listen(network, net.JoinHostPort(s.iface, s.port))
 	if err != nil {
 		return fmt.Errorf("Could not start HTTP server for challenge -> %v", err)
 	}
@@ -52,18 +89,23 @@ func (s *HTTPProviderServer) CleanUp(domain, token, keyAuth string) error {
 }
 
 func (s *HTTPProviderServer) serve(domain, token, keyAuth string) {
-	path := HTTP01ChallengePath(token)
+	path := s.pathPrefix + HTTP01ChallengePath(token)
 
 	// The handler validates the HOST header and request type.
-	// For validation it then writes the token the server returned with the challenge
+	// For validation it then writes the token the server returned with the challenge.
+	// HEAD requests, e.g. from health checks of reverse proxies, get the headers only.
 	mux := http.NewServeMux()
 	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
-		if strings.HasPrefix(r.Host, domain) && r.Method == "GET" {
+		if strings.HasPrefix(r.Host, domain) && (r.Method == "GET" || r.Method == "HEAD") {
 			w.Header().Add("Content-Type", "text/plain")
+			w.Header().Set("Content-Length", strconv.Itoa(len(keyAuth)))
+			if r.Method == "HEAD" {
+				return
+			}
 			w.Write([]byte(keyAuth))
-			logf("[INFO][%s] Served key authentication", domain)
+			s.logf("[INFO][%s] Served key authentication", domain)
 		} else {
-			logf("[WARN] Received request for domain %s with method %s but the domain did not match any challenge. Please ensure your are passing the HOST header properly.", r.Host, r.Method)
+			s.logf("[WARN] Received request for domain %s with method %s but the domain did not match any challenge. Please ensure your are passing the HOST header properly.", r.Host, r.Method)
 			w.Write([]byte("TEST"))
 		}
 	})
@@ -77,3 +119,18 @@ func (s *HTTPProviderServer) serve(domain, token, keyAuth string) {
 	httpServer.Serve(s.listener)
 	s.done <- true
 }
+
+// setLogger makes the server write its log entries to logf.
+func (s *HTTPProviderServer) setLogger(logf logFunc) {
+	s.logger = logf
+}
+
+// logf writes a log entry to the logger of the server, or to the package
+// logger if it has none.
+func (s *HTTPProviderServer) logf(format string, args ...interface{}) {
+	if s.logger != nil {
+		s.logger(format, args...)
+	} else {
+		logf(format, args...)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"strings"
 	"testing"
 )
@@ -11,10 +13,10 @@ import (
 func TestHTTPChallenge(t *testing.T) {
 	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
 	j := &jws{privKey: privKey}
-	clientChallenge := challenge{Type: HTTP01, Token: "http1"}
-	mockValidate := func(_ *jws, _, _ string, chlng challenge) error {
+	clientChallenge := AuthorizationChallenge{Type: HTTP01, Token: "http1"}
+	mockValidate := func(_ *jws, _, _ string, chlng AuthorizationChallenge) error {
 		uri := "http://localhost:23457/.well-known/acme-challenge/" + chlng.Token
-		resp, err := httpGet(uri)
+		resp, err := httpGet(uri, userAgent())
 		if err != nil {
 			return err
 		}
@@ -43,10 +45,62 @@ func TestHTTPChallenge(t *testing.T) {
 	}
 }
 
+func TestHTTPChallengePathPrefix(t *testing.T) {
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	j := &jws{privKey: privKey}
+	clientChallenge := AuthorizationChallenge{Type: HTTP01, Token: "http3"}
+	mockValidate := func(_ *jws, _, _ string, chlng AuthorizationChallenge) error {
+		uri := "http://localhost:23458/proxied/.well-known/acme-challenge/" + chlng.Token
+
+		req, err := http.NewRequest("HEAD", uri, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := HTTPClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Head(%q) StatusCode: got %d, want %d", uri, resp.StatusCode, http.StatusOK)
+		}
+		if want := "text/plain"; resp.Header.Get("Content-Type") != want {
+			t.Errorf("Head(%q) Content-Type: got %q, want %q", uri, resp.Header.Get("Content-Type"), want)
+		}
+		if want := int64(len(chlng.KeyAuthorization)); resp.ContentLength != want {
+			t.Errorf("Head(%q) Content-Length: got %d, want %d", uri, resp.ContentLength, want)
+		}
+
+		resp, err = httpGet(uri, userAgent())
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if string(body) != chlng.KeyAuthorization {
+			t.Errorf("Get(%q) Body: got %q, want %q", uri, body, chlng.KeyAuthorization)
+		}
+
+		return nil
+	}
+	provider := NewHTTPProviderServer("", "23458")
+	provider.SetPathPrefix("proxied/")
+	solver := &httpChallenge{jws: j, validate: mockValidate, provider: provider}
+
+	if err := solver.Solve(clientChallenge, "localhost:23458"); err != nil {
+		t.Errorf("Solve error: got %v, want nil", err)
+	}
+}
+
 func TestHTTPChallengeInvalidPort(t *testing.T) {
 	privKey, _ := rsa.GenerateKey(rand.Reader, 128)
 	j := &jws{privKey: privKey}
-	clientChallenge := challenge{Type: HTTP01, Token: "http2"}
+	clientChallenge := AuthorizationChallenge{Type: HTTP01, Token: "http2"}
 	solver := &httpChallenge{jws: j, validate: stubValidate, provider: &HTTPProviderServer{port: "123456"}}
 
 	if err := solver.Solve(clientChallenge, "localhost:123456"); err == nil {
@@ -55,3 +109,64 @@ func TestHTTPChallengeInvalidPort(t *testing.T) {
 		t.Errorf("Solve error: got %q, want suffix %q", err.Error(), want)
 	}
 }
+
+func TestHTTPChallengeIPv6(t *testing.T) {
+	if l, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skip("IPv6 is not available:", err)
+	} else {
+		l.Close()
+	}
+
+	privKey, _ := rsa.GenerateKey(rand.Reader, 512)
+	j := &jws{privKey: privKey}
+	clientChallenge := AuthorizationChallenge{Type: HTTP01, Token: "http4"}
+	mockValidate := func(_ *jws, _, _ string, chlng AuthorizationChallenge) error {
+		uri := "http://[::1]:23459/.well-known/acme-challenge/" + chlng.Token
+		resp, err := httpGet(uri, userAgent())
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if string(body) != chlng.KeyAuthorization {
+			t.Errorf("Get(%q) Body: got %q, want %q", uri, body, chlng.KeyAuthorization)
+		}
+		return nil
+	}
+
+	server := NewHTTPProviderServer("::", "23459")
+	if err := server.SetNetwork("tcp6"); err != nil {
+		t.Fatal(err)
+	}
+	solver := &httpChallenge{jws: j, validate: mockValidate, provider: server}
+
+	if err := solver.Solve(clientChallenge, "[::1]"); err != nil {
+		t.Errorf("Solve error: got %v, want nil", err)
+	}
+}
+
+func TestClientSetHTTP01Family(t *testing.T) {
+	c := &Client{solvers: map[Challenge]solver{HTTP01: &httpChallenge{provider: &HTTPProviderServer{}}}}
+
+	if err := c.SetHTTP01Family("udp"); err == nil {
+		t.Error("Expected an error for an unsupported family")
+	}
+	if err := c.SetHTTP01Family("tcp6"); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.solvers[HTTP01].(*httpChallenge).provider.(*HTTPProviderServer).network; got != "tcp6" {
+		t.Errorf("Expected the HTTP server to listen on tcp6 but got %q", got)
+	}
+
+	// The family is kept when the address changes.
+	if err := c.SetHTTPAddress(":8080"); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.solvers[HTTP01].(*httpChallenge).provider.(*HTTPProviderServer).network; got != "tcp6" {
+		t.Errorf("Expected the new HTTP server to listen on tcp6 but got %q", got)
+	}
+}
@@ -1,9 +1,15 @@
 package acme
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -15,7 +21,7 @@ func TestHTTPHeadUserAgent(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	_, err := httpHead(ts.URL)
+	_, err := httpHead(ts.URL, userAgent())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -36,7 +42,7 @@ func TestHTTPGetUserAgent(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	res, err := httpGet(ts.URL)
+	res, err := httpGet(ts.URL, userAgent())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -58,7 +64,7 @@ func TestHTTPPostUserAgent(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	res, err := httpPost(ts.URL, "text/plain", strings.NewReader("falalalala"))
+	res, err := httpPost(ts.URL, "text/plain", strings.NewReader("falalalala"), userAgent())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -98,3 +104,84 @@ func TestUserAgent(t *testing.T) {
 		t.Errorf("Expected custom UA to contain %s, got '%s'", UserAgent, ua)
 	}
 }
+
+func TestClientSetUserAgent(t *testing.T) {
+	var mu sync.Mutex
+	agents := map[string]string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents[r.Method] = r.Header.Get("User-Agent")
+		mu.Unlock()
+		w.Header().Set("Replay-Nonce", "nonce")
+		w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
+	client := &Client{jws: newTestJWS(t, ts.URL)}
+	client.SetUserAgent(" MyClient/4.5.6 ")
+
+	if _, err := postJSON(client.jws, ts.URL, struct{}{}, nil); err != nil {
+		t.Fatalf("Could not post: %v", err)
+	}
+	if _, err := fetchJSON(client.jws, ts.URL, &struct{}{}); err != nil {
+		t.Fatalf("Could not fetch: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := userAgent() + " MyClient/4.5.6"
+	for _, method := range []string{"HEAD", "POST", "GET"} {
+		if agents[method] != expected {
+			t.Errorf("Expected the %s request to have the User-Agent %q but got %q", method, expected, agents[method])
+		}
+	}
+	if UserAgentString() != userAgent() || strings.Contains(UserAgentString(), "MyClient") {
+		t.Errorf("Expected the client User-Agent not to change the package User-Agent, got %q", UserAgentString())
+	}
+}
+
+func TestNewClientWithTestCA(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSONResponse(w, Directory{
+			NewAuthzURL:   "https://" + r.Host + "/new-authz",
+			NewCertURL:    "https://" + r.Host + "/new-cert",
+			NewRegURL:     "https://" + r.Host + "/new-reg",
+			RevokeCertURL: "https://" + r.Host + "/revoke-cert",
+		})
+	}))
+	defer ts.Close()
+
+	defer func(client http.Client) { HTTPClient = client }(HTTPClient)
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{email: "test@test.com", privatekey: key}
+
+	if _, err := NewClient(ts.URL, user, RSA2048); err == nil {
+		t.Error("Expected the certificate of the test CA not to be trusted")
+	}
+
+	file, err := ioutil.TempFile("", "lego-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	pem.Encode(file, &pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	file.Close()
+
+	roots, err := LoadCACertificates(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetCACertificates(roots, false)
+	if _, err := NewClient(ts.URL, user, RSA2048); err != nil {
+		t.Errorf("Expected the test CA to be trusted, got %v", err)
+	}
+
+	SetCACertificates(nil, true)
+	if _, err := NewClient(ts.URL, user, RSA2048); err != nil {
+		t.Errorf("Expected the verification to be skipped, got %v", err)
+	}
+}
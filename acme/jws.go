@@ -13,24 +13,62 @@ import (
 	"gopkg.in/square/go-jose.v1"
 )
 
+// jws signs the requests to the ACME server. The Replay-Nonce of every
+// response to a signed request is kept in nonces and used for the next
+// request, so a new nonce only has to be fetched from the directory if none
+// is left, e.g. because the server rejected the last one.
 type jws struct {
 	directoryURL string
 	privKey      crypto.PrivateKey
 	nonces       nonceManager
+	// clientUserAgent is appended to the User-Agent of the requests to the
+	// CA.
+	clientUserAgent string
 }
 
+// keyAsJWK returns the public key as a JWK whose "alg" is the algorithm
+// signatureAlgorithm picks for it, or nil if the key is not supported.
 func keyAsJWK(key interface{}) *jose.JsonWebKey {
+	alg, err := signatureAlgorithm(key)
+	if err != nil {
+		return nil
+	}
 	switch k := key.(type) {
 	case *ecdsa.PublicKey:
-		return &jose.JsonWebKey{Key: k, Algorithm: "EC"}
+		return &jose.JsonWebKey{Key: k, Algorithm: string(alg)}
 	case *rsa.PublicKey:
-		return &jose.JsonWebKey{Key: k, Algorithm: "RSA"}
+		return &jose.JsonWebKey{Key: k, Algorithm: string(alg)}
 
 	default:
 		return nil
 	}
 }
 
+// signatureAlgorithm returns the JWS algorithm used to sign with the given
+// public or private key: RS256 for RSA keys and ES256, ES384 or ES512 for
+// ECDSA keys, depending on their curve.
+func signatureAlgorithm(key interface{}) (jose.SignatureAlgorithm, error) {
+	if signer, ok := key.(crypto.Signer); ok {
+		key = signer.Public()
+	}
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return jose.RS256, nil
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		case elliptic.P521():
+			return jose.ES512, nil
+		}
+		return "", fmt.Errorf("Unsupported ECDSA curve %s for the account key", k.Curve.Params().Name)
+	}
+	return "", fmt.Errorf("Unsupported account key type %T", key)
+}
+
 // Posts a JWS signed message to the specified URL.
 // It does NOT close the response body, so the caller must
 // do that if no error was returned.
@@ -40,7 +78,7 @@ func (j *jws) post(url string, content []byte) (*http.Response, error) {
 		return nil, fmt.Errorf("Failed to sign content -> %s", err.Error())
 	}
 
-	resp, err := httpPost(url, "application/jose+json", bytes.NewBuffer([]byte(signedContent.FullSerialize())))
+	resp, err := httpPost(url, "application/jose+json", bytes.NewBuffer([]byte(signedContent.FullSerialize())), j.userAgent())
 	if err != nil {
 		return nil, fmt.Errorf("Failed to HTTP POST to %s -> %s", url, err.Error())
 	}
@@ -54,17 +92,9 @@ func (j *jws) post(url string, content []byte) (*http.Response, error) {
 }
 
 func (j *jws) signContent(content []byte) (*jose.JsonWebSignature, error) {
-
-	var alg jose.SignatureAlgorithm
-	switch k := j.privKey.(type) {
-	case *rsa.PrivateKey:
-		alg = jose.RS256
-	case *ecdsa.PrivateKey:
-		if k.Curve == elliptic.P256() {
-			alg = jose.ES256
-		} else if k.Curve == elliptic.P384() {
-			alg = jose.ES384
-		}
+	alg, err := signatureAlgorithm(j.privKey)
+	if err != nil {
+		return nil, err
 	}
 
 	signer, err := jose.NewSigner(alg, j.privKey)
@@ -80,12 +110,23 @@ func (j *jws) signContent(content []byte) (*jose.JsonWebSignature, error) {
 	return signed, nil
 }
 
+// Nonce implements jose.NonceSource. It returns a pooled nonce if there is
+// one and fetches a new one from the directory otherwise.
 func (j *jws) Nonce() (string, error) {
 	if nonce, ok := j.nonces.Pop(); ok {
 		return nonce, nil
 	}
 
-	return getNonce(j.directoryURL)
+	return getNonce(j.directoryURL, j.userAgent())
+}
+
+// userAgent returns the User-Agent string for the requests signed by j,
+// which includes the application User-Agent set on the client.
+func (j *jws) userAgent() string {
+	if j.clientUserAgent == "" {
+		return userAgent()
+	}
+	return userAgent() + " " + j.clientUserAgent
 }
 
 type nonceManager struct {
@@ -112,8 +153,8 @@ func (n *nonceManager) Push(nonce string) {
 	n.nonces = append(n.nonces, nonce)
 }
 
-func getNonce(url string) (string, error) {
-	resp, err := httpHead(url)
+func getNonce(url, ua string) (string, error) {
+	resp, err := httpHead(url, ua)
 	if err != nil {
 		return "", fmt.Errorf("Failed to get nonce from HTTP HEAD -> %s", err.Error())
 	}
@@ -0,0 +1,284 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"gopkg.in/square/go-jose.v1"
+)
+
+// nonceServer is an ACME endpoint which hands out a new nonce with every
+// response and records the nonces of the JWS it receives.
+type nonceServer struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	heads int
+	used  []string
+	// reject lists the nonces to reject with a badNonce error.
+	reject map[string]bool
+	// omitOnReject leaves out the Replay-Nonce header when rejecting.
+	omitOnReject bool
+	// problemType is the type of the badNonce problem, urn:acme:error:badNonce
+	// if empty.
+	problemType string
+}
+
+func newNonceServer() *nonceServer {
+	s := &nonceServer{reject: make(map[string]bool)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *nonceServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Method == "HEAD" {
+		s.heads++
+		w.Header().Set("Replay-Nonce", fmt.Sprintf("head-%d", s.heads))
+		return
+	}
+
+	nonce, err := jwsNonce(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.used = append(s.used, nonce)
+
+	if s.reject[nonce] {
+		if !s.omitOnReject {
+			w.Header().Set("Replay-Nonce", fmt.Sprintf("post-%d", len(s.used)))
+		}
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusBadRequest)
+		if s.problemType != "" {
+			fmt.Fprintf(w, `{"type":"%s","detail":"Unable to validate JWS :: Invalid nonce"}`, s.problemType)
+			return
+		}
+		fmt.Fprintf(w, `{"type":"urn:acme:error:badNonce","detail":"%s: %s"}`, invalidNonceError, nonce)
+		return
+	}
+
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("post-%d", len(s.used)))
+	w.Write([]byte("{}"))
+}
+
+// jwsNonce returns the nonce in the protected header of the JWS in the
+// body of r.
+func jwsNonce(r *http.Request) (string, error) {
+	var signed struct {
+		Protected string `json:"protected"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&signed); err != nil {
+		return "", err
+	}
+	protected, err := base64.RawURLEncoding.DecodeString(signed.Protected)
+	if err != nil {
+		return "", err
+	}
+	var header struct {
+		Nonce string `json:"nonce"`
+	}
+	err = json.Unmarshal(protected, &header)
+	return header.Nonce, err
+}
+
+func newTestJWS(t *testing.T, directoryURL string) *jws {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	return &jws{privKey: key, directoryURL: directoryURL}
+}
+
+func TestJWSReusesNonces(t *testing.T) {
+	s := newNonceServer()
+	defer s.Close()
+	j := newTestJWS(t, s.URL)
+
+	for i := 0; i < 3; i++ {
+		if _, err := postJSON(j, s.URL, struct{}{}, nil); err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.heads != 1 {
+		t.Errorf("Expected a single nonce fetch but got %d", s.heads)
+	}
+	if expected := []string{"head-1", "post-1", "post-2"}; !reflect.DeepEqual(s.used, expected) {
+		t.Errorf("Expected nonces %v to be used but got %v", expected, s.used)
+	}
+}
+
+func TestJWSRefreshesNonceOnBadNonce(t *testing.T) {
+	s := newNonceServer()
+	defer s.Close()
+	s.reject["post-1"] = true
+	s.omitOnReject = true
+	j := newTestJWS(t, s.URL)
+
+	for i := 0; i < 2; i++ {
+		if _, err := postJSON(j, s.URL, struct{}{}, nil); err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.heads != 2 {
+		t.Errorf("Expected a new nonce to be fetched after the badNonce error but got %d fetches", s.heads)
+	}
+	if expected := []string{"head-1", "post-1", "head-2"}; !reflect.DeepEqual(s.used, expected) {
+		t.Errorf("Expected nonces %v to be used but got %v", expected, s.used)
+	}
+}
+
+func TestJWSRetriesBadNonceProblem(t *testing.T) {
+	for _, problemType := range []string{badNonceErrorV1, badNonceErrorV2} {
+		s := newNonceServer()
+		s.reject["head-1"] = true
+		s.problemType = problemType
+		j := newTestJWS(t, s.URL)
+
+		_, err := postJSON(j, s.URL, struct{}{}, nil)
+		s.Close()
+		if err != nil {
+			t.Fatalf("%s: expected the retry to succeed but got %v", problemType, err)
+		}
+
+		if expected := []string{"head-1", "post-1"}; !reflect.DeepEqual(s.used, expected) {
+			t.Errorf("%s: expected nonces %v to be used but got %v", problemType, expected, s.used)
+		}
+	}
+}
+
+// rfc7638Thumbprint computes the JWK thumbprint of key as described in
+// RFC 7638, independently of go-jose.
+func rfc7638Thumbprint(key crypto.PublicKey) string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	var input string
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		input = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`,
+			b64(big.NewInt(int64(k.E)).Bytes()), b64(k.N.Bytes()))
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		pad := func(n *big.Int) []byte {
+			b := n.Bytes()
+			return append(make([]byte, size-len(b)), b...)
+		}
+		input = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`,
+			k.Curve.Params().Name, b64(pad(k.X)), b64(pad(k.Y)))
+	}
+	sum := sha256.Sum256([]byte(input))
+	return b64(sum[:])
+}
+
+func TestJWSSignatureAlgorithm(t *testing.T) {
+	type signingKey struct {
+		key crypto.Signer
+		alg string
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	keys := []signingKey{{rsaKey, "RS256"}}
+	for curve, alg := range map[elliptic.Curve]string{
+		elliptic.P256(): "ES256",
+		elliptic.P384(): "ES384",
+		elliptic.P521(): "ES512",
+	} {
+		ecKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatal("Could not generate test key:", err)
+		}
+		keys = append(keys, signingKey{ecKey, alg})
+	}
+
+	for _, tt := range keys {
+		j := &jws{privKey: tt.key}
+		j.nonces.Push("nonce")
+
+		signed, err := j.signContent([]byte("{}"))
+		if err != nil {
+			t.Fatalf("%s: could not sign content: %v", tt.alg, err)
+		}
+		if _, err := signed.Verify(tt.key.Public()); err != nil {
+			t.Errorf("%s: could not verify the signature: %v", tt.alg, err)
+		}
+
+		var serialized struct {
+			Protected string `json:"protected"`
+		}
+		if err := json.Unmarshal([]byte(signed.FullSerialize()), &serialized); err != nil {
+			t.Fatal(err)
+		}
+		protected, err := base64.RawURLEncoding.DecodeString(serialized.Protected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var header struct {
+			Alg string          `json:"alg"`
+			JWK jose.JsonWebKey `json:"jwk"`
+		}
+		if err := json.Unmarshal(protected, &header); err != nil {
+			t.Fatalf("%s: could not parse the protected header: %v", tt.alg, err)
+		}
+		if header.Alg != tt.alg {
+			t.Errorf("%s: expected the alg header %s but got %s", tt.alg, tt.alg, header.Alg)
+		}
+
+		expected := rfc7638Thumbprint(tt.key.Public())
+		thumbprint, err := header.JWK.Thumbprint(crypto.SHA256)
+		if err != nil {
+			t.Fatalf("%s: could not compute the thumbprint of the header JWK: %v", tt.alg, err)
+		}
+		if got := base64.RawURLEncoding.EncodeToString(thumbprint); got != expected {
+			t.Errorf("%s: expected the header JWK thumbprint %s but got %s", tt.alg, expected, got)
+		}
+
+		jwk := keyAsJWK(tt.key.Public())
+		if jwk == nil || jwk.Algorithm != tt.alg {
+			t.Fatalf("%s: expected the account JWK to have the alg %s but got %+v", tt.alg, tt.alg, jwk)
+		}
+		thumbprint, err = jwk.Thumbprint(crypto.SHA256)
+		if err != nil {
+			t.Fatalf("%s: could not compute the thumbprint of the account JWK: %v", tt.alg, err)
+		}
+		if got := base64.RawURLEncoding.EncodeToString(thumbprint); got != expected {
+			t.Errorf("%s: expected the account JWK thumbprint %s but got %s", tt.alg, expected, got)
+		}
+	}
+}
+
+func TestJWSUnsupportedKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	j := &jws{privKey: key}
+	if _, err := j.signContent([]byte("{}")); err == nil {
+		t.Error("Expected signing with a P-224 key to fail")
+	}
+	if keyAsJWK(key.Public()) != nil {
+		t.Error("Expected no JWK for a P-224 key")
+	}
+}
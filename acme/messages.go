@@ -1,16 +1,65 @@
 package acme
 
 import (
+	"encoding/json"
+	"net"
 	"time"
 
 	"gopkg.in/square/go-jose.v1"
 )
 
-type directory struct {
+// Directory is the directory document of an ACME server, listing the
+// URLs of its resources and its meta data.
+type Directory struct {
 	NewAuthzURL   string `json:"new-authz"`
 	NewCertURL    string `json:"new-cert"`
 	NewRegURL     string `json:"new-reg"`
 	RevokeCertURL string `json:"revoke-cert"`
+	// RenewalInfoURL is the ACME Renewal Information (ARI) endpoint, if
+	// the CA offers one.
+	RenewalInfoURL string        `json:"renewalInfo,omitempty"`
+	Meta           DirectoryMeta `json:"meta"`
+}
+
+// DirectoryMeta is the optional meta data an ACME server advertises in its
+// directory.
+type DirectoryMeta struct {
+	// TermsOfService is the URL of the current terms of service.
+	TermsOfService string `json:"termsOfService,omitempty"`
+	// Website is the URL of a website describing the CA.
+	Website string `json:"website,omitempty"`
+	// CAAIdentities are the domain names the CA recognizes as referring to
+	// itself in CAA records.
+	CAAIdentities []string `json:"caaIdentities,omitempty"`
+	// ExternalAccountRequired is set if the CA requires new accounts to be
+	// bound to an account in an external system.
+	ExternalAccountRequired bool `json:"externalAccountRequired,omitempty"`
+	// Profiles maps the names of the certificate profiles the CA offers to
+	// their descriptions.
+	Profiles map[string]string `json:"profiles,omitempty"`
+}
+
+// UnmarshalJSON decodes the meta data, also accepting the hyphenated field
+// names older servers use, e.g. "terms-of-service".
+func (m *DirectoryMeta) UnmarshalJSON(data []byte) error {
+	type meta DirectoryMeta
+	var aux struct {
+		meta
+		TermsOfService string   `json:"terms-of-service"`
+		CAAIdentities  []string `json:"caa-identities"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	*m = DirectoryMeta(aux.meta)
+	if m.TermsOfService == "" {
+		m.TermsOfService = aux.TermsOfService
+	}
+	if m.CAAIdentities == nil {
+		m.CAAIdentities = aux.CAAIdentities
+	}
+	return nil
 }
 
 type registrationMessage struct {
@@ -29,6 +78,9 @@ type Registration struct {
 	Agreement      string          `json:"agreement,omitempty"`
 	Authorizations string          `json:"authorizations,omitempty"`
 	Certificates   string          `json:"certificates,omitempty"`
+	// Orders is the URL of the list of orders of the account, if the CA
+	// offers one.
+	Orders string `json:"orders,omitempty"`
 }
 
 // RegistrationResource represents all important informations about a registration
@@ -41,27 +93,41 @@ type RegistrationResource struct {
 }
 
 type authorizationResource struct {
-	Body       authorization
+	Body       Authorization
 	Domain     string
 	NewCertURL string
 	AuthURL    string
 }
 
-type authorization struct {
-	Resource     string      `json:"resource,omitempty"`
-	Identifier   identifier  `json:"identifier"`
-	Status       string      `json:"status,omitempty"`
-	Expires      time.Time   `json:"expires,omitempty"`
-	Challenges   []challenge `json:"challenges,omitempty"`
-	Combinations [][]int     `json:"combinations,omitempty"`
+// Authorization represents an authorization object as returned by the ACME
+// server. Status reflects the overall state of the authorization while each
+// entry in Challenges carries the state and, if any, the error of that challenge.
+type Authorization struct {
+	Resource     string                   `json:"resource,omitempty"`
+	Identifier   Identifier               `json:"identifier"`
+	Status       string                   `json:"status,omitempty"`
+	Expires      time.Time                `json:"expires,omitempty"`
+	Challenges   []AuthorizationChallenge `json:"challenges,omitempty"`
+	Combinations [][]int                  `json:"combinations,omitempty"`
 }
 
-type identifier struct {
+// Identifier is the identifier (a domain name or an IP address) an authorization is for.
+type Identifier struct {
 	Type  string `json:"type"`
 	Value string `json:"value"`
 }
 
-type validationRecord struct {
+// newIdentifier returns the identifier for name. Names which parse as an IP
+// address get the "ip" type, all others the "dns" type.
+func newIdentifier(name string) Identifier {
+	if net.ParseIP(name) != nil {
+		return Identifier{Type: "ip", Value: name}
+	}
+	return Identifier{Type: "dns", Value: name}
+}
+
+// ValidationRecord holds the details of a validation attempt made by the ACME server.
+type ValidationRecord struct {
 	URI               string   `json:"url,omitempty"`
 	Hostname          string   `json:"hostname,omitempty"`
 	Port              string   `json:"port,omitempty"`
@@ -69,7 +135,10 @@ type validationRecord struct {
 	UsedAddress       string   `json:"addressUsed,omitempty"`
 }
 
-type challenge struct {
+// AuthorizationChallenge represents a single challenge of an authorization.
+// If the validation of the challenge failed, Error holds the problem details
+// returned by the ACME server.
+type AuthorizationChallenge struct {
 	Resource          string             `json:"resource,omitempty"`
 	Type              Challenge          `json:"type,omitempty"`
 	Status            string             `json:"status,omitempty"`
@@ -79,13 +148,17 @@ type challenge struct {
 	TLS               bool               `json:"tls,omitempty"`
 	Iterations        int                `json:"n,omitempty"`
 	Error             RemoteError        `json:"error,omitempty"`
-	ValidationRecords []validationRecord `json:"validationRecord,omitempty"`
+	ValidationRecords []ValidationRecord `json:"validationRecord,omitempty"`
 }
 
 type csrMessage struct {
 	Resource       string   `json:"resource,omitempty"`
 	Csr            string   `json:"csr"`
 	Authorizations []string `json:"authorizations"`
+	Profile        string   `json:"profile,omitempty"`
+	NotBefore      string   `json:"notBefore,omitempty"`
+	NotAfter       string   `json:"notAfter,omitempty"`
+	Replaces       string   `json:"replaces,omitempty"`
 }
 
 type revokeCertMessage struct {
@@ -107,4 +180,58 @@ type CertificateResource struct {
 	Certificate       []byte `json:"-"`
 	IssuerCertificate []byte `json:"-"`
 	CSR               []byte `json:"-"`
+	// Challenges holds the challenges used for each domain. It is only
+	// set in dry-run mode, see Client.SetDryRun.
+	Challenges map[string][]Challenge `json:"-"`
+}
+
+// ObtainResult is the outcome of Client.Obtain.
+type ObtainResult struct {
+	// Certificate is the obtained certificate. It is only set if Issued is
+	// true, or in dry-run mode.
+	Certificate CertificateResource
+	// Issued reports whether the CA issued the certificate.
+	Issued bool
+	// Validated lists the domains whose authorizations are valid.
+	Validated []string
+	// Failures holds the reasons of the domains which failed.
+	Failures map[string]DomainFailure
+}
+
+// Errors returns the errors of the failed domains, as returned by
+// ObtainCertificate.
+func (r ObtainResult) Errors() map[string]error {
+	errs := make(map[string]error)
+	for domain, failure := range r.Failures {
+		errs[domain] = failure.Err
+	}
+	return errs
+}
+
+// DomainFailure describes why a domain could not be included in a
+// certificate.
+type DomainFailure struct {
+	// Stage is the step of the issuance that failed, one of the Failure
+	// reasons, e.g. FailureChallenge.
+	Stage string
+	// Err is the error the domain failed with.
+	Err error
+}
+
+// Problem returns the problem document the CA returned for the domain, or
+// nil if the failure was not reported by the CA.
+func (f DomainFailure) Problem() *RemoteError {
+	switch err := f.Err.(type) {
+	case RemoteError:
+		return &err
+	case TOSError:
+		return &err.RemoteError
+	case NonceError:
+		return &err.RemoteError
+	case CAAError:
+		return &err.RemoteError
+	case challengeError:
+		return &err.RemoteError
+	}
+	return nil
 }
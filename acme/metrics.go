@@ -0,0 +1,58 @@
+package acme
+
+import "time"
+
+// Reasons passed to Metrics.IncFailed.
+const (
+	// FailureDomains is reported when the requested names are invalid or
+	// too many.
+	FailureDomains = "domains"
+	// FailureCAA is reported when CAA records forbid the issuance.
+	FailureCAA = "caa"
+	// FailureAuthorization is reported when the authorizations could not be
+	// created.
+	FailureAuthorization = "authorization"
+	// FailureChallenge is reported when a challenge could not be solved.
+	FailureChallenge = "challenge"
+	// FailureCertificate is reported when the CA did not issue the
+	// certificate after the challenges were solved.
+	FailureCertificate = "certificate"
+	// FailureRenewal is reported when the certificate to renew is unusable.
+	FailureRenewal = "renewal"
+)
+
+// Metrics receives the outcomes of the issuances of a client, e.g. to
+// export them to Prometheus. The methods are called synchronously and must
+// be safe for concurrent use if the client is.
+type Metrics interface {
+	// IncIssued is called for every certificate the CA issued.
+	IncIssued()
+	// IncFailed is called for every certificate that could not be
+	// obtained, with one of the Failure reasons.
+	IncFailed(reason string)
+	// ObserveChallengeDuration is called after every challenge with the time
+	// it took to solve it and whether it was solved.
+	ObserveChallengeDuration(challenge Challenge, duration time.Duration, success bool)
+}
+
+// noopMetrics is the Metrics used when none is set.
+type noopMetrics struct{}
+
+func (noopMetrics) IncIssued()                                              {}
+func (noopMetrics) IncFailed(string)                                        {}
+func (noopMetrics) ObserveChallengeDuration(Challenge, time.Duration, bool) {}
+
+// SetMetrics makes the client report the outcomes of its issuances to m.
+// Passing nil disables the reporting, which is the default.
+func (c *Client) SetMetrics(m Metrics) {
+	c.metrics = m
+}
+
+// getMetrics returns the metrics of the client, or a no-op implementation
+// if none were set.
+func (c *Client) getMetrics() Metrics {
+	if c.metrics == nil {
+		return noopMetrics{}
+	}
+	return c.metrics
+}
@@ -0,0 +1,98 @@
+package acme
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMetrics counts the calls of the client.
+type fakeMetrics struct {
+	mu         sync.Mutex
+	issued     int
+	failed     []string
+	challenges map[bool]int
+}
+
+func (m *fakeMetrics) IncIssued() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.issued++
+}
+
+func (m *fakeMetrics) IncFailed(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failed = append(m.failed, reason)
+}
+
+func (m *fakeMetrics) ObserveChallengeDuration(challenge Challenge, duration time.Duration, success bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if challenge != HTTP01 || duration <= 0 {
+		panic("unexpected challenge observation")
+	}
+	m.challenges[success]++
+}
+
+func TestClientMetrics(t *testing.T) {
+	ca := newMockCA(t)
+	ca.pending = true
+	defer ca.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	user := mockUser{
+		email:      "test@test.com",
+		regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+		privatekey: key,
+	}
+
+	client, err := NewClient(ca.directoryURL(), user, EC256)
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+	metrics := &fakeMetrics{challenges: map[bool]int{}}
+	client.SetMetrics(metrics)
+	if err := client.SetChallengeProvider(HTTP01, &failingProvider{fail: "c.example.com"}); err != nil {
+		t.Fatalf("Could not set challenge provider: %v", err)
+	}
+
+	if _, failures := client.ObtainCertificate([]string{"a.example.com", "b.example.com"}, false, nil, false); len(failures) > 0 {
+		t.Fatalf("Expected no failures but got %v", failures)
+	}
+	if _, failures := client.ObtainCertificate([]string{"a.example.com", "c.example.com"}, false, nil, false); len(failures) != 1 {
+		t.Fatalf("Expected c.example.com to fail but got %v", failures)
+	}
+	client.SetMaxNamesPerCertificate(1)
+	if _, failures := client.ObtainCertificate([]string{"a.example.com", "b.example.com"}, false, nil, false); len(failures) != 1 {
+		t.Fatalf("Expected too many names to fail but got %v", failures)
+	}
+	if _, err := client.RenewCertificate(CertificateResource{Domain: "example.com"}, false, false); err == nil {
+		t.Fatal("Expected renewing without certificate to fail")
+	}
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if metrics.issued != 1 {
+		t.Errorf("Expected 1 issued certificate but got %d", metrics.issued)
+	}
+	if expected := []string{FailureChallenge, FailureDomains, FailureRenewal}; !reflect.DeepEqual(metrics.failed, expected) {
+		t.Errorf("Expected failures %v but got %v", expected, metrics.failed)
+	}
+	if metrics.challenges[true] != 3 || metrics.challenges[false] != 1 {
+		t.Errorf("Expected 3 solved and 1 failed challenge but got %v", metrics.challenges)
+	}
+}
+
+func TestClientWithoutMetrics(t *testing.T) {
+	client := &Client{}
+	client.getMetrics().IncIssued()
+	client.getMetrics().IncFailed(FailureChallenge)
+	client.getMetrics().ObserveChallengeDuration(HTTP01, time.Second, true)
+}
@@ -0,0 +1,39 @@
+package acme
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// EncodePKCS12 bundles the certificate, its chain and its private key into a
+// PKCS#12 archive (.pfx / .p12) protected by password, as expected by
+// Windows, IIS and Java key stores. RSA and ECDSA keys are supported. The
+// archive uses the legacy encryption algorithms, which all of these consumers
+// can read.
+func EncodePKCS12(cert CertificateResource, password string) ([]byte, error) {
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("acme: no certificate to encode")
+	}
+	if len(cert.PrivateKey) == 0 {
+		return nil, errors.New("acme: the certificate has no private key to encode")
+	}
+
+	leaf, chain, err := certificateChain(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	privKey, err := parsePEMPrivateKey(cert.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("acme: could not parse private key: %v", err)
+	}
+
+	pfx, err := pkcs12.Encode(rand.Reader, privKey, leaf, chain, password)
+	if err != nil {
+		return nil, fmt.Errorf("acme: could not encode PKCS#12 archive: %v", err)
+	}
+	return pfx, nil
+}
@@ -0,0 +1,88 @@
+package acme
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// selfSignedPEM returns a PEM encoded self-signed certificate for key.
+func selfSignedPEM(t *testing.T, key crypto.Signer, commonName string) []byte {
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{commonName},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	if err != nil {
+		t.Fatal("Could not create test certificate:", err)
+	}
+	return pemEncode(derCertificateBytes(der))
+}
+
+func TestEncodePKCS12(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	issuer := selfSignedPEM(t, ecKey, "issuer.example.com")
+
+	for _, key := range []crypto.Signer{rsaKey, ecKey} {
+		leaf := selfSignedPEM(t, key, "example.com")
+		cert := CertificateResource{
+			Certificate:       leaf,
+			IssuerCertificate: issuer,
+			PrivateKey:        pemEncode(key),
+		}
+
+		pfx, err := EncodePKCS12(cert, "secret")
+		if err != nil {
+			t.Fatalf("%T: could not encode the archive: %v", key, err)
+		}
+
+		if _, _, _, err := pkcs12.DecodeChain(pfx, "wrong"); err == nil {
+			t.Errorf("%T: expected decoding with the wrong password to fail", key)
+		}
+		decodedKey, decodedCert, caCerts, err := pkcs12.DecodeChain(pfx, "secret")
+		if err != nil {
+			t.Fatalf("%T: could not decode the archive: %v", key, err)
+		}
+
+		leafCert, _ := pemDecodeTox509(leaf)
+		issuerCert, _ := pemDecodeTox509(issuer)
+		if !bytes.Equal(decodedCert.Raw, leafCert.Raw) {
+			t.Errorf("%T: expected the leaf certificate in the archive", key)
+		}
+		if len(caCerts) != 1 || !bytes.Equal(caCerts[0].Raw, issuerCert.Raw) {
+			t.Errorf("%T: expected the issuer certificate as the only CA certificate but got %d", key, len(caCerts))
+		}
+		signer, ok := decodedKey.(crypto.Signer)
+		if !ok || !reflect.DeepEqual(signer.Public(), key.Public()) {
+			t.Errorf("%T: expected the private key of the certificate in the archive but got %T", key, decodedKey)
+		}
+		if !reflect.DeepEqual(decodedCert.PublicKey, key.Public()) {
+			t.Errorf("%T: expected the certificate to match the private key", key)
+		}
+	}
+
+	if _, err := EncodePKCS12(CertificateResource{Certificate: issuer}, "secret"); err == nil {
+		t.Error("Expected an error encoding a certificate without private key")
+	}
+}
@@ -1,6 +1,9 @@
 package acme
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
 // ChallengeProvider enables implementing a custom challenge
 // provider. Present presents the solution to a challenge available to
@@ -26,3 +29,86 @@ type ChallengeProviderTimeout interface {
 	ChallengeProvider
 	Timeout() (timeout, interval time.Duration)
 }
+
+// ChallengeProviderNameservers allows for implementing a
+// ChallengeProvider whose records have to be checked using specific
+// recursive nameservers, e.g. because the default resolvers only see an
+// internal view of a split-horizon DNS setup. If an implementor of a
+// ChallengeProvider provides a Nameservers method returning a non-empty
+// list of host:port addresses, these are used instead of
+// RecursiveNameservers when checking for DNS record propagation. A custom
+// PreCheckDNS function still takes precedence over this check, the
+// nameservers are then only used to verify the presented record.
+type ChallengeProviderNameservers interface {
+	ChallengeProvider
+	Nameservers() []string
+}
+
+// ChallengeProviderRecords allows for implementing a DNS
+// ChallengeProvider which can read back the TXT records it manages from
+// its API. If VerifyPresentedRecord is enabled, GetRecord is called after
+// Present until it returns the presented value, which catches APIs
+// reporting success without storing the record. GetRecord returns the
+// values of the TXT records at fqdn.
+type ChallengeProviderRecords interface {
+	ChallengeProvider
+	GetRecord(fqdn string) ([]string, error)
+}
+
+// ChallengeProviderSequential allows for implementing a
+// ChallengeProvider which cannot safely handle concurrent calls to
+// Present and CleanUp, such as DNS providers whose API requires
+// reading, modifying and writing back the whole zone. If an
+// implementor of a ChallengeProvider provides a Sequential method
+// returning true, a Client serializes all calls it makes to its
+// Present and CleanUp methods. Providers shared between several
+// clients obtaining certificates concurrently must synchronize those
+// calls themselves.
+type ChallengeProviderSequential interface {
+	ChallengeProvider
+	Sequential() bool
+}
+
+// providerLock returns mu if the provider p is sequential and a lock
+// which does nothing otherwise. A nil mu never locks.
+func providerLock(mu *sync.Mutex, p ChallengeProvider) sync.Locker {
+	if s, ok := p.(ChallengeProviderSequential); !ok || !s.Sequential() || mu == nil {
+		return noopLocker{}
+	}
+	return mu
+}
+
+// presentChallenge calls Present on the provider p, holding mu during the
+// call if p is sequential.
+func presentChallenge(mu *sync.Mutex, p ChallengeProvider, domain, token, keyAuth string) error {
+	l := providerLock(mu, p)
+	l.Lock()
+	defer l.Unlock()
+
+	return p.Present(domain, token, keyAuth)
+}
+
+// cleanUpChallenge calls CleanUp on the provider p, holding mu during the
+// call if p is sequential.
+func cleanUpChallenge(mu *sync.Mutex, p ChallengeProvider, domain, token, keyAuth string) error {
+	l := providerLock(mu, p)
+	l.Lock()
+	defer l.Unlock()
+
+	return p.CleanUp(domain, token, keyAuth)
+}
+
+// getRecord calls GetRecord on the provider p, holding mu during the call
+// if p is sequential.
+func getRecord(mu *sync.Mutex, p ChallengeProviderRecords, fqdn string) ([]string, error) {
+	l := providerLock(mu, p)
+	l.Lock()
+	defer l.Unlock()
+
+	return p.GetRecord(fqdn)
+}
+
+type noopLocker struct{}
+
+func (noopLocker) Lock()   {}
+func (noopLocker) Unlock() {}
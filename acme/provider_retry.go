@@ -0,0 +1,120 @@
+package acme
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	// ProviderMaxRetries is the number of times DoWithRetry repeats a
+	// request which failed transiently.
+	ProviderMaxRetries = 4
+	// ProviderRetryDelay is the delay before the first retry of
+	// DoWithRetry. It doubles with every further retry, up to
+	// ProviderMaxRetryDelay.
+	ProviderRetryDelay = 500 * time.Millisecond
+	// ProviderMaxRetryDelay bounds the delay between two retries of
+	// DoWithRetry, including delays the API asks for with Retry-After.
+	ProviderMaxRetryDelay = 30 * time.Second
+)
+
+// RetryableResponse reports whether resp indicates a transient failure,
+// i.e. the API is rate limiting (429) or failed on its side (5xx). A 5xx
+// response to a POST or PATCH request is not retried, since the API may
+// have carried out the request before failing and repeating it could
+// create a record twice.
+func RetryableResponse(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	if resp.Request != nil && (resp.Request.Method == "POST" || resp.Request.Method == "PATCH") {
+		return false
+	}
+	return resp.StatusCode >= http.StatusInternalServerError
+}
+
+// DoWithRetry sends req with ProviderHTTPClient and repeats it up to
+// ProviderMaxRetries times as long as isRetryable reports the response as
+// a transient failure. A nil isRetryable defaults to RetryableResponse.
+// Between the attempts it waits as long as the Retry-After header of the
+// response asks for, or backs off exponentially with jitter otherwise.
+// Requests whose body cannot be read again, and errors of the client
+// itself, are not retried. Callers should close resp.Body when done
+// reading from it.
+func DoWithRetry(req *http.Request, isRetryable func(resp *http.Response) bool) (*http.Response, error) {
+	if isRetryable == nil {
+		isRetryable = RetryableResponse
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := ProviderHTTPClient.Do(req)
+		if err != nil || attempt >= ProviderMaxRetries || !isRetryable(resp) {
+			return resp, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			return resp, nil
+		}
+
+		wait := RetryDelay(attempt, resp)
+		resp.Body.Close()
+		logf("[INFO] %s %s returned %s; retrying in %v", req.Method, req.URL.Host, resp.Status, wait)
+		time.Sleep(wait)
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+	}
+}
+
+// RetryDelay returns how long to wait before retrying a request for the
+// attempt+1-th time after it got resp. It honors the Retry-After header
+// of resp, if any, and otherwise backs off exponentially from
+// ProviderRetryDelay with up to 50% of jitter. The delay never exceeds
+// ProviderMaxRetryDelay.
+func RetryDelay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			if wait > ProviderMaxRetryDelay {
+				wait = ProviderMaxRetryDelay
+			}
+			return wait
+		}
+	}
+
+	wait := ProviderRetryDelay
+	for i := 0; i < attempt && wait < ProviderMaxRetryDelay; i++ {
+		wait *= 2
+	}
+	if wait > 0 {
+		wait += time.Duration(rand.Int63n(int64(wait)/2 + 1))
+	}
+	if wait > ProviderMaxRetryDelay {
+		wait = ProviderMaxRetryDelay
+	}
+	return wait
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is
+// either a number of seconds or an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		wait := date.Sub(time.Now())
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
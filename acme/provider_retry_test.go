@@ -0,0 +1,141 @@
+package acme
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDoWithRetry(t *testing.T) {
+	defer func(delay time.Duration) { ProviderRetryDelay = delay }(ProviderRetryDelay)
+	ProviderRetryDelay = time.Millisecond
+
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		switch len(bodies) {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer ts.Close()
+
+	req, err := http.NewRequest("PUT", ts.URL, bytes.NewReader([]byte("record")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := DoWithRetry(req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 but got %d", resp.StatusCode)
+	}
+	if len(bodies) != 3 {
+		t.Fatalf("Expected 3 requests but got %d", len(bodies))
+	}
+	for _, body := range bodies {
+		if body != "record" {
+			t.Errorf("Expected the body to be sent with every attempt but got %q", body)
+		}
+	}
+}
+
+func TestDoWithRetryGivesUp(t *testing.T) {
+	defer func(delay time.Duration, retries int) {
+		ProviderRetryDelay, ProviderMaxRetries = delay, retries
+	}(ProviderRetryDelay, ProviderMaxRetries)
+	ProviderRetryDelay, ProviderMaxRetries = time.Millisecond, 2
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := DoWithRetry(req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadGateway || requests != 3 {
+		t.Errorf("Expected the last 502 after 3 requests but got %d after %d", resp.StatusCode, requests)
+	}
+}
+
+func TestDoWithRetryPOST(t *testing.T) {
+	defer func(delay time.Duration) { ProviderRetryDelay = delay }(ProviderRetryDelay)
+	ProviderRetryDelay = time.Millisecond
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	req, err := http.NewRequest("POST", ts.URL, bytes.NewReader([]byte("record")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := DoWithRetry(req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// A throttled POST was not carried out and is retried, a failed one
+	// may have been.
+	if resp.StatusCode != http.StatusInternalServerError || requests != 2 {
+		t.Errorf("Expected the 500 to be returned after 2 requests but got %d after %d", resp.StatusCode, requests)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	header := func(retryAfter string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": []string{retryAfter}}}
+	}
+
+	if wait := RetryDelay(3, header("7")); wait != 7*time.Second {
+		t.Errorf("Expected Retry-After in seconds to be honored but got %v", wait)
+	}
+	date := time.Now().Add(20 * time.Second).UTC().Format(http.TimeFormat)
+	if wait := RetryDelay(0, header(date)); wait < 10*time.Second || wait > 20*time.Second {
+		t.Errorf("Expected Retry-After as a date to be honored but got %v", wait)
+	}
+	if wait := RetryDelay(0, header("3600")); wait != ProviderMaxRetryDelay {
+		t.Errorf("Expected Retry-After to be bounded by %v but got %v", ProviderMaxRetryDelay, wait)
+	}
+
+	for attempt, min := range []time.Duration{ProviderRetryDelay, 2 * ProviderRetryDelay, 4 * ProviderRetryDelay} {
+		wait := RetryDelay(attempt, header(""))
+		if wait < min || wait > min+min/2 {
+			t.Errorf("Expected a delay between %v and %v for attempt %d but got %v", min, min+min/2, attempt, wait)
+		}
+	}
+	if wait := RetryDelay(20, nil); wait != ProviderMaxRetryDelay {
+		t.Errorf("Expected the delay to be bounded by %v but got %v", ProviderMaxRetryDelay, wait)
+	}
+}
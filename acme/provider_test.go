@@ -0,0 +1,107 @@
+package acme
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sequentialProvider fails if Present or CleanUp are ever called
+// while another call is still in progress.
+type sequentialProvider struct {
+	mu       sync.Mutex
+	active   int
+	overlaps int
+	calls    int
+}
+
+func (p *sequentialProvider) enter() {
+	p.mu.Lock()
+	p.active++
+	p.calls++
+	if p.active > 1 {
+		p.overlaps++
+	}
+	p.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	p.mu.Lock()
+	p.active--
+	p.mu.Unlock()
+}
+
+func (p *sequentialProvider) Present(domain, token, keyAuth string) error {
+	p.enter()
+	return nil
+}
+
+func (p *sequentialProvider) CleanUp(domain, token, keyAuth string) error {
+	p.enter()
+	return nil
+}
+
+func (p *sequentialProvider) Sequential() bool { return true }
+
+func TestSequentialProviderNoOverlap(t *testing.T) {
+	privKey, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+
+	provider := &sequentialProvider{}
+	j := &jws{privKey: privKey}
+
+	// Two solvers of the same client sharing the provider.
+	var mu sync.Mutex
+	solvers := []solver{
+		&httpChallenge{jws: j, validate: stubValidate, provider: provider, providerMu: &mu},
+		&tlsSNIChallenge{jws: j, validate: stubValidate, provider: provider, providerMu: &mu},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			chlng := AuthorizationChallenge{Type: HTTP01, Token: fmt.Sprintf("token%d", i)}
+			if err := solvers[i%2].Solve(chlng, fmt.Sprintf("%d.example.com", i)); err != nil {
+				t.Errorf("Solve failed: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if provider.calls != 20 {
+		t.Errorf("Expected 20 calls to Present and CleanUp but got %d", provider.calls)
+	}
+	if provider.overlaps != 0 {
+		t.Errorf("Expected no overlapping calls but got %d", provider.overlaps)
+	}
+}
+
+// funcSequentialProvider is a sequential provider of a type which is
+// not comparable.
+type funcSequentialProvider func() error
+
+func (f funcSequentialProvider) Present(domain, token, keyAuth string) error { return f() }
+func (f funcSequentialProvider) CleanUp(domain, token, keyAuth string) error { return f() }
+func (f funcSequentialProvider) Sequential() bool                            { return true }
+
+func TestProviderLock(t *testing.T) {
+	var mu sync.Mutex
+	if _, ok := providerLock(&mu, &HTTPProviderServer{}).(noopLocker); !ok {
+		t.Error("Expected no lock for a provider which is not sequential")
+	}
+	if providerLock(&mu, &sequentialProvider{}) != &mu {
+		t.Error("Expected the lock of the client for a sequential provider")
+	}
+
+	p := funcSequentialProvider(func() error { return nil })
+	if err := presentChallenge(&mu, p, "example.com", "token", "keyAuth"); err != nil {
+		t.Errorf("Expected no error presenting to a provider which is not comparable but got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package acme
+
+import (
+	"sync"
+	"time"
+)
+
+// ProviderAction is a call to Present or CleanUp recorded by a
+// RecordingProvider. Fqdn and Value are the `dns-01` record the call
+// corresponds to, as returned by DNS01Record.
+type ProviderAction struct {
+	Method  string
+	Domain  string
+	Token   string
+	KeyAuth string
+	Fqdn    string
+	Value   string
+	Err     error
+}
+
+// RecordingProvider wraps a ChallengeProvider and records all calls to
+// Present and CleanUp, so users can assert on the actions taken while
+// testing their own integrations. It can also be told to fail calls
+// without passing them on to the wrapped provider.
+//
+// The Timeout, Nameservers and Sequential methods report the values of
+// the wrapped provider, or the defaults if it does not implement them.
+type RecordingProvider struct {
+	inner ChallengeProvider
+
+	mu             sync.Mutex
+	actions        []ProviderAction
+	presentFailure error
+	cleanUpFailure error
+}
+
+// NewRecordingProvider returns a RecordingProvider wrapping inner. If inner
+// is nil, calls are only recorded.
+func NewRecordingProvider(inner ChallengeProvider) *RecordingProvider {
+	return &RecordingProvider{inner: inner}
+}
+
+// Present records the call and passes it on to the wrapped provider, unless
+// a failure has been injected with FailPresent.
+func (p *RecordingProvider) Present(domain, token, keyAuth string) error {
+	return p.call("Present", domain, token, keyAuth)
+}
+
+// CleanUp records the call and passes it on to the wrapped provider, unless
+// a failure has been injected with FailCleanUp.
+func (p *RecordingProvider) CleanUp(domain, token, keyAuth string) error {
+	return p.call("CleanUp", domain, token, keyAuth)
+}
+
+// FailPresent makes all following calls to Present return err. Passing nil
+// turns the injected failure off again.
+func (p *RecordingProvider) FailPresent(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.presentFailure = err
+}
+
+// FailCleanUp makes all following calls to CleanUp return err. Passing nil
+// turns the injected failure off again.
+func (p *RecordingProvider) FailCleanUp(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cleanUpFailure = err
+}
+
+// Actions returns the calls recorded so far, in order.
+func (p *RecordingProvider) Actions() []ProviderAction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]ProviderAction(nil), p.actions...)
+}
+
+// Reset forgets all recorded calls.
+func (p *RecordingProvider) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.actions = nil
+}
+
+// Timeout returns the timeout and interval of the wrapped provider.
+func (p *RecordingProvider) Timeout() (timeout, interval time.Duration) {
+	return providerTimeout(p.inner)
+}
+
+// Nameservers returns the nameservers of the wrapped provider.
+func (p *RecordingProvider) Nameservers() []string {
+	return providerNameservers(p.inner)
+}
+
+// Sequential reports whether the wrapped provider is sequential.
+func (p *RecordingProvider) Sequential() bool {
+	s, ok := p.inner.(ChallengeProviderSequential)
+	return ok && s.Sequential()
+}
+
+func (p *RecordingProvider) call(method, domain, token, keyAuth string) error {
+	p.mu.Lock()
+	err := p.presentFailure
+	if method == "CleanUp" {
+		err = p.cleanUpFailure
+	}
+	p.mu.Unlock()
+
+	if err == nil && p.inner != nil {
+		if method == "Present" {
+			err = p.inner.Present(domain, token, keyAuth)
+		} else {
+			err = p.inner.CleanUp(domain, token, keyAuth)
+		}
+	}
+
+	fqdn, value, _ := DNS01Record(domain, keyAuth)
+	p.mu.Lock()
+	p.actions = append(p.actions, ProviderAction{
+		Method:  method,
+		Domain:  domain,
+		Token:   token,
+		KeyAuth: keyAuth,
+		Fqdn:    fqdn,
+		Value:   value,
+		Err:     err,
+	})
+	p.mu.Unlock()
+
+	return err
+}
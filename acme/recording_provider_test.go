@@ -0,0 +1,117 @@
+package acme
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingProvider counts the calls passed on to it.
+type countingProvider struct {
+	presents, cleanUps int
+}
+
+func (p *countingProvider) Present(domain, token, keyAuth string) error {
+	p.presents++
+	return nil
+}
+
+func (p *countingProvider) CleanUp(domain, token, keyAuth string) error {
+	p.cleanUps++
+	return nil
+}
+
+func (p *countingProvider) Timeout() (timeout, interval time.Duration) {
+	return 5 * time.Minute, 10 * time.Second
+}
+
+func TestRecordingProviderRecordsActions(t *testing.T) {
+	privKey, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	inner := &countingProvider{}
+	provider := NewRecordingProvider(inner)
+	solver := &httpChallenge{jws: &jws{privKey: privKey}, validate: stubValidate, provider: provider}
+
+	if err := solver.Solve(AuthorizationChallenge{Type: HTTP01, Token: "token"}, "example.com"); err != nil {
+		t.Fatalf("Solve failed: %v", err)
+	}
+
+	keyAuth, err := getKeyAuthorization("token", privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fqdn, value, _ := DNS01Record("example.com", keyAuth)
+
+	actions := provider.Actions()
+	if len(actions) != 2 {
+		t.Fatalf("Expected 2 recorded actions but got %d: %+v", len(actions), actions)
+	}
+	for i, method := range []string{"Present", "CleanUp"} {
+		a := actions[i]
+		if a.Method != method || a.Domain != "example.com" || a.Token != "token" || a.KeyAuth != keyAuth {
+			t.Errorf("Expected action %d to be %s for example.com but got %+v", i, method, a)
+		}
+		if a.Fqdn != fqdn || a.Value != value {
+			t.Errorf("Expected action %d to record %s %s but got %s %s", i, fqdn, value, a.Fqdn, a.Value)
+		}
+		if a.Err != nil {
+			t.Errorf("Expected action %d to succeed but got %v", i, a.Err)
+		}
+	}
+	if inner.presents != 1 || inner.cleanUps != 1 {
+		t.Errorf("Expected the calls to be passed on but got %d presents and %d clean ups", inner.presents, inner.cleanUps)
+	}
+
+	if timeout, interval := provider.Timeout(); timeout != 5*time.Minute || interval != 10*time.Second {
+		t.Errorf("Expected the timeout of the wrapped provider but got %v and %v", timeout, interval)
+	}
+
+	provider.Reset()
+	if actions := provider.Actions(); len(actions) != 0 {
+		t.Errorf("Expected no actions after Reset but got %+v", actions)
+	}
+}
+
+func TestRecordingProviderInjectedFailure(t *testing.T) {
+	privKey, err := rsa.GenerateKey(rand.Reader, 512)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	inner := &countingProvider{}
+	provider := NewRecordingProvider(inner)
+	solver := &httpChallenge{jws: &jws{privKey: privKey}, validate: stubValidate, provider: provider}
+
+	injected := errors.New("injected failure")
+	provider.FailPresent(injected)
+
+	err = solver.Solve(AuthorizationChallenge{Type: HTTP01, Token: "token"}, "example.com")
+	if err == nil || !strings.Contains(err.Error(), injected.Error()) {
+		t.Fatalf("Expected Solve to fail with the injected error but got %v", err)
+	}
+
+	actions := provider.Actions()
+	if len(actions) != 1 || actions[0].Method != "Present" || actions[0].Err != injected {
+		t.Fatalf("Expected a single failed Present but got %+v", actions)
+	}
+	if inner.presents != 0 || inner.cleanUps != 0 {
+		t.Errorf("Expected no calls to be passed on but got %d presents and %d clean ups", inner.presents, inner.cleanUps)
+	}
+
+	provider.FailPresent(nil)
+	provider.FailCleanUp(injected)
+	if err := solver.Solve(AuthorizationChallenge{Type: HTTP01, Token: "token"}, "example.com"); err != nil {
+		t.Fatalf("Expected a failing CleanUp not to fail Solve but got %v", err)
+	}
+	actions = provider.Actions()
+	if len(actions) != 3 || actions[2].Method != "CleanUp" || actions[2].Err != injected {
+		t.Errorf("Expected the CleanUp to be recorded as failed but got %+v", actions)
+	}
+	if inner.presents != 1 || inner.cleanUps != 0 {
+		t.Errorf("Expected only Present to be passed on but got %d presents and %d clean ups", inner.presents, inner.cleanUps)
+	}
+}
@@ -0,0 +1,81 @@
+package acme
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RenewalWindow is the time span in which the CA suggests renewing a
+// certificate.
+type RenewalWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// renewalInfoMessage is the response of the ARI endpoint.
+type renewalInfoMessage struct {
+	SuggestedWindow RenewalWindow `json:"suggestedWindow"`
+	ExplanationURL  string        `json:"explanationURL,omitempty"`
+}
+
+// GetRenewalInfo fetches the ACME Renewal Information for the PEM encoded
+// certificate or bundle cert from the CA. It returns the window in which
+// the CA suggests renewing the certificate, e.g. earlier than usual ahead
+// of a mass revocation, and the URL of a page explaining the suggestion, if
+// any. Schedulers should renew at a random time within the window.
+func (c *Client) GetRenewalInfo(cert []byte) (RenewalWindow, string, error) {
+	if c.directory.RenewalInfoURL == "" {
+		return RenewalWindow{}, "", errors.New("acme: the CA does not offer renewal information")
+	}
+
+	certificates, err := parsePEMBundle(cert)
+	if err != nil {
+		return RenewalWindow{}, "", err
+	}
+	certID, err := renewalInfoCertID(certificates[0].AuthorityKeyId, certificates[0].SerialNumber.Bytes())
+	if err != nil {
+		return RenewalWindow{}, "", err
+	}
+
+	uri := strings.TrimSuffix(c.directory.RenewalInfoURL, "/") + "/" + certID
+	resp, err := httpGet(uri, c.jws.userAgent())
+	if err != nil {
+		return RenewalWindow{}, "", fmt.Errorf("acme: could not get renewal information: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return RenewalWindow{}, "", handleHTTPError(resp)
+	}
+
+	var info renewalInfoMessage
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return RenewalWindow{}, "", fmt.Errorf("acme: could not decode renewal information: %v", err)
+	}
+	if info.SuggestedWindow.Start.IsZero() || info.SuggestedWindow.End.Before(info.SuggestedWindow.Start) {
+		return RenewalWindow{}, "", fmt.Errorf("acme: invalid suggested renewal window %v to %v",
+			info.SuggestedWindow.Start, info.SuggestedWindow.End)
+	}
+
+	return info.SuggestedWindow, info.ExplanationURL, nil
+}
+
+// renewalInfoCertID returns the ARI identifier of a certificate: the
+// base64url encoded key identifier of its Authority Key Identifier extension
+// and its serial number, as the content octets of the DER encoded INTEGER,
+// joined by a dot.
+func renewalInfoCertID(authorityKeyID, serial []byte) (string, error) {
+	if len(authorityKeyID) == 0 {
+		return "", errors.New("acme: the certificate has no authority key identifier")
+	}
+	if len(serial) == 0 || serial[0]&0x80 != 0 {
+		serial = append([]byte{0}, serial...)
+	}
+	return base64.RawURLEncoding.EncodeToString(authorityKeyID) + "." +
+		base64.RawURLEncoding.EncodeToString(serial), nil
+}
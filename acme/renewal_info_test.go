@@ -0,0 +1,151 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRenewalInfoCertID(t *testing.T) {
+	// The example of the ARI specification.
+	aki, _ := hex.DecodeString("69885B6B87464041E1B37B847BA0AE2CDE01C8D4")
+	serial, _ := hex.DecodeString("87654321")
+
+	certID, err := renewalInfoCertID(aki, serial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "aYhba4dGQEHhs3uEe6CuLN4ByNQ.AIdlQyE"; certID != expected {
+		t.Errorf("Expected the cert id %s but got %s", expected, certID)
+	}
+
+	if _, err := renewalInfoCertID(nil, serial); err == nil {
+		t.Error("Expected an error for a certificate without authority key identifier")
+	}
+}
+
+func TestGetRenewalInfo(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Could not generate test key:", err)
+	}
+	aki, _ := hex.DecodeString("69885B6B87464041E1B37B847BA0AE2CDE01C8D4")
+	template := x509.Certificate{
+		SerialNumber:   big.NewInt(0x87654321),
+		Subject:        pkix.Name{CommonName: "example.com"},
+		NotBefore:      time.Now(),
+		NotAfter:       time.Now().Add(90 * 24 * time.Hour),
+		AuthorityKeyId: aki,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	if err != nil {
+		t.Fatal("Could not create test certificate:", err)
+	}
+	cert := pemEncode(derCertificateBytes(der))
+
+	var path, ua string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, ua = r.URL.Path, r.Header.Get("User-Agent")
+		if r.URL.Path != "/renewalInfo/aYhba4dGQEHhs3uEe6CuLN4ByNQ.AIdlQyE" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{
+			"suggestedWindow": {"start": "2025-01-02T04:00:00Z", "end": "2025-01-03T04:00:00Z"},
+			"explanationURL": "https://acme.example.com/docs/ari"
+		}`)
+	}))
+	defer ts.Close()
+
+	client := &Client{jws: newTestJWS(t, ts.URL)}
+	if _, _, err := client.GetRenewalInfo(cert); err == nil {
+		t.Error("Expected an error for a CA without renewal information")
+	}
+
+	client.directory.RenewalInfoURL = ts.URL + "/renewalInfo/"
+	client.SetUserAgent("scheduler/1.0")
+	window, explanation, err := client.GetRenewalInfo(cert)
+	if err != nil {
+		t.Fatalf("Could not get renewal information: %v", err)
+	}
+
+	if expected := time.Date(2025, 1, 2, 4, 0, 0, 0, time.UTC); !window.Start.Equal(expected) {
+		t.Errorf("Expected the window to start at %v but got %v", expected, window.Start)
+	}
+	if expected := time.Date(2025, 1, 3, 4, 0, 0, 0, time.UTC); !window.End.Equal(expected) {
+		t.Errorf("Expected the window to end at %v but got %v", expected, window.End)
+	}
+	if explanation != "https://acme.example.com/docs/ari" {
+		t.Errorf("Expected the explanation URL but got %q", explanation)
+	}
+	if path != "/renewalInfo/aYhba4dGQEHhs3uEe6CuLN4ByNQ.AIdlQyE" {
+		t.Errorf("Expected the request for the cert id but got %s", path)
+	}
+	if ua != userAgent()+" scheduler/1.0" {
+		t.Errorf("Expected the client User-Agent but got %q", ua)
+	}
+}
+
+func TestRenewCertificateReplaces(t *testing.T) {
+	for _, renewalInfo := range []bool{false, true} {
+		ca := newMockCA(t)
+		ca.renewalInfo = renewalInfo
+		defer ca.Close()
+
+		key, err := rsa.GenerateKey(rand.Reader, 512)
+		if err != nil {
+			t.Fatal("Could not generate test key:", err)
+		}
+		user := mockUser{
+			email:      "test@test.com",
+			regres:     &RegistrationResource{NewAuthzURL: ca.URL + "/new-authz"},
+			privatekey: key,
+		}
+		client, err := NewClient(ca.directoryURL(), user, EC256)
+		if err != nil {
+			t.Fatalf("Could not create client: %v", err)
+		}
+
+		cert, failures := client.ObtainCertificate([]string{"example.com"}, false, nil, false)
+		if len(failures) > 0 {
+			t.Fatalf("Could not obtain certificate: %v", failures)
+		}
+		if _, err := client.RenewCertificate(cert, false, false); err != nil {
+			t.Fatalf("Could not renew certificate: %v", err)
+		}
+
+		oldCert, err := pemDecodeTox509(cert.Certificate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := ""
+		if renewalInfo {
+			expected, err = renewalInfoCertID(oldCert.AuthorityKeyId, oldCert.SerialNumber.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		ca.mu.Lock()
+		if len(ca.certRequests) != 2 {
+			t.Fatalf("Expected 2 certificate requests but got %d", len(ca.certRequests))
+		}
+		if ca.certRequests[0].Replaces != "" {
+			t.Errorf("Expected no replaced certificate for the first request but got %q", ca.certRequests[0].Replaces)
+		}
+		if got := ca.certRequests[1].Replaces; got != expected {
+			t.Errorf("renewalInfo %t: expected the renewal to replace %q but got %q", renewalInfo, expected, got)
+		}
+		ca.mu.Unlock()
+	}
+}
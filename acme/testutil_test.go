@@ -0,0 +1,296 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockCA is a minimal ACME server which hands out already valid
+// authorizations and issues certificates for every CSR it receives.
+type mockCA struct {
+	*httptest.Server
+
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+
+	// profiles are advertised in the meta data of the directory.
+	profiles map[string]string
+	// caaIdentities are advertised in the meta data of the directory.
+	caaIdentities []string
+	// renewalInfo makes the directory advertise an ARI endpoint.
+	renewalInfo bool
+	// pending makes new authorizations pending with a single http-01
+	// challenge instead of already valid.
+	pending bool
+	// processing makes the CA answer a new-cert request and the following
+	// polls of the certificate with an empty 202 response that many times
+	// before it issues the certificate.
+	processing int
+	// retryAfter is sent as the Retry-After header of these 202 responses.
+	retryAfter string
+	// rejectName makes the CA reject the certificate requests for this
+	// name.
+	rejectName string
+
+	mu            sync.Mutex
+	identifiers   []Identifier
+	deactivations []string
+	validations   []AuthorizationChallenge
+	csrs          []*x509.CertificateRequest
+	certRequests  []csrMessage
+	certs         map[string][]byte
+	certPolls     int
+}
+
+func newMockCA(t *testing.T) *mockCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Could not generate CA key:", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "lego test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Could not create CA certificate:", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal("Could not parse CA certificate:", err)
+	}
+
+	ca := &mockCA{key: key, cert: cert}
+	ca.Server = httptest.NewServer(http.HandlerFunc(ca.handle))
+	return ca
+}
+
+// directoryURL returns the URL of the ACME directory of the mock CA.
+func (ca *mockCA) directoryURL() string {
+	return ca.URL + "/directory"
+}
+
+func (ca *mockCA) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Replay-Nonce", "12345")
+
+	path := r.URL.Path
+	if strings.HasPrefix(path, "/challenge/") {
+		path = "/challenge/"
+	}
+	if strings.HasPrefix(path, "/authz/") {
+		path = "/authz/"
+	}
+	if strings.HasPrefix(path, "/cert/") {
+		path = "/cert/"
+	}
+
+	switch path {
+	case "/directory":
+		dir := Directory{
+			NewAuthzURL:   ca.URL + "/new-authz",
+			NewCertURL:    ca.URL + "/new-cert",
+			NewRegURL:     ca.URL + "/new-reg",
+			RevokeCertURL: ca.URL + "/revoke-cert",
+			Meta:          DirectoryMeta{Profiles: ca.profiles, CAAIdentities: ca.caaIdentities},
+		}
+		if ca.renewalInfo {
+			dir.RenewalInfoURL = ca.URL + "/renewal-info"
+		}
+		writeJSONResponse(w, dir)
+	case "/new-authz":
+		var authz Authorization
+		if err := decodeJWSPayload(r, &authz); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ca.mu.Lock()
+		ca.identifiers = append(ca.identifiers, authz.Identifier)
+		id := len(ca.identifiers)
+		pending := ca.pending
+		for _, deactivated := range ca.deactivations {
+			// Deactivated authorizations are not reused.
+			pending = pending || deactivated == authz.Identifier.Value
+		}
+		ca.mu.Unlock()
+
+		w.Header().Add("Link", fmt.Sprintf("<%s/new-cert>;rel=\"next\"", ca.URL))
+		w.Header().Set("Location", fmt.Sprintf("%s/authz/%d", ca.URL, id))
+		w.WriteHeader(http.StatusCreated)
+		if pending {
+			writeJSONResponse(w, Authorization{
+				Identifier: authz.Identifier,
+				Status:     "pending",
+				Challenges: []AuthorizationChallenge{{
+					Type:   HTTP01,
+					Status: "pending",
+					URI:    fmt.Sprintf("%s/challenge/%d", ca.URL, id),
+					Token:  fmt.Sprintf("token%d", id),
+				}},
+				Combinations: [][]int{{0}},
+			})
+			return
+		}
+		writeJSONResponse(w, Authorization{Identifier: authz.Identifier, Status: "valid"})
+	case "/new-cert":
+		var msg csrMessage
+		if err := decodeJWSPayload(r, &msg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		csrBytes, err := base64.URLEncoding.DecodeString(msg.Csr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		csr, err := x509.ParseCertificateRequest(csrBytes)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, name := range append([]string{csr.Subject.CommonName}, csr.DNSNames...) {
+			if ca.rejectName != "" && name == ca.rejectName {
+				http.Error(w, "Policy forbids issuing for "+name, http.StatusForbidden)
+				return
+			}
+		}
+
+		ca.mu.Lock()
+		ca.csrs = append(ca.csrs, csr)
+		ca.certRequests = append(ca.certRequests, msg)
+		serial := int64(len(ca.csrs) + 1)
+		ca.mu.Unlock()
+
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      csr.Subject,
+			DNSNames:     csr.DNSNames,
+			IPAddresses:  csr.IPAddresses,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(24 * time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		ca.mu.Lock()
+		if ca.certs == nil {
+			ca.certs = make(map[string][]byte)
+		}
+		ca.certs[fmt.Sprintf("/cert/%d", serial)] = der
+		ca.mu.Unlock()
+
+		w.Header().Set("Location", fmt.Sprintf("%s/cert/%d", ca.URL, serial))
+		if ca.processing > 0 {
+			if ca.retryAfter != "" {
+				w.Header().Set("Retry-After", ca.retryAfter)
+			}
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		w.Header().Add("Link", fmt.Sprintf("<%s/issuer>;rel=\"up\"", ca.URL))
+		w.WriteHeader(http.StatusCreated)
+		w.Write(der)
+	case "/cert/":
+		ca.mu.Lock()
+		der, ok := ca.certs[r.URL.Path]
+		ca.certPolls++
+		processing := ca.certPolls < ca.processing
+		ca.mu.Unlock()
+
+		switch {
+		case !ok:
+			http.NotFound(w, r)
+		case processing:
+			if ca.retryAfter != "" {
+				w.Header().Set("Retry-After", ca.retryAfter)
+			}
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.Header().Add("Link", fmt.Sprintf("<%s/issuer>;rel=\"up\"", ca.URL))
+			w.WriteHeader(http.StatusCreated)
+			w.Write(der)
+		}
+	case "/challenge/":
+		var chlng AuthorizationChallenge
+		if err := decodeJWSPayload(r, &chlng); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ca.mu.Lock()
+		ca.validations = append(ca.validations, chlng)
+		ca.mu.Unlock()
+
+		chlng.Status = "valid"
+		writeJSONResponse(w, chlng)
+	case "/authz/":
+		var authz Authorization
+		if err := decodeJWSPayload(r, &authz); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/authz/"))
+		if err != nil || authz.Status != "deactivated" {
+			http.Error(w, "unexpected authorization update", http.StatusBadRequest)
+			return
+		}
+
+		ca.mu.Lock()
+		if id < 1 || id > len(ca.identifiers) {
+			ca.mu.Unlock()
+			http.NotFound(w, r)
+			return
+		}
+		authz.Identifier = ca.identifiers[id-1]
+		ca.deactivations = append(ca.deactivations, authz.Identifier.Value)
+		ca.mu.Unlock()
+
+		writeJSONResponse(w, authz)
+	case "/issuer":
+		w.Write(ca.cert.Raw)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// decodeJWSPayload decodes the payload of the JWS in the body of r into v
+// without verifying the signature.
+func decodeJWSPayload(r *http.Request, v interface{}) error {
+	var signed struct {
+		Payload string `json:"payload"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&signed); err != nil {
+		return err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(signed.Payload)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(payload, v)
+}
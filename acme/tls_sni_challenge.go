@@ -6,20 +6,28 @@ import (
 	"crypto/tls"
 	"encoding/hex"
 	"fmt"
-	"log"
+	"sync"
 )
 
 type tlsSNIChallenge struct {
 	jws      *jws
 	validate validateFunc
 	provider ChallengeProvider
+
+	// providerMu serializes the calls to sequential providers. It is
+	// shared by the solvers of a client.
+	providerMu *sync.Mutex
+
+	// logf writes the log entries of the solver to the logger of its
+	// client.
+	logf logFunc
 }
 
-func (t *tlsSNIChallenge) Solve(chlng challenge, domain string) error {
+func (t *tlsSNIChallenge) Solve(chlng AuthorizationChallenge, domain string) error {
 	// FIXME: https://github.com/ietf-wg-acme/acme/pull/22
 	// Currently we implement this challenge to track boulder, not the current spec!
 
-	logf("[INFO][%s] acme: Trying to solve TLS-SNI-01", domain)
+	t.logf.printf("[INFO][%s] acme: Trying to solve TLS-SNI-01", domain)
 
 	// Generate the Key Authorization for the challenge
 	keyAuth, err := getKeyAuthorization(chlng.Token, t.jws.privKey)
@@ -27,17 +35,17 @@ func (t *tlsSNIChallenge) Solve(chlng challenge, domain string) error {
 		return err
 	}
 
-	err = t.provider.Present(domain, chlng.Token, keyAuth)
+	err = presentChallenge(t.providerMu, t.provider, domain, chlng.Token, keyAuth)
 	if err != nil {
 		return fmt.Errorf("[%s] error presenting token: %v", domain, err)
 	}
 	defer func() {
-		err := t.provider.CleanUp(domain, chlng.Token, keyAuth)
+		err := cleanUpChallenge(t.providerMu, t.provider, domain, chlng.Token, keyAuth)
 		if err != nil {
-			log.Printf("[%s] error cleaning up: %v", domain, err)
+			t.logf.printf("[ERROR][%s] acme: Error cleaning up: %v", domain, err)
 		}
 	}()
-	return t.validate(t.jws, domain, chlng.URI, challenge{Resource: "challenge", Type: chlng.Type, Token: chlng.Token, KeyAuthorization: keyAuth})
+	return t.validate(t.jws, domain, chlng.URI, AuthorizationChallenge{Resource: "challenge", Type: chlng.Type, Token: chlng.Token, KeyAuthorization: keyAuth})
 }
 
 // TLSSNI01ChallengeCert returns a certificate and target domain for the `tls-sni-01` challenge
@@ -2,7 +2,11 @@ package acme
 
 import (
 	"fmt"
+	"net"
+	"strings"
 	"time"
+
+	"golang.org/x/net/idna"
 )
 
 // WaitFor polls the given function 'f', once every 'interval', up to 'timeout'.
@@ -27,3 +31,59 @@ func WaitFor(timeout, interval time.Duration, f func() (bool, error)) error {
 		time.Sleep(interval)
 	}
 }
+
+// normalizeDomain converts domain into the form expected by the ACME server:
+// the trailing dot is removed, the name is lowercased and internationalized
+// names are converted to their punycode (A-label) representation.
+// IP addresses are returned unchanged apart from the lowercasing.
+func normalizeDomain(domain string) (string, error) {
+	domain = strings.ToLower(strings.TrimSpace(UnFqdn(domain)))
+	if net.ParseIP(domain) != nil {
+		return domain, nil
+	}
+
+	ascii, err := idna.ToASCII(domain)
+	if err != nil {
+		return "", fmt.Errorf("acme: could not convert domain %q to ASCII: %v", domain, err)
+	}
+	return strings.ToLower(ascii), nil
+}
+
+// normalizeDomains normalizes all domains using normalizeDomain, dropping
+// duplicates which only differed in their spelling. Domains which could
+// not be normalized are returned in the error map keyed by their input.
+func normalizeDomains(domains []string) ([]string, map[string]error) {
+	var normalized []string
+	failures := make(map[string]error)
+	seen := make(map[string]bool)
+
+	for _, domain := range domains {
+		name, err := normalizeDomain(domain)
+		if err != nil {
+			failures[domain] = err
+			continue
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		normalized = append(normalized, name)
+	}
+
+	return normalized, failures
+}
+
+// SplitDomains splits domains into consecutive chunks of at most size
+// domains each. A size of zero or less returns all domains in one chunk.
+func SplitDomains(domains []string, size int) [][]string {
+	if size <= 0 || len(domains) <= size {
+		return [][]string{domains}
+	}
+
+	var chunks [][]string
+	for len(domains) > size {
+		chunks = append(chunks, domains[:size:size])
+		domains = domains[size:]
+	}
+	return append(chunks, domains)
+}
@@ -1,6 +1,7 @@
 package acme
 
 import (
+	"reflect"
 	"testing"
 	"time"
 )
@@ -24,3 +25,65 @@ func TestWaitForTimeout(t *testing.T) {
 		}
 	}
 }
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := []struct {
+		domain   string
+		expected string
+	}{
+		{"example.com", "example.com"},
+		{"Example.COM.", "example.com"},
+		{"WWW.Example.com", "www.example.com"},
+		{"例え.テスト", "xn--r8jz45g.xn--zckzah"},
+		{"XN--R8JZ45G.xn--zckzah.", "xn--r8jz45g.xn--zckzah"},
+		{"192.0.2.1", "192.0.2.1"},
+		{"2001:DB8::1", "2001:db8::1"},
+	}
+
+	for _, test := range tests {
+		actual, err := normalizeDomain(test.domain)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.domain, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("%s: expected %s but got %s", test.domain, test.expected, actual)
+		}
+	}
+}
+
+func TestNormalizeDomains(t *testing.T) {
+	domains, failures := normalizeDomains([]string{"Example.COM.", "example.com", "例え.テスト"})
+	if len(failures) > 0 {
+		t.Fatalf("unexpected failures: %v", failures)
+	}
+
+	expected := []string{"example.com", "xn--r8jz45g.xn--zckzah"}
+	if len(domains) != len(expected) {
+		t.Fatalf("expected %v but got %v", expected, domains)
+	}
+	for i := range expected {
+		if domains[i] != expected[i] {
+			t.Errorf("expected %v but got %v", expected, domains)
+		}
+	}
+}
+
+func TestSplitDomains(t *testing.T) {
+	domains := []string{"a.com", "b.com", "c.com", "d.com", "e.com"}
+	tests := []struct {
+		size     int
+		expected [][]string
+	}{
+		{0, [][]string{domains}},
+		{5, [][]string{domains}},
+		{10, [][]string{domains}},
+		{2, [][]string{{"a.com", "b.com"}, {"c.com", "d.com"}, {"e.com"}}},
+		{1, [][]string{{"a.com"}, {"b.com"}, {"c.com"}, {"d.com"}, {"e.com"}}},
+	}
+	for _, tt := range tests {
+		if chunks := SplitDomains(domains, tt.size); !reflect.DeepEqual(chunks, tt.expected) {
+			t.Errorf("size %d: expected %v but got %v", tt.size, tt.expected, chunks)
+		}
+	}
+}
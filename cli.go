@@ -68,6 +68,10 @@ func main() {
 					Name:  "must-staple",
 					Usage: "Include the OCSP must staple TLS extension in the CSR and generated certificate. Only works if the CSR is generated by lego.",
 				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Present and clean up the challenges without having them validated and without requesting a certificate.",
+				},
 			},
 		},
 		{
@@ -142,6 +146,10 @@ func main() {
 			Name:  "exclude, x",
 			Usage: "Explicitly disallow solvers by name from being used. Solvers: \"http-01\", \"tls-sni-01\".",
 		},
+		cli.StringSliceFlag{
+			Name:  "prefer",
+			Usage: "Prefer solvers in the given order if the CA offers several, e.g. --prefer dns-01 --prefer http-01.",
+		},
 		cli.StringFlag{
 			Name:  "webroot",
 			Usage: "Set the webroot folder to use for HTTP based challenges to write directly in a file in .well-known/acme-challenge",
@@ -154,6 +162,10 @@ func main() {
 			Name:  "http",
 			Usage: "Set the port and interface to use for HTTP based challenges to listen on. Supported: interface:port or :port",
 		},
+		cli.StringFlag{
+			Name:  "http-family",
+			Usage: "Set the address family for HTTP based challenges to listen on: tcp4, tcp6 or tcp for both.",
+		},
 		cli.StringFlag{
 			Name:  "tls",
 			Usage: "Set the port and interface to use for TLS based challenges to listen on. Supported: interface:port or :port",
@@ -166,10 +178,52 @@ func main() {
 			Name:  "http-timeout",
 			Usage: "Set the HTTP timeout value to a specific value in seconds. The default is 10 seconds.",
 		},
+		cli.StringSliceFlag{
+			Name:  "ca-certs",
+			Usage: "Trust the PEM encoded root certificates in this file instead of the system roots when connecting to the CA, e.g. for a test CA like Pebble. Can be passed multiple times.",
+		},
+		cli.BoolFlag{
+			Name:  "insecure-skip-verify",
+			Usage: "Do not verify the certificate of the CA. Only use this with test CAs.",
+		},
 		cli.IntFlag{
 			Name:  "dns-timeout",
 			Usage: "Set the DNS timeout value to a specific value in seconds. The default is 10 seconds.",
 		},
+		cli.BoolFlag{
+			Name:  "force-new-authz",
+			Usage: "Deactivate valid authorizations the CA reuses and validate all domains again, e.g. after fixing their DNS.",
+		},
+		cli.IntFlag{
+			Name:  "challenge-timeout",
+			Usage: "Set the time in seconds to wait for a single challenge to be validated by the server. By default there is no limit.",
+		},
+		cli.IntFlag{
+			Name:  "cert-poll-interval",
+			Usage: "Set the time in seconds between polls for a certificate the CA did not issue yet, unless the CA sends Retry-After.",
+			Value: 1,
+		},
+		cli.IntFlag{
+			Name:  "cert-timeout",
+			Usage: "Set the time in seconds to wait for the CA to issue a certificate. By default there is no limit.",
+		},
+		cli.IntFlag{
+			Name:  "directory-timeout",
+			Usage: "Set the time in seconds to keep retrying to fetch the directory of the CA while it is unreachable. 0 disables the retries.",
+			Value: 30,
+		},
+		cli.BoolFlag{
+			Name:  "caa-check",
+			Usage: "Check that the CAA records of all domains permit the CA to issue before contacting it.",
+		},
+		cli.BoolFlag{
+			Name:  "dns-authoritative-precheck",
+			Usage: "Check the propagation of DNS records at the authoritative nameservers only, so the recursive resolvers never cache a negative answer for the challenge record.",
+		},
+		cli.BoolFlag{
+			Name:  "dns-verify-present",
+			Usage: "Read back the DNS record after the provider created it and wait until it holds the challenge value, to catch APIs which report success without storing the record.",
+		},
 		cli.StringSliceFlag{
 			Name:  "dns-resolvers",
 			Usage: "Set the resolvers to use for performing recursive DNS queries. Supported: host:port. The default is to use Google's DNS resolvers.",
@@ -189,6 +243,9 @@ func main() {
 func dnshelp(c *cli.Context) error {
 	fmt.Printf(
 		`Credentials for DNS providers must be passed through environment variables.
+The cloudflare and route53 providers also read missing variables from the
+file named by LEGO_CREDENTIALS_FILE, which holds NAME = value lines or a JSON
+object.
 
 Here is an example bash command using the CloudFlare DNS provider:
 
@@ -201,23 +258,41 @@ Here is an example bash command using the CloudFlare DNS provider:
 	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
 	fmt.Fprintln(w, "Valid providers and their associated credential environment variables:")
 	fmt.Fprintln(w)
-	fmt.Fprintln(w, "\tazure:\tAZURE_CLIENT_ID, AZURE_CLIENT_SECRET, AZURE_SUBSCRIPTION_ID, AZURE_TENANT_ID, AZURE_RESOURCE_GROUP")
+	fmt.Fprintln(w, "\tactive24:\tACTIVE24_API_KEY")
+	fmt.Fprintln(w, "\talidns:\tALICLOUD_ACCESS_KEY, ALICLOUD_SECRET_KEY, ALICLOUD_REGION_ID")
+	fmt.Fprintln(w, "\tazure:\tAZURE_CLIENT_ID, AZURE_CLIENT_SECRET, AZURE_SUBSCRIPTION_ID, AZURE_TENANT_ID, AZURE_RESOURCE_GROUP,\n\t\tAZURE_PRIVATE_ZONE, AZURE_PRIVATE_ZONE_NAMESERVERS")
 	fmt.Fprintln(w, "\tauroradns:\tAURORA_USER_ID, AURORA_KEY, AURORA_ENDPOINT")
-	fmt.Fprintln(w, "\tcloudflare:\tCLOUDFLARE_EMAIL, CLOUDFLARE_API_KEY")
+	fmt.Fprintln(w, "\tbunny:\tBUNNY_API_KEY")
+	fmt.Fprintln(w, "\tcivo:\tCIVO_TOKEN")
+	fmt.Fprintln(w, "\tcloudflare:\tCLOUDFLARE_EMAIL, CLOUDFLARE_API_KEY, CLOUDFLARE_ZONE_ID, CLOUDFLARE_TTL")
+	fmt.Fprintln(w, "\tdesec:\tDESEC_TOKEN")
 	fmt.Fprintln(w, "\tdigitalocean:\tDO_AUTH_TOKEN")
 	fmt.Fprintln(w, "\tdnsimple:\tDNSIMPLE_EMAIL, DNSIMPLE_API_KEY")
 	fmt.Fprintln(w, "\tdnsmadeeasy:\tDNSMADEEASY_API_KEY, DNSMADEEASY_API_SECRET")
+	fmt.Fprintln(w, "\tdode:\tDODE_TOKEN")
+	fmt.Fprintln(w, "\tdomeneshop:\tDOMENESHOP_API_TOKEN, DOMENESHOP_API_SECRET")
 	fmt.Fprintln(w, "\texoscale:\tEXOSCALE_API_KEY, EXOSCALE_API_SECRET, EXOSCALE_ENDPOINT")
-	fmt.Fprintln(w, "\tgandi:\tGANDI_API_KEY")
-	fmt.Fprintln(w, "\tgcloud:\tGCE_PROJECT")
+	fmt.Fprintln(w, "\tgandi:\tGANDI_API_KEY, GANDI_PERSONAL_ACCESS_TOKEN, GANDI_ENDPOINT, GANDI_TTL")
+	fmt.Fprintln(w, "\tgcloud:\tGCE_PROJECT, GCE_ZONE_VISIBILITY, GCE_PRIVATE_ZONE_NAMESERVERS")
+	fmt.Fprintln(w, "\tgcore:\tGCORE_PERMANENT_API_TOKEN")
+	fmt.Fprintln(w, "\tinfoblox:\tINFOBLOX_HOST, INFOBLOX_USERNAME, INFOBLOX_PASSWORD,\n\t\tINFOBLOX_WAPI_VERSION, INFOBLOX_DNS_VIEW")
 	fmt.Fprintln(w, "\tlinode:\tLINODE_API_KEY")
+	fmt.Fprintln(w, "\tloopia:\tLOOPIA_API_USER, LOOPIA_API_PASSWORD")
 	fmt.Fprintln(w, "\tmanual:\tnone")
+	fmt.Fprintln(w, "\tmiab:\tMIAB_BASE_URL, MIAB_USERNAME, MIAB_PASSWORD")
 	fmt.Fprintln(w, "\tnamecheap:\tNAMECHEAP_API_USER, NAMECHEAP_API_KEY")
+	fmt.Fprintln(w, "\tporkbun:\tPORKBUN_API_KEY, PORKBUN_SECRET_API_KEY")
 	fmt.Fprintln(w, "\trackspace:\tRACKSPACE_USER, RACKSPACE_API_KEY")
-	fmt.Fprintln(w, "\trfc2136:\tRFC2136_TSIG_KEY, RFC2136_TSIG_SECRET,\n\t\tRFC2136_TSIG_ALGORITHM, RFC2136_NAMESERVER")
+	fmt.Fprintln(w, "\trfc2136:\tRFC2136_TSIG_KEY, RFC2136_TSIG_SECRET,\n\t\tRFC2136_TSIG_ALGORITHM, RFC2136_NAMESERVER,\n\t\tRFC2136_PROTOCOL")
 	fmt.Fprintln(w, "\troute53:\tAWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_REGION")
+	fmt.Fprintln(w, "\tscaleway:\tSCALEWAY_API_TOKEN or SCALEWAY_SECRET_KEY")
+	fmt.Fprintln(w, "\tsimply:\tSIMPLY_ACCOUNT_NAME, SIMPLY_API_KEY")
 	fmt.Fprintln(w, "\tdyn:\tDYN_CUSTOMER_NAME, DYN_USER_NAME, DYN_PASSWORD")
+	fmt.Fprintln(w, "\tvariomedia:\tVARIOMEDIA_API_TOKEN")
+	fmt.Fprintln(w, "\tvercel:\tVERCEL_API_TOKEN, VERCEL_TEAM_ID")
 	fmt.Fprintln(w, "\tvultr:\tVULTR_API_KEY")
+	fmt.Fprintln(w, "\twindns:\tWINDNS_HOST, WINDNS_USERNAME, WINDNS_PASSWORD,\n\t\tWINDNS_HTTPS, WINDNS_INSECURE, WINDNS_PORT, WINDNS_ZONE")
+	fmt.Fprintln(w, "\tyandexcloud:\tYANDEX_CLOUD_FOLDER_ID, YANDEX_CLOUD_IAM_TOKEN or YANDEX_CLOUD_SERVICE_ACCOUNT_KEY_FILE")
 	fmt.Fprintln(w, "\tovh:\tOVH_ENDPOINT, OVH_APPLICATION_KEY, OVH_APPLICATION_SECRET, OVH_CONSUMER_KEY")
 	fmt.Fprintln(w, "\tpdns:\tPDNS_API_KEY, PDNS_API_URL")
 	fmt.Fprintln(w, "\tdnspod:\tDNSPOD_API_KEY")
@@ -33,10 +33,30 @@ func setup(c *cli.Context) (*Configuration, *Account, *acme.Client) {
 		acme.HTTPClient = http.Client{Timeout: time.Duration(c.GlobalInt("http-timeout")) * time.Second}
 	}
 
+	if len(c.GlobalStringSlice("ca-certs")) > 0 || c.GlobalBool("insecure-skip-verify") {
+		var roots *x509.CertPool
+		if len(c.GlobalStringSlice("ca-certs")) > 0 {
+			var err error
+			roots, err = acme.LoadCACertificates(c.GlobalStringSlice("ca-certs")...)
+			if err != nil {
+				logger().Fatalf("Could not load CA certificates: %s", err.Error())
+			}
+		}
+		acme.SetCACertificates(roots, c.GlobalBool("insecure-skip-verify"))
+	}
+
 	if c.GlobalIsSet("dns-timeout") {
 		acme.DNSTimeout = time.Duration(c.GlobalInt("dns-timeout")) * time.Second
 	}
 
+	if c.GlobalBool("dns-authoritative-precheck") {
+		acme.AuthoritativePreCheck = true
+	}
+
+	if c.GlobalBool("dns-verify-present") {
+		acme.VerifyPresentedRecord = true
+	}
+
 	if len(c.GlobalStringSlice("dns-resolvers")) > 0 {
 		resolvers := []string{}
 		for _, resolver := range c.GlobalStringSlice("dns-resolvers") {
@@ -66,15 +86,33 @@ func setup(c *cli.Context) (*Configuration, *Account, *acme.Client) {
 		logger().Fatal(err.Error())
 	}
 
+	acme.DirectoryTimeout = time.Duration(c.GlobalInt("directory-timeout")) * time.Second
 	client, err := acme.NewClient(c.GlobalString("server"), acc, keyType)
 	if err != nil {
 		logger().Fatalf("Could not create client: %s", err.Error())
 	}
+	client.SetLogger(logger())
+
+	if c.GlobalBool("force-new-authz") {
+		client.SetForceNewAuthorizations(true)
+	}
+
+	if c.GlobalIsSet("challenge-timeout") {
+		client.SetChallengeTimeout(time.Duration(c.GlobalInt("challenge-timeout")) * time.Second)
+	}
+
+	client.SetCertificatePolling(time.Duration(c.GlobalInt("cert-poll-interval"))*time.Second, time.Duration(c.GlobalInt("cert-timeout"))*time.Second)
+
+	client.SetCAACheck(c.GlobalBool("caa-check"))
 
 	if len(c.GlobalStringSlice("exclude")) > 0 {
 		client.ExcludeChallenges(conf.ExcludedSolvers())
 	}
 
+	if len(c.GlobalStringSlice("prefer")) > 0 {
+		client.SetChallengePreferenceOrder(conf.PreferredSolvers())
+	}
+
 	if c.GlobalIsSet("webroot") {
 		provider, err := webroot.NewHTTPProvider(c.GlobalString("webroot"))
 		if err != nil {
@@ -99,6 +137,11 @@ func setup(c *cli.Context) (*Configuration, *Account, *acme.Client) {
 		// infer that the user also wants to exclude all other challenges
 		client.ExcludeChallenges([]acme.Challenge{acme.DNS01, acme.TLSSNI01})
 	}
+	if c.GlobalIsSet("http-family") {
+		if err := client.SetHTTP01Family(c.GlobalString("http-family")); err != nil {
+			logger().Fatalf("Could not set the HTTP address family: %v", err)
+		}
+	}
 	if c.GlobalIsSet("http") {
 		if strings.Index(c.GlobalString("http"), ":") == -1 {
 			logger().Fatalf("The --http switch only accepts interface:port or :port for its argument.")
@@ -289,6 +332,8 @@ func run(c *cli.Context) error {
 		logger().Fatal("Please specify --domains/-d (or --csr/-c if you already have a CSR)")
 	}
 
+	client.SetDryRun(c.Bool("dry-run"))
+
 	var cert acme.CertificateResource
 	var failures map[string]error
 
@@ -318,6 +363,13 @@ func run(c *cli.Context) error {
 		os.Exit(1)
 	}
 
+	if c.Bool("dry-run") {
+		for domain, challenges := range cert.Challenges {
+			logger().Printf("[%s] Dry run succeeded using %v", domain, challenges)
+		}
+		return nil
+	}
+
 	err := checkFolder(conf.CertPath())
 	if err != nil {
 		logger().Fatalf("Could not check/create path: %s", err.Error())
@@ -47,6 +47,14 @@ func (c *Configuration) ExcludedSolvers() (cc []acme.Challenge) {
 	return
 }
 
+// PreferredSolvers is the list of solvers in the order the user prefers them.
+func (c *Configuration) PreferredSolvers() (cc []acme.Challenge) {
+	for _, s := range c.context.GlobalStringSlice("prefer") {
+		cc = append(cc, acme.Challenge(s))
+	}
+	return
+}
+
 // ServerPath returns the OS dependent path to the data for a specific CA
 func (c *Configuration) ServerPath() string {
 	srv, _ := url.Parse(c.context.GlobalString("server"))
@@ -7,9 +7,10 @@ import (
 	"crypto/rand"
 	"crypto/x509"
 	"encoding/pem"
-	"errors"
 	"io/ioutil"
 	"os"
+
+	"github.com/stangah/lego/acme"
 )
 
 func generatePrivateKey(file string) (crypto.PrivateKey, error) {
@@ -43,14 +44,5 @@ func loadPrivateKey(file string) (crypto.PrivateKey, error) {
 		return nil, err
 	}
 
-	keyBlock, _ := pem.Decode(keyBytes)
-
-	switch keyBlock.Type {
-	case "RSA PRIVATE KEY":
-		return x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
-	case "EC PRIVATE KEY":
-		return x509.ParseECPrivateKey(keyBlock.Bytes)
-	}
-
-	return nil, errors.New("Unknown private key type.")
+	return acme.LoadPrivateKeyPEM(keyBytes)
 }
@@ -0,0 +1,164 @@
+// Package active24 implements a DNS provider for solving the DNS-01
+// challenge using Active24 DNS.
+package active24
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/stangah/lego/acme"
+)
+
+// Active24 API reference: https://faq.active24.com/eng/739445-REST-API-rozhran%C3%AD
+
+var (
+	// active24BaseURL is the base URL of the Active24 API.
+	active24BaseURL = "https://api.active24.com"
+	// findZoneByFqdn determines the domain of an fqdn. It is overridden
+	// during tests.
+	findZoneByFqdn = acme.FindZoneByFqdn
+)
+
+// defaultTTL is the TTL of the TXT records created by the provider.
+const defaultTTL = 300
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses Active24's API to manage TXT records for a domain.
+type DNSProvider struct {
+	apiKey string
+	ttl    int
+}
+
+// txtRecord is a TXT record of the Active24 API.
+type txtRecord struct {
+	HashID string `json:"hashId,omitempty"`
+	Type   string `json:"type,omitempty"`
+	Name   string `json:"name"`
+	Text   string `json:"text"`
+	TTL    int    `json:"ttl,omitempty"`
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Active24.
+// The API key must be passed in the environment variable ACTIVE24_API_KEY.
+func NewDNSProvider() (*DNSProvider, error) {
+	return NewDNSProviderCredentials(os.Getenv("ACTIVE24_API_KEY"))
+}
+
+// NewDNSProviderCredentials uses the supplied API key to return a
+// DNSProvider instance configured for Active24.
+func NewDNSProviderCredentials(apiKey string) (*DNSProvider, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("Active24 credentials missing")
+	}
+	return &DNSProvider{apiKey: apiKey, ttl: defaultTTL}, nil
+}
+
+// SetTTL sets the TTL in seconds of the TXT records created by the provider.
+func (d *DNSProvider) SetTTL(ttl int) error {
+	if ttl <= 0 {
+		return fmt.Errorf("Active24 TTL must be positive, got %d", ttl)
+	}
+	d.ttl = ttl
+	return nil
+}
+
+// Present creates a TXT record for the challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	zone, name, err := recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	record := txtRecord{Name: name, Text: value, TTL: d.ttl}
+	return d.doRequest("POST", fmt.Sprintf("/dns/%s/txt/v1", zone), record, nil)
+}
+
+// CleanUp deletes the TXT records with the challenge value, leaving other
+// values of the challenge name alone.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	zone, name, err := recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	var records []txtRecord
+	if err := d.doRequest("GET", fmt.Sprintf("/dns/%s/records/v1", zone), nil, &records); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if record.Type != "TXT" || record.Name != name || record.Text != value {
+			continue
+		}
+		if err := d.doRequest("DELETE", fmt.Sprintf("/dns/%s/%s/v1", zone, record.HashID), nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordLocation returns the Active24 domain used in the paths of the DNS
+// endpoints and the name of fqdn within it, which is empty at the apex.
+func recordLocation(fqdn string) (string, string, error) {
+	authZone, err := findZoneByFqdn(fqdn, acme.RecursiveNameservers)
+	if err != nil {
+		return "", "", fmt.Errorf("Active24: could not determine zone for %s: %v", fqdn, err)
+	}
+
+	zone := acme.UnFqdn(authZone)
+	name := acme.UnFqdn(fqdn)
+	if name == zone {
+		return zone, "", nil
+	}
+	return zone, name[:len(name)-len(zone)-1], nil
+}
+
+func (d *DNSProvider) doRequest(method, uri string, body, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, active24BaseURL+uri, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+d.apiKey)
+	req.Header.Set("User-Agent", acme.UserAgentString())
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := acme.DoWithRetry(req, nil)
+	if err != nil {
+		return fmt.Errorf("Active24 API call failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var errInfo struct {
+			Errors map[string][]string `json:"errors"`
+		}
+		json.NewDecoder(resp.Body).Decode(&errInfo)
+		msg := http.StatusText(resp.StatusCode)
+		for field, errs := range errInfo.Errors {
+			msg += fmt.Sprintf(", %s: %v", field, errs)
+		}
+		return fmt.Errorf("Active24 API call failed: HTTP %d: %s", resp.StatusCode, msg)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,259 @@
+package active24
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stangah/lego/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	active24LiveTest bool
+	active24APIKey   string
+	active24Domain   string
+)
+
+func init() {
+	active24APIKey = os.Getenv("ACTIVE24_API_KEY")
+	active24Domain = os.Getenv("ACTIVE24_DOMAIN")
+	if len(active24APIKey) > 0 && len(active24Domain) > 0 {
+		active24LiveTest = true
+	}
+}
+
+func restoreActive24Env() {
+	os.Setenv("ACTIVE24_API_KEY", active24APIKey)
+}
+
+// fakeActive24 serves the DNS records of the domain example.com from
+// memory.
+type fakeActive24 struct {
+	mu      sync.Mutex
+	records []txtRecord
+	deleted []string
+	next    int
+}
+
+func (f *fakeActive24) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/dns/example.com/txt/v1":
+		var record txtRecord
+		json.NewDecoder(r.Body).Decode(&record)
+		if record.Text == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errors":{"text":["This value should not be blank."]}}`)
+			return
+		}
+		f.next++
+		record.HashID = fmt.Sprintf("hash%d", f.next)
+		record.Type = "TXT"
+		f.records = append(f.records, record)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "GET" && r.URL.Path == "/dns/example.com/records/v1":
+		records := append([]txtRecord{{HashID: "a1", Type: "A", Name: "www"}}, f.records...)
+		json.NewEncoder(w).Encode(records)
+	case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/dns/example.com/"):
+		f.deleted = append(f.deleted, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// mockActive24 serves the Active24 API with handler and makes the zone of
+// every fqdn be example.com until the returned function is called.
+func mockActive24(handler http.Handler) func() {
+	server := httptest.NewServer(handler)
+	baseURL, findZone := active24BaseURL, findZoneByFqdn
+	active24BaseURL = server.URL
+	findZoneByFqdn = func(fqdn string, nameservers []string) (string, error) {
+		return "example.com.", nil
+	}
+	return func() {
+		active24BaseURL, findZoneByFqdn = baseURL, findZone
+		server.Close()
+	}
+}
+
+func newFakeActive24() (*fakeActive24, func()) {
+	fake := &fakeActive24{}
+	return fake, mockActive24(fake)
+}
+
+func TestNewDNSProviderValid(t *testing.T) {
+	os.Setenv("ACTIVE24_API_KEY", "")
+	defer restoreActive24Env()
+	_, err := NewDNSProviderCredentials("123")
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	os.Setenv("ACTIVE24_API_KEY", "secret")
+	defer restoreActive24Env()
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", provider.apiKey)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	os.Setenv("ACTIVE24_API_KEY", "")
+	defer restoreActive24Env()
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "Active24 credentials missing")
+}
+
+func TestActive24Present(t *testing.T) {
+	var requestReceived bool
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockActive24(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestReceived = true
+
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/dns/example.com/txt/v1", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "Bearer asdf1234", r.Header.Get("Authorization"))
+
+		reqBody, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"name":"_acme-challenge.www","text":"`+value+`","ttl":300}`, string(reqBody))
+
+		w.WriteHeader(http.StatusNoContent)
+	}))()
+
+	provider, err := NewDNSProviderCredentials("asdf1234")
+	assert.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.True(t, requestReceived, "Expected request to be received by mock backend")
+}
+
+func TestActive24CleanUp(t *testing.T) {
+	var requests []string
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockActive24(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		assert.Equal(t, "Bearer asdf1234", r.Header.Get("Authorization"))
+
+		if r.Method == "GET" {
+			fmt.Fprint(w, `[
+				{"hashId":"a1","type":"A","name":"_acme-challenge.www","ip":"192.0.2.1"},
+				{"hashId":"t1","type":"TXT","name":"_acme-challenge.www","text":"other"},
+				{"hashId":"t2","type":"TXT","name":"_acme-challenge.www","text":"`+value+`"},
+				{"hashId":"t3","type":"TXT","name":"_acme-challenge","text":"`+value+`"}
+			]`)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))()
+
+	provider, err := NewDNSProviderCredentials("asdf1234")
+	assert.NoError(t, err)
+
+	// Only the TXT record with the challenge name and value is deleted.
+	err = provider.CleanUp("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"GET /dns/example.com/records/v1",
+		"DELETE /dns/example.com/t2/v1",
+	}, requests)
+}
+
+func TestActive24SharedName(t *testing.T) {
+	fake, done := newFakeActive24()
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+
+	assert.NoError(t, provider.Present("www.example.com", "", "key"))
+	assert.NoError(t, provider.Present("www.example.com", "", "other"))
+	_, value, _ := acme.DNS01Record("www.example.com", "key")
+	if assert.Len(t, fake.records, 2) {
+		assert.Equal(t, txtRecord{HashID: "hash1", Type: "TXT", Name: "_acme-challenge.www", Text: value, TTL: 300}, fake.records[0])
+	}
+
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "key"))
+	assert.Equal(t, []string{"/dns/example.com/hash1/v1"}, fake.deleted)
+}
+
+func TestActive24ApexAndTTL(t *testing.T) {
+	fake, done := newFakeActive24()
+	defer done()
+
+	acme.DNS01RecordName = func(domain, fqdn string) string { return "example.com." }
+	defer func() { acme.DNS01RecordName = nil }()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+	assert.Error(t, provider.SetTTL(0))
+	assert.NoError(t, provider.SetTTL(600))
+
+	// Records at the apex of the domain have an empty name.
+	assert.NoError(t, provider.Present("example.com", "", "key"))
+	_, value, _ := acme.DNS01Record("example.com", "key")
+	assert.Equal(t, []txtRecord{{HashID: "hash1", Type: "TXT", Name: "", Text: value, TTL: 600}}, fake.records)
+
+	assert.NoError(t, provider.CleanUp("example.com", "", "key"))
+	assert.Equal(t, []string{"/dns/example.com/hash1/v1"}, fake.deleted)
+}
+
+func TestActive24PresentFailed(t *testing.T) {
+	_, done := newFakeActive24()
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("wrong")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.com", "", "key"), "Active24 API call failed: HTTP 401: Unauthorized")
+
+	// Validation errors are reported per field.
+	provider, err = NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+	err = provider.doRequest("POST", "/dns/example.com/txt/v1", txtRecord{Name: "www"}, nil)
+	assert.EqualError(t, err, "Active24 API call failed: HTTP 400: Bad Request, text: [This value should not be blank.]")
+}
+
+func TestLiveActive24Present(t *testing.T) {
+	if !active24LiveTest {
+		t.Skip("skipping live test")
+	}
+
+	provider, err := NewDNSProviderCredentials(active24APIKey)
+	assert.NoError(t, err)
+
+	err = provider.Present(active24Domain, "", "123d==")
+	assert.NoError(t, err)
+}
+
+func TestLiveActive24CleanUp(t *testing.T) {
+	if !active24LiveTest {
+		t.Skip("skipping live test")
+	}
+
+	time.Sleep(time.Second * 1)
+
+	provider, err := NewDNSProviderCredentials(active24APIKey)
+	assert.NoError(t, err)
+
+	err = provider.CleanUp(active24Domain, "", "123d==")
+	assert.NoError(t, err)
+}
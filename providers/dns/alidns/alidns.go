@@ -0,0 +1,269 @@
+// Package alidns implements a DNS provider for solving the DNS-01 challenge
+// using Alibaba Cloud DNS.
+package alidns
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stangah/lego/acme"
+)
+
+// Alibaba Cloud DNS API reference: https://www.alibabacloud.com/help/en/dns/api-alibaba-cloud-dns-2015-01-09-dir-parsing-records
+
+var (
+	// findZoneByFqdn determines the domain of an fqdn. It is overridden
+	// during tests.
+	findZoneByFqdn = acme.FindZoneByFqdn
+	// regionPattern matches the IDs of Alibaba Cloud regions, such as
+	// cn-hangzhou or ap-southeast-1.
+	regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+(-[0-9]+)?$`)
+)
+
+const (
+	// DefaultRegion is the region used when none is configured.
+	DefaultRegion = "cn-hangzhou"
+	// apiVersion is the version of the Alibaba Cloud DNS API.
+	apiVersion = "2015-01-09"
+	// defaultTTL is the minimum TTL accepted by Alibaba Cloud DNS.
+	defaultTTL = 600
+)
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses the Alibaba Cloud DNS API to manage TXT records for a domain.
+type DNSProvider struct {
+	accessKey string
+	secretKey string
+	endpoint  string
+	ttl       int
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Alibaba
+// Cloud DNS. The credentials must be passed in the environment variables
+// ALICLOUD_ACCESS_KEY and ALICLOUD_SECRET_KEY. The region, which selects
+// the API endpoint, can be set in ALICLOUD_REGION_ID, DefaultRegion is
+// used otherwise.
+func NewDNSProvider() (*DNSProvider, error) {
+	provider, err := NewDNSProviderCredentials(os.Getenv("ALICLOUD_ACCESS_KEY"), os.Getenv("ALICLOUD_SECRET_KEY"))
+	if err != nil {
+		return nil, err
+	}
+	if region := os.Getenv("ALICLOUD_REGION_ID"); region != "" {
+		if err := provider.SetRegion(region); err != nil {
+			return nil, err
+		}
+	}
+	return provider, nil
+}
+
+// NewDNSProviderCredentials uses the supplied credentials to return a
+// DNSProvider instance configured for Alibaba Cloud DNS in DefaultRegion.
+func NewDNSProviderCredentials(accessKey, secretKey string) (*DNSProvider, error) {
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("Alibaba Cloud credentials missing")
+	}
+	provider := &DNSProvider{accessKey: accessKey, secretKey: secretKey, ttl: defaultTTL}
+	provider.SetRegion(DefaultRegion)
+	return provider, nil
+}
+
+// SetRegion selects the API endpoint of the region with the given ID. The
+// regions in mainland China share one endpoint, while international
+// regions are served by endpoints of their own.
+func (d *DNSProvider) SetRegion(region string) error {
+	host, err := endpointHost(region)
+	if err != nil {
+		return err
+	}
+	d.endpoint = "https://" + host + "/"
+	return nil
+}
+
+// endpointHost returns the host name of the API endpoint of region.
+func endpointHost(region string) (string, error) {
+	region = strings.ToLower(region)
+	if !regionPattern.MatchString(region) {
+		return "", fmt.Errorf("Alibaba Cloud: invalid region ID %q", region)
+	}
+	if strings.HasPrefix(region, "cn-") && !strings.HasPrefix(region, "cn-hongkong") {
+		return "alidns.aliyuncs.com", nil
+	}
+	return fmt.Sprintf("alidns.%s.aliyuncs.com", region), nil
+}
+
+// SetTTL sets the TTL in seconds of the TXT records created by the provider.
+// Alibaba Cloud DNS rejects TTLs below 600 seconds for most editions.
+func (d *DNSProvider) SetTTL(ttl int) error {
+	if ttl <= 0 {
+		return fmt.Errorf("Alibaba Cloud TTL must be positive, got %d", ttl)
+	}
+	d.ttl = ttl
+	return nil
+}
+
+// Present creates a TXT record for the challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	zone, rr, err := recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	params := url.Values{}
+	params.Set("DomainName", zone)
+	params.Set("RR", rr)
+	params.Set("Type", "TXT")
+	params.Set("Value", value)
+	params.Set("TTL", fmt.Sprint(d.ttl))
+	return d.doRequest("AddDomainRecord", params, nil)
+}
+
+// CleanUp deletes the TXT records with the challenge value, leaving other
+// values of the challenge name alone.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	zone, rr, err := recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	params := url.Values{}
+	params.Set("DomainName", zone)
+	params.Set("RRKeyWord", rr)
+	params.Set("TypeKeyWord", "TXT")
+	params.Set("PageSize", "500")
+	var result struct {
+		DomainRecords struct {
+			Record []struct {
+				RecordID string `json:"RecordId"`
+				RR       string `json:"RR"`
+				Type     string `json:"Type"`
+				Value    string `json:"Value"`
+			} `json:"Record"`
+		} `json:"DomainRecords"`
+	}
+	if err := d.doRequest("DescribeDomainRecords", params, &result); err != nil {
+		return err
+	}
+
+	for _, record := range result.DomainRecords.Record {
+		// The keyword search also returns longer names containing rr.
+		if record.Type != "TXT" || record.RR != rr || record.Value != value {
+			continue
+		}
+		params := url.Values{}
+		params.Set("RecordId", record.RecordID)
+		if err := d.doRequest("DeleteDomainRecord", params, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordLocation returns the DomainName and RR parameters of the Alibaba Cloud
+// DNS API for fqdn. The RR of the apex is "@".
+func recordLocation(fqdn string) (string, string, error) {
+	authZone, err := findZoneByFqdn(fqdn, acme.RecursiveNameservers)
+	if err != nil {
+		return "", "", fmt.Errorf("Alibaba Cloud: could not determine zone for %s: %v", fqdn, err)
+	}
+
+	zone := acme.UnFqdn(authZone)
+	name := acme.UnFqdn(fqdn)
+	if name == zone {
+		return zone, "@", nil
+	}
+	return zone, name[:len(name)-len(zone)-1], nil
+}
+
+// doRequest calls action with params, signed with the credentials of the
+// provider, and decodes the response into result.
+func (d *DNSProvider) doRequest(action string, params url.Values, result interface{}) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	params.Set("Action", action)
+	params.Set("Format", "JSON")
+	params.Set("Version", apiVersion)
+	params.Set("AccessKeyId", d.accessKey)
+	params.Set("SignatureMethod", "HMAC-SHA1")
+	params.Set("SignatureVersion", "1.0")
+	params.Set("SignatureNonce", hex.EncodeToString(nonce))
+	params.Set("Timestamp", time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	query := canonicalQuery(params)
+	query += "&Signature=" + percentEncode(sign(d.secretKey, "GET", query))
+
+	req, err := http.NewRequest("GET", d.endpoint+"?"+query, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", acme.UserAgentString())
+
+	resp, err := acme.DoWithRetry(req, nil)
+	if err != nil {
+		return fmt.Errorf("Alibaba Cloud API call failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var errInfo struct {
+			Code    string `json:"Code"`
+			Message string `json:"Message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&errInfo)
+		return fmt.Errorf("Alibaba Cloud API call failed: HTTP %d: %s: %s", resp.StatusCode, errInfo.Code, errInfo.Message)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// canonicalQuery encodes params sorted by key, as required for signing.
+func canonicalQuery(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, percentEncode(key)+"="+percentEncode(params.Get(key)))
+	}
+	return strings.Join(pairs, "&")
+}
+
+// sign returns the signature of a request with the canonical query string
+// query.
+func sign(secretKey, method, query string) string {
+	stringToSign := method + "&" + percentEncode("/") + "&" + percentEncode(query)
+	mac := hmac.New(sha1.New, []byte(secretKey+"&"))
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// percentEncode encodes s as specified by RFC 3986, which differs from
+// url.QueryEscape in the encoding of spaces, asterisks and tildes.
+func percentEncode(s string) string {
+	s = url.QueryEscape(s)
+	s = strings.Replace(s, "+", "%20", -1)
+	s = strings.Replace(s, "*", "%2A", -1)
+	s = strings.Replace(s, "%7E", "~", -1)
+	return s
+}
@@ -0,0 +1,159 @@
+package alidns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stangah/lego/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointHost(t *testing.T) {
+	for region, host := range map[string]string{
+		"cn-hangzhou":    "alidns.aliyuncs.com",
+		"cn-beijing":     "alidns.aliyuncs.com",
+		"CN-Shanghai":    "alidns.aliyuncs.com",
+		"cn-hongkong":    "alidns.cn-hongkong.aliyuncs.com",
+		"ap-southeast-1": "alidns.ap-southeast-1.aliyuncs.com",
+		"eu-central-1":   "alidns.eu-central-1.aliyuncs.com",
+		"us-west-1":      "alidns.us-west-1.aliyuncs.com",
+	} {
+		got, err := endpointHost(region)
+		assert.NoError(t, err, region)
+		assert.Equal(t, host, got, region)
+	}
+
+	for _, region := range []string{"", "hangzhou", "cn-hangzhou.evil.com", "ap-southeast-1/"} {
+		_, err := endpointHost(region)
+		assert.Error(t, err, region)
+	}
+}
+
+// TestSign checks the signature against the example of the Alibaba Cloud
+// documentation.
+func TestSign(t *testing.T) {
+	params := url.Values{}
+	params.Set("AccessKeyId", "testid")
+	params.Set("Action", "DescribeRegions")
+	params.Set("Format", "XML")
+	params.Set("SignatureMethod", "HMAC-SHA1")
+	params.Set("SignatureNonce", "3ee8c1b8-83d3-44af-a94f-4e0ad82fd6cf")
+	params.Set("SignatureVersion", "1.0")
+	params.Set("Timestamp", "2016-02-23T12:46:24Z")
+	params.Set("Version", "2014-05-26")
+
+	assert.Equal(t, "OLeaidS1JvxuMvnyHOwuJ+uX5qY=", sign("testsecret", "GET", canonicalQuery(params)))
+}
+
+// fakeAlidns serves the DNS records of the domain example.com from memory.
+type fakeAlidns struct {
+	mu      sync.Mutex
+	records []map[string]string
+	deleted []string
+	next    int
+}
+
+func (f *fakeAlidns) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	query := r.URL.Query()
+	signature := query.Get("Signature")
+	query.Del("Signature")
+	if query.Get("AccessKeyId") != "key" || signature != sign("secret", "GET", canonicalQuery(query)) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"Code":"SignatureDoesNotMatch","Message":"Specified signature is not matched with our calculation."}`)
+		return
+	}
+
+	switch query.Get("Action") {
+	case "AddDomainRecord":
+		f.next++
+		id := fmt.Sprint(f.next)
+		f.records = append(f.records, map[string]string{
+			"RecordId": id,
+			"RR":       query.Get("RR"),
+			"Type":     query.Get("Type"),
+			"Value":    query.Get("Value"),
+			"TTL":      query.Get("TTL"),
+		})
+		fmt.Fprintf(w, `{"RecordId":%q}`, id)
+	case "DescribeDomainRecords":
+		records := []map[string]string{{"RecordId": "99", "RR": "_acme-challenge.www.other", "Type": "TXT"}}
+		records = append(records, f.records...)
+		json.NewEncoder(w).Encode(map[string]interface{}{"DomainRecords": map[string]interface{}{"Record": records}})
+	case "DeleteDomainRecord":
+		f.deleted = append(f.deleted, query.Get("RecordId"))
+		fmt.Fprintf(w, `{"RecordId":%q}`, query.Get("RecordId"))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestAlidnsPresentAndCleanUp(t *testing.T) {
+	fake := &fakeAlidns{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	defer func(find func(string, []string) (string, error)) { findZoneByFqdn = find }(findZoneByFqdn)
+	findZoneByFqdn = func(fqdn string, nameservers []string) (string, error) {
+		return "example.com.", nil
+	}
+
+	provider, err := NewDNSProviderCredentials("key", "secret")
+	assert.NoError(t, err)
+	provider.endpoint = server.URL + "/"
+
+	assert.NoError(t, provider.Present("www.example.com", "", "key"))
+	assert.NoError(t, provider.Present("www.example.com", "", "other"))
+	_, value, _ := acme.DNS01Record("www.example.com", "key")
+	if assert.Len(t, fake.records, 2) {
+		assert.Equal(t, map[string]string{
+			"RecordId": "1",
+			"RR":       "_acme-challenge.www",
+			"Type":     "TXT",
+			"Value":    value,
+			"TTL":      "600",
+		}, fake.records[0])
+	}
+
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "key"))
+	assert.Equal(t, []string{"1"}, fake.deleted)
+
+	provider, err = NewDNSProviderCredentials("key", "wrong")
+	assert.NoError(t, err)
+	provider.endpoint = server.URL + "/"
+	assert.EqualError(t, provider.Present("www.example.com", "", "key"),
+		"Alibaba Cloud API call failed: HTTP 400: SignatureDoesNotMatch: Specified signature is not matched with our calculation.")
+}
+
+func TestNewDNSProviderRegion(t *testing.T) {
+	for _, name := range []string{"ALICLOUD_ACCESS_KEY", "ALICLOUD_SECRET_KEY", "ALICLOUD_REGION_ID"} {
+		defer os.Setenv(name, os.Getenv(name))
+	}
+
+	os.Setenv("ALICLOUD_ACCESS_KEY", "key")
+	os.Setenv("ALICLOUD_SECRET_KEY", "")
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "Alibaba Cloud credentials missing")
+
+	os.Setenv("ALICLOUD_SECRET_KEY", "secret")
+	os.Setenv("ALICLOUD_REGION_ID", "")
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, "https://alidns.aliyuncs.com/", provider.endpoint)
+
+	os.Setenv("ALICLOUD_REGION_ID", "ap-southeast-1")
+	provider, err = NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, "https://alidns.ap-southeast-1.aliyuncs.com/", provider.endpoint)
+
+	os.Setenv("ALICLOUD_REGION_ID", "moon")
+	_, err = NewDNSProvider()
+	assert.EqualError(t, err, `Alibaba Cloud: invalid region ID "moon"`)
+}
@@ -5,17 +5,29 @@ package azure
 
 import (
 	"fmt"
+	"net/http"
 	"os"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/arm/dns"
 
+	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/stangah/lego/acme"
 	"strings"
 )
 
+// azureManagementURL is the Azure Resource Manager endpoint the private
+// zones are managed at. It is overridden during tests.
+var azureManagementURL = azure.PublicCloud.ResourceManagerEndpoint
+
+// azureResolver is the address of the DNS service Azure provides inside
+// virtual networks, which resolves the private zones linked to them.
+const azureResolver = "168.63.129.16:53"
+
 // DNSProvider is an implementation of the acme.ChallengeProvider interface
 type DNSProvider struct {
 	clientId       string
@@ -23,18 +35,48 @@ type DNSProvider struct {
 	subscriptionId string
 	tenantId       string
 	resourceGroup  string
+	privateZone    bool
+	nameservers    []string
+
+	// authorize adds the credentials to requests for private zones. When
+	// nil, a service principal token is used. It is set during tests.
+	authorize func(req *http.Request) (*http.Request, error)
+
+	// token is the service principal token of the credentials, created on
+	// first use. It refreshes itself before it expires.
+	tokenMu sync.Mutex
+	token   *azure.ServicePrincipalToken
 }
 
 // NewDNSProvider returns a DNSProvider instance configured for azure.
 // Credentials must be passed in the environment variables: AZURE_CLIENT_ID,
-// AZURE_CLIENT_SECRET, AZURE_SUBSCRIPTION_ID, AZURE_TENANT_ID
+// AZURE_CLIENT_SECRET, AZURE_SUBSCRIPTION_ID, AZURE_TENANT_ID. Setting
+// AZURE_PRIVATE_ZONE to true selects Azure Private DNS zones, whose records
+// are checked using the comma separated host:port addresses in
+// AZURE_PRIVATE_ZONE_NAMESERVERS, see SetNameservers.
 func NewDNSProvider() (*DNSProvider, error) {
 	clientId := os.Getenv("AZURE_CLIENT_ID")
 	clientSecret := os.Getenv("AZURE_CLIENT_SECRET")
 	subscriptionId := os.Getenv("AZURE_SUBSCRIPTION_ID")
 	tenantId := os.Getenv("AZURE_TENANT_ID")
 	resourceGroup := os.Getenv("AZURE_RESOURCE_GROUP")
-	return NewDNSProviderCredentials(clientId, clientSecret, subscriptionId, tenantId, resourceGroup)
+	provider, err := NewDNSProviderCredentials(clientId, clientSecret, subscriptionId, tenantId, resourceGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	if v := os.Getenv("AZURE_PRIVATE_ZONE"); v != "" {
+		private, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid AZURE_PRIVATE_ZONE %q, expected true or false", v)
+		}
+		provider.SetPrivateZone(private)
+	}
+	if v := os.Getenv("AZURE_PRIVATE_ZONE_NAMESERVERS"); v != "" {
+		provider.SetNameservers(strings.Split(v, ","))
+	}
+
+	return provider, nil
 }
 
 // NewDNSProviderCredentials uses the supplied credentials to return a
@@ -53,6 +95,35 @@ func NewDNSProviderCredentials(clientId, clientSecret, subscriptionId, tenantId,
 	}, nil
 }
 
+// SetPrivateZone makes the provider manage the records in Azure Private
+// DNS zones (Microsoft.Network/privateDnsZones) instead of public zones,
+// e.g. for internal CAs validating over private networks.
+func (c *DNSProvider) SetPrivateZone(private bool) {
+	c.privateZone = private
+}
+
+// SetNameservers sets the recursive nameservers, as host:port addresses,
+// which are used to check the propagation of records in private zones.
+// Private zones are invisible to public resolvers, so by default the DNS
+// service Azure provides inside virtual networks, 168.63.129.16, is used,
+// which only answers from a virtual network linked to the zones. When
+// running elsewhere, set resolvers forwarding to it.
+func (c *DNSProvider) SetNameservers(nameservers []string) {
+	c.nameservers = nameservers
+}
+
+// Nameservers returns the recursive nameservers to check the records
+// with, if the provider manages private zones, and nil otherwise.
+func (c *DNSProvider) Nameservers() []string {
+	if !c.privateZone {
+		return nil
+	}
+	if len(c.nameservers) > 0 {
+		return c.nameservers
+	}
+	return []string{azureResolver}
+}
+
 // Timeout returns the timeout and interval to use when checking for DNS
 // propagation. Adjusting here to cope with spikes in propagation times.
 func (c *DNSProvider) Timeout() (timeout, interval time.Duration) {
@@ -62,13 +133,17 @@ func (c *DNSProvider) Timeout() (timeout, interval time.Duration) {
 // Present creates a TXT record to fulfil the dns-01 challenge
 func (c *DNSProvider) Present(domain, token, keyAuth string) error {
 	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	if c.privateZone {
+		return c.presentPrivate(fqdn, value)
+	}
+
 	zone, err := c.getHostedZoneID(fqdn)
 	if err != nil {
 		return err
 	}
 
 	rsc := dns.NewRecordSetsClient(c.subscriptionId)
-	rsc.Authorizer, err = c.newServicePrincipalTokenFromCredentials(azure.PublicCloud.ResourceManagerEndpoint)
+	rsc.Authorizer, err = c.servicePrincipalToken()
 	relative := toRelativeRecord(fqdn, acme.ToFqdn(zone))
 	rec := dns.RecordSet{
 		Name: &relative,
@@ -94,6 +169,9 @@ func toRelativeRecord(domain, zone string) string {
 // CleanUp removes the TXT record matching the specified parameters
 func (c *DNSProvider) CleanUp(domain, token, keyAuth string) error {
 	fqdn, _, _ := acme.DNS01Record(domain, keyAuth)
+	if c.privateZone {
+		return c.cleanUpPrivate(fqdn)
+	}
 
 	zone, err := c.getHostedZoneID(fqdn)
 	if err != nil {
@@ -102,7 +180,7 @@ func (c *DNSProvider) CleanUp(domain, token, keyAuth string) error {
 
 	relative := toRelativeRecord(fqdn, acme.ToFqdn(zone))
 	rsc := dns.NewRecordSetsClient(c.subscriptionId)
-	rsc.Authorizer, err = c.newServicePrincipalTokenFromCredentials(azure.PublicCloud.ResourceManagerEndpoint)
+	rsc.Authorizer, err = c.servicePrincipalToken()
 	_, err = rsc.Delete(c.resourceGroup, zone, relative, dns.TXT, "")
 	if err != nil {
 		return err
@@ -120,7 +198,7 @@ func (c *DNSProvider) getHostedZoneID(fqdn string) (string, error) {
 
 	// Now we want to to Azure and get the zone.
 	dc := dns.NewZonesClient(c.subscriptionId)
-	dc.Authorizer, err = c.newServicePrincipalTokenFromCredentials(azure.PublicCloud.ResourceManagerEndpoint)
+	dc.Authorizer, err = c.servicePrincipalToken()
 	zone, err := dc.Get(c.resourceGroup, acme.UnFqdn(authZone))
 
 	if err != nil {
@@ -140,3 +218,32 @@ func (c *DNSProvider) newServicePrincipalTokenFromCredentials(scope string) (*az
 	}
 	return azure.NewServicePrincipalToken(*oauthConfig, c.clientId, c.clientSecret, scope)
 }
+
+// servicePrincipalToken returns the service principal token of the
+// provider, creating it on first use.
+func (c *DNSProvider) servicePrincipalToken() (*azure.ServicePrincipalToken, error) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+
+	if c.token == nil {
+		spt, err := c.newServicePrincipalTokenFromCredentials(azure.PublicCloud.ResourceManagerEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		c.token = spt
+	}
+	return c.token, nil
+}
+
+// authorizeServicePrincipal adds the service principal token of the
+// provider to req, refreshing it if it expired.
+func (c *DNSProvider) authorizeServicePrincipal(req *http.Request) (*http.Request, error) {
+	spt, err := c.servicePrincipalToken()
+	if err != nil {
+		return nil, err
+	}
+
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	return autorest.Prepare(req, spt.WithAuthorization())
+}
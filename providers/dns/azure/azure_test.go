@@ -1,10 +1,15 @@
 package azure
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/stangah/lego/acme"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -62,6 +67,91 @@ func TestNewDNSProviderMissingCredErr(t *testing.T) {
 	restoreAzureEnv()
 }
 
+func TestAzurePrivateZone(t *testing.T) {
+	const zones = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/privateDnsZones/"
+	var requests []string
+	var record privateRecordSet
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+strings.TrimPrefix(r.URL.Path, zones))
+		assert.Equal(t, "2018-09-01", r.URL.Query().Get("api-version"))
+		assert.Equal(t, "Bearer test", r.Header.Get("Authorization"))
+		if !strings.HasPrefix(r.URL.Path, zones) {
+			t.Errorf("Expected a private zone request but got %s", r.URL.Path)
+		}
+
+		switch strings.TrimPrefix(r.URL.Path, zones) {
+		case "example.com":
+			w.Write([]byte(`{"name":"example.com"}`))
+		case "example.com/TXT/_acme-challenge.www":
+			if r.Method == "PUT" {
+				json.NewDecoder(r.Body).Decode(&record)
+			}
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	defer func(url string) { azureManagementURL = url }(azureManagementURL)
+	azureManagementURL = ts.URL + "/"
+
+	provider, err := NewDNSProviderCredentials("id", "secret", "sub", "tenant", "rg")
+	assert.NoError(t, err)
+	provider.SetPrivateZone(true)
+	provider.authorize = func(req *http.Request) (*http.Request, error) {
+		req.Header.Set("Authorization", "Bearer test")
+		return req, nil
+	}
+
+	assert.NoError(t, provider.Present("www.example.com", "", "123d=="))
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "123d=="))
+
+	assert.Equal(t, []string{
+		"GET www.example.com",
+		"GET example.com",
+		"PUT example.com/TXT/_acme-challenge.www",
+		"GET www.example.com",
+		"GET example.com",
+		"DELETE example.com/TXT/_acme-challenge.www",
+	}, requests)
+	_, value, _ := acme.DNS01Record("www.example.com", "123d==")
+	assert.Equal(t, privateRecordSet{Properties: privateRecordSetProperties{
+		TTL:        60,
+		TxtRecords: []privateTxtRecord{{Value: []string{value}}},
+	}}, record)
+}
+
+func TestAzurePrivateZoneNameservers(t *testing.T) {
+	for _, name := range []string{"AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET", "AZURE_SUBSCRIPTION_ID", "AZURE_TENANT_ID",
+		"AZURE_RESOURCE_GROUP", "AZURE_PRIVATE_ZONE", "AZURE_PRIVATE_ZONE_NAMESERVERS"} {
+		defer os.Setenv(name, os.Getenv(name))
+	}
+	os.Setenv("AZURE_CLIENT_ID", "id")
+	os.Setenv("AZURE_CLIENT_SECRET", "secret")
+	os.Setenv("AZURE_SUBSCRIPTION_ID", "sub")
+	os.Setenv("AZURE_TENANT_ID", "tenant")
+	os.Setenv("AZURE_RESOURCE_GROUP", "rg")
+	os.Setenv("AZURE_PRIVATE_ZONE", "")
+	os.Setenv("AZURE_PRIVATE_ZONE_NAMESERVERS", "")
+
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Nil(t, provider.Nameservers(), "Expected public zones to be checked with the default resolvers")
+
+	os.Setenv("AZURE_PRIVATE_ZONE", "true")
+	provider, err = NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"168.63.129.16:53"}, provider.Nameservers())
+
+	os.Setenv("AZURE_PRIVATE_ZONE_NAMESERVERS", "10.0.0.4:53,10.0.0.5:53")
+	provider, err = NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.4:53", "10.0.0.5:53"}, provider.Nameservers())
+
+	var _ acme.ChallengeProviderNameservers = provider
+}
+
 func TestLiveAzurePresent(t *testing.T) {
 	if !azureLiveTest {
 		t.Skip("skipping live test")
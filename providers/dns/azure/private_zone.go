@@ -0,0 +1,134 @@
+package azure
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/stangah/lego/acme"
+)
+
+// Azure Private DNS reference: https://docs.microsoft.com/en-us/rest/api/dns/privatedns/
+
+// privateDNSAPIVersion is the version of the Azure Private DNS API.
+const privateDNSAPIVersion = "2018-09-01"
+
+// errPrivateZoneNotFound is returned by privateZoneRequest if the API
+// answers 404.
+var errPrivateZoneNotFound = errors.New("not found")
+
+type privateRecordSet struct {
+	Properties privateRecordSetProperties `json:"properties"`
+}
+
+type privateRecordSetProperties struct {
+	TTL        int                `json:"ttl"`
+	TxtRecords []privateTxtRecord `json:"txtRecords"`
+}
+
+type privateTxtRecord struct {
+	Value []string `json:"value"`
+}
+
+// presentPrivate creates the TXT record fqdn with value in the private
+// zone containing it.
+func (c *DNSProvider) presentPrivate(fqdn, value string) error {
+	zone, err := c.findPrivateZone(fqdn)
+	if err != nil {
+		return err
+	}
+
+	relative := toRelativeRecord(fqdn, acme.ToFqdn(zone))
+	rec := privateRecordSet{
+		Properties: privateRecordSetProperties{
+			TTL:        60,
+			TxtRecords: []privateTxtRecord{{Value: []string{value}}},
+		},
+	}
+	return c.privateZoneRequest("PUT", zone+"/TXT/"+relative, rec)
+}
+
+// cleanUpPrivate removes the TXT record fqdn from the private zone
+// containing it.
+func (c *DNSProvider) cleanUpPrivate(fqdn string) error {
+	zone, err := c.findPrivateZone(fqdn)
+	if err != nil {
+		return err
+	}
+
+	relative := toRelativeRecord(fqdn, acme.ToFqdn(zone))
+	return c.privateZoneRequest("DELETE", zone+"/TXT/"+relative, nil)
+}
+
+// findPrivateZone returns the name of the most specific private zone of
+// the resource group containing fqdn. Private zones are not visible in
+// the public DNS, so the parent domains of fqdn are looked up one by one.
+func (c *DNSProvider) findPrivateZone(fqdn string) (string, error) {
+	name := acme.UnFqdn(fqdn)
+	for {
+		i := strings.Index(name, ".")
+		if i < 0 {
+			return "", fmt.Errorf("No Azure private DNS zone found for %s", fqdn)
+		}
+		name = name[i+1:]
+
+		err := c.privateZoneRequest("GET", name, nil)
+		if err == nil {
+			return name, nil
+		}
+		if err != errPrivateZoneNotFound {
+			return "", err
+		}
+	}
+}
+
+// privateZoneRequest sends a request with the JSON encoded body, if any, to
+// path below the private zones of the resource group.
+func (c *DNSProvider) privateZoneRequest(method, path string, body interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	url := fmt.Sprintf("%s/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/privateDnsZones/%s?api-version=%s",
+		strings.TrimSuffix(azureManagementURL, "/"), c.subscriptionId, c.resourceGroup, path, privateDNSAPIVersion)
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", acme.UserAgentString())
+
+	authorize := c.authorize
+	if authorize == nil {
+		authorize = c.authorizeServicePrincipal
+	}
+	req, err = authorize(req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := acme.DoWithRetry(req, nil)
+	if err != nil {
+		return fmt.Errorf("Azure private DNS API call failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errPrivateZoneNotFound
+	}
+	if resp.StatusCode >= 300 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Azure private DNS API call failed: %s %s returned %s: %s", method, path, resp.Status, b)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+// Package bunny implements a DNS provider for solving the DNS-01 challenge
+// using Bunny DNS.
+package bunny
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/stangah/lego/acme"
+)
+
+// Bunny API reference: https://docs.bunny.net/reference/dnszonepublic_index
+
+// bunnyBaseURL is the base URL of the Bunny API.
+var bunnyBaseURL = "https://api.bunny.net"
+
+// defaultTTL is the TTL of the TXT records created by the provider.
+const defaultTTL = 120
+
+// txtRecordType is the numeric record type of TXT records in the Bunny API.
+const txtRecordType = 3
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses Bunny's API to manage TXT records for a domain.
+type DNSProvider struct {
+	apiKey string
+	ttl    int
+
+	// ids maps the fqdn and value of each challenge to the numeric ID of its
+	// record in the Bunny zone. CleanUp searches the zone for records it
+	// does not know.
+	ids   map[string]int
+	idsMu sync.Mutex
+}
+
+// dnsZone is a DNS zone of the Bunny API.
+type dnsZone struct {
+	ID      int         `json:"Id"`
+	Domain  string      `json:"Domain"`
+	Records []dnsRecord `json:"Records,omitempty"`
+}
+
+// dnsRecord is a DNS record of the Bunny API.
+type dnsRecord struct {
+	ID    int    `json:"Id,omitempty"`
+	Type  int    `json:"Type"`
+	TTL   int    `json:"Ttl,omitempty"`
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Bunny. The
+// API key must be passed in the environment variable BUNNY_API_KEY.
+func NewDNSProvider() (*DNSProvider, error) {
+	return NewDNSProviderCredentials(os.Getenv("BUNNY_API_KEY"))
+}
+
+// NewDNSProviderCredentials uses the supplied API key to return a
+// DNSProvider instance configured for Bunny.
+func NewDNSProviderCredentials(apiKey string) (*DNSProvider, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("Bunny credentials missing")
+	}
+	return &DNSProvider{apiKey: apiKey, ttl: defaultTTL, ids: make(map[string]int)}, nil
+}
+
+// SetTTL sets the TTL in seconds of the TXT records created by the provider.
+func (d *DNSProvider) SetTTL(ttl int) error {
+	if ttl <= 0 {
+		return fmt.Errorf("Bunny TTL must be positive, got %d", ttl)
+	}
+	d.ttl = ttl
+	return nil
+}
+
+// Present creates a TXT record for the challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	zone, name, err := d.recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	record := dnsRecord{Type: txtRecordType, TTL: d.ttl, Name: name, Value: value}
+	var created dnsRecord
+	if err := d.doRequest("PUT", fmt.Sprintf("/dnszone/%d/records", zone.ID), record, &created); err != nil {
+		return err
+	}
+
+	d.idsMu.Lock()
+	d.ids[fqdn+" "+value] = created.ID
+	d.idsMu.Unlock()
+
+	return nil
+}
+
+// CleanUp deletes the TXT record created by Present. Records created by
+// another run are looked up by name and value.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	zone, name, err := d.recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	d.idsMu.Lock()
+	id, ok := d.ids[fqdn+" "+value]
+	d.idsMu.Unlock()
+
+	ids := []int{id}
+	if !ok {
+		ids, err = d.findTxtRecords(zone.ID, name, value)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return fmt.Errorf("Bunny: no TXT record found for '%s'", fqdn)
+		}
+	}
+
+	for _, id := range ids {
+		if err := d.doRequest("DELETE", fmt.Sprintf("/dnszone/%d/records/%d", zone.ID, id), nil, nil); err != nil {
+			return err
+		}
+	}
+
+	d.idsMu.Lock()
+	delete(d.ids, fqdn+" "+value)
+	d.idsMu.Unlock()
+
+	return nil
+}
+
+// findTxtRecords returns the IDs of the TXT records with the given name and
+// value in the zone.
+func (d *DNSProvider) findTxtRecords(zoneID int, name, value string) ([]int, error) {
+	var zone dnsZone
+	if err := d.doRequest("GET", fmt.Sprintf("/dnszone/%d", zoneID), nil, &zone); err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for _, record := range zone.Records {
+		if record.Type == txtRecordType && strings.EqualFold(record.Name, name) && record.Value == value {
+			ids = append(ids, record.ID)
+		}
+	}
+	return ids, nil
+}
+
+// recordLocation returns the zone containing fqdn and the name of fqdn
+// relative to it. The most specific zone of the account is used, following
+// the pagination of the zone listing.
+func (d *DNSProvider) recordLocation(fqdn string) (*dnsZone, string, error) {
+	name := strings.ToLower(acme.UnFqdn(fqdn))
+	var match *dnsZone
+	for page := 1; ; page++ {
+		var result struct {
+			Items        []dnsZone `json:"Items"`
+			HasMoreItems bool      `json:"HasMoreItems"`
+		}
+		if err := d.doRequest("GET", fmt.Sprintf("/dnszone?page=%d&perPage=1000", page), nil, &result); err != nil {
+			return nil, "", err
+		}
+
+		for i, zone := range result.Items {
+			zoneName := strings.ToLower(acme.UnFqdn(zone.Domain))
+			if zoneName != name && !strings.HasSuffix(name, "."+zoneName) {
+				continue
+			}
+			if match == nil || len(zoneName) > len(match.Domain) {
+				match = &result.Items[i]
+				match.Domain = zoneName
+			}
+		}
+
+		if !result.HasMoreItems {
+			break
+		}
+	}
+
+	if match == nil {
+		return nil, "", fmt.Errorf("Bunny: no zone found for %s", fqdn)
+	}
+	if name == match.Domain {
+		return match, "", nil
+	}
+	return match, strings.TrimSuffix(name, "."+match.Domain), nil
+}
+
+func (d *DNSProvider) doRequest(method, uri string, body, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, bunnyBaseURL+uri, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("AccessKey", d.apiKey)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", acme.UserAgentString())
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := acme.DoWithRetry(req, nil)
+	if err != nil {
+		return fmt.Errorf("Bunny API call failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var errInfo struct {
+			Message string `json:"Message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&errInfo)
+		return fmt.Errorf("Bunny API call failed: HTTP %d: %s", resp.StatusCode, errInfo.Message)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
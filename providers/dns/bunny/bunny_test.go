@@ -0,0 +1,291 @@
+package bunny
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stangah/lego/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	bunnyLiveTest bool
+	bunnyAPIKey   string
+	bunnyDomain   string
+)
+
+func init() {
+	bunnyAPIKey = os.Getenv("BUNNY_API_KEY")
+	bunnyDomain = os.Getenv("BUNNY_DOMAIN")
+	if len(bunnyAPIKey) > 0 && len(bunnyDomain) > 0 {
+		bunnyLiveTest = true
+	}
+}
+
+func restoreBunnyEnv() {
+	os.Setenv("BUNNY_API_KEY", bunnyAPIKey)
+}
+
+// fakeBunny serves the DNS zones example.com (ID 1) and sub.example.com
+// (ID 2) from memory, listing one zone per page.
+type fakeBunny struct {
+	mu      sync.Mutex
+	zones   []dnsZone
+	deleted []string
+	next    int
+}
+
+func (f *fakeBunny) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("AccessKey") != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"Message":"Authorization has been denied for this request."}`)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if r.Method == "GET" && len(parts) == 1 {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Items":        []dnsZone{{ID: f.zones[page-1].ID, Domain: f.zones[page-1].Domain}},
+			"HasMoreItems": page < len(f.zones),
+		})
+		return
+	}
+
+	id, _ := strconv.Atoi(parts[1])
+	if id < 1 || id > len(f.zones) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"Message":"The requested DNS zone was not found"}`)
+		return
+	}
+	zone := &f.zones[id-1]
+	switch {
+	case r.Method == "GET" && len(parts) == 2:
+		json.NewEncoder(w).Encode(zone)
+	case r.Method == "PUT" && len(parts) == 3:
+		var record dnsRecord
+		json.NewDecoder(r.Body).Decode(&record)
+		f.next++
+		record.ID = f.next
+		zone.Records = append(zone.Records, record)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(record)
+	case r.Method == "DELETE" && len(parts) == 4:
+		recordID, _ := strconv.Atoi(parts[3])
+		for i, record := range zone.Records {
+			if record.ID == recordID {
+				zone.Records = append(zone.Records[:i], zone.Records[i+1:]...)
+				f.deleted = append(f.deleted, r.URL.Path)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"Message":"The requested DNS record was not found"}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// mockBunny serves the Bunny API with handler until the returned function
+// is called.
+func mockBunny(handler http.Handler) func() {
+	server := httptest.NewServer(handler)
+	baseURL := bunnyBaseURL
+	bunnyBaseURL = server.URL
+	return func() {
+		bunnyBaseURL = baseURL
+		server.Close()
+	}
+}
+
+func newFakeBunny() (*fakeBunny, func()) {
+	fake := &fakeBunny{zones: []dnsZone{{ID: 1, Domain: "example.com"}, {ID: 2, Domain: "sub.example.com"}}}
+	return fake, mockBunny(fake)
+}
+
+func TestNewDNSProviderValid(t *testing.T) {
+	os.Setenv("BUNNY_API_KEY", "")
+	defer restoreBunnyEnv()
+	_, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	os.Setenv("BUNNY_API_KEY", "secret")
+	defer restoreBunnyEnv()
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", provider.apiKey)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	os.Setenv("BUNNY_API_KEY", "")
+	defer restoreBunnyEnv()
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "Bunny credentials missing")
+}
+
+func TestBunnyPresent(t *testing.T) {
+	var requests []string
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockBunny(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.String())
+		assert.Equal(t, "secret", r.Header.Get("AccessKey"))
+
+		switch r.Method {
+		case "GET":
+			fmt.Fprint(w, `{"Items":[{"Id":12,"Domain":"example.com"}],"HasMoreItems":false}`)
+		case "PUT":
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			reqBody, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, `{"Type":3,"Ttl":120,"Name":"_acme-challenge.www","Value":"`+value+`"}`, string(reqBody))
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"Id":345,"Type":3,"Ttl":120,"Name":"_acme-challenge.www","Value":%q}`, value)
+		}
+	}))()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"GET /dnszone?page=1&perPage=1000", "PUT /dnszone/12/records"}, requests)
+	assert.Equal(t, map[string]int{"_acme-challenge.www.example.com. " + value: 345}, provider.ids)
+}
+
+func TestBunnyCleanUp(t *testing.T) {
+	var requests []string
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockBunny(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.String())
+		assert.Equal(t, "secret", r.Header.Get("AccessKey"))
+
+		switch r.Method {
+		case "GET":
+			fmt.Fprint(w, `{"Items":[{"Id":12,"Domain":"example.com"}],"HasMoreItems":false}`)
+		case "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+	provider.ids["_acme-challenge.www.example.com. "+value] = 345
+
+	// The record is deleted by the ID remembered by Present.
+	err = provider.CleanUp("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"GET /dnszone?page=1&perPage=1000", "DELETE /dnszone/12/records/345"}, requests)
+	assert.Empty(t, provider.ids)
+}
+
+func TestBunnyZonePagination(t *testing.T) {
+	fake, done := newFakeBunny()
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+	assert.NoError(t, provider.SetTTL(300))
+
+	// sub.example.com is only listed on the second page, and is more
+	// specific than example.com on the first one.
+	assert.NoError(t, provider.Present("www.Sub.example.com", "", "key"))
+	_, value, _ := acme.DNS01Record("www.sub.example.com", "key")
+	assert.Equal(t, []dnsRecord{{ID: 1, Type: 3, TTL: 300, Name: "_acme-challenge.www", Value: value}}, fake.zones[1].Records)
+	assert.Empty(t, fake.zones[0].Records)
+
+	assert.NoError(t, provider.CleanUp("www.sub.example.com", "", "key"))
+	assert.Equal(t, []string{"/dnszone/2/records/1"}, fake.deleted)
+	assert.Empty(t, fake.zones[1].Records)
+}
+
+func TestBunnyApex(t *testing.T) {
+	fake, done := newFakeBunny()
+	defer done()
+
+	acme.DNS01RecordName = func(domain, fqdn string) string { return "example.com." }
+	defer func() { acme.DNS01RecordName = nil }()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+
+	// Records at the apex of the zone have an empty name.
+	assert.NoError(t, provider.Present("example.com", "", "key"))
+	if assert.Len(t, fake.zones[0].Records, 1) {
+		assert.Equal(t, "", fake.zones[0].Records[0].Name)
+	}
+}
+
+func TestBunnyCleanUpLooksUpRecords(t *testing.T) {
+	fake, done := newFakeBunny()
+	defer done()
+
+	first, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+	assert.NoError(t, first.Present("example.com", "", "key"))
+	assert.NoError(t, first.Present("example.com", "", "other"))
+
+	// A provider which did not create the record looks it up by name and
+	// only deletes the one with its value.
+	second, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+	assert.NoError(t, second.CleanUp("example.com", "", "key"))
+	assert.Equal(t, []string{"/dnszone/1/records/1"}, fake.deleted)
+	assert.EqualError(t, second.CleanUp("example.com", "", "key"), "Bunny: no TXT record found for '_acme-challenge.example.com.'")
+}
+
+func TestBunnyPresentFailed(t *testing.T) {
+	_, done := newFakeBunny()
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("wrong")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.com", "", "key"), "Bunny API call failed: HTTP 401: Authorization has been denied for this request.")
+
+	provider, err = NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.org", "", "key"), "Bunny: no zone found for _acme-challenge.example.org.")
+}
+
+func TestLiveBunnyPresent(t *testing.T) {
+	if !bunnyLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	provider, err := NewDNSProviderCredentials(bunnyAPIKey)
+	assert.NoError(t, err)
+
+	err = provider.Present(bunnyDomain, "", "123d==")
+	assert.NoError(t, err)
+}
+
+func TestLiveBunnyCleanUp(t *testing.T) {
+	if !bunnyLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	time.Sleep(time.Second * 1)
+
+	// A new provider looks the record up by name and value.
+	provider, err := NewDNSProviderCredentials(bunnyAPIKey)
+	assert.NoError(t, err)
+
+	err = provider.CleanUp(bunnyDomain, "", "123d==")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,175 @@
+// Package civo implements a DNS provider for solving the DNS-01 challenge
+// using Civo DNS.
+package civo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/stangah/lego/acme"
+)
+
+// Civo API reference: https://www.civo.com/api/dns
+
+// civoBaseURL is the base URL of the Civo API.
+var civoBaseURL = "https://api.civo.com/v2"
+
+// defaultTTL is the minimum TTL accepted by Civo.
+const defaultTTL = 600
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses Civo's API to manage TXT records for a domain.
+type DNSProvider struct {
+	token string
+	ttl   int
+}
+
+// dnsRecord is a DNS record of the Civo API.
+type dnsRecord struct {
+	ID    string `json:"id,omitempty"`
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	TTL   int    `json:"ttl,omitempty"`
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Civo. The
+// API key must be passed in the environment variable CIVO_TOKEN.
+func NewDNSProvider() (*DNSProvider, error) {
+	return NewDNSProviderCredentials(os.Getenv("CIVO_TOKEN"))
+}
+
+// NewDNSProviderCredentials uses the supplied API key to return a
+// DNSProvider instance configured for Civo.
+func NewDNSProviderCredentials(token string) (*DNSProvider, error) {
+	if token == "" {
+		return nil, fmt.Errorf("Civo credentials missing")
+	}
+	return &DNSProvider{token: token, ttl: defaultTTL}, nil
+}
+
+// SetTTL sets the TTL in seconds of the TXT records created by the provider.
+// It must be at least Civo's minimum of 600 seconds.
+func (d *DNSProvider) SetTTL(ttl int) error {
+	if ttl < defaultTTL {
+		return fmt.Errorf("Civo TTL must be at least %d, got %d", defaultTTL, ttl)
+	}
+	d.ttl = ttl
+	return nil
+}
+
+// Present creates a TXT record for the challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	domainID, name, err := d.recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	record := dnsRecord{Type: "TXT", Name: name, Value: value, TTL: d.ttl}
+	return d.doRequest("POST", fmt.Sprintf("/dns/%s/records", domainID), record, nil)
+}
+
+// CleanUp deletes the TXT records with the challenge value, leaving other
+// values of the challenge name alone.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	domainID, name, err := d.recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	var records []dnsRecord
+	if err := d.doRequest("GET", fmt.Sprintf("/dns/%s/records", domainID), nil, &records); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if !strings.EqualFold(record.Type, "TXT") || record.Name != name || record.Value != value {
+			continue
+		}
+		if err := d.doRequest("DELETE", fmt.Sprintf("/dns/%s/records/%s", domainID, record.ID), nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordLocation returns the ID of the Civo domain containing fqdn and the
+// name of fqdn relative to it. The most specific domain of the account is
+// used, and the apex is named "@".
+func (d *DNSProvider) recordLocation(fqdn string) (string, string, error) {
+	var domains []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := d.doRequest("GET", "/dns", nil, &domains); err != nil {
+		return "", "", err
+	}
+
+	name := strings.ToLower(acme.UnFqdn(fqdn))
+	var id, zone string
+	for _, domain := range domains {
+		zoneName := strings.ToLower(acme.UnFqdn(domain.Name))
+		if zoneName != name && !strings.HasSuffix(name, "."+zoneName) {
+			continue
+		}
+		if len(zoneName) > len(zone) {
+			id, zone = domain.ID, zoneName
+		}
+	}
+	if zone == "" {
+		return "", "", fmt.Errorf("Civo: no domain found for %s", fqdn)
+	}
+
+	if name == zone {
+		return id, "@", nil
+	}
+	return id, strings.TrimSuffix(name, "."+zone), nil
+}
+
+func (d *DNSProvider) doRequest(method, uri string, body, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, civoBaseURL+uri, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+d.token)
+	req.Header.Set("User-Agent", acme.UserAgentString())
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := acme.DoWithRetry(req, nil)
+	if err != nil {
+		return fmt.Errorf("Civo API call failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var errInfo struct {
+			Code   string `json:"code"`
+			Reason string `json:"reason"`
+		}
+		json.NewDecoder(resp.Body).Decode(&errInfo)
+		return fmt.Errorf("Civo API call failed: HTTP %d: %s %s", resp.StatusCode, errInfo.Code, errInfo.Reason)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,269 @@
+package civo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stangah/lego/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	civoLiveTest bool
+	civoToken    string
+	civoDomain   string
+)
+
+func init() {
+	civoToken = os.Getenv("CIVO_TOKEN")
+	civoDomain = os.Getenv("CIVO_DOMAIN")
+	if len(civoToken) > 0 && len(civoDomain) > 0 {
+		civoLiveTest = true
+	}
+}
+
+func restoreCivoEnv() {
+	os.Setenv("CIVO_TOKEN", civoToken)
+}
+
+// fakeCivo serves the DNS records of the domains example.com (ID d1) and
+// sub.example.com (ID d2) from memory.
+type fakeCivo struct {
+	mu      sync.Mutex
+	records map[string][]dnsRecord
+	deleted []string
+	next    int
+}
+
+func (f *fakeCivo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"code":"authentication_invalid_key","reason":"The API key provided is invalid"}`)
+		return
+	}
+
+	if r.URL.Path == "/dns" {
+		fmt.Fprint(w, `[{"id":"d1","name":"example.com"},{"id":"d2","name":"sub.example.com"}]`)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/dns/"), "/")
+	domainID := parts[0]
+	switch {
+	case r.Method == "POST" && len(parts) == 2:
+		var record dnsRecord
+		json.NewDecoder(r.Body).Decode(&record)
+		f.next++
+		record.ID = fmt.Sprintf("r%d", f.next)
+		f.records[domainID] = append(f.records[domainID], record)
+		json.NewEncoder(w).Encode(record)
+	case r.Method == "GET" && len(parts) == 2:
+		records := f.records[domainID]
+		if records == nil {
+			records = []dnsRecord{}
+		}
+		json.NewEncoder(w).Encode(records)
+	case r.Method == "DELETE" && len(parts) == 3:
+		f.deleted = append(f.deleted, r.URL.Path)
+		fmt.Fprint(w, `{"result":"success"}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// mockCivo serves the Civo API with handler until the returned function is
+// called.
+func mockCivo(handler http.Handler) func() {
+	server := httptest.NewServer(handler)
+	baseURL := civoBaseURL
+	civoBaseURL = server.URL
+	return func() {
+		civoBaseURL = baseURL
+		server.Close()
+	}
+}
+
+func newFakeCivo() (*fakeCivo, func()) {
+	fake := &fakeCivo{records: map[string][]dnsRecord{}}
+	return fake, mockCivo(fake)
+}
+
+func TestNewDNSProviderValid(t *testing.T) {
+	os.Setenv("CIVO_TOKEN", "")
+	defer restoreCivoEnv()
+	_, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	os.Setenv("CIVO_TOKEN", "secret")
+	defer restoreCivoEnv()
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", provider.token)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	os.Setenv("CIVO_TOKEN", "")
+	defer restoreCivoEnv()
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "Civo credentials missing")
+}
+
+func TestCivoPresent(t *testing.T) {
+	var requests []string
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockCivo(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		assert.Equal(t, "bearer secret", r.Header.Get("Authorization"))
+
+		switch r.Method {
+		case "GET":
+			fmt.Fprint(w, `[{"id":"d1","name":"example.com"}]`)
+		case "POST":
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			reqBody, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, `{"type":"TXT","name":"_acme-challenge.www","value":"`+value+`","ttl":600}`, string(reqBody))
+			fmt.Fprint(w, `{"id":"r1"}`)
+		}
+	}))()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"GET /dns", "POST /dns/d1/records"}, requests)
+}
+
+func TestCivoCleanUp(t *testing.T) {
+	var requests []string
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockCivo(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		assert.Equal(t, "bearer secret", r.Header.Get("Authorization"))
+
+		switch {
+		case r.URL.Path == "/dns":
+			fmt.Fprint(w, `[{"id":"d1","name":"example.com"}]`)
+		case r.Method == "GET":
+			fmt.Fprintf(w, `[{"id":"r1","type":"txt","name":"_acme-challenge.www","value":"other"},{"id":"r2","type":"txt","name":"_acme-challenge.www","value":%q},{"id":"r3","type":"txt","name":"_acme-challenge","value":%q}]`, value, value)
+		case r.Method == "DELETE":
+			fmt.Fprint(w, `{"result":"success"}`)
+		}
+	}))()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+
+	// Only the record with our name and value is deleted.
+	err = provider.CleanUp("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"GET /dns", "GET /dns/d1/records", "DELETE /dns/d1/records/r2"}, requests)
+}
+
+func TestCivoMostSpecificDomain(t *testing.T) {
+	fake, done := newFakeCivo()
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+
+	assert.NoError(t, provider.Present("www.sub.example.com", "", "key"))
+	assert.NoError(t, provider.Present("www.sub.example.com", "", "other"))
+	assert.NoError(t, provider.Present("example.com", "", "key"))
+
+	_, value, _ := acme.DNS01Record("www.sub.example.com", "key")
+	if assert.Len(t, fake.records["d2"], 2) {
+		assert.Equal(t, dnsRecord{ID: "r1", Type: "TXT", Name: "_acme-challenge.www", Value: value, TTL: 600}, fake.records["d2"][0])
+	}
+	if assert.Len(t, fake.records["d1"], 1) {
+		assert.Equal(t, "_acme-challenge", fake.records["d1"][0].Name)
+	}
+
+	assert.NoError(t, provider.CleanUp("www.sub.example.com", "", "key"))
+	assert.Equal(t, []string{"/dns/d2/records/r1"}, fake.deleted)
+}
+
+func TestCivoApex(t *testing.T) {
+	fake, done := newFakeCivo()
+	defer done()
+
+	acme.DNS01RecordName = func(domain, fqdn string) string { return "example.com." }
+	defer func() { acme.DNS01RecordName = nil }()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+	assert.NoError(t, provider.Present("example.com", "", "key"))
+	if assert.Len(t, fake.records["d1"], 1) {
+		assert.Equal(t, "@", fake.records["d1"][0].Name)
+	}
+}
+
+func TestCivoSetTTL(t *testing.T) {
+	fake, done := newFakeCivo()
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.SetTTL(599), "Civo TTL must be at least 600, got 599")
+	assert.NoError(t, provider.SetTTL(3600))
+
+	assert.NoError(t, provider.Present("www.example.com", "", "key"))
+	if assert.Len(t, fake.records["d1"], 1) {
+		assert.Equal(t, 3600, fake.records["d1"][0].TTL)
+	}
+}
+
+func TestCivoPresentFailed(t *testing.T) {
+	_, done := newFakeCivo()
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("wrong")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.com", "", "key"), "Civo API call failed: HTTP 401: authentication_invalid_key The API key provided is invalid")
+
+	provider, err = NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.org", "", "key"), "Civo: no domain found for _acme-challenge.example.org.")
+}
+
+func TestLiveCivoPresent(t *testing.T) {
+	if !civoLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	provider, err := NewDNSProviderCredentials(civoToken)
+	assert.NoError(t, err)
+
+	err = provider.Present(civoDomain, "", "123d==")
+	assert.NoError(t, err)
+}
+
+func TestLiveCivoCleanUp(t *testing.T) {
+	if !civoLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	time.Sleep(time.Second * 1)
+
+	provider, err := NewDNSProviderCredentials(civoToken)
+	assert.NoError(t, err)
+
+	err = provider.CleanUp(civoDomain, "", "123d==")
+	assert.NoError(t, err)
+}
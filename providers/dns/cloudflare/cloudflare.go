@@ -8,7 +8,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/stangah/lego/acme"
@@ -16,21 +20,59 @@ import (
 
 // CloudFlareAPIURL represents the API endpoint to call.
 // TODO: Unexport?
-const CloudFlareAPIURL = "https://api.cloudflare.com/client/v4"
+var CloudFlareAPIURL = "https://api.cloudflare.com/client/v4"
 
 // DNSProvider is an implementation of the acme.ChallengeProvider interface
 type DNSProvider struct {
 	authEmail string
 	authKey   string
+	zoneID    string
+	ttl       int
+
+	// records holds the records created by Present, keyed by their FQDN and
+	// value, so that CleanUp deletes exactly those.
+	records   map[string]cloudFlareRecord
+	recordsMu sync.Mutex
 }
 
 // NewDNSProvider returns a DNSProvider instance configured for cloudflare.
 // Credentials must be passed in the environment variables: CLOUDFLARE_EMAIL
-// and CLOUDFLARE_API_KEY.
+// and CLOUDFLARE_API_KEY, or in the credentials file named by
+// LEGO_CREDENTIALS_FILE. The credentials are validated against the API
+// unless CLOUDFLARE_SKIP_VALIDATION is set to true. If CLOUDFLARE_ZONE_ID
+// is set, all records are created in that zone and the zones are never
+// listed, so neither are the credentials validated. The TTL of the TXT
+// records in seconds can be set in CLOUDFLARE_TTL, a TTL of 0 uses the
+// CloudFlare default.
 func NewDNSProvider() (*DNSProvider, error) {
-	email := os.Getenv("CLOUDFLARE_EMAIL")
-	key := os.Getenv("CLOUDFLARE_API_KEY")
-	return NewDNSProviderCredentials(email, key)
+	values, err := acme.LoadCredentials("CLOUDFLARE_EMAIL", "CLOUDFLARE_API_KEY", "CLOUDFLARE_ZONE_ID")
+	if err != nil {
+		return nil, err
+	}
+	provider, err := NewDNSProviderCredentials(values["CLOUDFLARE_EMAIL"], values["CLOUDFLARE_API_KEY"])
+	if err != nil {
+		return nil, err
+	}
+	provider.SetZoneID(values["CLOUDFLARE_ZONE_ID"])
+
+	if v := os.Getenv("CLOUDFLARE_TTL"); v != "" {
+		ttl, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("CloudFlare TTL %q is not a number", v)
+		}
+		if err := provider.SetTTL(ttl); err != nil {
+			return nil, err
+		}
+	}
+
+	skip, _ := strconv.ParseBool(os.Getenv("CLOUDFLARE_SKIP_VALIDATION"))
+	if !skip && provider.zoneID == "" {
+		if err := provider.ValidateCredentials(); err != nil {
+			return nil, err
+		}
+	}
+
+	return provider, nil
 }
 
 // NewDNSProviderCredentials uses the supplied credentials to return a
@@ -43,9 +85,38 @@ func NewDNSProviderCredentials(email, key string) (*DNSProvider, error) {
 	return &DNSProvider{
 		authEmail: email,
 		authKey:   key,
+		ttl:       120,
+		records:   make(map[string]cloudFlareRecord),
 	}, nil
 }
 
+// SetZoneID makes the provider create all records in the zone with the
+// given ID instead of looking up the zone of each record. This allows to
+// use API tokens which are not permitted to list the zones.
+func (c *DNSProvider) SetZoneID(zoneID string) {
+	c.zoneID = zoneID
+}
+
+// SetTTL sets the TTL in seconds of the TXT records created by the provider,
+// 120 by default. A TTL of zero omits the TTL from the request, so that
+// CloudFlare uses its default.
+func (c *DNSProvider) SetTTL(ttl int) error {
+	if ttl < 0 {
+		return fmt.Errorf("CloudFlare TTL must not be negative, got %d", ttl)
+	}
+	c.ttl = ttl
+	return nil
+}
+
+// ValidateCredentials checks that the credentials of the provider are
+// accepted by the CloudFlare API by listing a single zone.
+func (c *DNSProvider) ValidateCredentials() error {
+	if _, err := c.makeRequest("GET", "/zones?per_page=1", nil); err != nil {
+		return fmt.Errorf("CloudFlare credentials invalid: %v", err)
+	}
+	return nil
+}
+
 // Timeout returns the timeout and interval to use when checking for DNS
 // propagation. Adjusting here to cope with spikes in propagation times.
 func (c *DNSProvider) Timeout() (timeout, interval time.Duration) {
@@ -64,7 +135,7 @@ func (c *DNSProvider) Present(domain, token, keyAuth string) error {
 		Type:    "TXT",
 		Name:    acme.UnFqdn(fqdn),
 		Content: value,
-		TTL:     120,
+		TTL:     c.ttl,
 	}
 
 	body, err := json.Marshal(rec)
@@ -72,62 +143,102 @@ func (c *DNSProvider) Present(domain, token, keyAuth string) error {
 		return err
 	}
 
-	_, err = c.makeRequest("POST", fmt.Sprintf("/zones/%s/dns_records", zoneID), bytes.NewReader(body))
+	result, err := c.makeRequest("POST", fmt.Sprintf("/zones/%s/dns_records", zoneID), bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
 
+	var created cloudFlareRecord
+	if err := json.Unmarshal(result, &created); err == nil && created.ID != "" {
+		created.ZoneID = zoneID
+		c.recordsMu.Lock()
+		c.records[fqdn+" "+value] = created
+		c.recordsMu.Unlock()
+	}
+
 	return nil
 }
 
-// CleanUp removes the TXT record matching the specified parameters
+// CleanUp removes the TXT record created by Present. If Present did not run
+// in this process, the record is looked up by its name and value, so that
+// other TXT records of the same name are left alone.
 func (c *DNSProvider) CleanUp(domain, token, keyAuth string) error {
-	fqdn, _, _ := acme.DNS01Record(domain, keyAuth)
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
 
-	record, err := c.findTxtRecord(fqdn)
-	if err != nil {
-		return err
+	c.recordsMu.Lock()
+	record, ok := c.records[fqdn+" "+value]
+	c.recordsMu.Unlock()
+
+	if !ok {
+		found, err := c.findTxtRecord(fqdn, value)
+		if err != nil {
+			return err
+		}
+		record = *found
 	}
 
-	_, err = c.makeRequest("DELETE", fmt.Sprintf("/zones/%s/dns_records/%s", record.ZoneID, record.ID), nil)
+	_, err := c.makeRequest("DELETE", fmt.Sprintf("/zones/%s/dns_records/%s", record.ZoneID, record.ID), nil)
 	if err != nil {
 		return err
 	}
 
+	c.recordsMu.Lock()
+	delete(c.records, fqdn+" "+value)
+	c.recordsMu.Unlock()
+
 	return nil
 }
 
 func (c *DNSProvider) getHostedZoneID(fqdn string) (string, error) {
-	// HostedZone represents a CloudFlare DNS zone
-	type HostedZone struct {
-		ID   string `json:"id"`
-		Name string `json:"name"`
+	if c.zoneID != "" {
+		return c.zoneID, nil
 	}
 
-	authZone, err := acme.FindZoneByFqdn(fqdn, acme.RecursiveNameservers)
+	zoneID, err := c.findZoneID(acme.UnFqdn(fqdn))
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%v for domain %s", err, fqdn)
 	}
 
-	result, err := c.makeRequest("GET", "/zones?name="+acme.UnFqdn(authZone), nil)
-	if err != nil {
-		return "", err
-	}
+	return zoneID, nil
+}
 
-	var hostedZone []HostedZone
-	err = json.Unmarshal(result, &hostedZone)
-	if err != nil {
-		return "", err
+// findZoneID looks up the ID of the most specific zone of the account
+// containing name. It asks for a zone named like name and then like each
+// of its parent domains in turn, so a child zone like foo.example.com is
+// picked over its parent example.com, even if the child zone is not
+// delegated in the public DNS.
+func (c *DNSProvider) findZoneID(name string) (string, error) {
+	// HostedZone represents a CloudFlare DNS zone
+	type HostedZone struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
 	}
 
-	if len(hostedZone) != 1 {
-		return "", fmt.Errorf("Zone %s not found in CloudFlare for domain %s", authZone, fqdn)
+	name = strings.ToLower(name)
+	for candidate := name; strings.Contains(candidate, "."); candidate = candidate[strings.Index(candidate, ".")+1:] {
+		result, err := c.makeRequest("GET", "/zones?name="+url.QueryEscape(candidate), nil)
+		if err != nil {
+			return "", err
+		}
+
+		var hostedZones []HostedZone
+		err = json.Unmarshal(result, &hostedZones)
+		if err != nil {
+			return "", err
+		}
+
+		for _, zone := range hostedZones {
+			if strings.ToLower(zone.Name) == candidate {
+				return zone.ID, nil
+			}
+		}
 	}
 
-	return hostedZone[0].ID, nil
+	return "", fmt.Errorf("Zone %s not found in CloudFlare", name)
 }
 
-func (c *DNSProvider) findTxtRecord(fqdn string) (*cloudFlareRecord, error) {
+// findTxtRecord looks up the TXT record named fqdn with the given value.
+func (c *DNSProvider) findTxtRecord(fqdn, value string) (*cloudFlareRecord, error) {
 	zoneID, err := c.getHostedZoneID(fqdn)
 	if err != nil {
 		return nil, err
@@ -149,7 +260,10 @@ func (c *DNSProvider) findTxtRecord(fqdn string) (*cloudFlareRecord, error) {
 	}
 
 	for _, rec := range records {
-		if rec.Name == acme.UnFqdn(fqdn) {
+		if rec.Name == acme.UnFqdn(fqdn) && rec.Content == value {
+			if rec.ZoneID == "" {
+				rec.ZoneID = zoneID
+			}
 			return &rec, nil
 		}
 	}
@@ -179,10 +293,9 @@ func (c *DNSProvider) makeRequest(method, uri string, body io.Reader) (json.RawM
 
 	req.Header.Set("X-Auth-Email", c.authEmail)
 	req.Header.Set("X-Auth-Key", c.authKey)
-	//req.Header.Set("User-Agent", userAgent())
+	req.Header.Set("User-Agent", acme.UserAgentString())
 
-	client := http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := acme.DoWithRetry(req, nil)
 	if err != nil {
 		return nil, fmt.Errorf("Error querying Cloudflare API -> %v", err)
 	}
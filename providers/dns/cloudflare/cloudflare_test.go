@@ -1,10 +1,16 @@
 package cloudflare
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/stangah/lego/acme"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -27,6 +33,37 @@ func init() {
 func restoreCloudFlareEnv() {
 	os.Setenv("CLOUDFLARE_EMAIL", cflareEmail)
 	os.Setenv("CLOUDFLARE_API_KEY", cflareAPIKey)
+	os.Unsetenv("CLOUDFLARE_SKIP_VALIDATION")
+	os.Unsetenv("CLOUDFLARE_ZONE_ID")
+	os.Unsetenv("CLOUDFLARE_TTL")
+}
+
+// mockCloudFlareAPI starts a server answering zone listings with success if
+// the request carries the given API key, and with an authentication error
+// otherwise. CloudFlareAPIURL points to the server until the returned
+// function is called.
+func mockCloudFlareAPI(t *testing.T, apiKey string) (requests *int, teardown func()) {
+	requests = new(int)
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+
+		if got, want := r.URL.Path, "/zones"; got != want {
+			t.Errorf("Expected path to be '%s' but got '%s'", want, got)
+		}
+		if r.Header.Get("X-Auth-Key") != apiKey {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"success":false,"errors":[{"code":9103,"message":"Unknown X-Auth-Key or X-Auth-Email"}],"result":null}`)
+			return
+		}
+		fmt.Fprint(w, `{"success":true,"errors":[],"result":[]}`)
+	}))
+
+	apiURL := CloudFlareAPIURL
+	CloudFlareAPIURL = mock.URL
+	return requests, func() {
+		CloudFlareAPIURL = apiURL
+		mock.Close()
+	}
 }
 
 func TestNewDNSProviderValid(t *testing.T) {
@@ -38,10 +75,38 @@ func TestNewDNSProviderValid(t *testing.T) {
 }
 
 func TestNewDNSProviderValidEnv(t *testing.T) {
+	requests, teardown := mockCloudFlareAPI(t, "123")
+	defer teardown()
+
 	os.Setenv("CLOUDFLARE_EMAIL", "test@example.com")
 	os.Setenv("CLOUDFLARE_API_KEY", "123")
 	_, err := NewDNSProvider()
 	assert.NoError(t, err)
+	assert.Equal(t, 1, *requests)
+	restoreCloudFlareEnv()
+}
+
+func TestNewDNSProviderInvalidCredErr(t *testing.T) {
+	_, teardown := mockCloudFlareAPI(t, "123")
+	defer teardown()
+
+	os.Setenv("CLOUDFLARE_EMAIL", "test@example.com")
+	os.Setenv("CLOUDFLARE_API_KEY", "456")
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "CloudFlare credentials invalid: Cloudflare API Error \n\t Error: 9103: Unknown X-Auth-Key or X-Auth-Email")
+	restoreCloudFlareEnv()
+}
+
+func TestNewDNSProviderSkipValidation(t *testing.T) {
+	requests, teardown := mockCloudFlareAPI(t, "123")
+	defer teardown()
+
+	os.Setenv("CLOUDFLARE_EMAIL", "test@example.com")
+	os.Setenv("CLOUDFLARE_API_KEY", "456")
+	os.Setenv("CLOUDFLARE_SKIP_VALIDATION", "true")
+	_, err := NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, *requests)
 	restoreCloudFlareEnv()
 }
 
@@ -53,6 +118,318 @@ func TestNewDNSProviderMissingCredErr(t *testing.T) {
 	restoreCloudFlareEnv()
 }
 
+func TestNewDNSProviderCredentialsFile(t *testing.T) {
+	requests, teardown := mockCloudFlareAPI(t, "123")
+	defer teardown()
+
+	file, err := ioutil.TempFile("", "lego-cloudflare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	fmt.Fprint(file, "CLOUDFLARE_EMAIL = test@example.com\nCLOUDFLARE_API_KEY = 123\n")
+	file.Close()
+
+	defer os.Unsetenv(acme.CredentialsFileEnv)
+	os.Setenv(acme.CredentialsFileEnv, file.Name())
+	os.Setenv("CLOUDFLARE_EMAIL", "")
+	os.Setenv("CLOUDFLARE_API_KEY", "")
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+	if assert.NotNil(t, provider) {
+		assert.Equal(t, "test@example.com", provider.authEmail)
+		assert.Equal(t, "123", provider.authKey)
+	}
+	assert.Equal(t, 1, *requests)
+	restoreCloudFlareEnv()
+}
+
+func TestCloudFlareZoneIDSkipsZoneLookup(t *testing.T) {
+	var requests []string
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.Method == "GET" {
+			fmt.Fprint(w, `{"success":true,"errors":[],"result":[{"id":"rec1","type":"TXT","name":"_acme-challenge.example.com","zone_id":"zone1"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"success":true,"errors":[],"result":{"id":"rec1"}}`)
+	}))
+	defer mock.Close()
+
+	apiURL := CloudFlareAPIURL
+	CloudFlareAPIURL = mock.URL
+	defer func() { CloudFlareAPIURL = apiURL }()
+
+	os.Setenv("CLOUDFLARE_EMAIL", "test@example.com")
+	os.Setenv("CLOUDFLARE_API_KEY", "123")
+	os.Setenv("CLOUDFLARE_ZONE_ID", "zone1")
+	defer restoreCloudFlareEnv()
+
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Empty(t, requests, "Expected the credentials not to be validated by listing zones")
+
+	assert.NoError(t, provider.Present("example.com", "", "123d=="))
+	assert.NoError(t, provider.CleanUp("example.com", "", "123d=="))
+	assert.Equal(t, []string{
+		"POST /zones/zone1/dns_records",
+		"DELETE /zones/zone1/dns_records/rec1",
+	}, requests)
+}
+
+func TestCloudFlareCleanUpDeletesCreatedRecord(t *testing.T) {
+	var requests []string
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.Method == "POST" {
+			fmt.Fprint(w, `{"success":true,"errors":[],"result":{"id":"created","type":"TXT","name":"_acme-challenge.example.com"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"success":true,"errors":[],"result":{}}`)
+	}))
+	defer mock.Close()
+
+	apiURL := CloudFlareAPIURL
+	CloudFlareAPIURL = mock.URL
+	defer func() { CloudFlareAPIURL = apiURL }()
+
+	provider, err := NewDNSProviderCredentials("test@example.com", "123")
+	assert.NoError(t, err)
+	provider.SetZoneID("zone1")
+
+	assert.NoError(t, provider.Present("example.com", "", "123d=="))
+	assert.NoError(t, provider.CleanUp("example.com", "", "123d=="))
+	assert.Equal(t, []string{
+		"POST /zones/zone1/dns_records",
+		"DELETE /zones/zone1/dns_records/created",
+	}, requests)
+}
+
+func TestCloudFlareCleanUpMatchesValue(t *testing.T) {
+	_, value, _ := acme.DNS01Record("example.com", "123d==")
+
+	var requests []string
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.Method == "GET" {
+			fmt.Fprintf(w, `{"success":true,"errors":[],"result":[
+				{"id":"existing","type":"TXT","name":"_acme-challenge.example.com","content":"other"},
+				{"id":"ours","type":"TXT","name":"_acme-challenge.example.com","content":%q}
+			]}`, value)
+			return
+		}
+		fmt.Fprint(w, `{"success":true,"errors":[],"result":{}}`)
+	}))
+	defer mock.Close()
+
+	apiURL := CloudFlareAPIURL
+	CloudFlareAPIURL = mock.URL
+	defer func() { CloudFlareAPIURL = apiURL }()
+
+	provider, err := NewDNSProviderCredentials("test@example.com", "123")
+	assert.NoError(t, err)
+	provider.SetZoneID("zone1")
+
+	assert.NoError(t, provider.CleanUp("example.com", "", "123d=="))
+	assert.Equal(t, []string{
+		"GET /zones/zone1/dns_records",
+		"DELETE /zones/zone1/dns_records/ours",
+	}, requests)
+}
+
+func TestCloudFlareTTL(t *testing.T) {
+	var bodies []map[string]interface{}
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		fmt.Fprint(w, `{"success":true,"errors":[],"result":{"id":"rec1"}}`)
+	}))
+	defer mock.Close()
+
+	apiURL := CloudFlareAPIURL
+	CloudFlareAPIURL = mock.URL
+	defer func() { CloudFlareAPIURL = apiURL }()
+
+	os.Setenv("CLOUDFLARE_EMAIL", "test@example.com")
+	os.Setenv("CLOUDFLARE_API_KEY", "123")
+	os.Setenv("CLOUDFLARE_ZONE_ID", "zone1")
+	defer restoreCloudFlareEnv()
+
+	os.Setenv("CLOUDFLARE_TTL", "-1")
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "CloudFlare TTL must not be negative, got -1")
+
+	provider, err := NewDNSProviderCredentials("test@example.com", "123")
+	assert.NoError(t, err)
+	provider.SetZoneID("zone1")
+	assert.NoError(t, provider.Present("example.com", "", "123d=="))
+
+	// A TTL of zero leaves the TTL to CloudFlare.
+	os.Setenv("CLOUDFLARE_TTL", "0")
+	provider, err = NewDNSProvider()
+	assert.NoError(t, err)
+	assert.NoError(t, provider.Present("example.com", "", "123d=="))
+
+	if assert.Len(t, bodies, 2) {
+		assert.Equal(t, float64(120), bodies[0]["ttl"])
+		assert.NotContains(t, bodies[1], "ttl")
+		assert.Contains(t, bodies[1], "content")
+	}
+}
+
+// mockCloudFlareZones starts a server answering zone lookups by name with
+// the zones of the given IDs by name and records the names looked up.
+// CloudFlareAPIURL points to the server until the returned function is
+// called.
+func mockCloudFlareZones(t *testing.T, zones map[string]string) (names *[]string, teardown func()) {
+	names = new([]string)
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.URL.Path, "/zones"; got != want {
+			t.Errorf("Expected path to be '%s' but got '%s'", want, got)
+		}
+		name := r.URL.Query().Get("name")
+		*names = append(*names, name)
+
+		if id, ok := zones[name]; ok {
+			fmt.Fprintf(w, `{"success":true,"errors":[],"result":[{"id":%q,"name":%q}]}`, id, name)
+			return
+		}
+		fmt.Fprint(w, `{"success":true,"errors":[],"result":[]}`)
+	}))
+
+	apiURL := CloudFlareAPIURL
+	CloudFlareAPIURL = mock.URL
+	return names, func() {
+		CloudFlareAPIURL = apiURL
+		mock.Close()
+	}
+}
+
+func TestCloudFlareFindZoneIDByName(t *testing.T) {
+	names, teardown := mockCloudFlareZones(t, map[string]string{"example.com": "1"})
+	defer teardown()
+
+	provider, err := NewDNSProviderCredentials("test@example.com", "123")
+	assert.NoError(t, err)
+
+	zoneID, err := provider.findZoneID("_acme-challenge.www.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", zoneID)
+	assert.Equal(t, []string{"_acme-challenge.www.example.com", "www.example.com", "example.com"}, *names)
+
+	*names = nil
+	_, err = provider.findZoneID("_acme-challenge.example.info")
+	assert.EqualError(t, err, "Zone _acme-challenge.example.info not found in CloudFlare")
+	assert.Equal(t, []string{"_acme-challenge.example.info", "example.info"}, *names, "Expected no lookup of the TLD")
+}
+
+func TestCloudFlareFindZoneIDMostSpecific(t *testing.T) {
+	_, teardown := mockCloudFlareZones(t, map[string]string{
+		"example.com":     "1",
+		"oo.example.com":  "2",
+		"foo.example.com": "3",
+	})
+	defer teardown()
+
+	provider, err := NewDNSProviderCredentials("test@example.com", "123")
+	assert.NoError(t, err)
+
+	for fqdn, zoneID := range map[string]string{
+		"_acme-challenge.foo.example.com.":     "3",
+		"_acme-challenge.www.foo.example.com.": "3",
+		"_acme-challenge.Foo.Example.COM.":     "3",
+		"_acme-challenge.example.com.":         "1",
+		"_acme-challenge.boo.example.com.":     "1",
+	} {
+		got, err := provider.getHostedZoneID(fqdn)
+		assert.NoError(t, err)
+		assert.Equal(t, zoneID, got, "Unexpected zone for %s", fqdn)
+	}
+
+	_, err = provider.getHostedZoneID("_acme-challenge.example.org.")
+	assert.Error(t, err)
+}
+
+func TestCloudFlareUserAgent(t *testing.T) {
+	var ua string
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ua = r.Header.Get("User-Agent")
+		fmt.Fprint(w, `{"success":true,"errors":[],"result":[{"id":"1","name":"example.com"}]}`)
+	}))
+	defer mock.Close()
+
+	apiURL := CloudFlareAPIURL
+	CloudFlareAPIURL = mock.URL
+	defer func() { CloudFlareAPIURL = apiURL }()
+
+	defer func(userAgent string) { acme.UserAgent = userAgent }(acme.UserAgent)
+	acme.UserAgent = "MyApp/1.2.3"
+
+	provider, err := NewDNSProviderCredentials("test@example.com", "123")
+	assert.NoError(t, err)
+
+	_, err = provider.findZoneID("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, acme.UserAgentString(), ua)
+	assert.Contains(t, ua, "MyApp/1.2.3")
+}
+
+func TestCloudFlareUsesProviderHTTPClient(t *testing.T) {
+	unblock := make(chan struct{})
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer mock.Close()
+	defer close(unblock)
+
+	apiURL := CloudFlareAPIURL
+	CloudFlareAPIURL = mock.URL
+	defer func() { CloudFlareAPIURL = apiURL }()
+
+	defer func(client *http.Client) { acme.ProviderHTTPClient = client }(acme.ProviderHTTPClient)
+	acme.ProviderHTTPClient = &http.Client{Timeout: 50 * time.Millisecond}
+
+	provider, err := NewDNSProviderCredentials("test@example.com", "123")
+	assert.NoError(t, err)
+
+	start := time.Now()
+	_, err = provider.findZoneID("example.com")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Client.Timeout")
+	}
+	assert.True(t, time.Since(start) < 5*time.Second, "Expected the configured timeout to apply")
+}
+
+func TestCloudFlareRetriesRateLimitedRequests(t *testing.T) {
+	var requests int
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"success":false,"errors":[{"code":10000,"message":"Rate limited"}],"result":null}`)
+			return
+		}
+		fmt.Fprint(w, `{"success":true,"errors":[],"result":[{"id":"1","name":"example.com"}]}`)
+	}))
+	defer mock.Close()
+
+	apiURL := CloudFlareAPIURL
+	CloudFlareAPIURL = mock.URL
+	defer func() { CloudFlareAPIURL = apiURL }()
+
+	defer func(delay time.Duration) { acme.ProviderRetryDelay = delay }(acme.ProviderRetryDelay)
+	acme.ProviderRetryDelay = time.Millisecond
+
+	provider, err := NewDNSProviderCredentials("test@example.com", "123")
+	assert.NoError(t, err)
+
+	zoneID, err := provider.findZoneID("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", zoneID)
+	assert.Equal(t, 2, requests)
+}
+
 func TestCloudFlarePresent(t *testing.T) {
 	if !cflareLiveTest {
 		t.Skip("skipping live test")
@@ -0,0 +1,216 @@
+// Package desec implements a DNS provider for solving the DNS-01 challenge
+// using deSEC DNS.
+package desec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/stangah/lego/acme"
+)
+
+// desecBaseURL is the base URL of the deSEC API.
+var desecBaseURL = "https://desec.io/api/v1"
+
+// defaultTTL is the minimum TTL accepted by deSEC.
+const defaultTTL = 3600
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses deSEC's REST API to manage TXT records for a domain.
+type DNSProvider struct {
+	token string
+	ttl   int
+
+	// deSEC manages all values of a name and type as one rrset, so updates
+	// are read-modify-write and must not interleave.
+	mu sync.Mutex
+}
+
+// rrSet is a resource record set of the deSEC API.
+type rrSet struct {
+	Subname string   `json:"subname"`
+	Type    string   `json:"type"`
+	TTL     int      `json:"ttl,omitempty"`
+	Records []string `json:"records"`
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for deSEC.
+// The API token must be passed in the environment variable DESEC_TOKEN.
+func NewDNSProvider() (*DNSProvider, error) {
+	return NewDNSProviderCredentials(os.Getenv("DESEC_TOKEN"))
+}
+
+// NewDNSProviderCredentials uses the supplied token to return a DNSProvider
+// instance configured for deSEC.
+func NewDNSProviderCredentials(token string) (*DNSProvider, error) {
+	if token == "" {
+		return nil, fmt.Errorf("deSEC credentials missing")
+	}
+	return &DNSProvider{token: token, ttl: defaultTTL}, nil
+}
+
+// SetTTL sets the TTL in seconds of the TXT records created by the provider.
+// deSEC rejects TTLs below its minimum of 3600 seconds for most accounts.
+func (d *DNSProvider) SetTTL(ttl int) error {
+	if ttl <= 0 {
+		return fmt.Errorf("deSEC TTL must be positive, got %d", ttl)
+	}
+	d.ttl = ttl
+	return nil
+}
+
+// Present adds the challenge value to the TXT rrset of the challenge name.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	zone, subname, err := d.recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	records, err := d.getTxtRecords(zone, subname)
+	if err != nil {
+		return err
+	}
+
+	quoted := fmt.Sprintf("%q", value)
+	for _, record := range records {
+		if record == quoted {
+			return nil
+		}
+	}
+
+	return d.updateTxtRecords(zone, subname, append(records, quoted))
+}
+
+// CleanUp removes the challenge value from the TXT rrset of the challenge
+// name. The rrset is deleted once it has no values left.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	zone, subname, err := d.recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	records, err := d.getTxtRecords(zone, subname)
+	if err != nil {
+		return err
+	}
+
+	quoted := fmt.Sprintf("%q", value)
+	remaining := []string{}
+	for _, record := range records {
+		if record != quoted {
+			remaining = append(remaining, record)
+		}
+	}
+	if len(remaining) == len(records) {
+		return nil
+	}
+
+	return d.updateTxtRecords(zone, subname, remaining)
+}
+
+// recordLocation returns the deSEC domain containing fqdn and the name of
+// fqdn relative to it. The most specific domain of the account is used.
+func (d *DNSProvider) recordLocation(fqdn string) (string, string, error) {
+	var domains []struct {
+		Name string `json:"name"`
+	}
+	if err := d.doRequest("GET", "/domains/", nil, &domains); err != nil {
+		return "", "", err
+	}
+
+	name := strings.ToLower(acme.UnFqdn(fqdn))
+	var zone string
+	for _, domain := range domains {
+		zoneName := strings.ToLower(acme.UnFqdn(domain.Name))
+		if strings.HasSuffix(name, "."+zoneName) && len(zoneName) > len(zone) {
+			zone = zoneName
+		}
+	}
+	if zone == "" {
+		return "", "", fmt.Errorf("deSEC: no domain found for %s", fqdn)
+	}
+
+	return zone, strings.TrimSuffix(name, "."+zone), nil
+}
+
+// getTxtRecords returns the values of the TXT rrset subname in zone, or
+// none if the rrset does not exist.
+func (d *DNSProvider) getTxtRecords(zone, subname string) ([]string, error) {
+	var set rrSet
+	err := d.doRequest("GET", fmt.Sprintf("/domains/%s/rrsets/%s/TXT/", zone, subname), nil, &set)
+	if err == errNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return set.Records, nil
+}
+
+// updateTxtRecords replaces the values of the TXT rrset subname in zone.
+// An empty list of records deletes the rrset.
+func (d *DNSProvider) updateTxtRecords(zone, subname string, records []string) error {
+	sets := []rrSet{{Subname: subname, Type: "TXT", TTL: d.ttl, Records: records}}
+	return d.doRequest("PATCH", fmt.Sprintf("/domains/%s/rrsets/", zone), sets, nil)
+}
+
+var errNotFound = fmt.Errorf("deSEC: not found")
+
+func (d *DNSProvider) doRequest(method, uri string, body, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, desecBaseURL+uri, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+d.token)
+	req.Header.Set("User-Agent", acme.UserAgentString())
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := acme.DoWithRetry(req, nil)
+	if err != nil {
+		return fmt.Errorf("deSEC API call failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode >= 400 {
+		var errInfo struct {
+			Detail string `json:"detail"`
+		}
+		json.NewDecoder(resp.Body).Decode(&errInfo)
+		return fmt.Errorf("deSEC API call failed: HTTP %d: %s", resp.StatusCode, errInfo.Detail)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
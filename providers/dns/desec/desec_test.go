@@ -0,0 +1,293 @@
+package desec
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stangah/lego/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	desecLiveTest bool
+	desecToken    string
+	desecDomain   string
+)
+
+func init() {
+	desecToken = os.Getenv("DESEC_TOKEN")
+	desecDomain = os.Getenv("DESEC_DOMAIN")
+	if len(desecToken) > 0 && len(desecDomain) > 0 {
+		desecLiveTest = true
+	}
+}
+
+func restoreDesecEnv() {
+	os.Setenv("DESEC_TOKEN", desecToken)
+}
+
+// fakeDesec serves the parts of the deSEC API used by the provider and keeps
+// the TXT rrsets of its domains in memory.
+type fakeDesec struct {
+	mu      sync.Mutex
+	domains []string
+	rrsets  map[string][]string
+	patches int
+}
+
+func (f *fakeDesec) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Token secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"detail":"Invalid token."}`))
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1"), "/"), "/")
+	switch {
+	case r.Method == "GET" && len(parts) == 1 && parts[0] == "domains":
+		var domains []map[string]string
+		for _, name := range f.domains {
+			domains = append(domains, map[string]string{"name": name})
+		}
+		json.NewEncoder(w).Encode(domains)
+	case r.Method == "GET" && len(parts) == 5 && parts[2] == "rrsets" && parts[4] == "TXT":
+		records, ok := f.rrsets[parts[1]+"/"+parts[3]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"detail":"Not found."}`))
+			return
+		}
+		json.NewEncoder(w).Encode(rrSet{Subname: parts[3], Type: "TXT", TTL: 3600, Records: records})
+	case r.Method == "PATCH" && len(parts) == 3 && parts[2] == "rrsets":
+		f.patches++
+		var sets []rrSet
+		if err := json.NewDecoder(r.Body).Decode(&sets); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, set := range sets {
+			key := parts[1] + "/" + set.Subname
+			if len(set.Records) == 0 {
+				delete(f.rrsets, key)
+			} else {
+				f.rrsets[key] = set.Records
+			}
+		}
+		json.NewEncoder(w).Encode(sets)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// mockDesec serves the deSEC API with handler until the returned function
+// is called.
+func mockDesec(handler http.Handler) func() {
+	server := httptest.NewServer(handler)
+	baseURL := desecBaseURL
+	desecBaseURL = server.URL + "/api/v1"
+	return func() {
+		desecBaseURL = baseURL
+		server.Close()
+	}
+}
+
+func newFakeDesec(domains ...string) (*fakeDesec, func()) {
+	fake := &fakeDesec{domains: domains, rrsets: map[string][]string{}}
+	return fake, mockDesec(fake)
+}
+
+func TestNewDNSProviderValid(t *testing.T) {
+	os.Setenv("DESEC_TOKEN", "")
+	defer restoreDesecEnv()
+	_, err := NewDNSProviderCredentials("123")
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	os.Setenv("DESEC_TOKEN", "secret")
+	defer restoreDesecEnv()
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", provider.token)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	os.Setenv("DESEC_TOKEN", "")
+	defer restoreDesecEnv()
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "deSEC credentials missing")
+}
+
+func TestDesecPresent(t *testing.T) {
+	var requests []string
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockDesec(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		assert.Equal(t, "Token asdf1234", r.Header.Get("Authorization"))
+
+		switch r.Method {
+		case "GET":
+			if r.URL.Path == "/api/v1/domains/" {
+				fmt.Fprint(w, `[{"name":"example.com"}]`)
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"detail":"Not found."}`)
+		case "PATCH":
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+			reqBody, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, `[{"subname":"_acme-challenge.www","type":"TXT","ttl":3600,"records":["\"`+value+`\""]}]`, string(reqBody))
+
+			w.Write(reqBody)
+		}
+	}))()
+
+	provider, err := NewDNSProviderCredentials("asdf1234")
+	assert.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"GET /api/v1/domains/",
+		"GET /api/v1/domains/example.com/rrsets/_acme-challenge.www/TXT/",
+		"PATCH /api/v1/domains/example.com/rrsets/",
+	}, requests)
+}
+
+func TestDesecCleanUp(t *testing.T) {
+	var requests []string
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockDesec(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		assert.Equal(t, "Token asdf1234", r.Header.Get("Authorization"))
+
+		switch r.Method {
+		case "GET":
+			if r.URL.Path == "/api/v1/domains/" {
+				fmt.Fprint(w, `[{"name":"example.com"}]`)
+				return
+			}
+			fmt.Fprint(w, `{"subname":"_acme-challenge.www","type":"TXT","ttl":3600,"records":["\"other\"","\"`+value+`\""]}`)
+		case "PATCH":
+			// Only the challenge value is removed from the rrset.
+			reqBody, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, `[{"subname":"_acme-challenge.www","type":"TXT","ttl":3600,"records":["\"other\""]}]`, string(reqBody))
+
+			w.Write(reqBody)
+		}
+	}))()
+
+	provider, err := NewDNSProviderCredentials("asdf1234")
+	assert.NoError(t, err)
+
+	err = provider.CleanUp("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"GET /api/v1/domains/",
+		"GET /api/v1/domains/example.com/rrsets/_acme-challenge.www/TXT/",
+		"PATCH /api/v1/domains/example.com/rrsets/",
+	}, requests)
+}
+
+func TestDesecSharedName(t *testing.T) {
+	fake, done := newFakeDesec("example.com")
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+
+	// Two challenges for the same name end up in one rrset, and presenting
+	// a value twice does not touch the zone.
+	assert.NoError(t, provider.Present("www.example.com", "", "key1"))
+	assert.NoError(t, provider.Present("www.example.com", "", "key2"))
+	assert.NoError(t, provider.Present("www.example.com", "", "key1"))
+
+	_, value1, _ := acme.DNS01Record("www.example.com", "key1")
+	_, value2, _ := acme.DNS01Record("www.example.com", "key2")
+	expected := []string{`"` + value1 + `"`, `"` + value2 + `"`}
+	sort.Strings(expected)
+	records := fake.rrsets["example.com/_acme-challenge.www"]
+	sort.Strings(records)
+	assert.Equal(t, expected, records)
+	assert.Equal(t, 2, fake.patches)
+
+	// The rrset is deleted with its last value.
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "key1"))
+	assert.Equal(t, []string{`"` + value2 + `"`}, fake.rrsets["example.com/_acme-challenge.www"])
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "key2"))
+	assert.Empty(t, fake.rrsets)
+
+	// Cleaning up a record that is gone does not touch the zone.
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "key1"))
+	assert.Equal(t, 4, fake.patches)
+}
+
+func TestDesecMostSpecificDomain(t *testing.T) {
+	fake, done := newFakeDesec("example.com", "sub.example.com", "ub.example.com")
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+
+	// The name is relative to the most specific domain of the account.
+	assert.NoError(t, provider.Present("www.sub.example.com", "", "key"))
+	_, ok := fake.rrsets["sub.example.com/_acme-challenge.www"]
+	assert.True(t, ok)
+	assert.Len(t, fake.rrsets, 1)
+}
+
+func TestDesecPresentFailed(t *testing.T) {
+	_, done := newFakeDesec("example.com")
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("wrong")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.com", "", "key"), "deSEC API call failed: HTTP 401: Invalid token.")
+
+	provider, err = NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.org", "", "key"), "deSEC: no domain found for _acme-challenge.example.org.")
+}
+
+func TestLiveDesecPresent(t *testing.T) {
+	if !desecLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	provider, err := NewDNSProviderCredentials(desecToken)
+	assert.NoError(t, err)
+
+	err = provider.Present(desecDomain, "", "123d==")
+	assert.NoError(t, err)
+}
+
+func TestLiveDesecCleanUp(t *testing.T) {
+	if !desecLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	time.Sleep(time.Second * 1)
+
+	provider, err := NewDNSProviderCredentials(desecToken)
+	assert.NoError(t, err)
+
+	err = provider.CleanUp(desecDomain, "", "123d==")
+	assert.NoError(t, err)
+}
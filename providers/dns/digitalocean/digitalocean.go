@@ -8,8 +8,9 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
-	"time"
 
 	"github.com/stangah/lego/acme"
 )
@@ -18,16 +19,40 @@ import (
 // that uses DigitalOcean's REST API to manage TXT records for a domain.
 type DNSProvider struct {
 	apiAuthToken string
+	ttl          int
 	recordIDs    map[string]int
 	recordIDsMu  sync.Mutex
 }
 
 // NewDNSProvider returns a DNSProvider instance configured for Digital
 // Ocean. Credentials must be passed in the environment variable:
-// DO_AUTH_TOKEN.
+// DO_AUTH_TOKEN. The token is validated against the API unless
+// DO_SKIP_VALIDATION is set to true. The TTL of the TXT records in seconds
+// can be set in DO_TTL, the DigitalOcean default is used otherwise.
 func NewDNSProvider() (*DNSProvider, error) {
 	apiAuthToken := os.Getenv("DO_AUTH_TOKEN")
-	return NewDNSProviderCredentials(apiAuthToken)
+	provider, err := NewDNSProviderCredentials(apiAuthToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if v := os.Getenv("DO_TTL"); v != "" {
+		ttl, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("DigitalOcean TTL %q is not a number", v)
+		}
+		if err := provider.SetTTL(ttl); err != nil {
+			return nil, err
+		}
+	}
+
+	if skip, _ := strconv.ParseBool(os.Getenv("DO_SKIP_VALIDATION")); !skip {
+		if err := provider.ValidateCredentials(); err != nil {
+			return nil, err
+		}
+	}
+
+	return provider, nil
 }
 
 // NewDNSProviderCredentials uses the supplied credentials to return a
@@ -42,6 +67,41 @@ func NewDNSProviderCredentials(apiAuthToken string) (*DNSProvider, error) {
 	}, nil
 }
 
+// SetTTL sets the TTL in seconds of the TXT records created by the provider.
+// A TTL of zero uses the DigitalOcean default.
+func (d *DNSProvider) SetTTL(ttl int) error {
+	if ttl < 0 {
+		return fmt.Errorf("DigitalOcean TTL must not be negative, got %d", ttl)
+	}
+	d.ttl = ttl
+	return nil
+}
+
+// ValidateCredentials checks that the auth token of the provider is accepted
+// by the DigitalOcean API by fetching the account information.
+func (d *DNSProvider) ValidateCredentials() error {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v2/account", digitalOceanBaseURL), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", d.apiAuthToken))
+	req.Header.Set("User-Agent", acme.UserAgentString())
+
+	resp, err := acme.DoWithRetry(req, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var errInfo digitalOceanAPIError
+		json.NewDecoder(resp.Body).Decode(&errInfo)
+		return fmt.Errorf("DigitalOcean credentials invalid: HTTP %d: %s: %s", resp.StatusCode, errInfo.ID, errInfo.Message)
+	}
+
+	return nil
+}
+
 // Present creates a TXT record using the specified parameters
 func (d *DNSProvider) Present(domain, token, keyAuth string) error {
 	// txtRecordRequest represents the request body to DO's API to make a TXT record
@@ -49,6 +109,7 @@ func (d *DNSProvider) Present(domain, token, keyAuth string) error {
 		RecordType string `json:"type"`
 		Name       string `json:"name"`
 		Data       string `json:"data"`
+		TTL        int    `json:"ttl,omitempty"`
 	}
 
 	// txtRecordResponse represents a response from DO's API after making a TXT record
@@ -63,15 +124,27 @@ func (d *DNSProvider) Present(domain, token, keyAuth string) error {
 
 	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
 
-	authZone, err := acme.FindZoneByFqdn(acme.ToFqdn(domain), acme.RecursiveNameservers)
+	authZone, err := findZoneByFqdn(acme.ToFqdn(domain), acme.RecursiveNameservers)
 	if err != nil {
 		return fmt.Errorf("Could not determine zone for domain: '%s'. %s", domain, err)
 	}
 
 	authZone = acme.UnFqdn(authZone)
 
+	// A record with the same value may be left over from a retried run.
+	recordID, err := d.findTxtRecord(authZone, fqdn, value)
+	if err != nil {
+		return err
+	}
+	if recordID != 0 {
+		d.recordIDsMu.Lock()
+		d.recordIDs[fqdn] = recordID
+		d.recordIDsMu.Unlock()
+		return nil
+	}
+
 	reqURL := fmt.Sprintf("%s/v2/domains/%s/records", digitalOceanBaseURL, authZone)
-	reqData := txtRecordRequest{RecordType: "TXT", Name: fqdn, Data: value}
+	reqData := txtRecordRequest{RecordType: "TXT", Name: fqdn, Data: value, TTL: d.ttl}
 	body, err := json.Marshal(reqData)
 	if err != nil {
 		return err
@@ -83,18 +156,16 @@ func (d *DNSProvider) Present(domain, token, keyAuth string) error {
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", d.apiAuthToken))
+	req.Header.Set("User-Agent", acme.UserAgentString())
 
-	client := http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := acme.DoWithRetry(req, nil)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		var errInfo digitalOceanAPIError
-		json.NewDecoder(resp.Body).Decode(&errInfo)
-		return fmt.Errorf("HTTP %d: %s: %s", resp.StatusCode, errInfo.ID, errInfo.Message)
+		return domainError(resp, authZone)
 	}
 
 	// Everything looks good; but we'll need the ID later to delete the record
@@ -112,23 +183,30 @@ func (d *DNSProvider) Present(domain, token, keyAuth string) error {
 
 // CleanUp removes the TXT record matching the specified parameters
 func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
-	fqdn, _, _ := acme.DNS01Record(domain, keyAuth)
-
-	// get the record's unique ID from when we created it
-	d.recordIDsMu.Lock()
-	recordID, ok := d.recordIDs[fqdn]
-	d.recordIDsMu.Unlock()
-	if !ok {
-		return fmt.Errorf("unknown record ID for '%s'", fqdn)
-	}
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
 
-	authZone, err := acme.FindZoneByFqdn(acme.ToFqdn(domain), acme.RecursiveNameservers)
+	authZone, err := findZoneByFqdn(acme.ToFqdn(domain), acme.RecursiveNameservers)
 	if err != nil {
 		return fmt.Errorf("Could not determine zone for domain: '%s'. %s", domain, err)
 	}
 
 	authZone = acme.UnFqdn(authZone)
 
+	// get the record's unique ID from when we created it, or look up the
+	// record with the matching value if it was created by another run
+	d.recordIDsMu.Lock()
+	recordID, ok := d.recordIDs[fqdn]
+	d.recordIDsMu.Unlock()
+	if !ok {
+		recordID, err = d.findTxtRecord(authZone, fqdn, value)
+		if err != nil {
+			return err
+		}
+		if recordID == 0 {
+			return fmt.Errorf("unknown record ID for '%s'", fqdn)
+		}
+	}
+
 	reqURL := fmt.Sprintf("%s/v2/domains/%s/records/%d", digitalOceanBaseURL, authZone, recordID)
 	req, err := http.NewRequest("DELETE", reqURL, nil)
 	if err != nil {
@@ -136,9 +214,9 @@ func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", d.apiAuthToken))
+	req.Header.Set("User-Agent", acme.UserAgentString())
 
-	client := http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := acme.DoWithRetry(req, nil)
 	if err != nil {
 		return err
 	}
@@ -158,9 +236,75 @@ func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
 	return nil
 }
 
+// findTxtRecord returns the ID of the TXT record for fqdn with the given
+// value in authZone, or zero if there is no such record.
+func (d *DNSProvider) findTxtRecord(authZone, fqdn, value string) (int, error) {
+	// txtRecordsResponse represents a response from DO's API listing records
+	type txtRecordsResponse struct {
+		DomainRecords []struct {
+			ID   int    `json:"id"`
+			Type string `json:"type"`
+			Name string `json:"name"`
+			Data string `json:"data"`
+		} `json:"domain_records"`
+	}
+
+	name := acme.UnFqdn(fqdn)
+	reqURL := fmt.Sprintf("%s/v2/domains/%s/records?type=TXT&name=%s&per_page=200", digitalOceanBaseURL, authZone, name)
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", d.apiAuthToken))
+	req.Header.Set("User-Agent", acme.UserAgentString())
+
+	resp, err := acme.DoWithRetry(req, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return 0, domainError(resp, authZone)
+	}
+
+	var respData txtRecordsResponse
+	err = json.NewDecoder(resp.Body).Decode(&respData)
+	if err != nil {
+		return 0, err
+	}
+
+	// DO returns the record names relative to the zone.
+	relName := strings.TrimSuffix(name, "."+authZone)
+	for _, record := range respData.DomainRecords {
+		if record.Type == "TXT" && record.Data == value && (record.Name == relName || record.Name == name) {
+			return record.ID, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// domainError returns the error of a failed request for the records of
+// authZone. DigitalOcean tokens are scoped to the account or team which
+// created them, and the API answers 404 for domains outside of it.
+func domainError(resp *http.Response, authZone string) error {
+	var errInfo digitalOceanAPIError
+	json.NewDecoder(resp.Body).Decode(&errInfo)
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("DigitalOcean domain %s not found; make sure it is added to the account or team the auth token belongs to: HTTP %d: %s: %s", authZone, resp.StatusCode, errInfo.ID, errInfo.Message)
+	}
+	return fmt.Errorf("HTTP %d: %s: %s", resp.StatusCode, errInfo.ID, errInfo.Message)
+}
+
 type digitalOceanAPIError struct {
 	ID      string `json:"id"`
 	Message string `json:"message"`
 }
 
-var digitalOceanBaseURL = "https://api.digitalocean.com"
+var (
+	digitalOceanBaseURL = "https://api.digitalocean.com"
+	// findZoneByFqdn determines the zone of an fqdn. It is overridden
+	// during tests.
+	findZoneByFqdn = acme.FindZoneByFqdn
+)
@@ -1,19 +1,106 @@
 package digitalocean
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/stangah/lego/acme"
 )
 
 var fakeDigitalOceanAuth = "asdf1234"
 
+func TestDigitalOceanValidateCredentials(t *testing.T) {
+	var requests int
+
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		if got, want := r.Method, "GET"; got != want {
+			t.Errorf("Expected method to be '%s' but got '%s'", want, got)
+		}
+		if got, want := r.URL.Path, "/v2/account"; got != want {
+			t.Errorf("Expected path to be '%s' but got '%s'", want, got)
+		}
+		if r.Header.Get("Authorization") != "Bearer asdf1234" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, `{"id":"unauthorized","message":"Unable to authenticate you."}`)
+			return
+		}
+		fmt.Fprintf(w, `{"account":{"email":"test@example.com","status":"active"}}`)
+	}))
+	defer mock.Close()
+	digitalOceanBaseURL = mock.URL
+
+	defer os.Setenv("DO_AUTH_TOKEN", os.Getenv("DO_AUTH_TOKEN"))
+	defer os.Unsetenv("DO_SKIP_VALIDATION")
+
+	os.Setenv("DO_AUTH_TOKEN", fakeDigitalOceanAuth)
+	if _, err := NewDNSProvider(); err != nil {
+		t.Errorf("Expected no error creating provider with valid token, but got: %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected 1 validation request, but got %d", requests)
+	}
+
+	os.Setenv("DO_AUTH_TOKEN", "wrong")
+	_, err := NewDNSProvider()
+	if got, want := fmt.Sprint(err), "DigitalOcean credentials invalid: HTTP 401: unauthorized: Unable to authenticate you."; got != want {
+		t.Errorf("Expected error '%s' but got '%s'", want, got)
+	}
+
+	os.Setenv("DO_SKIP_VALIDATION", "true")
+	if _, err := NewDNSProvider(); err != nil {
+		t.Errorf("Expected no error creating provider without validation, but got: %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected no validation request when skipped, but got %d requests", requests)
+	}
+}
+
+func TestDigitalOceanUsesProviderHTTPClient(t *testing.T) {
+	unblock := make(chan struct{})
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer mock.Close()
+	defer close(unblock)
+	digitalOceanBaseURL = mock.URL
+
+	defer func(client *http.Client) { acme.ProviderHTTPClient = client }(acme.ProviderHTTPClient)
+	acme.ProviderHTTPClient = &http.Client{Timeout: 50 * time.Millisecond}
+
+	provider, err := NewDNSProviderCredentials(fakeDigitalOceanAuth)
+	if err != nil {
+		t.Fatalf("Expected no error creating provider, but got: %v", err)
+	}
+
+	start := time.Now()
+	err = provider.ValidateCredentials()
+	if err == nil || !strings.Contains(err.Error(), "Client.Timeout") {
+		t.Errorf("Expected the request to time out, but got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the configured timeout to apply, but the request took %v", elapsed)
+	}
+}
+
 func TestDigitalOceanPresent(t *testing.T) {
 	var requestReceived bool
 
 	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			// No leftover record exists yet.
+			fmt.Fprintf(w, `{"domain_records":[]}`)
+			return
+		}
+
 		requestReceived = true
 
 		if got, want := r.Method, "POST"; got != want {
@@ -115,3 +202,96 @@ func TestDigitalOceanCleanUp(t *testing.T) {
 		t.Error("Expected request to be received by mock backend, but it wasn't")
 	}
 }
+
+func TestDigitalOceanPresentIdempotent(t *testing.T) {
+	type record struct {
+		ID   int    `json:"id"`
+		Type string `json:"type"`
+		Name string `json:"name"`
+		Data string `json:"data"`
+		TTL  int    `json:"ttl"`
+	}
+	var records []record
+
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.URL.Path, "/v2/domains/example.com/records"; got != want {
+			t.Errorf("Expected path to be '%s' but got '%s'", want, got)
+		}
+
+		switch r.Method {
+		case "GET":
+			if got, want := r.URL.Query().Get("name"), "_acme-challenge.example.com"; got != want {
+				t.Errorf("Expected name filter to be '%s' but got '%s'", want, got)
+			}
+			json.NewEncoder(w).Encode(map[string][]record{"domain_records": records})
+		case "POST":
+			var rec record
+			if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+				t.Fatalf("Error decoding request body: %v", err)
+			}
+			rec.ID = 1234567 + len(records)
+			// DO stores the record names relative to the zone.
+			rec.Name = "_acme-challenge"
+			records = append(records, rec)
+
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]record{"domain_record": rec})
+		default:
+			t.Errorf("Unexpected method '%s'", r.Method)
+		}
+	}))
+	defer mock.Close()
+	digitalOceanBaseURL = mock.URL
+
+	doprov, err := NewDNSProviderCredentials(fakeDigitalOceanAuth)
+	if err != nil {
+		t.Fatalf("Expected no error creating provider, but got: %v", err)
+	}
+	if err := doprov.SetTTL(30); err != nil {
+		t.Fatalf("Expected no error setting TTL, but got: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := doprov.Present("example.com", "", "foobar"); err != nil {
+			t.Fatalf("Expected no error creating TXT record, but got: %v", err)
+		}
+	}
+
+	if len(records) != 1 {
+		t.Fatalf("Expected exactly one TXT record, but got %d", len(records))
+	}
+	if records[0].TTL != 30 {
+		t.Errorf("Expected TTL to be 30 but got %d", records[0].TTL)
+	}
+	if got := doprov.recordIDs["_acme-challenge.example.com."]; got != records[0].ID {
+		t.Errorf("Expected record ID %d to be remembered but got %d", records[0].ID, got)
+	}
+}
+
+func TestDigitalOceanPresentUnknownDomain(t *testing.T) {
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"id":"not_found","message":"The resource you were accessing could not be found."}`)
+	}))
+	defer mock.Close()
+	digitalOceanBaseURL = mock.URL
+
+	findZone := findZoneByFqdn
+	findZoneByFqdn = func(fqdn string, nameservers []string) (string, error) {
+		return "example.com.", nil
+	}
+	defer func() { findZoneByFqdn = findZone }()
+
+	doprov, err := NewDNSProviderCredentials(fakeDigitalOceanAuth)
+	if err != nil {
+		t.Fatalf("Expected no error creating provider, but got: %v", err)
+	}
+
+	err = doprov.Present("example.com", "", "foobar")
+	if err == nil {
+		t.Fatal("Expected an error for a domain not in the account, but got none")
+	}
+	if !strings.Contains(err.Error(), "domain example.com not found") || !strings.Contains(err.Error(), "team") {
+		t.Errorf("Expected an error naming the missing domain, but got: %v", err)
+	}
+}
@@ -3,78 +3,143 @@ package dns
 
 import (
 	"fmt"
+	"sort"
+	"sync"
 
 	"github.com/stangah/lego/acme"
+	"github.com/stangah/lego/providers/dns/active24"
+	"github.com/stangah/lego/providers/dns/alidns"
 	"github.com/stangah/lego/providers/dns/auroradns"
 	"github.com/stangah/lego/providers/dns/azure"
+	"github.com/stangah/lego/providers/dns/bunny"
+	"github.com/stangah/lego/providers/dns/civo"
 	"github.com/stangah/lego/providers/dns/cloudflare"
+	"github.com/stangah/lego/providers/dns/desec"
 	"github.com/stangah/lego/providers/dns/digitalocean"
 	"github.com/stangah/lego/providers/dns/dnsimple"
 	"github.com/stangah/lego/providers/dns/dnsmadeeasy"
 	"github.com/stangah/lego/providers/dns/dnspod"
+	"github.com/stangah/lego/providers/dns/dode"
+	"github.com/stangah/lego/providers/dns/domeneshop"
 	"github.com/stangah/lego/providers/dns/dyn"
 	"github.com/stangah/lego/providers/dns/exoscale"
 	"github.com/stangah/lego/providers/dns/gandi"
+	"github.com/stangah/lego/providers/dns/gcore"
 	"github.com/stangah/lego/providers/dns/googlecloud"
+	"github.com/stangah/lego/providers/dns/infoblox"
 	"github.com/stangah/lego/providers/dns/linode"
+	"github.com/stangah/lego/providers/dns/loopia"
+	"github.com/stangah/lego/providers/dns/miab"
 	"github.com/stangah/lego/providers/dns/namecheap"
 	"github.com/stangah/lego/providers/dns/ns1"
 	"github.com/stangah/lego/providers/dns/ovh"
 	"github.com/stangah/lego/providers/dns/pdns"
+	"github.com/stangah/lego/providers/dns/porkbun"
 	"github.com/stangah/lego/providers/dns/rackspace"
 	"github.com/stangah/lego/providers/dns/rfc2136"
 	"github.com/stangah/lego/providers/dns/route53"
+	"github.com/stangah/lego/providers/dns/scaleway"
+	"github.com/stangah/lego/providers/dns/simply"
+	"github.com/stangah/lego/providers/dns/variomedia"
+	"github.com/stangah/lego/providers/dns/vercel"
 	"github.com/stangah/lego/providers/dns/vultr"
+	"github.com/stangah/lego/providers/dns/windnsserver"
+	"github.com/stangah/lego/providers/dns/yandexcloud"
 )
 
+// providerFactory creates a DNS provider configured from the environment.
+type providerFactory func() (acme.ChallengeProvider, error)
+
+// providersMu guards providers against concurrent registrations.
+var providersMu sync.RWMutex
+
+// providers maps the names of the supported DNS providers to their factories.
+var providers = map[string]providerFactory{
+	"active24":     func() (acme.ChallengeProvider, error) { return active24.NewDNSProvider() },
+	"alidns":       func() (acme.ChallengeProvider, error) { return alidns.NewDNSProvider() },
+	"azure":        func() (acme.ChallengeProvider, error) { return azure.NewDNSProvider() },
+	"auroradns":    func() (acme.ChallengeProvider, error) { return auroradns.NewDNSProvider() },
+	"bunny":        func() (acme.ChallengeProvider, error) { return bunny.NewDNSProvider() },
+	"civo":         func() (acme.ChallengeProvider, error) { return civo.NewDNSProvider() },
+	"cloudflare":   func() (acme.ChallengeProvider, error) { return cloudflare.NewDNSProvider() },
+	"desec":        func() (acme.ChallengeProvider, error) { return desec.NewDNSProvider() },
+	"digitalocean": func() (acme.ChallengeProvider, error) { return digitalocean.NewDNSProvider() },
+	"dnsimple":     func() (acme.ChallengeProvider, error) { return dnsimple.NewDNSProvider() },
+	"dnsmadeeasy":  func() (acme.ChallengeProvider, error) { return dnsmadeeasy.NewDNSProvider() },
+	"dnspod":       func() (acme.ChallengeProvider, error) { return dnspod.NewDNSProvider() },
+	"dode":         func() (acme.ChallengeProvider, error) { return dode.NewDNSProvider() },
+	"domeneshop":   func() (acme.ChallengeProvider, error) { return domeneshop.NewDNSProvider() },
+	"dyn":          func() (acme.ChallengeProvider, error) { return dyn.NewDNSProvider() },
+	"exoscale":     func() (acme.ChallengeProvider, error) { return exoscale.NewDNSProvider() },
+	"gandi":        func() (acme.ChallengeProvider, error) { return gandi.NewDNSProvider() },
+	"gcloud":       func() (acme.ChallengeProvider, error) { return googlecloud.NewDNSProvider() },
+	"gcore":        func() (acme.ChallengeProvider, error) { return gcore.NewDNSProvider() },
+	"infoblox":     func() (acme.ChallengeProvider, error) { return infoblox.NewDNSProvider() },
+	"linode":       func() (acme.ChallengeProvider, error) { return linode.NewDNSProvider() },
+	"loopia":       func() (acme.ChallengeProvider, error) { return loopia.NewDNSProvider() },
+	"manual":       func() (acme.ChallengeProvider, error) { return acme.NewDNSProviderManual() },
+	"miab":         func() (acme.ChallengeProvider, error) { return miab.NewDNSProvider() },
+	"namecheap":    func() (acme.ChallengeProvider, error) { return namecheap.NewDNSProvider() },
+	"porkbun":      func() (acme.ChallengeProvider, error) { return porkbun.NewDNSProvider() },
+	"rackspace":    func() (acme.ChallengeProvider, error) { return rackspace.NewDNSProvider() },
+	"route53":      func() (acme.ChallengeProvider, error) { return route53.NewDNSProvider() },
+	"rfc2136":      func() (acme.ChallengeProvider, error) { return rfc2136.NewDNSProvider() },
+	"scaleway":     func() (acme.ChallengeProvider, error) { return scaleway.NewDNSProvider() },
+	"simply":       func() (acme.ChallengeProvider, error) { return simply.NewDNSProvider() },
+	"variomedia":   func() (acme.ChallengeProvider, error) { return variomedia.NewDNSProvider() },
+	"vercel":       func() (acme.ChallengeProvider, error) { return vercel.NewDNSProvider() },
+	"vultr":        func() (acme.ChallengeProvider, error) { return vultr.NewDNSProvider() },
+	"windns":       func() (acme.ChallengeProvider, error) { return windnsserver.NewDNSProvider() },
+	"yandexcloud":  func() (acme.ChallengeProvider, error) { return yandexcloud.NewDNSProvider() },
+	"ovh":          func() (acme.ChallengeProvider, error) { return ovh.NewDNSProvider() },
+	"pdns":         func() (acme.ChallengeProvider, error) { return pdns.NewDNSProvider() },
+	"ns1":          func() (acme.ChallengeProvider, error) { return ns1.NewDNSProvider() },
+}
+
+// RegisterProvider registers a custom DNS provider under name, so that it can
+// be created with NewDNSChallengeProviderByName. An error is returned if a
+// provider is already registered under the same name.
+func RegisterProvider(name string, factory func() (acme.ChallengeProvider, error)) error {
+	if name == "" {
+		return fmt.Errorf("DNS provider name must not be empty")
+	}
+	if factory == nil {
+		return fmt.Errorf("DNS provider factory for %s must not be nil", name)
+	}
+
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	if _, ok := providers[name]; ok {
+		return fmt.Errorf("DNS provider %s is already registered", name)
+	}
+	providers[name] = factory
+	return nil
+}
+
+// SupportedProviders returns the sorted names of all DNS providers which can
+// be created with NewDNSChallengeProviderByName, including those registered
+// with RegisterProvider.
+func SupportedProviders() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewDNSChallengeProviderByName creates the DNS provider registered under
+// name, configured from the environment.
 func NewDNSChallengeProviderByName(name string) (acme.ChallengeProvider, error) {
-	var err error
-	var provider acme.ChallengeProvider
-	switch name {
-	case "azure":
-		provider, err = azure.NewDNSProvider()
-	case "auroradns":
-		provider, err = auroradns.NewDNSProvider()
-	case "cloudflare":
-		provider, err = cloudflare.NewDNSProvider()
-	case "digitalocean":
-		provider, err = digitalocean.NewDNSProvider()
-	case "dnsimple":
-		provider, err = dnsimple.NewDNSProvider()
-	case "dnsmadeeasy":
-		provider, err = dnsmadeeasy.NewDNSProvider()
-	case "dnspod":
-		provider, err = dnspod.NewDNSProvider()
-	case "dyn":
-		provider, err = dyn.NewDNSProvider()
-	case "exoscale":
-		provider, err = exoscale.NewDNSProvider()
-	case "gandi":
-		provider, err = gandi.NewDNSProvider()
-	case "gcloud":
-		provider, err = googlecloud.NewDNSProvider()
-	case "linode":
-		provider, err = linode.NewDNSProvider()
-	case "manual":
-		provider, err = acme.NewDNSProviderManual()
-	case "namecheap":
-		provider, err = namecheap.NewDNSProvider()
-	case "rackspace":
-		provider, err = rackspace.NewDNSProvider()
-	case "route53":
-		provider, err = route53.NewDNSProvider()
-	case "rfc2136":
-		provider, err = rfc2136.NewDNSProvider()
-	case "vultr":
-		provider, err = vultr.NewDNSProvider()
-	case "ovh":
-		provider, err = ovh.NewDNSProvider()
-	case "pdns":
-		provider, err = pdns.NewDNSProvider()
-	case "ns1":
-		provider, err = ns1.NewDNSProvider()
-	default:
-		err = fmt.Errorf("Unrecognised DNS provider: %s", name)
+	providersMu.RLock()
+	factory, ok := providers[name]
+	providersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unrecognised DNS provider: %s", name)
 	}
-	return provider, err
+	return factory()
 }
@@ -3,9 +3,11 @@ package dns
 import (
 	"os"
 	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stangah/lego/acme"
 	"github.com/stangah/lego/providers/dns/exoscale"
 )
 
@@ -48,3 +50,42 @@ func TestUnknownDNSProvider(t *testing.T) {
 	_, err := NewDNSChallengeProviderByName("foobar")
 	assert.Error(t, err)
 }
+
+func TestSupportedProviders(t *testing.T) {
+	expected := []string{
+		"active24", "alidns", "auroradns", "azure", "bunny", "civo", "cloudflare",
+		"desec", "digitalocean", "dnsimple", "dnsmadeeasy", "dnspod", "dode",
+		"domeneshop", "dyn", "exoscale", "gandi", "gcloud", "gcore", "infoblox",
+		"linode", "loopia", "manual", "miab", "namecheap", "ns1", "ovh", "pdns",
+		"porkbun", "rackspace", "rfc2136", "route53", "scaleway", "simply",
+		"variomedia", "vercel", "vultr", "windns", "yandexcloud",
+	}
+	names := SupportedProviders()
+	for _, name := range expected {
+		assert.Contains(t, names, name)
+	}
+	assert.True(t, sort.StringsAreSorted(names), "Expected the provider names to be sorted")
+}
+
+type fakeProvider struct{}
+
+func (fakeProvider) Present(domain, token, keyAuth string) error { return nil }
+func (fakeProvider) CleanUp(domain, token, keyAuth string) error { return nil }
+
+func TestRegisterProvider(t *testing.T) {
+	factory := func() (acme.ChallengeProvider, error) { return fakeProvider{}, nil }
+
+	err := RegisterProvider("fake", factory)
+	assert.NoError(t, err)
+
+	provider, err := NewDNSChallengeProviderByName("fake")
+	assert.NoError(t, err)
+	assert.Equal(t, fakeProvider{}, provider)
+	assert.Contains(t, SupportedProviders(), "fake")
+
+	err = RegisterProvider("fake", factory)
+	assert.EqualError(t, err, "DNS provider fake is already registered")
+
+	err = RegisterProvider("cloudflare", factory)
+	assert.EqualError(t, err, "DNS provider cloudflare is already registered")
+}
@@ -12,6 +12,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/stangah/lego/acme"
@@ -23,6 +24,12 @@ type DNSProvider struct {
 	baseURL   string
 	apiKey    string
 	apiSecret string
+
+	// records holds the records created by Present, keyed by fqdn and
+	// value, so CleanUp only deletes its own record if several challenges
+	// share a name.
+	records   map[string]Record
+	recordsMu sync.Mutex
 }
 
 // Domain holds the DNSMadeEasy API representation of a Domain
@@ -72,6 +79,7 @@ func NewDNSProviderCredentials(baseURL, apiKey, apiSecret string) (*DNSProvider,
 		baseURL:   baseURL,
 		apiKey:    apiKey,
 		apiSecret: apiSecret,
+		records:   make(map[string]Record),
 	}, nil
 }
 
@@ -94,17 +102,39 @@ func (d *DNSProvider) Present(domainName, token, keyAuth string) error {
 	name := strings.Replace(fqdn, "."+authZone, "", 1)
 	record := &Record{Type: "TXT", Name: name, Value: value, TTL: ttl}
 
-	err = d.createRecord(domain, record)
+	created, err := d.createRecord(domain, record)
+	if _, ok := err.(*recordDecodeError); ok {
+		// The record exists, CleanUp looks it up by name and value
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
+	d.recordsMu.Lock()
+	d.records[fqdn+" "+value] = *created
+	d.recordsMu.Unlock()
+
 	return nil
 }
 
-// CleanUp removes the TXT records matching the specified parameters
+// CleanUp removes the TXT record created by Present. Records created by
+// another run are looked up by name and value.
 func (d *DNSProvider) CleanUp(domainName, token, keyAuth string) error {
-	fqdn, _, _ := acme.DNS01Record(domainName, keyAuth)
+	fqdn, value, _ := acme.DNS01Record(domainName, keyAuth)
+
+	d.recordsMu.Lock()
+	record, ok := d.records[fqdn+" "+value]
+	d.recordsMu.Unlock()
+	if ok {
+		if err := d.deleteRecord(record); err != nil {
+			return err
+		}
+		d.recordsMu.Lock()
+		delete(d.records, fqdn+" "+value)
+		d.recordsMu.Unlock()
+		return nil
+	}
 
 	authZone, err := acme.FindZoneByFqdn(fqdn, acme.RecursiveNameservers)
 	if err != nil {
@@ -124,8 +154,11 @@ func (d *DNSProvider) CleanUp(domainName, token, keyAuth string) error {
 		return err
 	}
 
-	// delete records
+	// delete the records with our value, leaving those of other challenges
 	for _, record := range *records {
+		if strings.Trim(record.Value, "\"") != value {
+			continue
+		}
 		err = d.deleteRecord(record)
 		if err != nil {
 			return err
@@ -176,16 +209,39 @@ func (d *DNSProvider) getRecords(domain *Domain, recordName, recordType string)
 	return records.Records, nil
 }
 
-func (d *DNSProvider) createRecord(domain *Domain, record *Record) error {
+// recordDecodeError is returned by createRecord if the record was created,
+// but the response could not be decoded.
+type recordDecodeError struct {
+	name string
+	err  error
+}
+
+func (e *recordDecodeError) Error() string {
+	return fmt.Sprintf("DNSMadeEasy: could not decode the created record %s: %v", e.name, e.err)
+}
+
+// createRecord creates record in domain and returns the created record. If
+// the record was created but the response cannot be decoded, it returns a
+// *recordDecodeError.
+func (d *DNSProvider) createRecord(domain *Domain, record *Record) (*Record, error) {
 	url := fmt.Sprintf("%s/%d/%s", "/dns/managed", domain.ID, "records")
 
 	resp, err := d.sendRequest("POST", url, record)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	return nil
+	created := &Record{}
+	err = json.NewDecoder(resp.Body).Decode(created)
+	if err != nil {
+		return nil, &recordDecodeError{name: record.Name, err: err}
+	}
+	if created.SourceID == 0 {
+		created.SourceID = domain.ID
+	}
+
+	return created, nil
 }
 
 func (d *DNSProvider) deleteRecord(record Record) error {
@@ -220,6 +276,7 @@ func (d *DNSProvider) sendRequest(method, resource string, payload interface{})
 	req.Header.Set("x-dnsme-hmac", signature)
 	req.Header.Set("accept", "application/json")
 	req.Header.Set("content-type", "application/json")
+	req.Header.Set("User-Agent", acme.UserAgentString())
 
 	transport := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
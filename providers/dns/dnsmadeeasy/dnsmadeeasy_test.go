@@ -1,9 +1,18 @@
 package dnsmadeeasy
 
 import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/miekg/dns"
+	"github.com/stangah/lego/acme"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -35,3 +44,150 @@ func TestPresentAndCleanup(t *testing.T) {
 	err = provider.CleanUp(testDomain, "", "123d==")
 	assert.NoError(t, err)
 }
+
+// mockDNSMadeEasy serves the records of the domain example.com with id 1.
+type mockDNSMadeEasy struct {
+	mu      sync.Mutex
+	records map[int]Record
+	nextID  int
+	deleted []int
+	// garbled makes the responses to created records undecodable.
+	garbled bool
+}
+
+func (m *mockDNSMadeEasy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/dns/managed/name":
+		json.NewEncoder(w).Encode(Domain{ID: 1, Name: r.URL.Query().Get("domainname")})
+	case r.Method == "POST" && r.URL.Path == "/dns/managed/1/records":
+		var record Record
+		json.NewDecoder(r.Body).Decode(&record)
+		m.nextID++
+		record.ID = m.nextID
+		record.SourceID = 1
+		m.records[record.ID] = record
+		w.WriteHeader(http.StatusCreated)
+		if m.garbled {
+			w.Write([]byte("<html>Created</html>"))
+			return
+		}
+		json.NewEncoder(w).Encode(record)
+	case r.Method == "GET" && r.URL.Path == "/dns/managed/1/records":
+		var records []Record
+		for _, record := range m.records {
+			if record.Name == r.URL.Query().Get("recordName") && record.Type == r.URL.Query().Get("type") {
+				records = append(records, record)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": records})
+	case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/dns/managed/1/records/"):
+		id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/dns/managed/1/records/"))
+		if _, ok := m.records[id]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(m.records, id)
+		m.deleted = append(m.deleted, id)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// withExampleZone makes acme.FindZoneByFqdn find the zone example.com at a
+// local nameserver until the returned function is called.
+func withExampleZone(t *testing.T) func() {
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Name == "example.com." {
+			soa, _ := dns.NewRR("example.com. 3600 IN SOA ns.example.com. admin.example.com. 1 3600 600 86400 60")
+			m.Answer = append(m.Answer, soa)
+		}
+		w.WriteMsg(m)
+	})
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: pc, Handler: handler}
+	go server.ActivateAndServe()
+
+	nameservers := acme.RecursiveNameservers
+	acme.RecursiveNameservers = []string{pc.LocalAddr().String()}
+	return func() {
+		acme.RecursiveNameservers = nameservers
+		server.Shutdown()
+	}
+}
+
+func TestDNSMadeEasySharedName(t *testing.T) {
+	defer withExampleZone(t)()
+	mock := &mockDNSMadeEasy{records: map[int]Record{}}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	provider, err := NewDNSProviderCredentials(server.URL, "key", "secret")
+	assert.NoError(t, err)
+
+	// Two challenges for the same name, e.g. a retried authorization.
+	assert.NoError(t, provider.Present("www.example.com", "", "key1"))
+	assert.NoError(t, provider.Present("www.example.com", "", "key2"))
+	assert.Len(t, mock.records, 2)
+	assert.Len(t, provider.records, 2)
+
+	_, value2, _ := acme.DNS01Record("www.example.com", "key2")
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "key1"))
+	assert.Equal(t, []int{1}, mock.deleted)
+	if assert.Len(t, mock.records, 1) {
+		assert.Equal(t, value2, mock.records[2].Value)
+	}
+
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "key2"))
+	assert.Equal(t, []int{1, 2}, mock.deleted)
+	assert.Empty(t, mock.records)
+	assert.Empty(t, provider.records)
+}
+
+func TestDNSMadeEasyCleanUpLooksUpRecord(t *testing.T) {
+	defer withExampleZone(t)()
+	mock := &mockDNSMadeEasy{records: map[int]Record{}}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	provider, err := NewDNSProviderCredentials(server.URL, "key", "secret")
+	assert.NoError(t, err)
+	assert.NoError(t, provider.Present("www.example.com", "", "key1"))
+	assert.NoError(t, provider.Present("www.example.com", "", "key2"))
+
+	// A new provider does not know the ids, but only deletes its value.
+	provider, err = NewDNSProviderCredentials(server.URL, "key", "secret")
+	assert.NoError(t, err)
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "key2"))
+	assert.Equal(t, []int{2}, mock.deleted)
+	assert.Len(t, mock.records, 1)
+}
+
+func TestDNSMadeEasyPresentUndecodableResponse(t *testing.T) {
+	defer withExampleZone(t)()
+	mock := &mockDNSMadeEasy{records: map[int]Record{}, garbled: true}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	provider, err := NewDNSProviderCredentials(server.URL, "key", "secret")
+	assert.NoError(t, err)
+
+	// The record exists, so Present succeeds and CleanUp looks it up.
+	assert.NoError(t, provider.Present("www.example.com", "", "key1"))
+	assert.Len(t, mock.records, 1)
+	assert.Empty(t, provider.records)
+
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "key1"))
+	assert.Equal(t, []int{1}, mock.deleted)
+	assert.Empty(t, mock.records)
+
+	_, err = provider.createRecord(&Domain{ID: 1}, &Record{Type: "TXT", Name: "_acme-challenge.www", Value: "value"})
+	assert.IsType(t, &recordDecodeError{}, err)
+}
@@ -55,14 +55,14 @@ func (c *DNSProvider) Present(domain, token, keyAuth string) error {
 
 // CleanUp removes the TXT record matching the specified parameters.
 func (c *DNSProvider) CleanUp(domain, token, keyAuth string) error {
-	fqdn, _, _ := acme.DNS01Record(domain, keyAuth)
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
 
-	records, err := c.findTxtRecords(domain, fqdn)
+	zoneID, zoneName, err := c.getHostedZone(domain)
 	if err != nil {
 		return err
 	}
 
-	zoneID, _, err := c.getHostedZone(domain)
+	records, err := c.findTxtRecords(zoneID, zoneName, fqdn, value)
 	if err != nil {
 		return err
 	}
@@ -70,36 +70,37 @@ func (c *DNSProvider) CleanUp(domain, token, keyAuth string) error {
 	for _, rec := range records {
 		_, err := c.client.Domains.DeleteRecord(zoneID, rec.ID)
 		if err != nil {
-			return err
+			return fmt.Errorf("dnspod API call failed: %v", err)
 		}
 	}
 	return nil
 }
 
+// getHostedZone returns the ID and name of the most specific zone of the
+// account containing domain.
 func (c *DNSProvider) getHostedZone(domain string) (string, string, error) {
 	zones, _, err := c.client.Domains.List()
 	if err != nil {
 		return "", "", fmt.Errorf("dnspod API call failed: %v", err)
 	}
 
-	authZone, err := acme.FindZoneByFqdn(acme.ToFqdn(domain), acme.RecursiveNameservers)
-	if err != nil {
-		return "", "", err
-	}
-
+	name := strings.ToLower(acme.UnFqdn(domain))
 	var hostedZone dnspod.Domain
 	for _, zone := range zones {
-		if zone.Name == acme.UnFqdn(authZone) {
+		zoneName := strings.ToLower(zone.Name)
+		if zoneName != name && !strings.HasSuffix(name, "."+zoneName) {
+			continue
+		}
+		if len(zoneName) > len(hostedZone.Name) {
 			hostedZone = zone
 		}
 	}
 
 	if hostedZone.ID == 0 {
-		return "", "", fmt.Errorf("Zone %s not found in dnspod for domain %s", authZone, domain)
-
+		return "", "", fmt.Errorf("Zone not found in dnspod for domain %s", domain)
 	}
 
-	return fmt.Sprintf("%v", hostedZone.ID), hostedZone.Name, nil
+	return fmt.Sprintf("%v", hostedZone.ID), strings.ToLower(hostedZone.Name), nil
 }
 
 func (c *DNSProvider) newTxtRecord(zone, fqdn, value string, ttl int) *dnspod.Record {
@@ -114,22 +115,20 @@ func (c *DNSProvider) newTxtRecord(zone, fqdn, value string, ttl int) *dnspod.Re
 	}
 }
 
-func (c *DNSProvider) findTxtRecords(domain, fqdn string) ([]dnspod.Record, error) {
-	zoneID, zoneName, err := c.getHostedZone(domain)
-	if err != nil {
-		return nil, err
-	}
+// findTxtRecords returns the TXT records at fqdn containing value. The
+// record list is filtered by sub_domain on the server, so the result is not
+// cut off by the paging of large zones.
+func (c *DNSProvider) findTxtRecords(zoneID, zoneName, fqdn, value string) ([]dnspod.Record, error) {
+	recordName := c.extractRecordName(fqdn, zoneName)
 
 	var records []dnspod.Record
-	result, _, err := c.client.Domains.ListRecords(zoneID, "")
+	result, _, err := c.client.Domains.ListRecords(zoneID, recordName)
 	if err != nil {
 		return records, fmt.Errorf("dnspod API call has failed: %v", err)
 	}
 
-	recordName := c.extractRecordName(fqdn, zoneName)
-
 	for _, record := range result {
-		if record.Name == recordName {
+		if strings.EqualFold(record.Name, recordName) && record.Type == "TXT" && record.Value == value {
 			records = append(records, record)
 		}
 	}
@@ -137,10 +136,14 @@ func (c *DNSProvider) findTxtRecords(domain, fqdn string) ([]dnspod.Record, erro
 	return records, nil
 }
 
+// extractRecordName returns the name of fqdn relative to the zone domain,
+// e.g. "_acme-challenge.a.b" for "_acme-challenge.a.b.example.com." in the
+// zone example.com, which dnspod expects as sub_domain.
 func (c *DNSProvider) extractRecordName(fqdn, domain string) string {
-	name := acme.UnFqdn(fqdn)
-	if idx := strings.Index(name, "."+domain); idx != -1 {
-		return name[:idx]
+	name := strings.ToLower(acme.UnFqdn(fqdn))
+	domain = strings.ToLower(acme.UnFqdn(domain))
+	if name == domain {
+		return "@"
 	}
-	return name
+	return strings.TrimSuffix(name, "."+domain)
 }
@@ -45,6 +45,24 @@ func TestNewDNSProviderMissingCredErr(t *testing.T) {
 	restorednspodEnv()
 }
 
+func TestDNSPodRecordName(t *testing.T) {
+	provider, err := NewDNSProviderCredentials("123")
+	assert.NoError(t, err)
+
+	for fqdn, name := range map[string]string{
+		"_acme-challenge.example.com.":             "_acme-challenge",
+		"_acme-challenge.sub.example.com.":         "_acme-challenge.sub",
+		"_acme-challenge.a.b.c.example.com.":       "_acme-challenge.a.b.c",
+		"_acme-challenge.example.com.example.com.": "_acme-challenge.example.com",
+		"_acme-challenge.Deep.Sub.EXAMPLE.com.":    "_acme-challenge.deep.sub",
+		"example.com.":                             "@",
+	} {
+		record := provider.newTxtRecord("example.com", fqdn, "value", 120)
+		assert.Equal(t, name, record.Name, "Unexpected sub_domain for %s", fqdn)
+		assert.Equal(t, "TXT", record.Type)
+	}
+}
+
 func TestLivednspodPresent(t *testing.T) {
 	if !dnspodLiveTest {
 		t.Skip("skipping live test")
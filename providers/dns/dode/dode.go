@@ -0,0 +1,85 @@
+// Package dode implements a DNS provider for solving the DNS-01 challenge
+// using Domain Offensive (do.de).
+package dode
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/stangah/lego/acme"
+)
+
+// Domain Offensive API reference: https://www.do.de/wiki/LetsEncrypt_-_Entwickler
+
+// dodeBaseURL is the URL of the Let's Encrypt endpoint of the do.de API.
+var dodeBaseURL = "https://www.do.de/api/letsencrypt"
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses the Let's Encrypt endpoint of do.de to set the TXT record of a
+// domain. The endpoint manages a single TXT value per challenge name.
+type DNSProvider struct {
+	token string
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for do.de. The
+// API token must be passed in the environment variable DODE_TOKEN.
+func NewDNSProvider() (*DNSProvider, error) {
+	return NewDNSProviderCredentials(os.Getenv("DODE_TOKEN"))
+}
+
+// NewDNSProviderCredentials uses the supplied API token to return a
+// DNSProvider instance configured for do.de.
+func NewDNSProviderCredentials(token string) (*DNSProvider, error) {
+	if token == "" {
+		return nil, fmt.Errorf("do.de credentials missing")
+	}
+	return &DNSProvider{token: token}, nil
+}
+
+// Present sets the TXT record of the challenge name to the challenge value.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	return d.updateTxtRecord(fqdn, value)
+}
+
+// CleanUp clears the TXT record of the challenge name.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, _, _ := acme.DNS01Record(domain, keyAuth)
+	return d.updateTxtRecord(fqdn, "")
+}
+
+// updateTxtRecord sets the TXT record fqdn to value. An empty value
+// clears the record.
+func (d *DNSProvider) updateTxtRecord(fqdn, value string) error {
+	query := url.Values{}
+	query.Set("token", d.token)
+	query.Set("domain", acme.UnFqdn(fqdn))
+	query.Set("value", value)
+
+	req, err := http.NewRequest("GET", dodeBaseURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", acme.UserAgentString())
+
+	resp, err := acme.DoWithRetry(req, nil)
+	if err != nil {
+		return fmt.Errorf("do.de API call failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil && resp.StatusCode < 400 {
+		return fmt.Errorf("do.de API call failed: %v", err)
+	}
+	if resp.StatusCode >= 400 || !result.Success {
+		return fmt.Errorf("do.de API call failed: HTTP %d: %s", resp.StatusCode, result.Error)
+	}
+	return nil
+}
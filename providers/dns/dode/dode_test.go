@@ -0,0 +1,71 @@
+package dode
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stangah/lego/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockDode records the queries of the requests it receives.
+func mockDode(queries *[]url.Values) func() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*queries = append(*queries, r.URL.Query())
+		if r.URL.Query().Get("token") != "secret" {
+			fmt.Fprint(w, `{"success":false,"error":"invalid token"}`)
+			return
+		}
+		fmt.Fprint(w, `{"success":true}`)
+	}))
+
+	baseURL := dodeBaseURL
+	dodeBaseURL = server.URL + "/api/letsencrypt"
+	return func() {
+		dodeBaseURL = baseURL
+		server.Close()
+	}
+}
+
+func TestDodePresentAndCleanUp(t *testing.T) {
+	var queries []url.Values
+	defer mockDode(&queries)()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+
+	assert.NoError(t, provider.Present("www.example.com", "", "key"))
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "key"))
+
+	_, value, _ := acme.DNS01Record("www.example.com", "key")
+	assert.Equal(t, []url.Values{
+		{"token": {"secret"}, "domain": {"_acme-challenge.www.example.com"}, "value": {value}},
+		{"token": {"secret"}, "domain": {"_acme-challenge.www.example.com"}, "value": {""}},
+	}, queries)
+}
+
+func TestDodeErrors(t *testing.T) {
+	var queries []url.Values
+	defer mockDode(&queries)()
+
+	provider, err := NewDNSProviderCredentials("wrong")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.com", "", "key"), "do.de API call failed: HTTP 200: invalid token")
+}
+
+func TestNewDNSProviderCredentials(t *testing.T) {
+	defer os.Setenv("DODE_TOKEN", os.Getenv("DODE_TOKEN"))
+
+	os.Setenv("DODE_TOKEN", "")
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "do.de credentials missing")
+
+	os.Setenv("DODE_TOKEN", "secret")
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", provider.token)
+}
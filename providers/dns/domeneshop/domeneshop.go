@@ -0,0 +1,182 @@
+// Package domeneshop implements a DNS provider for solving the DNS-01
+// challenge using Domeneshop DNS.
+package domeneshop
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/stangah/lego/acme"
+)
+
+// Domeneshop API reference: https://api.domeneshop.no/docs/
+
+// domeneshopBaseURL is the base URL of the Domeneshop API.
+var domeneshopBaseURL = "https://api.domeneshop.no/v0"
+
+// defaultTTL is the TTL of the TXT records created by the provider.
+const defaultTTL = 300
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses Domeneshop's API to manage TXT records for a domain.
+type DNSProvider struct {
+	token  string
+	secret string
+	ttl    int
+}
+
+// dnsRecord is a DNS record of the Domeneshop API.
+type dnsRecord struct {
+	ID   int    `json:"id,omitempty"`
+	Host string `json:"host"`
+	TTL  int    `json:"ttl,omitempty"`
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Domeneshop.
+// The API credentials must be passed in the environment variables
+// DOMENESHOP_API_TOKEN and DOMENESHOP_API_SECRET.
+func NewDNSProvider() (*DNSProvider, error) {
+	return NewDNSProviderCredentials(os.Getenv("DOMENESHOP_API_TOKEN"), os.Getenv("DOMENESHOP_API_SECRET"))
+}
+
+// NewDNSProviderCredentials uses the supplied API token and secret to
+// return a DNSProvider instance configured for Domeneshop.
+func NewDNSProviderCredentials(token, secret string) (*DNSProvider, error) {
+	if token == "" || secret == "" {
+		return nil, fmt.Errorf("Domeneshop credentials missing")
+	}
+	return &DNSProvider{token: token, secret: secret, ttl: defaultTTL}, nil
+}
+
+// SetTTL sets the TTL in seconds of the TXT records created by the provider.
+func (d *DNSProvider) SetTTL(ttl int) error {
+	if ttl <= 0 {
+		return fmt.Errorf("Domeneshop TTL must be positive, got %d", ttl)
+	}
+	d.ttl = ttl
+	return nil
+}
+
+// Present creates a TXT record for the challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	domainID, host, err := d.recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	record := dnsRecord{Host: host, TTL: d.ttl, Type: "TXT", Data: value}
+	return d.doRequest("POST", fmt.Sprintf("/domains/%d/dns", domainID), record, nil)
+}
+
+// CleanUp deletes the TXT records with the challenge value, leaving other
+// values of the challenge name alone.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	domainID, host, err := d.recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("host", host)
+	query.Set("type", "TXT")
+
+	var records []dnsRecord
+	if err := d.doRequest("GET", fmt.Sprintf("/domains/%d/dns?%s", domainID, query.Encode()), nil, &records); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if record.Type != "TXT" || record.Host != host || record.Data != value {
+			continue
+		}
+		if err := d.doRequest("DELETE", fmt.Sprintf("/domains/%d/dns/%d", domainID, record.ID), nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordLocation returns the ID of the Domeneshop domain containing fqdn
+// and the host name of fqdn relative to it. The most specific domain of
+// the account is used, and the apex is named "@".
+func (d *DNSProvider) recordLocation(fqdn string) (int, string, error) {
+	var domains []struct {
+		ID     int    `json:"id"`
+		Domain string `json:"domain"`
+	}
+	if err := d.doRequest("GET", "/domains", nil, &domains); err != nil {
+		return 0, "", err
+	}
+
+	name := strings.ToLower(acme.UnFqdn(fqdn))
+	var id int
+	var zone string
+	for _, domain := range domains {
+		zoneName := strings.ToLower(acme.UnFqdn(domain.Domain))
+		if zoneName != name && !strings.HasSuffix(name, "."+zoneName) {
+			continue
+		}
+		if len(zoneName) > len(zone) {
+			id, zone = domain.ID, zoneName
+		}
+	}
+	if zone == "" {
+		return 0, "", fmt.Errorf("Domeneshop: no domain found for %s", fqdn)
+	}
+
+	if name == zone {
+		return id, "@", nil
+	}
+	return id, strings.TrimSuffix(name, "."+zone), nil
+}
+
+func (d *DNSProvider) doRequest(method, uri string, body, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, domeneshopBaseURL+uri, reqBody)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(d.token, d.secret)
+	req.Header.Set("User-Agent", acme.UserAgentString())
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := acme.DoWithRetry(req, nil)
+	if err != nil {
+		return fmt.Errorf("Domeneshop API call failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var errInfo struct {
+			Help string `json:"help"`
+			Code string `json:"code"`
+		}
+		json.NewDecoder(resp.Body).Decode(&errInfo)
+		return fmt.Errorf("Domeneshop API call failed: HTTP %d: %s %s", resp.StatusCode, errInfo.Code, errInfo.Help)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
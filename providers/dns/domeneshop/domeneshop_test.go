@@ -0,0 +1,271 @@
+package domeneshop
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stangah/lego/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	domeneshopLiveTest bool
+	domeneshopToken    string
+	domeneshopSecret   string
+	domeneshopDomain   string
+)
+
+func init() {
+	domeneshopToken = os.Getenv("DOMENESHOP_API_TOKEN")
+	domeneshopSecret = os.Getenv("DOMENESHOP_API_SECRET")
+	domeneshopDomain = os.Getenv("DOMENESHOP_DOMAIN")
+	if len(domeneshopToken) > 0 && len(domeneshopSecret) > 0 && len(domeneshopDomain) > 0 {
+		domeneshopLiveTest = true
+	}
+}
+
+func restoreDomeneshopEnv() {
+	os.Setenv("DOMENESHOP_API_TOKEN", domeneshopToken)
+	os.Setenv("DOMENESHOP_API_SECRET", domeneshopSecret)
+}
+
+// fakeDomeneshop serves the DNS records of the domains example.com (ID 1)
+// and sub.example.com (ID 2) from memory.
+type fakeDomeneshop struct {
+	mu      sync.Mutex
+	records map[int][]dnsRecord
+	deleted []string
+	next    int
+}
+
+func (f *fakeDomeneshop) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if user, pass, ok := r.BasicAuth(); !ok || user != "token" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"help":"Authentication failed","code":"unauthorized"}`)
+		return
+	}
+
+	if r.URL.Path == "/domains" {
+		fmt.Fprint(w, `[{"id":1,"domain":"example.com"},{"id":2,"domain":"sub.example.com"}]`)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/domains/"), "/")
+	domainID, _ := strconv.Atoi(parts[0])
+	switch {
+	case r.Method == "POST" && len(parts) == 2:
+		var record dnsRecord
+		json.NewDecoder(r.Body).Decode(&record)
+		f.next++
+		record.ID = f.next
+		f.records[domainID] = append(f.records[domainID], record)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"id":%d}`, record.ID)
+	case r.Method == "GET" && len(parts) == 2:
+		records := []dnsRecord{}
+		for _, record := range f.records[domainID] {
+			if record.Host == r.URL.Query().Get("host") && record.Type == r.URL.Query().Get("type") {
+				records = append(records, record)
+			}
+		}
+		json.NewEncoder(w).Encode(records)
+	case r.Method == "DELETE" && len(parts) == 3:
+		f.deleted = append(f.deleted, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// mockDomeneshop serves the Domeneshop API with handler until the returned
+// function is called.
+func mockDomeneshop(handler http.Handler) func() {
+	server := httptest.NewServer(handler)
+	baseURL := domeneshopBaseURL
+	domeneshopBaseURL = server.URL
+	return func() {
+		domeneshopBaseURL = baseURL
+		server.Close()
+	}
+}
+
+func TestNewDNSProviderValid(t *testing.T) {
+	os.Setenv("DOMENESHOP_API_TOKEN", "")
+	os.Setenv("DOMENESHOP_API_SECRET", "")
+	defer restoreDomeneshopEnv()
+	_, err := NewDNSProviderCredentials("token", "secret")
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	os.Setenv("DOMENESHOP_API_TOKEN", "token")
+	os.Setenv("DOMENESHOP_API_SECRET", "secret")
+	defer restoreDomeneshopEnv()
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, "token", provider.token)
+	assert.Equal(t, "secret", provider.secret)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	os.Setenv("DOMENESHOP_API_TOKEN", "token")
+	os.Setenv("DOMENESHOP_API_SECRET", "")
+	defer restoreDomeneshopEnv()
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "Domeneshop credentials missing")
+}
+
+func TestDomeneshopPresent(t *testing.T) {
+	var requests []string
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockDomeneshop(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.String())
+
+		user, pass, _ := r.BasicAuth()
+		assert.Equal(t, "token", user)
+		assert.Equal(t, "secret", pass)
+
+		switch r.URL.Path {
+		case "/domains":
+			fmt.Fprint(w, `[{"id":1,"domain":"example.com"}]`)
+		case "/domains/1/dns":
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			reqBody, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, `{"host":"_acme-challenge.www","ttl":300,"type":"TXT","data":"`+value+`"}`, string(reqBody))
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"id":1}`)
+		}
+	}))()
+
+	provider, err := NewDNSProviderCredentials("token", "secret")
+	assert.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"GET /domains", "POST /domains/1/dns"}, requests)
+}
+
+func TestDomeneshopCleanUp(t *testing.T) {
+	var requests []string
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockDomeneshop(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.String())
+
+		switch {
+		case r.URL.Path == "/domains":
+			fmt.Fprint(w, `[{"id":1,"domain":"example.com"}]`)
+		case r.Method == "GET":
+			fmt.Fprintf(w, `[{"id":7,"host":"_acme-challenge.www","ttl":300,"type":"TXT","data":"other"},{"id":8,"host":"_acme-challenge.www","ttl":300,"type":"TXT","data":%q}]`, value)
+		case r.Method == "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))()
+
+	provider, err := NewDNSProviderCredentials("token", "secret")
+	assert.NoError(t, err)
+
+	// Only the record with our value is deleted.
+	err = provider.CleanUp("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"GET /domains",
+		"GET /domains/1/dns?host=_acme-challenge.www&type=TXT",
+		"DELETE /domains/1/dns/8",
+	}, requests)
+}
+
+func TestDomeneshopMostSpecificDomain(t *testing.T) {
+	fake := &fakeDomeneshop{records: map[int][]dnsRecord{}}
+	defer mockDomeneshop(fake)()
+
+	provider, err := NewDNSProviderCredentials("token", "secret")
+	assert.NoError(t, err)
+
+	// Names are matched case insensitively against the most specific domain
+	// of the account.
+	assert.NoError(t, provider.Present("www.Sub.Example.com", "", "key"))
+	assert.NoError(t, provider.Present("www.sub.example.com", "", "other"))
+	assert.NoError(t, provider.Present("example.com", "", "key"))
+
+	_, value, _ := acme.DNS01Record("www.sub.example.com", "key")
+	if assert.Len(t, fake.records[2], 2) {
+		assert.Equal(t, dnsRecord{ID: 1, Host: "_acme-challenge.www", TTL: 300, Type: "TXT", Data: value}, fake.records[2][0])
+	}
+	if assert.Len(t, fake.records[1], 1) {
+		assert.Equal(t, "_acme-challenge", fake.records[1][0].Host)
+	}
+
+	assert.NoError(t, provider.CleanUp("www.sub.example.com", "", "key"))
+	assert.Equal(t, []string{"/domains/2/dns/1"}, fake.deleted)
+}
+
+func TestDomeneshopApex(t *testing.T) {
+	fake := &fakeDomeneshop{records: map[int][]dnsRecord{}}
+	defer mockDomeneshop(fake)()
+
+	acme.DNS01RecordName = func(domain, fqdn string) string { return "example.com." }
+	defer func() { acme.DNS01RecordName = nil }()
+
+	provider, err := NewDNSProviderCredentials("token", "secret")
+	assert.NoError(t, err)
+	assert.NoError(t, provider.SetTTL(3600))
+	assert.NoError(t, provider.Present("example.com", "", "key"))
+	if assert.Len(t, fake.records[1], 1) {
+		assert.Equal(t, "@", fake.records[1][0].Host)
+		assert.Equal(t, 3600, fake.records[1][0].TTL)
+	}
+}
+
+func TestDomeneshopPresentFailed(t *testing.T) {
+	fake := &fakeDomeneshop{records: map[int][]dnsRecord{}}
+	defer mockDomeneshop(fake)()
+
+	provider, err := NewDNSProviderCredentials("token", "wrong")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.com", "", "key"), "Domeneshop API call failed: HTTP 401: unauthorized Authentication failed")
+
+	provider, err = NewDNSProviderCredentials("token", "secret")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.org", "", "key"), "Domeneshop: no domain found for _acme-challenge.example.org.")
+}
+
+func TestLiveDomeneshopPresent(t *testing.T) {
+	if !domeneshopLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	provider, err := NewDNSProviderCredentials(domeneshopToken, domeneshopSecret)
+	assert.NoError(t, err)
+
+	err = provider.Present(domeneshopDomain, "", "123d==")
+	assert.NoError(t, err)
+}
+
+func TestLiveDomeneshopCleanUp(t *testing.T) {
+	if !domeneshopLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	time.Sleep(time.Second * 1)
+
+	provider, err := NewDNSProviderCredentials(domeneshopToken, domeneshopSecret)
+	assert.NoError(t, err)
+
+	err = provider.CleanUp(domeneshopDomain, "", "123d==")
+	assert.NoError(t, err)
+}
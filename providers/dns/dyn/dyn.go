@@ -76,6 +76,7 @@ func (d *DNSProvider) sendRequest(method, resource string, payload interface{})
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", acme.UserAgentString())
 	if len(d.token) > 0 {
 		req.Header.Set("Auth-Token", d.token)
 	}
@@ -152,6 +153,7 @@ func (d *DNSProvider) logout() error {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", acme.UserAgentString())
 	req.Header.Set("Auth-Token", d.token)
 
 	client := &http.Client{Timeout: time.Duration(10 * time.Second)}
@@ -247,6 +249,7 @@ func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", acme.UserAgentString())
 	req.Header.Set("Auth-Token", d.token)
 
 	client := &http.Client{Timeout: time.Duration(10 * time.Second)}
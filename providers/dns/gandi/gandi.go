@@ -10,6 +10,8 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -27,13 +29,26 @@ var (
 	// findZoneByFqdn determines the DNS zone of an fqdn. It is overridden
 	// during tests.
 	findZoneByFqdn = acme.FindZoneByFqdn
+	// challengeZoneName matches the names of the temporary zones
+	// created by Present and captures their creation date.
+	challengeZoneName = regexp.MustCompile(`^.+ \[ACME Challenge ([^\]]+)\]$`)
 )
 
 // inProgressInfo contains information about an in-progress challenge
 type inProgressInfo struct {
-	zoneID    int    // zoneID of gandi zone to restore in CleanUp
-	newZoneID int    // zoneID of temporary gandi zone containing TXT record
-	authZone  string // the domain name registered at gandi with trailing "."
+	zoneID     int    // zoneID of gandi zone to restore in CleanUp
+	newZoneID  int    // zoneID of temporary gandi zone containing TXT record, 0 if zoneID was edited in place
+	version    int    // version of zoneID to restore in CleanUp if edited in place
+	newVersion int    // version of zoneID containing TXT record if edited in place
+	authZone   string // the domain name registered at gandi with trailing "."
+}
+
+// zoneInfo contains the information about a gandi zone needed to
+// decide whether it can be edited in place.
+type zoneInfo struct {
+	public  bool // zone is shared between gandi customers and read-only
+	domains int  // number of domains using the zone
+	version int  // active version of the zone
 }
 
 // DNSProvider is an implementation of the
@@ -41,16 +56,38 @@ type inProgressInfo struct {
 // API to manage TXT records for a domain.
 type DNSProvider struct {
 	apiKey              string
+	personalAccessToken string
+	endpoint            string
+	ttl                 int
 	inProgressFQDNs     map[string]inProgressInfo
 	inProgressAuthZones map[string]struct{}
 	inProgressMu        sync.Mutex
 }
 
 // NewDNSProvider returns a DNSProvider instance configured for Gandi.
-// Credentials must be passed in the environment variable: GANDI_API_KEY.
+// Credentials must be passed in the environment variable GANDI_API_KEY
+// or GANDI_PERSONAL_ACCESS_TOKEN. The endpoint of the API may be
+// changed with GANDI_ENDPOINT and the TTL of the TXT records in seconds
+// with GANDI_TTL, a TTL of 0 uses the TTL of the zone.
 func NewDNSProvider() (*DNSProvider, error) {
 	apiKey := os.Getenv("GANDI_API_KEY")
-	return NewDNSProviderCredentials(apiKey)
+	token := os.Getenv("GANDI_PERSONAL_ACCESS_TOKEN")
+	if apiKey == "" && token == "" {
+		return nil, fmt.Errorf("No Gandi API Key or Personal Access Token given")
+	}
+	d := newDNSProvider(apiKey)
+	d.SetPersonalAccessToken(token)
+	d.SetEndpoint(os.Getenv("GANDI_ENDPOINT"))
+	if v := os.Getenv("GANDI_TTL"); v != "" {
+		ttl, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("Gandi TTL %q is not a number", v)
+		}
+		if err := d.SetTTL(ttl); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
 }
 
 // NewDNSProviderCredentials uses the supplied credentials to return a
@@ -59,19 +96,64 @@ func NewDNSProviderCredentials(apiKey string) (*DNSProvider, error) {
 	if apiKey == "" {
 		return nil, fmt.Errorf("No Gandi API Key given")
 	}
+	return newDNSProvider(apiKey), nil
+}
+
+// NewDNSProviderPersonalAccessToken returns a DNSProvider instance
+// configured for Gandi which authenticates with a Personal Access Token
+// instead of an API key.
+func NewDNSProviderPersonalAccessToken(token string) (*DNSProvider, error) {
+	if token == "" {
+		return nil, fmt.Errorf("No Gandi Personal Access Token given")
+	}
+	d := newDNSProvider("")
+	d.SetPersonalAccessToken(token)
+	return d, nil
+}
+
+func newDNSProvider(apiKey string) *DNSProvider {
 	return &DNSProvider{
 		apiKey:              apiKey,
+		ttl:                 300,
 		inProgressFQDNs:     make(map[string]inProgressInfo),
 		inProgressAuthZones: make(map[string]struct{}),
-	}, nil
+	}
 }
 
-// Present creates a TXT record using the specified parameters. It
-// does this by creating and activating a new temporary Gandi DNS
-// zone. This new zone contains the TXT record.
+// SetPersonalAccessToken makes the provider send token in the
+// Authorization header of its requests, in addition to the API key, if
+// any. This allows to migrate from API keys to Personal Access Tokens.
+func (d *DNSProvider) SetPersonalAccessToken(token string) {
+	d.personalAccessToken = token
+}
+
+// SetEndpoint sets the URL of the Gandi API used by the provider. An
+// empty url selects the default endpoint.
+func (d *DNSProvider) SetEndpoint(url string) {
+	d.endpoint = url
+}
+
+// SetTTL sets the TTL in seconds of the TXT records created by the provider,
+// 300 by default. TTLs below the Gandi minimum of 300 are raised to it. A
+// TTL of zero omits the TTL from the request, so that the record uses the
+// TTL of the zone.
+func (d *DNSProvider) SetTTL(ttl int) error {
+	if ttl < 0 {
+		return fmt.Errorf("Gandi TTL must not be negative, got %d", ttl)
+	}
+	d.ttl = ttl
+	return nil
+}
+
+// Present creates a TXT record using the specified parameters. If the
+// domain uses a private zone of its own, it does this by creating and
+// activating a new version of that zone containing the TXT record.
+// Otherwise it creates and activates a new temporary Gandi DNS zone,
+// cloned from the current one, which contains the TXT record.
 func (d *DNSProvider) Present(domain, token, keyAuth string) error {
-	fqdn, value, ttl := acme.DNS01Record(domain, keyAuth)
-	if ttl < 300 {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	ttl := d.ttl
+	if ttl > 0 && ttl < 300 {
 		ttl = 300 // 300 is gandi minimum value for ttl
 	}
 	// find authZone and Gandi zone_id for fqdn
@@ -99,6 +181,40 @@ func (d *DNSProvider) Present(domain, token, keyAuth string) error {
 			"Gandi DNS: challenge already in progress for authZone %s",
 			authZone)
 	}
+	info, err := d.getZoneInfo(zoneID)
+	if err != nil {
+		return err
+	}
+	if !info.public && info.domains <= 1 {
+		// the zone is only used by this domain, so edit it in place
+		// instead of cloning it
+		var newVersion int
+		newVersion, err = d.newZoneVersion(zoneID)
+		if err != nil {
+			return err
+		}
+		err = d.addTXTRecord(zoneID, newVersion, name, value, ttl)
+		if err == nil {
+			err = d.setZoneVersion(zoneID, newVersion)
+		}
+		if err != nil {
+			// remove the new version like CleanUp does, the active
+			// version is left untouched
+			if delErr := d.deleteZoneVersion(zoneID, newVersion); delErr != nil {
+				return fmt.Errorf("%v; deleting zone version %d also failed: %v", err, newVersion, delErr)
+			}
+			return err
+		}
+		// save data necessary for CleanUp
+		d.inProgressFQDNs[fqdn] = inProgressInfo{
+			zoneID:     zoneID,
+			version:    info.version,
+			newVersion: newVersion,
+			authZone:   authZone,
+		}
+		d.inProgressAuthZones[authZone] = struct{}{}
+		return nil
+	}
 	// perform API actions to create and activate new gandi zone
 	// containing the required TXT record
 	newZoneName := fmt.Sprintf(
@@ -135,8 +251,10 @@ func (d *DNSProvider) Present(domain, token, keyAuth string) error {
 }
 
 // CleanUp removes the TXT record matching the specified
-// parameters. It does this by restoring the old Gandi DNS zone and
-// removing the temporary one created by Present.
+// parameters. It does this by restoring the old Gandi DNS zone, or
+// zone version, and removing the temporary one created by Present.
+// Afterwards it tries to purge orphaned challenge zones, ignoring any
+// errors doing so.
 func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
 	fqdn, _, _ := acme.DNS01Record(domain, keyAuth)
 	// acquire lock and retrieve zoneID, newZoneID and authZone
@@ -146,11 +264,26 @@ func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
 		// if there is no cleanup information then just return
 		return nil
 	}
-	zoneID := d.inProgressFQDNs[fqdn].zoneID
-	newZoneID := d.inProgressFQDNs[fqdn].newZoneID
-	authZone := d.inProgressFQDNs[fqdn].authZone
+	info := d.inProgressFQDNs[fqdn]
+	zoneID := info.zoneID
+	newZoneID := info.newZoneID
+	authZone := info.authZone
 	delete(d.inProgressFQDNs, fqdn)
 	delete(d.inProgressAuthZones, authZone)
+	if newZoneID == 0 {
+		// perform API actions to restore old version of the zone
+		// edited in place
+		err := d.setZoneVersion(zoneID, info.version)
+		if err != nil {
+			return err
+		}
+		err = d.deleteZoneVersion(zoneID, info.newVersion)
+		if err != nil {
+			return err
+		}
+		d.purgeOrphanChallengeZones()
+		return nil
+	}
 	// perform API actions to restore old gandi zone for authZone
 	err := d.setZone(authZone, zoneID)
 	if err != nil {
@@ -160,6 +293,57 @@ func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
 	if err != nil {
 		return err
 	}
+	d.purgeOrphanChallengeZones()
+	return nil
+}
+
+// PurgeOrphanChallengeZones deletes the temporary challenge zones,
+// named "<domain> [ACME Challenge <date>]", which are not attached to
+// any domain. Such zones are left behind if a previous run stopped
+// between Present and CleanUp. Only zones created longer ago than the
+// propagation timeout are deleted, since younger ones may belong to a
+// challenge another process is still solving with the same account.
+func (d *DNSProvider) PurgeOrphanChallengeZones() error {
+	d.inProgressMu.Lock()
+	defer d.inProgressMu.Unlock()
+	return d.purgeOrphanChallengeZones()
+}
+
+// purgeOrphanChallengeZones implements PurgeOrphanChallengeZones. The
+// caller must hold inProgressMu.
+func (d *DNSProvider) purgeOrphanChallengeZones() error {
+	zones, err := d.listZones()
+	if err != nil {
+		return err
+	}
+	inProgress := make(map[int]bool)
+	for _, info := range d.inProgressFQDNs {
+		inProgress[info.newZoneID] = true
+	}
+	timeout, _ := d.Timeout()
+	var errs []string
+	for _, zone := range zones {
+		if zone.domains > 0 || inProgress[zone.id] {
+			continue
+		}
+		m := challengeZoneName.FindStringSubmatch(zone.name)
+		if m == nil {
+			continue
+		}
+		created, err := time.Parse(time.RFC822Z, m[1])
+		if err != nil || time.Since(created) < timeout {
+			continue
+		}
+		err = d.deleteZone(zone.id)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf(
+			"Gandi DNS: could not purge orphaned challenge zones: %s",
+			strings.Join(errs, "; "))
+	}
 	return nil
 }
 
@@ -230,8 +414,9 @@ func (r responseFault) faultString() string { return r.FaultString }
 type responseStruct struct {
 	responseFault
 	StructMembers []struct {
-		Name     string `xml:"name"`
-		ValueInt int    `xml:"value>int"`
+		Name      string `xml:"name"`
+		ValueInt  int    `xml:"value>int"`
+		ValueBool bool   `xml:"value>boolean"`
 	} `xml:"params>param>value>struct>member"`
 }
 
@@ -245,6 +430,17 @@ type responseBool struct {
 	Value bool `xml:"params>param>value>boolean"`
 }
 
+type responseZoneList struct {
+	responseFault
+	Zones []struct {
+		StructMembers []struct {
+			Name        string `xml:"name"`
+			ValueInt    int    `xml:"value>int"`
+			ValueString string `xml:"value>string"`
+		} `xml:"struct>member"`
+	} `xml:"params>param>value>array>data>value"`
+}
+
 // POSTing/Marshalling/Unmarshalling
 
 type rpcError struct {
@@ -257,9 +453,17 @@ func (e rpcError) Error() string {
 		"Gandi DNS: RPC Error: (%d) %s", e.faultCode, e.faultString)
 }
 
-func httpPost(url string, bodyType string, body io.Reader) ([]byte, error) {
+func (d *DNSProvider) httpPost(url string, bodyType string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		return nil, fmt.Errorf("Gandi DNS: HTTP Post Error: %v", err)
+	}
+	req.Header.Set("Content-Type", bodyType)
+	if d.personalAccessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+d.personalAccessToken)
+	}
 	client := http.Client{Timeout: 60 * time.Second}
-	resp, err := client.Post(url, bodyType, body)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("Gandi DNS: HTTP Post Error: %v", err)
 	}
@@ -275,15 +479,19 @@ func httpPost(url string, bodyType string, body io.Reader) ([]byte, error) {
 // marshalling the data given in the call argument to XML and sending
 // that via HTTP Post to Gandi. The response is then unmarshalled into
 // the resp argument.
-func rpcCall(call *methodCall, resp response) error {
+func (d *DNSProvider) rpcCall(call *methodCall, resp response) error {
 	// marshal
 	b, err := xml.MarshalIndent(call, "", "  ")
 	if err != nil {
 		return fmt.Errorf("Gandi DNS: Marshal Error: %v", err)
 	}
 	// post
+	url := d.endpoint
+	if url == "" {
+		url = endpoint
+	}
 	b = append([]byte(`<?xml version="1.0"?>`+"\n"), b...)
-	respBody, err := httpPost(endpoint, "text/xml", bytes.NewReader(b))
+	respBody, err := d.httpPost(url, "text/xml", bytes.NewReader(b))
 	if err != nil {
 		return err
 	}
@@ -303,7 +511,7 @@ func rpcCall(call *methodCall, resp response) error {
 
 func (d *DNSProvider) getZoneID(domain string) (int, error) {
 	resp := &responseStruct{}
-	err := rpcCall(&methodCall{
+	err := d.rpcCall(&methodCall{
 		MethodName: "domain.info",
 		Params: []param{
 			paramString{Value: d.apiKey},
@@ -326,9 +534,75 @@ func (d *DNSProvider) getZoneID(domain string) (int, error) {
 	return zoneID, nil
 }
 
+func (d *DNSProvider) getZoneInfo(zoneID int) (zoneInfo, error) {
+	resp := &responseStruct{}
+	err := d.rpcCall(&methodCall{
+		MethodName: "domain.zone.info",
+		Params: []param{
+			paramString{Value: d.apiKey},
+			paramInt{Value: zoneID},
+		},
+	}, resp)
+	if err != nil {
+		return zoneInfo{}, err
+	}
+	var info zoneInfo
+	for _, member := range resp.StructMembers {
+		switch member.Name {
+		case "public":
+			info.public = member.ValueBool
+		case "domains":
+			info.domains = member.ValueInt
+		case "version":
+			info.version = member.ValueInt
+		}
+	}
+	if info.version == 0 {
+		return zoneInfo{}, fmt.Errorf(
+			"Gandi DNS: Could not determine active version of zone_id %d", zoneID)
+	}
+	return info, nil
+}
+
+// listedZone is a zone as returned by domain.zone.list.
+type listedZone struct {
+	id      int
+	name    string
+	domains int
+}
+
+func (d *DNSProvider) listZones() ([]listedZone, error) {
+	resp := &responseZoneList{}
+	err := d.rpcCall(&methodCall{
+		MethodName: "domain.zone.list",
+		Params: []param{
+			paramString{Value: d.apiKey},
+		},
+	}, resp)
+	if err != nil {
+		return nil, err
+	}
+	var zones []listedZone
+	for _, z := range resp.Zones {
+		var zone listedZone
+		for _, member := range z.StructMembers {
+			switch member.Name {
+			case "id":
+				zone.id = member.ValueInt
+			case "name":
+				zone.name = member.ValueString
+			case "domains":
+				zone.domains = member.ValueInt
+			}
+		}
+		zones = append(zones, zone)
+	}
+	return zones, nil
+}
+
 func (d *DNSProvider) cloneZone(zoneID int, name string) (int, error) {
 	resp := &responseStruct{}
-	err := rpcCall(&methodCall{
+	err := d.rpcCall(&methodCall{
 		MethodName: "domain.zone.clone",
 		Params: []param{
 			paramString{Value: d.apiKey},
@@ -360,7 +634,7 @@ func (d *DNSProvider) cloneZone(zoneID int, name string) (int, error) {
 
 func (d *DNSProvider) newZoneVersion(zoneID int) (int, error) {
 	resp := &responseInt{}
-	err := rpcCall(&methodCall{
+	err := d.rpcCall(&methodCall{
 		MethodName: "domain.zone.version.new",
 		Params: []param{
 			paramString{Value: d.apiKey},
@@ -376,30 +650,34 @@ func (d *DNSProvider) newZoneVersion(zoneID int) (int, error) {
 	return resp.Value, nil
 }
 
+// addTXTRecord adds a TXT record to the given version of a zone. A ttl of
+// zero omits the TTL, so that the record uses the TTL of the zone.
 func (d *DNSProvider) addTXTRecord(zoneID int, version int, name string, value string, ttl int) error {
+	members := []structMember{
+		structMemberString{
+			Name:  "type",
+			Value: "TXT",
+		}, structMemberString{
+			Name:  "name",
+			Value: name,
+		}, structMemberString{
+			Name:  "value",
+			Value: value,
+		}}
+	if ttl > 0 {
+		members = append(members, structMemberInt{
+			Name:  "ttl",
+			Value: ttl,
+		})
+	}
 	resp := &responseStruct{}
-	err := rpcCall(&methodCall{
+	err := d.rpcCall(&methodCall{
 		MethodName: "domain.zone.record.add",
 		Params: []param{
 			paramString{Value: d.apiKey},
 			paramInt{Value: zoneID},
 			paramInt{Value: version},
-			paramStruct{
-				StructMembers: []structMember{
-					structMemberString{
-						Name:  "type",
-						Value: "TXT",
-					}, structMemberString{
-						Name:  "name",
-						Value: name,
-					}, structMemberString{
-						Name:  "value",
-						Value: value,
-					}, structMemberInt{
-						Name:  "ttl",
-						Value: ttl,
-					}},
-			},
+			paramStruct{StructMembers: members},
 		},
 	}, resp)
 	if err != nil {
@@ -410,7 +688,7 @@ func (d *DNSProvider) addTXTRecord(zoneID int, version int, name string, value s
 
 func (d *DNSProvider) setZoneVersion(zoneID int, version int) error {
 	resp := &responseBool{}
-	err := rpcCall(&methodCall{
+	err := d.rpcCall(&methodCall{
 		MethodName: "domain.zone.version.set",
 		Params: []param{
 			paramString{Value: d.apiKey},
@@ -427,9 +705,28 @@ func (d *DNSProvider) setZoneVersion(zoneID int, version int) error {
 	return nil
 }
 
+func (d *DNSProvider) deleteZoneVersion(zoneID int, version int) error {
+	resp := &responseBool{}
+	err := d.rpcCall(&methodCall{
+		MethodName: "domain.zone.version.delete",
+		Params: []param{
+			paramString{Value: d.apiKey},
+			paramInt{Value: zoneID},
+			paramInt{Value: version},
+		},
+	}, resp)
+	if err != nil {
+		return err
+	}
+	if !resp.Value {
+		return fmt.Errorf("Gandi DNS: could not delete zone version")
+	}
+	return nil
+}
+
 func (d *DNSProvider) setZone(domain string, zoneID int) error {
 	resp := &responseStruct{}
-	err := rpcCall(&methodCall{
+	err := d.rpcCall(&methodCall{
 		MethodName: "domain.zone.set",
 		Params: []param{
 			paramString{Value: d.apiKey},
@@ -455,7 +752,7 @@ func (d *DNSProvider) setZone(domain string, zoneID int) error {
 
 func (d *DNSProvider) deleteZone(zoneID int) error {
 	resp := &responseBool{}
-	err := rpcCall(&methodCall{
+	err := d.rpcCall(&methodCall{
 		MethodName: "domain.zone.delete",
 		Params: []param{
 			paramString{Value: d.apiKey},
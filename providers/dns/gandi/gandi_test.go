@@ -1,9 +1,11 @@
 package gandi
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -12,6 +14,8 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/stangah/lego/acme"
 )
@@ -92,6 +96,478 @@ func TestDNSProvider(t *testing.T) {
 	}
 }
 
+// TestDNSProviderIDN runs Present and CleanUp for an internationalized
+// domain name and checks that only its punycode form is sent to Gandi.
+func TestDNSProviderIDN(t *testing.T) {
+	fakeAPIKey := "123412341234123412341234"
+	fakeKeyAuth := "XXXX"
+	provider, err := NewDNSProviderCredentials(fakeAPIKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	regexpDate, err := regexp.Compile(`\[ACME Challenge [^\]:]*:[^\]]*\]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recordAdded bool
+	// start fake RPC server
+	fakeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range req {
+			if b >= utf8.RuneSelf {
+				t.Fatalf("Request contains non-ASCII data: %s", req)
+			}
+		}
+		if bytes.Contains(req, []byte("<string>_acme-challenge.xn--r8jz45g.def</string>")) {
+			recordAdded = true
+		}
+		// map the request onto the recorded session for abc.def.example.com
+		req = bytes.Replace(req, []byte("xn--r8jz45g"), []byte("abc"), -1)
+		req = regexpDate.ReplaceAllLiteral(
+			req, []byte(`[ACME Challenge 01 Jan 16 00:00 +0000]`))
+		resp, ok := serverResponses[string(req)]
+		if !ok {
+			t.Fatalf("Server response for request not found")
+		}
+		_, err = io.Copy(w, strings.NewReader(resp))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}))
+	defer fakeServer.Close()
+	// define function to override findZoneByFqdn with
+	fakeFindZoneByFqdn := func(fqdn string, nameserver []string) (string, error) {
+		return "example.com.", nil
+	}
+	// override gandi endpoint and findZoneByFqdn function
+	savedEndpoint, savedFindZoneByFqdn := endpoint, findZoneByFqdn
+	defer func() {
+		endpoint, findZoneByFqdn = savedEndpoint, savedFindZoneByFqdn
+	}()
+	endpoint, findZoneByFqdn = fakeServer.URL+"/", fakeFindZoneByFqdn
+	// run Present
+	err = provider.Present("例え.def.example.com", "", fakeKeyAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !recordAdded {
+		t.Fatal("Expected the TXT record to be added with its punycode name")
+	}
+	// run CleanUp
+	err = provider.CleanUp("例え.def.example.com", "", fakeKeyAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDNSProviderMultipleZones runs Present for two domains in
+// distinct zones before cleaning up either of them, as happens when a
+// single certificate covers both, and checks that each TXT record is
+// created relative to and activated in its own zone.
+func TestDNSProviderMultipleZones(t *testing.T) {
+	fakeAPIKey := "123412341234123412341234"
+	fakeKeyAuth := "XXXX"
+	provider, err := NewDNSProviderCredentials(fakeAPIKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	regexpDate, err := regexp.Compile(`\[ACME Challenge [^\]:]*:[^\]]*\]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	regexpZoneSet, err := regexp.Compile(`(?s)<methodName>domain\.zone\.set</methodName>.*?<string>.*?</string>.*?<string>(.*?)</string>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var zonesSet []string
+	// start fake RPC server
+	fakeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m := regexpZoneSet.FindSubmatch(req); m != nil {
+			zonesSet = append(zonesSet, string(m[1]))
+		}
+		// map the request onto the recorded session for abc.def.example.com
+		req = bytes.Replace(req, []byte("example.net"), []byte("example.com"), -1)
+		req = regexpDate.ReplaceAllLiteral(
+			req, []byte(`[ACME Challenge 01 Jan 16 00:00 +0000]`))
+		resp, ok := serverResponses[string(req)]
+		if !ok {
+			t.Fatalf("Server response for request not found")
+		}
+		_, err = io.Copy(w, strings.NewReader(resp))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}))
+	defer fakeServer.Close()
+	// define function to override findZoneByFqdn with
+	fakeFindZoneByFqdn := func(fqdn string, nameserver []string) (string, error) {
+		if strings.HasSuffix(fqdn, ".example.net.") {
+			return "example.net.", nil
+		}
+		return "example.com.", nil
+	}
+	// override gandi endpoint and findZoneByFqdn function
+	savedEndpoint, savedFindZoneByFqdn := endpoint, findZoneByFqdn
+	defer func() {
+		endpoint, findZoneByFqdn = savedEndpoint, savedFindZoneByFqdn
+	}()
+	endpoint, findZoneByFqdn = fakeServer.URL+"/", fakeFindZoneByFqdn
+	domains := []string{"abc.def.example.com", "abc.def.example.net"}
+	// run Present for both domains
+	for _, domain := range domains {
+		err = provider.Present(domain, "", fakeKeyAuth)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// run CleanUp for both domains
+	for _, domain := range domains {
+		err = provider.CleanUp(domain, "", fakeKeyAuth)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []string{"example.com.", "example.net.", "example.com.", "example.net."}
+	if strings.Join(zonesSet, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected zones %v to be set but got %v", expected, zonesSet)
+	}
+}
+
+// TestDNSProviderPrivateZone runs Present and CleanUp for a domain
+// using a private zone of its own and checks that the zone is edited in
+// place through a new version instead of being cloned.
+func TestDNSProviderPrivateZone(t *testing.T) {
+	fakeAPIKey := "123412341234123412341234"
+	fakeKeyAuth := "XXXX"
+	provider, err := NewDNSProviderCredentials(fakeAPIKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	regexpMethod, err := regexp.Compile(`<methodName>(.*)</methodName>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	regexpInts, err := regexp.Compile(`<int>(\d+)</int>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []string
+	var failMethod string
+	// start fake RPC server
+	fakeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		method := string(regexpMethod.FindSubmatch(req)[1])
+		call := method
+		for _, m := range regexpInts.FindAllSubmatch(req, -1) {
+			call += " " + string(m[1])
+		}
+		calls = append(calls, call)
+		if method == failMethod {
+			io.WriteString(w, `<?xml version='1.0'?>
+<methodResponse><fault><value><struct>
+<member><name>faultCode</name><value><int>510042</int></value></member>
+<member><name>faultString</name><value><string>Error</string></value></member>
+</struct></value></fault></methodResponse>`)
+			return
+		}
+		var value string
+		switch method {
+		case "domain.info":
+			value = `<struct><member><name>zone_id</name><value><int>1234567</int></value></member></struct>`
+		case "domain.zone.info":
+			value = `<struct>
+<member><name>public</name><value><boolean>0</boolean></value></member>
+<member><name>domains</name><value><int>1</int></value></member>
+<member><name>version</name><value><int>3</int></value></member>
+</struct>`
+		case "domain.zone.version.new":
+			value = `<int>4</int>`
+		case "domain.zone.record.add":
+			value = `<struct><member><name>id</name><value><int>333333333</int></value></member></struct>`
+		case "domain.zone.version.set", "domain.zone.version.delete":
+			value = `<boolean>1</boolean>`
+		case "domain.zone.list":
+			value = `<array><data></data></array>`
+		default:
+			t.Errorf("Unexpected call of %s", method)
+		}
+		io.WriteString(w, `<?xml version='1.0'?>
+<methodResponse><params><param><value>`+value+`</value></param></params></methodResponse>`)
+	}))
+	defer fakeServer.Close()
+	// define function to override findZoneByFqdn with
+	fakeFindZoneByFqdn := func(fqdn string, nameserver []string) (string, error) {
+		return "example.com.", nil
+	}
+	// override gandi endpoint and findZoneByFqdn function
+	savedEndpoint, savedFindZoneByFqdn := endpoint, findZoneByFqdn
+	defer func() {
+		endpoint, findZoneByFqdn = savedEndpoint, savedFindZoneByFqdn
+	}()
+	endpoint, findZoneByFqdn = fakeServer.URL+"/", fakeFindZoneByFqdn
+	// run Present
+	err = provider.Present("abc.def.example.com", "", fakeKeyAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// run CleanUp
+	err = provider.CleanUp("abc.def.example.com", "", fakeKeyAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"domain.info",
+		"domain.zone.info 1234567",
+		"domain.zone.version.new 1234567",
+		"domain.zone.record.add 1234567 4 300",
+		"domain.zone.version.set 1234567 4",
+		"domain.zone.version.set 1234567 3",
+		"domain.zone.version.delete 1234567 4",
+		"domain.zone.list",
+	}
+	if strings.Join(calls, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected calls\n%s\nbut got\n%s",
+			strings.Join(expected, "\n"), strings.Join(calls, "\n"))
+	}
+	// a failing Present deletes the new zone version again
+	for _, method := range []string{"domain.zone.record.add", "domain.zone.version.set"} {
+		calls, failMethod = nil, method
+		err = provider.Present("abc.def.example.com", "", fakeKeyAuth)
+		if err == nil {
+			t.Fatalf("Expected Present to fail when %s fails", method)
+		}
+		if last := calls[len(calls)-1]; last != "domain.zone.version.delete 1234567 4" {
+			t.Errorf("Expected the new zone version to be deleted when %s fails but got\n%s",
+				method, strings.Join(calls, "\n"))
+		}
+		n := len(calls)
+		err = provider.CleanUp("abc.def.example.com", "", fakeKeyAuth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(calls) != n {
+			t.Errorf("Expected CleanUp to do nothing after a failed Present but got\n%s",
+				strings.Join(calls[n:], "\n"))
+		}
+	}
+}
+
+// TestDNSProviderZoneTTL checks that no TTL is sent to Gandi if the TTL
+// is set to zero, so that the record uses the TTL of the zone.
+func TestDNSProviderZoneTTL(t *testing.T) {
+	provider, err := NewDNSProviderCredentials("123412341234123412341234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.SetTTL(-1); err == nil {
+		t.Error("Expected an error for a negative TTL")
+	}
+	if err := provider.SetTTL(0); err != nil {
+		t.Fatal(err)
+	}
+	regexpMethod := regexp.MustCompile(`<methodName>(.*)</methodName>`)
+	var records []string
+	fakeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var value string
+		switch method := string(regexpMethod.FindSubmatch(req)[1]); method {
+		case "domain.info":
+			value = `<struct><member><name>zone_id</name><value><int>1234567</int></value></member></struct>`
+		case "domain.zone.info":
+			value = `<struct>
+<member><name>public</name><value><boolean>0</boolean></value></member>
+<member><name>domains</name><value><int>1</int></value></member>
+<member><name>version</name><value><int>3</int></value></member>
+</struct>`
+		case "domain.zone.version.new":
+			value = `<int>4</int>`
+		case "domain.zone.record.add":
+			records = append(records, string(req))
+			value = `<struct><member><name>id</name><value><int>333333333</int></value></member></struct>`
+		case "domain.zone.version.set":
+			value = `<boolean>1</boolean>`
+		default:
+			t.Errorf("Unexpected call of %s", method)
+		}
+		io.WriteString(w, `<?xml version='1.0'?>
+<methodResponse><params><param><value>`+value+`</value></param></params></methodResponse>`)
+	}))
+	defer fakeServer.Close()
+	savedFindZoneByFqdn := findZoneByFqdn
+	defer func() { findZoneByFqdn = savedFindZoneByFqdn }()
+	findZoneByFqdn = func(fqdn string, nameserver []string) (string, error) {
+		return "example.com.", nil
+	}
+	provider.SetEndpoint(fakeServer.URL + "/")
+	err = provider.Present("abc.def.example.com", "", "XXXX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("Expected one record to be added but got %d", len(records))
+	}
+	if !strings.Contains(records[0], "<name>value</name>") || strings.Contains(records[0], "<name>ttl</name>") {
+		t.Errorf("Expected a record without TTL but got\n%s", records[0])
+	}
+}
+
+// TestPurgeOrphanChallengeZones checks that only challenge zones which
+// are attached to no domain, not in use by a challenge in progress and
+// older than the propagation timeout are deleted, both on request and
+// by CleanUp.
+func TestPurgeOrphanChallengeZones(t *testing.T) {
+	provider, err := NewDNSProviderCredentials("123412341234123412341234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.inProgressFQDNs["_acme-challenge.example.org."] = inProgressInfo{
+		zoneID:    1,
+		newZoneID: 5,
+		authZone:  "example.org.",
+	}
+	regexpMethod, err := regexp.Compile(`<methodName>(.*)</methodName>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	regexpInt, err := regexp.Compile(`<int>(\d+)</int>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zone := func(id int, name string, domains int) string {
+		return fmt.Sprintf(`<value><struct>
+<member><name>id</name><value><int>%d</int></value></member>
+<member><name>name</name><value><string>%s</string></value></member>
+<member><name>domains</name><value><int>%d</int></value></member>
+</struct></value>`, id, name, domains)
+	}
+	var deleted []string
+	// start fake RPC server
+	fakeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var value string
+		switch method := string(regexpMethod.FindSubmatch(req)[1]); method {
+		case "domain.zone.list":
+			value = `<array><data>` +
+				zone(1, "example.com", 0) +
+				zone(2, "example.com [ACME Challenge 01 Jan 16 00:00 +0000]", 0) +
+				zone(3, "example.com [ACME Challenge 02 Jan 16 00:00 +0000]", 1) +
+				zone(4, "example.net [ACME Challenge 01 Jan 16 00:00 +0000]", 0) +
+				zone(5, "example.org [ACME Challenge 03 Jan 16 00:00 +0000]", 0) +
+				zone(6, "example.com [ACME Challenge "+time.Now().Add(-time.Minute).Format(time.RFC822Z)+"]", 0) +
+				zone(7, "example.com [ACME Challenge yesterday]", 0) +
+				`</data></array>`
+		case "domain.zone.delete":
+			deleted = append(deleted, string(regexpInt.FindSubmatch(req)[1]))
+			value = `<boolean>1</boolean>`
+		case "domain.zone.set":
+			value = `<struct><member><name>zone_id</name><value><int>1</int></value></member></struct>`
+		default:
+			t.Errorf("Unexpected call of %s", method)
+		}
+		io.WriteString(w, `<?xml version='1.0'?>
+<methodResponse><params><param><value>`+value+`</value></param></params></methodResponse>`)
+	}))
+	defer fakeServer.Close()
+	// override gandi endpoint
+	savedEndpoint := endpoint
+	defer func() {
+		endpoint = savedEndpoint
+	}()
+	endpoint = fakeServer.URL + "/"
+	// purge orphaned zones
+	err = provider.PurgeOrphanChallengeZones()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(deleted, " ") != "2 4" {
+		t.Errorf("Expected zones 2 and 4 to be deleted but got %v", deleted)
+	}
+	// CleanUp deletes its own zone, then purges the old orphans but
+	// leaves the young one and the one still in progress alone
+	deleted = nil
+	provider.inProgressFQDNs["_acme-challenge.example.com."] = inProgressInfo{
+		zoneID:    1,
+		newZoneID: 8,
+		authZone:  "example.com.",
+	}
+	provider.inProgressAuthZones["example.com."] = struct{}{}
+	err = provider.CleanUp("example.com", "", "XXXX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(deleted, " ") != "8 2 4" {
+		t.Errorf("Expected zones 8, 2 and 4 to be deleted but got %v", deleted)
+	}
+}
+
+// TestDNSProviderPersonalAccessToken checks that the Personal Access
+// Token is sent in the Authorization header to the configured endpoint.
+func TestDNSProviderPersonalAccessToken(t *testing.T) {
+	provider, err := NewDNSProviderPersonalAccessToken("pat-1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths, auths []string
+	fakeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		auths = append(auths, r.Header.Get("Authorization"))
+		io.WriteString(w, `<?xml version='1.0'?>
+<methodResponse><params><param><value><array><data></data></array></value></param></params></methodResponse>`)
+	}))
+	defer fakeServer.Close()
+	provider.SetEndpoint(fakeServer.URL + "/xmlrpc/")
+	err = provider.PurgeOrphanChallengeZones()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(auths) != 1 || auths[0] != "Bearer pat-1234" {
+		t.Errorf("Expected Authorization header %q but got %v", "Bearer pat-1234", auths)
+	}
+	if len(paths) != 1 || paths[0] != "/xmlrpc/" {
+		t.Errorf("Expected request to /xmlrpc/ but got %v", paths)
+	}
+}
+
+// TestNewDNSProviderEnv checks that either an API key or a Personal
+// Access Token is required, and that the endpoint can be configured.
+func TestNewDNSProviderEnv(t *testing.T) {
+	for _, name := range []string{"GANDI_API_KEY", "GANDI_PERSONAL_ACCESS_TOKEN", "GANDI_ENDPOINT"} {
+		defer os.Setenv(name, os.Getenv(name))
+	}
+	os.Setenv("GANDI_API_KEY", "")
+	os.Setenv("GANDI_PERSONAL_ACCESS_TOKEN", "")
+	os.Setenv("GANDI_ENDPOINT", "")
+	_, err := NewDNSProvider()
+	if err == nil {
+		t.Error("Expected an error without credentials")
+	}
+	os.Setenv("GANDI_PERSONAL_ACCESS_TOKEN", "pat-1234")
+	os.Setenv("GANDI_ENDPOINT", "https://api.example.com/xmlrpc/")
+	provider, err := NewDNSProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.personalAccessToken != "pat-1234" || provider.endpoint != "https://api.example.com/xmlrpc/" {
+		t.Errorf("Unexpected provider configuration %+v", provider)
+	}
+}
+
 // TestDNSProviderLive performs a live test to obtain a certificate
 // using the Let's Encrypt staging server. It runs provided that both
 // the environment variables GANDI_API_KEY and GANDI_TEST_DOMAIN are
@@ -333,6 +809,50 @@ var serverResponses = map[string]string{
 </param>
 </params>
 </methodResponse>
+`,
+	// Present Request->Response 1a (getZoneInfo)
+	`<?xml version="1.0"?>
+<methodCall>
+  <methodName>domain.zone.info</methodName>
+  <param>
+    <value>
+      <string>123412341234123412341234</string>
+    </value>
+  </param>
+  <param>
+    <value>
+      <int>1234567</int>
+    </value>
+  </param>
+</methodCall>`: `<?xml version='1.0'?>
+<methodResponse>
+<params>
+<param>
+<value><struct>
+<member>
+<name>id</name>
+<value><int>1234567</int></value>
+</member>
+<member>
+<name>name</name>
+<value><string>Gandi zone</string></value>
+</member>
+<member>
+<name>public</name>
+<value><boolean>1</boolean></value>
+</member>
+<member>
+<name>domains</name>
+<value><int>1000</int></value>
+</member>
+<member>
+<name>version</name>
+<value><int>1</int></value>
+</member>
+</struct></value>
+</param>
+</params>
+</methodResponse>
 `,
 	// Present Request->Response 2 (cloneZone)
 	`<?xml version="1.0"?>
@@ -935,5 +1455,38 @@ var serverResponses = map[string]string{
 </param>
 </params>
 </methodResponse>
+`,
+	// CleanUp Request->Response 3 (listZones)
+	`<?xml version="1.0"?>
+<methodCall>
+  <methodName>domain.zone.list</methodName>
+  <param>
+    <value>
+      <string>123412341234123412341234</string>
+    </value>
+  </param>
+</methodCall>`: `<?xml version='1.0'?>
+<methodResponse>
+<params>
+<param>
+<value><array><data>
+<value><struct>
+<member>
+<name>id</name>
+<value><int>7654321</int></value>
+</member>
+<member>
+<name>name</name>
+<value><string>example.com [ACME Challenge 01 Jan 16 00:00 +0000]</string></value>
+</member>
+<member>
+<name>domains</name>
+<value><int>1</int></value>
+</member>
+</struct></value>
+</data></array></value>
+</param>
+</params>
+</methodResponse>
 `,
 }
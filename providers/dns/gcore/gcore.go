@@ -0,0 +1,232 @@
+// Package gcore implements a DNS provider for solving the DNS-01 challenge
+// using G-Core Labs DNS.
+package gcore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/stangah/lego/acme"
+)
+
+// G-Core Labs DNS API reference: https://apidocs.gcore.com/dns
+
+// gcoreBaseURL is the base URL of the G-Core Labs DNS API.
+var gcoreBaseURL = "https://api.gcore.com/dns"
+
+// defaultTTL is the TTL of the TXT rrsets created by the provider.
+const defaultTTL = 120
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses the G-Core Labs DNS API to manage TXT records for a domain.
+type DNSProvider struct {
+	token string
+	ttl   int
+
+	// G-Core manages all values of a name and type as one rrset, so updates
+	// are read-modify-write and must not interleave.
+	mu sync.Mutex
+}
+
+// rrSet is a resource record set of the G-Core Labs DNS API.
+type rrSet struct {
+	TTL     int              `json:"ttl"`
+	Records []resourceRecord `json:"resource_records"`
+}
+
+// resourceRecord is a single value of an rrSet.
+type resourceRecord struct {
+	Content []string `json:"content"`
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for G-Core Labs.
+// The permanent API token must be passed in the environment variable
+// GCORE_PERMANENT_API_TOKEN.
+func NewDNSProvider() (*DNSProvider, error) {
+	return NewDNSProviderCredentials(os.Getenv("GCORE_PERMANENT_API_TOKEN"))
+}
+
+// NewDNSProviderCredentials uses the supplied permanent API token to return
+// a DNSProvider instance configured for G-Core Labs.
+func NewDNSProviderCredentials(token string) (*DNSProvider, error) {
+	if token == "" {
+		return nil, fmt.Errorf("G-Core credentials missing")
+	}
+	return &DNSProvider{token: token, ttl: defaultTTL}, nil
+}
+
+// SetTTL sets the TTL in seconds of the TXT rrsets created by the provider.
+func (d *DNSProvider) SetTTL(ttl int) error {
+	if ttl <= 0 {
+		return fmt.Errorf("G-Core TTL must be positive, got %d", ttl)
+	}
+	d.ttl = ttl
+	return nil
+}
+
+// Present adds the challenge value to the TXT rrset of the challenge name,
+// creating the rrset if it does not exist yet.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	name := strings.ToLower(acme.UnFqdn(fqdn))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	zone, err := d.findZone(name)
+	if err != nil {
+		return err
+	}
+
+	values, err := d.getTxtValues(zone, name)
+	if err != nil {
+		return err
+	}
+	if values == nil {
+		return d.doRequest("POST", rrSetPath(zone, name), newRRSet(d.ttl, []string{value}), nil)
+	}
+
+	for _, v := range values {
+		if v == value {
+			return nil
+		}
+	}
+	return d.doRequest("PUT", rrSetPath(zone, name), newRRSet(d.ttl, append(values, value)), nil)
+}
+
+// CleanUp removes the challenge value from the TXT rrset of the challenge
+// name. The rrset is deleted once it has no values left.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	name := strings.ToLower(acme.UnFqdn(fqdn))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	zone, err := d.findZone(name)
+	if err != nil {
+		return err
+	}
+
+	values, err := d.getTxtValues(zone, name)
+	if err != nil {
+		return err
+	}
+
+	var remaining []string
+	for _, v := range values {
+		if v != value {
+			remaining = append(remaining, v)
+		}
+	}
+	if len(remaining) == len(values) {
+		return nil
+	}
+	if len(remaining) == 0 {
+		return d.doRequest("DELETE", rrSetPath(zone, name), nil, nil)
+	}
+	return d.doRequest("PUT", rrSetPath(zone, name), newRRSet(d.ttl, remaining), nil)
+}
+
+// findZone returns the most specific zone of the account containing name.
+// The parent domains of name are looked up one by one.
+func (d *DNSProvider) findZone(name string) (string, error) {
+	zone := name
+	for {
+		i := strings.Index(zone, ".")
+		if i < 0 {
+			return "", fmt.Errorf("G-Core: no zone found for %s", name)
+		}
+		zone = zone[i+1:]
+
+		err := d.doRequest("GET", "/v2/zones/"+zone, nil, nil)
+		if err == nil {
+			return zone, nil
+		}
+		if err != errNotFound {
+			return "", err
+		}
+	}
+}
+
+// getTxtValues returns the values of the TXT rrset name in zone, or nil if
+// the rrset does not exist.
+func (d *DNSProvider) getTxtValues(zone, name string) ([]string, error) {
+	var set rrSet
+	err := d.doRequest("GET", rrSetPath(zone, name), nil, &set)
+	if err == errNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	values := []string{}
+	for _, record := range set.Records {
+		values = append(values, record.Content...)
+	}
+	return values, nil
+}
+
+func rrSetPath(zone, name string) string {
+	return fmt.Sprintf("/v2/zones/%s/%s/TXT", zone, name)
+}
+
+func newRRSet(ttl int, values []string) rrSet {
+	set := rrSet{TTL: ttl}
+	for _, value := range values {
+		set.Records = append(set.Records, resourceRecord{Content: []string{value}})
+	}
+	return set
+}
+
+var errNotFound = fmt.Errorf("G-Core: not found")
+
+func (d *DNSProvider) doRequest(method, uri string, body, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, gcoreBaseURL+uri, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "APIKey "+d.token)
+	req.Header.Set("User-Agent", acme.UserAgentString())
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := acme.DoWithRetry(req, nil)
+	if err != nil {
+		return fmt.Errorf("G-Core API call failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode >= 400 {
+		var errInfo struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&errInfo)
+		return fmt.Errorf("G-Core API call failed: HTTP %d: %s", resp.StatusCode, errInfo.Error)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,274 @@
+package gcore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stangah/lego/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	gcoreLiveTest bool
+	gcoreToken    string
+	gcoreDomain   string
+)
+
+func init() {
+	gcoreToken = os.Getenv("GCORE_PERMANENT_API_TOKEN")
+	gcoreDomain = os.Getenv("GCORE_DOMAIN")
+	if len(gcoreToken) > 0 && len(gcoreDomain) > 0 {
+		gcoreLiveTest = true
+	}
+}
+
+func restoreGcoreEnv() {
+	os.Setenv("GCORE_PERMANENT_API_TOKEN", gcoreToken)
+}
+
+// fakeGcore serves the parts of the G-Core Labs DNS API used by the provider
+// and keeps the TXT rrsets of its zones in memory.
+type fakeGcore struct {
+	mu       sync.Mutex
+	zones    []string
+	rrsets   map[string]rrSet
+	requests []string
+}
+
+func (f *fakeGcore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "APIKey secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":"invalid api key"}`)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v2/zones/"), "/")
+	switch {
+	case r.Method == "GET" && len(parts) == 1:
+		for _, zone := range f.zones {
+			if zone == parts[0] {
+				fmt.Fprintf(w, `{"name":%q}`, zone)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":"zone is not found"}`)
+		return
+	case len(parts) == 3 && parts[2] == "TXT":
+		key := parts[0] + "/" + parts[1]
+		if r.Method != "GET" {
+			f.requests = append(f.requests, r.Method+" "+key)
+		}
+		set, ok := f.rrsets[key]
+		switch r.Method {
+		case "GET":
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"error":"record is not found"}`)
+				return
+			}
+			json.NewEncoder(w).Encode(set)
+		case "POST", "PUT":
+			if ok == (r.Method == "POST") {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":"rrset already exists"}`)
+				return
+			}
+			json.NewDecoder(r.Body).Decode(&set)
+			f.rrsets[key] = set
+			fmt.Fprint(w, `{}`)
+		case "DELETE":
+			delete(f.rrsets, key)
+			fmt.Fprint(w, `{}`)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// mockGcore serves the G-Core Labs DNS API with handler until the returned
+// function is called.
+func mockGcore(handler http.Handler) func() {
+	server := httptest.NewServer(handler)
+	baseURL := gcoreBaseURL
+	gcoreBaseURL = server.URL
+	return func() {
+		gcoreBaseURL = baseURL
+		server.Close()
+	}
+}
+
+func TestNewDNSProviderValid(t *testing.T) {
+	os.Setenv("GCORE_PERMANENT_API_TOKEN", "")
+	defer restoreGcoreEnv()
+	_, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	os.Setenv("GCORE_PERMANENT_API_TOKEN", "secret")
+	defer restoreGcoreEnv()
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", provider.token)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	os.Setenv("GCORE_PERMANENT_API_TOKEN", "")
+	defer restoreGcoreEnv()
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "G-Core credentials missing")
+}
+
+func TestGcorePresent(t *testing.T) {
+	var requests []string
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockGcore(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		assert.Equal(t, "APIKey secret", r.Header.Get("Authorization"))
+
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/v2/zones/example.com":
+			fmt.Fprint(w, `{"name":"example.com"}`)
+		case r.Method == "GET":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"record is not found"}`)
+		case r.Method == "POST":
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			reqBody, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, `{"ttl":120,"resource_records":[{"content":["`+value+`"]}]}`, string(reqBody))
+			fmt.Fprint(w, `{}`)
+		}
+	}))()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"GET /v2/zones/www.example.com",
+		"GET /v2/zones/example.com",
+		"GET /v2/zones/example.com/_acme-challenge.www.example.com/TXT",
+		"POST /v2/zones/example.com/_acme-challenge.www.example.com/TXT",
+	}, requests)
+}
+
+func TestGcoreCleanUp(t *testing.T) {
+	var requests []string
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockGcore(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		assert.Equal(t, "APIKey secret", r.Header.Get("Authorization"))
+
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/v2/zones/example.com":
+			fmt.Fprint(w, `{"name":"example.com"}`)
+		case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/TXT"):
+			fmt.Fprintf(w, `{"ttl":120,"resource_records":[{"content":[%q]}]}`, value)
+		case r.Method == "GET":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"zone is not found"}`)
+		case r.Method == "DELETE":
+			fmt.Fprint(w, `{}`)
+		}
+	}))()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+
+	// The rrset is deleted along with its last value.
+	err = provider.CleanUp("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"GET /v2/zones/www.example.com",
+		"GET /v2/zones/example.com",
+		"GET /v2/zones/example.com/_acme-challenge.www.example.com/TXT",
+		"DELETE /v2/zones/example.com/_acme-challenge.www.example.com/TXT",
+	}, requests)
+}
+
+func TestGcoreSharedRRSet(t *testing.T) {
+	fake := &fakeGcore{zones: []string{"example.com", "sub.example.com"}, rrsets: map[string]rrSet{}}
+	defer mockGcore(fake)()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+
+	// Two challenges for the same name end up in one rrset of the most
+	// specific zone, and presenting a value again does not duplicate it.
+	assert.NoError(t, provider.Present("www.sub.example.com", "", "key1"))
+	assert.NoError(t, provider.Present("www.sub.example.com", "", "key2"))
+	assert.NoError(t, provider.Present("www.sub.example.com", "", "key1"))
+
+	_, value1, _ := acme.DNS01Record("www.sub.example.com", "key1")
+	_, value2, _ := acme.DNS01Record("www.sub.example.com", "key2")
+	key := "sub.example.com/_acme-challenge.www.sub.example.com"
+	assert.Equal(t, rrSet{TTL: 120, Records: []resourceRecord{
+		{Content: []string{value1}},
+		{Content: []string{value2}},
+	}}, fake.rrsets[key])
+
+	assert.NoError(t, provider.CleanUp("www.sub.example.com", "", "key1"))
+	assert.Equal(t, rrSet{TTL: 120, Records: []resourceRecord{{Content: []string{value2}}}}, fake.rrsets[key])
+
+	assert.NoError(t, provider.CleanUp("www.sub.example.com", "", "key2"))
+	assert.Empty(t, fake.rrsets)
+
+	// Cleaning up a record that is gone does not touch the zone.
+	assert.NoError(t, provider.CleanUp("www.sub.example.com", "", "key1"))
+	assert.Equal(t, []string{"POST " + key, "PUT " + key, "PUT " + key, "DELETE " + key}, fake.requests)
+}
+
+func TestGcorePresentFailed(t *testing.T) {
+	fake := &fakeGcore{zones: []string{"example.com"}, rrsets: map[string]rrSet{}}
+	defer mockGcore(fake)()
+
+	provider, err := NewDNSProviderCredentials("wrong")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.com", "", "key"), "G-Core API call failed: HTTP 401: invalid api key")
+
+	provider, err = NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.org", "", "key"), "G-Core: no zone found for _acme-challenge.example.org")
+}
+
+func TestLiveGcorePresent(t *testing.T) {
+	if !gcoreLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	provider, err := NewDNSProviderCredentials(gcoreToken)
+	assert.NoError(t, err)
+
+	err = provider.Present(gcoreDomain, "", "123d==")
+	assert.NoError(t, err)
+}
+
+func TestLiveGcoreCleanUp(t *testing.T) {
+	if !gcoreLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	time.Sleep(time.Second * 1)
+
+	provider, err := NewDNSProviderCredentials(gcoreToken)
+	assert.NoError(t, err)
+
+	err = provider.CleanUp(gcoreDomain, "", "123d==")
+	assert.NoError(t, err)
+}
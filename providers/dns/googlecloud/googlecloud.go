@@ -5,6 +5,7 @@ package googlecloud
 import (
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/stangah/lego/acme"
@@ -15,17 +16,37 @@ import (
 	"google.golang.org/api/dns/v1"
 )
 
+// gceResolver is the address of the metadata server resolver of Compute
+// Engine instances, which resolves the private zones of their network.
+const gceResolver = "169.254.169.254:53"
+
 // DNSProvider is an implementation of the DNSProvider interface.
 type DNSProvider struct {
-	project string
-	client  *dns.Service
+	project     string
+	client      *dns.Service
+	visibility  string
+	nameservers []string
 }
 
 // NewDNSProvider returns a DNSProvider instance configured for Google Cloud
 // DNS. Credentials must be passed in the environment variable: GCE_PROJECT.
+// GCE_ZONE_VISIBILITY may restrict the managed zones to "public" or
+// "private" ones. The records of private zones are checked using the comma
+// separated host:port addresses in GCE_PRIVATE_ZONE_NAMESERVERS, see
+// SetNameservers.
 func NewDNSProvider() (*DNSProvider, error) {
 	project := os.Getenv("GCE_PROJECT")
-	return NewDNSProviderCredentials(project)
+	provider, err := NewDNSProviderCredentials(project)
+	if err != nil {
+		return nil, err
+	}
+	if err := provider.SetVisibility(os.Getenv("GCE_ZONE_VISIBILITY")); err != nil {
+		return nil, err
+	}
+	if v := os.Getenv("GCE_PRIVATE_ZONE_NAMESERVERS"); v != "" {
+		provider.SetNameservers(strings.Split(v, ","))
+	}
+	return provider, nil
 }
 
 // NewDNSProviderCredentials uses the supplied credentials to return a
@@ -49,6 +70,43 @@ func NewDNSProviderCredentials(project string) (*DNSProvider, error) {
 	}, nil
 }
 
+// SetVisibility restricts the managed zones the records are created in to
+// "public" or "private" ones. Private zones are found by their DNS name
+// only, so they need not be visible in the public DNS, e.g. for internal
+// CAs. By default public zones are preferred over private zones of the
+// same name.
+func (c *DNSProvider) SetVisibility(visibility string) error {
+	switch visibility {
+	case "", "public", "private":
+		c.visibility = visibility
+		return nil
+	}
+	return fmt.Errorf("Invalid Google Cloud DNS zone visibility %q, expected public or private", visibility)
+}
+
+// SetNameservers sets the recursive nameservers, as host:port addresses,
+// which are used to check the propagation of records when the visibility
+// is "private". Private zones are invisible to public resolvers, so by
+// default the metadata server of Compute Engine instances, 169.254.169.254,
+// is used, which only answers on instances in a network the zones are
+// visible to. When running elsewhere, set resolvers forwarding to an
+// inbound server policy of that network.
+func (c *DNSProvider) SetNameservers(nameservers []string) {
+	c.nameservers = nameservers
+}
+
+// Nameservers returns the recursive nameservers to check the records
+// with, if the visibility is "private", and nil otherwise.
+func (c *DNSProvider) Nameservers() []string {
+	if c.visibility != "private" {
+		return nil
+	}
+	if len(c.nameservers) > 0 {
+		return c.nameservers
+	}
+	return []string{gceResolver}
+}
+
 // Present creates a TXT record to fulfil the dns-01 challenge.
 func (c *DNSProvider) Present(domain, token, keyAuth string) error {
 	fqdn, value, ttl := acme.DNS01Record(domain, keyAuth)
@@ -130,11 +188,21 @@ func (c *DNSProvider) Timeout() (timeout, interval time.Duration) {
 
 // getHostedZone returns the managed-zone
 func (c *DNSProvider) getHostedZone(domain string) (string, error) {
+	if c.visibility == "private" {
+		return c.findPrivateZone(acme.ToFqdn(domain))
+	}
+
 	authZone, err := acme.FindZoneByFqdn(acme.ToFqdn(domain), acme.RecursiveNameservers)
 	if err != nil {
 		return "", err
 	}
 
+	return c.findZone(authZone)
+}
+
+// findZone returns the managed zone named authZone, preferring public
+// zones unless the visibility is restricted.
+func (c *DNSProvider) findZone(authZone string) (string, error) {
 	zones, err := c.client.ManagedZones.
 		List(c.project).
 		DnsName(authZone).
@@ -143,11 +211,61 @@ func (c *DNSProvider) getHostedZone(domain string) (string, error) {
 		return "", fmt.Errorf("GoogleCloud API call failed: %v", err)
 	}
 
-	if len(zones.ManagedZones) == 0 {
+	var match *dns.ManagedZone
+	for _, zone := range zones.ManagedZones {
+		visibility := zoneVisibility(zone)
+		if c.visibility != "" && visibility != c.visibility {
+			continue
+		}
+		if match == nil || (visibility == "public" && zoneVisibility(match) != "public") {
+			match = zone
+		}
+	}
+
+	if match == nil {
 		return "", fmt.Errorf("No matching GoogleCloud domain found for domain %s", authZone)
 	}
 
-	return zones.ManagedZones[0].Name, nil
+	return match.Name, nil
+}
+
+// findPrivateZone returns the private managed zone with the longest DNS
+// name containing fqdn.
+func (c *DNSProvider) findPrivateZone(fqdn string) (string, error) {
+	fqdn = strings.ToLower(fqdn)
+	var match *dns.ManagedZone
+	err := c.client.ManagedZones.
+		List(c.project).
+		Pages(context.Background(), func(page *dns.ManagedZonesListResponse) error {
+			for _, zone := range page.ManagedZones {
+				name := strings.ToLower(zone.DnsName)
+				if zoneVisibility(zone) != "private" || (fqdn != name && !strings.HasSuffix(fqdn, "."+name)) {
+					continue
+				}
+				if match == nil || len(zone.DnsName) > len(match.DnsName) {
+					match = zone
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return "", fmt.Errorf("GoogleCloud API call failed: %v", err)
+	}
+
+	if match == nil {
+		return "", fmt.Errorf("No matching private GoogleCloud zone found for %s", fqdn)
+	}
+
+	return match.Name, nil
+}
+
+// zoneVisibility returns the visibility of zone. Zones created before
+// private zones existed report none and are public.
+func zoneVisibility(zone *dns.ManagedZone) string {
+	if zone.Visibility == "" {
+		return "public"
+	}
+	return zone.Visibility
 }
 
 func (c *DNSProvider) findTxtRecords(zone, fqdn string) ([]*dns.ResourceRecordSet, error) {
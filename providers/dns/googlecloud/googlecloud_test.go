@@ -1,7 +1,11 @@
 package googlecloud
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -9,6 +13,7 @@ import (
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/dns/v1"
 
+	"github.com/stangah/lego/acme"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -58,6 +63,88 @@ func TestNewDNSProviderMissingCredErr(t *testing.T) {
 	restoreGCloudEnv()
 }
 
+// newMixedZonesProvider returns a provider for the project p, whose
+// managed zones are a public and a private example.com zone and a private
+// internal.example.com zone.
+func newMixedZonesProvider(t *testing.T) (*DNSProvider, func()) {
+	zones := []struct{ name, dnsName, visibility string }{
+		{"public-example", "example.com.", ""},
+		{"private-example", "example.com.", "private"},
+		{"private-internal", "internal.example.com.", "private"},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/p/managedZones") {
+			t.Errorf("Unexpected request %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var list []string
+		for _, zone := range zones {
+			if dnsName := r.URL.Query().Get("dnsName"); dnsName == "" || dnsName == zone.dnsName {
+				list = append(list, fmt.Sprintf(`{"name":%q,"dnsName":%q,"visibility":%q}`, zone.name, zone.dnsName, zone.visibility))
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"managedZones":[%s]}`, strings.Join(list, ","))
+	}))
+
+	svc, err := dns.New(http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.BasePath = ts.URL + "/"
+	return &DNSProvider{project: "p", client: svc}, ts.Close
+}
+
+func TestGoogleCloudZoneVisibility(t *testing.T) {
+	provider, teardown := newMixedZonesProvider(t)
+	defer teardown()
+
+	zone, err := provider.findZone("example.com.")
+	assert.NoError(t, err)
+	assert.Equal(t, "public-example", zone, "Expected public zones to be preferred")
+
+	assert.NoError(t, provider.SetVisibility("public"))
+	zone, err = provider.findZone("example.com.")
+	assert.NoError(t, err)
+	assert.Equal(t, "public-example", zone)
+	_, err = provider.findZone("internal.example.com.")
+	assert.Error(t, err)
+
+	assert.NoError(t, provider.SetVisibility("private"))
+	zone, err = provider.findZone("example.com.")
+	assert.NoError(t, err)
+	assert.Equal(t, "private-example", zone)
+
+	for domain, expected := range map[string]string{
+		"www.example.com":          "private-example",
+		"www.internal.example.com": "private-internal",
+		"Internal.Example.com":     "private-internal",
+	} {
+		zone, err := provider.getHostedZone(domain)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, zone, "Unexpected zone for %s", domain)
+	}
+	_, err = provider.getHostedZone("example.org")
+	assert.Error(t, err)
+
+	assert.Error(t, provider.SetVisibility("internal"))
+}
+
+func TestGoogleCloudPrivateZoneNameservers(t *testing.T) {
+	provider := &DNSProvider{project: "p"}
+	var _ acme.ChallengeProviderNameservers = provider
+
+	assert.NoError(t, provider.SetVisibility("public"))
+	assert.Nil(t, provider.Nameservers(), "Expected public zones to be checked with the default resolvers")
+
+	assert.NoError(t, provider.SetVisibility("private"))
+	assert.Equal(t, []string{"169.254.169.254:53"}, provider.Nameservers())
+
+	provider.SetNameservers([]string{"10.0.0.2:53"})
+	assert.Equal(t, []string{"10.0.0.2:53"}, provider.Nameservers())
+}
+
 func TestLiveGoogleCloudPresent(t *testing.T) {
 	if !gcloudLiveTest {
 		t.Skip("skipping live test")
@@ -0,0 +1,236 @@
+// Package infoblox implements a DNS provider for solving the DNS-01
+// challenge using the WAPI of an Infoblox grid.
+package infoblox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/stangah/lego/acme"
+)
+
+// DefaultWAPIVersion is the WAPI version used when none is configured.
+const DefaultWAPIVersion = "2.7"
+
+// DefaultView is the DNS view records are created in when none is
+// configured.
+const DefaultView = "default"
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses the Infoblox WAPI to manage TXT records.
+type DNSProvider struct {
+	baseURL  string
+	username string
+	password string
+	view     string
+	ttl      int
+
+	// refs holds the object references of the records created by Present,
+	// keyed by record name and value.
+	refs   map[string]string
+	refsMu sync.Mutex
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Infoblox.
+// The grid host, username and password must be passed in the environment
+// variables INFOBLOX_HOST, INFOBLOX_USERNAME and INFOBLOX_PASSWORD. The WAPI
+// version and the DNS view can be set in INFOBLOX_WAPI_VERSION and
+// INFOBLOX_DNS_VIEW, DefaultWAPIVersion and DefaultView are used otherwise.
+func NewDNSProvider() (*DNSProvider, error) {
+	provider, err := NewDNSProviderCredentials(
+		os.Getenv("INFOBLOX_HOST"),
+		os.Getenv("INFOBLOX_USERNAME"),
+		os.Getenv("INFOBLOX_PASSWORD"),
+		os.Getenv("INFOBLOX_WAPI_VERSION"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if view := os.Getenv("INFOBLOX_DNS_VIEW"); view != "" {
+		provider.SetView(view)
+	}
+	return provider, nil
+}
+
+// NewDNSProviderCredentials uses the supplied grid host, credentials and WAPI
+// version to return a DNSProvider instance configured for Infoblox. The host
+// may include a scheme and port, https is used otherwise. An empty version
+// selects DefaultWAPIVersion.
+func NewDNSProviderCredentials(host, username, password, wapiVersion string) (*DNSProvider, error) {
+	if host == "" || username == "" || password == "" {
+		return nil, fmt.Errorf("Infoblox credentials missing")
+	}
+	if wapiVersion == "" {
+		wapiVersion = DefaultWAPIVersion
+	}
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+
+	return &DNSProvider{
+		baseURL:  fmt.Sprintf("%s/wapi/v%s", strings.TrimSuffix(host, "/"), strings.TrimPrefix(wapiVersion, "v")),
+		username: username,
+		password: password,
+		view:     DefaultView,
+		refs:     make(map[string]string),
+	}, nil
+}
+
+// SetView sets the DNS view the TXT records are created in.
+func (d *DNSProvider) SetView(view string) {
+	d.view = view
+}
+
+// SetTTL sets the TTL in seconds of the TXT records created by the provider.
+// A TTL of zero uses the TTL of the zone.
+func (d *DNSProvider) SetTTL(ttl int) error {
+	if ttl < 0 {
+		return fmt.Errorf("Infoblox TTL must not be negative, got %d", ttl)
+	}
+	d.ttl = ttl
+	return nil
+}
+
+// txtRecord is a record:txt object of the WAPI.
+type txtRecord struct {
+	Ref     string `json:"_ref,omitempty"`
+	Name    string `json:"name"`
+	Text    string `json:"text"`
+	View    string `json:"view,omitempty"`
+	TTL     int    `json:"ttl,omitempty"`
+	UseTTL  bool   `json:"use_ttl,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// Present creates a TXT record object for the challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	name := acme.UnFqdn(fqdn)
+
+	record := txtRecord{
+		Name:    name,
+		Text:    value,
+		View:    d.view,
+		TTL:     d.ttl,
+		UseTTL:  d.ttl > 0,
+		Comment: "ACME DNS-01 challenge",
+	}
+
+	var ref string
+	if err := d.doRequest("POST", "/record:txt", record, &ref); err != nil {
+		return err
+	}
+
+	d.refsMu.Lock()
+	d.refs[name+" "+value] = ref
+	d.refsMu.Unlock()
+
+	return nil
+}
+
+// CleanUp deletes the TXT record object created by Present. Records created
+// by another run are looked up by name, value and view.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	name := acme.UnFqdn(fqdn)
+
+	d.refsMu.Lock()
+	ref, ok := d.refs[name+" "+value]
+	d.refsMu.Unlock()
+
+	refs := []string{ref}
+	if !ok {
+		var err error
+		refs, err = d.findTxtRecords(name, value)
+		if err != nil {
+			return err
+		}
+		if len(refs) == 0 {
+			return fmt.Errorf("Infoblox: no TXT record found for '%s'", fqdn)
+		}
+	}
+
+	for _, ref := range refs {
+		if err := d.doRequest("DELETE", "/"+ref, nil, nil); err != nil {
+			return err
+		}
+	}
+
+	d.refsMu.Lock()
+	delete(d.refs, name+" "+value)
+	d.refsMu.Unlock()
+
+	return nil
+}
+
+// findTxtRecords returns the references of the TXT record objects with the
+// given name and value in the view of the provider.
+func (d *DNSProvider) findTxtRecords(name, value string) ([]string, error) {
+	query := url.Values{}
+	query.Set("name", name)
+	query.Set("text", value)
+	query.Set("view", d.view)
+
+	var records []txtRecord
+	if err := d.doRequest("GET", "/record:txt?"+query.Encode(), nil, &records); err != nil {
+		return nil, err
+	}
+
+	var refs []string
+	for _, record := range records {
+		refs = append(refs, record.Ref)
+	}
+	return refs, nil
+}
+
+func (d *DNSProvider) doRequest(method, uri string, body, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, d.baseURL+uri, reqBody)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(d.username, d.password)
+	req.Header.Set("User-Agent", acme.UserAgentString())
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := acme.DoWithRetry(req, nil)
+	if err != nil {
+		return fmt.Errorf("Infoblox API call failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var errInfo struct {
+			Error string `json:"Error"`
+			Text  string `json:"text"`
+		}
+		json.NewDecoder(resp.Body).Decode(&errInfo)
+		msg := errInfo.Text
+		if msg == "" {
+			msg = errInfo.Error
+		}
+		return fmt.Errorf("Infoblox API call failed: HTTP %d: %s", resp.StatusCode, msg)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
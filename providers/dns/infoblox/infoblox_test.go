@@ -0,0 +1,292 @@
+package infoblox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stangah/lego/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	infobloxLiveTest    bool
+	infobloxHost        string
+	infobloxUsername    string
+	infobloxPassword    string
+	infobloxWAPIVersion string
+	infobloxView        string
+	infobloxDomain      string
+)
+
+func init() {
+	infobloxHost = os.Getenv("INFOBLOX_HOST")
+	infobloxUsername = os.Getenv("INFOBLOX_USERNAME")
+	infobloxPassword = os.Getenv("INFOBLOX_PASSWORD")
+	infobloxWAPIVersion = os.Getenv("INFOBLOX_WAPI_VERSION")
+	infobloxView = os.Getenv("INFOBLOX_DNS_VIEW")
+	infobloxDomain = os.Getenv("INFOBLOX_DOMAIN")
+	if len(infobloxHost) > 0 && len(infobloxUsername) > 0 && len(infobloxPassword) > 0 && len(infobloxDomain) > 0 {
+		infobloxLiveTest = true
+	}
+}
+
+func restoreInfobloxEnv() {
+	os.Setenv("INFOBLOX_HOST", infobloxHost)
+	os.Setenv("INFOBLOX_USERNAME", infobloxUsername)
+	os.Setenv("INFOBLOX_PASSWORD", infobloxPassword)
+	os.Setenv("INFOBLOX_WAPI_VERSION", infobloxWAPIVersion)
+	os.Setenv("INFOBLOX_DNS_VIEW", infobloxView)
+}
+
+// fakeWAPI serves the record:txt objects of the WAPI from memory.
+type fakeWAPI struct {
+	mu      sync.Mutex
+	records map[string]txtRecord
+	deleted []string
+	next    int
+}
+
+func (f *fakeWAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/wapi/v2.5/")
+	switch {
+	case r.Method == "POST" && path == "record:txt":
+		var record txtRecord
+		if err := json.NewDecoder(r.Body).Decode(&record); err != nil || record.View == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"Error":"AdmConProtoError: Invalid value","code":"Client.Ibap.Proto","text":"Invalid value"}`)
+			return
+		}
+		f.next++
+		record.Ref = fmt.Sprintf("record:txt/ZG5z%d:%s/%s", f.next, record.Name, record.View)
+		f.records[record.Ref] = record
+		json.NewEncoder(w).Encode(record.Ref)
+	case r.Method == "GET" && path == "record:txt":
+		query := r.URL.Query()
+		records := []txtRecord{}
+		for _, record := range f.records {
+			if record.Name == query.Get("name") && record.Text == query.Get("text") && record.View == query.Get("view") {
+				records = append(records, record)
+			}
+		}
+		json.NewEncoder(w).Encode(records)
+	case r.Method == "DELETE":
+		if _, ok := f.records[path]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"Error":"AdmConDataNotFoundError: Reference not found","code":"Client.Ibap.Data.NotFound","text":"Reference not found"}`)
+			return
+		}
+		delete(f.records, path)
+		f.deleted = append(f.deleted, path)
+		json.NewEncoder(w).Encode(path)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newFakeWAPI() (*fakeWAPI, *httptest.Server) {
+	fake := &fakeWAPI{records: map[string]txtRecord{}}
+	return fake, httptest.NewServer(fake)
+}
+
+func TestNewDNSProviderValid(t *testing.T) {
+	os.Setenv("INFOBLOX_HOST", "")
+	os.Setenv("INFOBLOX_USERNAME", "")
+	os.Setenv("INFOBLOX_PASSWORD", "")
+	defer restoreInfobloxEnv()
+	provider, err := NewDNSProviderCredentials("grid.example.com", "admin", "secret", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://grid.example.com/wapi/v"+DefaultWAPIVersion, provider.baseURL)
+	assert.Equal(t, DefaultView, provider.view)
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	os.Setenv("INFOBLOX_HOST", "http://grid.example.com:8080/")
+	os.Setenv("INFOBLOX_USERNAME", "admin")
+	os.Setenv("INFOBLOX_PASSWORD", "secret")
+	os.Setenv("INFOBLOX_WAPI_VERSION", "v2.10")
+	os.Setenv("INFOBLOX_DNS_VIEW", "external")
+	defer restoreInfobloxEnv()
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, "http://grid.example.com:8080/wapi/v2.10", provider.baseURL)
+	assert.Equal(t, "external", provider.view)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	os.Setenv("INFOBLOX_HOST", "grid.example.com")
+	os.Setenv("INFOBLOX_USERNAME", "admin")
+	os.Setenv("INFOBLOX_PASSWORD", "")
+	defer restoreInfobloxEnv()
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "Infoblox credentials missing")
+}
+
+func TestInfobloxPresent(t *testing.T) {
+	var requestReceived bool
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestReceived = true
+
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/wapi/v2.5/record:txt", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		user, pass, _ := r.BasicAuth()
+		assert.Equal(t, "admin", user)
+		assert.Equal(t, "secret", pass)
+
+		reqBody, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"name":"_acme-challenge.www.example.com","text":"`+value+`","view":"default","comment":"ACME DNS-01 challenge"}`, string(reqBody))
+
+		fmt.Fprint(w, `"record:txt/ZG5zLmJpbmRfdHh0:_acme-challenge.www.example.com/default"`)
+	}))
+	defer server.Close()
+
+	provider, err := NewDNSProviderCredentials(server.URL, "admin", "secret", "2.5")
+	assert.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.True(t, requestReceived, "Expected request to be received by mock backend")
+	assert.Equal(t, map[string]string{
+		"_acme-challenge.www.example.com " + value: "record:txt/ZG5zLmJpbmRfdHh0:_acme-challenge.www.example.com/default",
+	}, provider.refs)
+}
+
+func TestInfobloxCleanUp(t *testing.T) {
+	var requestReceived bool
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestReceived = true
+
+		// The record is deleted by the reference remembered by Present.
+		assert.Equal(t, "DELETE", r.Method)
+		assert.Equal(t, "/wapi/v2.5/record:txt/ZG5zLmJpbmRfdHh0:_acme-challenge.www.example.com/default", r.URL.Path)
+
+		fmt.Fprint(w, `"record:txt/ZG5zLmJpbmRfdHh0:_acme-challenge.www.example.com/default"`)
+	}))
+	defer server.Close()
+
+	provider, err := NewDNSProviderCredentials(server.URL, "admin", "secret", "2.5")
+	assert.NoError(t, err)
+	provider.refs["_acme-challenge.www.example.com "+value] = "record:txt/ZG5zLmJpbmRfdHh0:_acme-challenge.www.example.com/default"
+
+	err = provider.CleanUp("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.True(t, requestReceived, "Expected request to be received by mock backend")
+	assert.Empty(t, provider.refs)
+}
+
+func TestInfobloxViewAndTTL(t *testing.T) {
+	fake, server := newFakeWAPI()
+	defer server.Close()
+
+	provider, err := NewDNSProviderCredentials(server.URL, "admin", "secret", "2.5")
+	assert.NoError(t, err)
+	provider.SetView("internal")
+	assert.Error(t, provider.SetTTL(-1))
+	assert.NoError(t, provider.SetTTL(120))
+
+	assert.NoError(t, provider.Present("example.com", "", "key"))
+	_, value, _ := acme.DNS01Record("example.com", "key")
+	assert.Equal(t, map[string]txtRecord{
+		"record:txt/ZG5z1:_acme-challenge.example.com/internal": {
+			Ref:     "record:txt/ZG5z1:_acme-challenge.example.com/internal",
+			Name:    "_acme-challenge.example.com",
+			Text:    value,
+			View:    "internal",
+			TTL:     120,
+			UseTTL:  true,
+			Comment: "ACME DNS-01 challenge",
+		},
+	}, fake.records)
+
+	assert.NoError(t, provider.CleanUp("example.com", "", "key"))
+	assert.Equal(t, []string{"record:txt/ZG5z1:_acme-challenge.example.com/internal"}, fake.deleted)
+	assert.Empty(t, fake.records)
+}
+
+func TestInfobloxCleanUpLooksUpReference(t *testing.T) {
+	fake, server := newFakeWAPI()
+	defer server.Close()
+
+	provider, err := NewDNSProviderCredentials(server.URL, "admin", "secret", "v2.5")
+	assert.NoError(t, err)
+	assert.NoError(t, provider.Present("example.com", "", "key"))
+	assert.NoError(t, provider.Present("example.com", "", "other"))
+
+	// A new provider does not know the references of the records, and only
+	// finds the ones in its view.
+	other, err := NewDNSProviderCredentials(server.URL, "admin", "secret", "2.5")
+	assert.NoError(t, err)
+	other.SetView("internal")
+	assert.EqualError(t, other.CleanUp("example.com", "", "key"), "Infoblox: no TXT record found for '_acme-challenge.example.com.'")
+
+	provider, err = NewDNSProviderCredentials(server.URL, "admin", "secret", "2.5")
+	assert.NoError(t, err)
+	assert.NoError(t, provider.CleanUp("example.com", "", "key"))
+	assert.Equal(t, []string{"record:txt/ZG5z1:_acme-challenge.example.com/default"}, fake.deleted)
+	assert.Len(t, fake.records, 1)
+
+	assert.EqualError(t, provider.CleanUp("example.com", "", "key"), "Infoblox: no TXT record found for '_acme-challenge.example.com.'")
+}
+
+func TestInfobloxPresentFailed(t *testing.T) {
+	_, server := newFakeWAPI()
+	defer server.Close()
+
+	provider, err := NewDNSProviderCredentials(server.URL, "admin", "wrong", "2.5")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.com", "", "key"), "Infoblox API call failed: HTTP 401: ")
+
+	// The text of WAPI errors is reported.
+	provider, err = NewDNSProviderCredentials(server.URL, "admin", "secret", "2.5")
+	assert.NoError(t, err)
+	provider.SetView("")
+	assert.EqualError(t, provider.Present("example.com", "", "key"), "Infoblox API call failed: HTTP 400: Invalid value")
+}
+
+func TestLiveInfobloxPresent(t *testing.T) {
+	if !infobloxLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+
+	err = provider.Present(infobloxDomain, "", "123d==")
+	assert.NoError(t, err)
+}
+
+func TestLiveInfobloxCleanUp(t *testing.T) {
+	if !infobloxLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	time.Sleep(time.Second * 1)
+
+	// A new provider looks the record up by name, value and view.
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+
+	err = provider.CleanUp(infobloxDomain, "", "123d==")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,340 @@
+// Package loopia implements a DNS provider for solving the DNS-01
+// challenge using Loopia DNS.
+package loopia
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/stangah/lego/acme"
+)
+
+// Loopia API reference: https://www.loopia.com/api/
+
+var (
+	// endpoint is the Loopia XML-RPC endpoint used by Present and
+	// CleanUp. It is overridden during tests.
+	endpoint = "https://api.loopia.se/RPCSERV"
+	// findZoneByFqdn determines the DNS zone of an fqdn. It is overridden
+	// during tests.
+	findZoneByFqdn = acme.FindZoneByFqdn
+)
+
+// minTTL is the minimum TTL accepted by Loopia.
+const minTTL = 300
+
+// DNSProvider is an implementation of the acme.ChallengeProvider
+// interface that uses Loopia's XML-RPC API to manage TXT records for a
+// domain.
+type DNSProvider struct {
+	apiUser     string
+	apiPassword string
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Loopia.
+// Credentials must be passed in the environment variables
+// LOOPIA_API_USER and LOOPIA_API_PASSWORD.
+func NewDNSProvider() (*DNSProvider, error) {
+	return NewDNSProviderCredentials(os.Getenv("LOOPIA_API_USER"), os.Getenv("LOOPIA_API_PASSWORD"))
+}
+
+// NewDNSProviderCredentials uses the supplied credentials to return a
+// DNSProvider instance configured for Loopia.
+func NewDNSProviderCredentials(apiUser, apiPassword string) (*DNSProvider, error) {
+	if apiUser == "" || apiPassword == "" {
+		return nil, fmt.Errorf("Loopia credentials missing")
+	}
+	return &DNSProvider{apiUser: apiUser, apiPassword: apiPassword}, nil
+}
+
+// Present adds a TXT record with the challenge value to the subdomain of
+// the challenge name.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, ttl := acme.DNS01Record(domain, keyAuth)
+	if ttl < minTTL {
+		ttl = minTTL
+	}
+	zone, subdomain, err := recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+	return d.addTXTRecord(zone, subdomain, value, ttl)
+}
+
+// CleanUp removes the TXT records with the challenge value from the
+// subdomain of the challenge name, leaving other records alone.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	zone, subdomain, err := recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+	records, err := d.getZoneRecords(zone, subdomain)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if record.recordType != "TXT" || record.rdata != value {
+			continue
+		}
+		if err := d.removeZoneRecord(zone, subdomain, record.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordLocation returns the domain registered at Loopia containing fqdn
+// and the subdomain of fqdn relative to it. The apex is named "@".
+func recordLocation(fqdn string) (string, string, error) {
+	authZone, err := findZoneByFqdn(fqdn, acme.RecursiveNameservers)
+	if err != nil {
+		return "", "", fmt.Errorf("Loopia DNS: findZoneByFqdn failure: %v", err)
+	}
+	zone := strings.ToLower(acme.UnFqdn(authZone))
+	name := strings.ToLower(acme.UnFqdn(fqdn))
+	if name == zone {
+		return zone, "@", nil
+	}
+	if !strings.HasSuffix(name, "."+zone) {
+		return "", "", fmt.Errorf("Loopia DNS: unexpected authZone %s for fqdn %s", authZone, fqdn)
+	}
+	return zone, strings.TrimSuffix(name, "."+zone), nil
+}
+
+// types for XML-RPC method calls and parameters
+
+type param interface {
+	param()
+}
+type paramString struct {
+	XMLName xml.Name `xml:"param"`
+	Value   string   `xml:"value>string"`
+}
+type paramInt struct {
+	XMLName xml.Name `xml:"param"`
+	Value   int      `xml:"value>int"`
+}
+
+type structMember interface {
+	structMember()
+}
+type structMemberString struct {
+	Name  string `xml:"name"`
+	Value string `xml:"value>string"`
+}
+type structMemberInt struct {
+	Name  string `xml:"name"`
+	Value int    `xml:"value>int"`
+}
+type paramStruct struct {
+	XMLName       xml.Name       `xml:"param"`
+	StructMembers []structMember `xml:"value>struct>member"`
+}
+
+func (p paramString) param()               {}
+func (p paramInt) param()                  {}
+func (m structMemberString) structMember() {}
+func (m structMemberInt) structMember()    {}
+func (p paramStruct) param()               {}
+
+// methodCall is an XML-RPC method call. The parameters are wrapped in a
+// params element as required by the XML-RPC specification.
+type methodCall struct {
+	XMLName    xml.Name `xml:"methodCall"`
+	MethodName string   `xml:"methodName"`
+	Params     []param  `xml:"params>param"`
+}
+
+// types for XML-RPC responses
+
+type response interface {
+	faultCode() int
+	faultString() string
+}
+
+type responseFault struct {
+	FaultCode   int    `xml:"fault>value>struct>member>value>int"`
+	FaultString string `xml:"fault>value>struct>member>value>string"`
+}
+
+func (r responseFault) faultCode() int      { return r.FaultCode }
+func (r responseFault) faultString() string { return r.FaultString }
+
+type responseString struct {
+	responseFault
+	Value string `xml:"params>param>value>string"`
+}
+
+type responseRecordList struct {
+	responseFault
+	Records []struct {
+		StructMembers []struct {
+			Name        string `xml:"name"`
+			ValueInt    int    `xml:"value>int"`
+			ValueString string `xml:"value>string"`
+		} `xml:"struct>member"`
+	} `xml:"params>param>value>array>data>value"`
+	// Value is set instead of Records if the call failed.
+	Value string `xml:"params>param>value>string"`
+}
+
+// POSTing/Marshalling/Unmarshalling
+
+type rpcError struct {
+	faultCode   int
+	faultString string
+}
+
+func (e rpcError) Error() string {
+	return fmt.Sprintf(
+		"Loopia DNS: RPC Error: (%d) %s", e.faultCode, e.faultString)
+}
+
+// rpcCall makes an XML-RPC call to Loopia's RPC endpoint by marshalling
+// the data given in the call argument to XML and sending that via HTTP
+// Post to Loopia. The response is then unmarshalled into the resp
+// argument.
+func (d *DNSProvider) rpcCall(call *methodCall, resp response) error {
+	// marshal
+	b, err := xml.MarshalIndent(call, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Loopia DNS: Marshal Error: %v", err)
+	}
+	b = append([]byte(`<?xml version="1.0"?>`+"\n"), b...)
+	// post
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("Loopia DNS: HTTP Post Error: %v", err)
+	}
+	req.Header.Set("Content-Type", "text/xml")
+	req.Header.Set("User-Agent", acme.UserAgentString())
+	httpResp, err := acme.DoWithRetry(req, nil)
+	if err != nil {
+		return fmt.Errorf("Loopia DNS: HTTP Post Error: %v", err)
+	}
+	defer httpResp.Body.Close()
+	respBody, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return fmt.Errorf("Loopia DNS: HTTP Post Error: %v", err)
+	}
+	// unmarshal
+	err = xml.Unmarshal(respBody, resp)
+	if err != nil {
+		return fmt.Errorf("Loopia DNS: Unmarshal Error: %v", err)
+	}
+	if resp.faultCode() != 0 {
+		return rpcError{
+			faultCode: resp.faultCode(), faultString: resp.faultString()}
+	}
+	return nil
+}
+
+// functions to perform API actions
+
+// zoneRecord is a record as returned by getZoneRecords.
+type zoneRecord struct {
+	id         int
+	recordType string
+	rdata      string
+}
+
+func (d *DNSProvider) addTXTRecord(domain, subdomain, value string, ttl int) error {
+	resp := &responseString{}
+	err := d.rpcCall(&methodCall{
+		MethodName: "addZoneRecord",
+		Params: []param{
+			paramString{Value: d.apiUser},
+			paramString{Value: d.apiPassword},
+			paramString{Value: domain},
+			paramString{Value: subdomain},
+			paramStruct{
+				StructMembers: []structMember{
+					structMemberString{
+						Name:  "type",
+						Value: "TXT",
+					}, structMemberInt{
+						Name:  "ttl",
+						Value: ttl,
+					}, structMemberInt{
+						Name:  "priority",
+						Value: 0,
+					}, structMemberString{
+						Name:  "rdata",
+						Value: value,
+					}, structMemberInt{
+						Name:  "record_id",
+						Value: 0,
+					}},
+			},
+		},
+	}, resp)
+	if err != nil {
+		return err
+	}
+	if resp.Value != "OK" {
+		return fmt.Errorf("Loopia DNS: could not add TXT record to %s.%s: %s", subdomain, domain, resp.Value)
+	}
+	return nil
+}
+
+func (d *DNSProvider) getZoneRecords(domain, subdomain string) ([]zoneRecord, error) {
+	resp := &responseRecordList{}
+	err := d.rpcCall(&methodCall{
+		MethodName: "getZoneRecords",
+		Params: []param{
+			paramString{Value: d.apiUser},
+			paramString{Value: d.apiPassword},
+			paramString{Value: domain},
+			paramString{Value: subdomain},
+		},
+	}, resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Value != "" {
+		return nil, fmt.Errorf("Loopia DNS: could not get records of %s.%s: %s", subdomain, domain, resp.Value)
+	}
+	var records []zoneRecord
+	for _, r := range resp.Records {
+		var record zoneRecord
+		for _, member := range r.StructMembers {
+			switch member.Name {
+			case "record_id":
+				record.id = member.ValueInt
+			case "type":
+				record.recordType = member.ValueString
+			case "rdata":
+				record.rdata = member.ValueString
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (d *DNSProvider) removeZoneRecord(domain, subdomain string, recordID int) error {
+	resp := &responseString{}
+	err := d.rpcCall(&methodCall{
+		MethodName: "removeZoneRecord",
+		Params: []param{
+			paramString{Value: d.apiUser},
+			paramString{Value: d.apiPassword},
+			paramString{Value: domain},
+			paramString{Value: subdomain},
+			paramInt{Value: recordID},
+		},
+	}, resp)
+	if err != nil {
+		return err
+	}
+	if resp.Value != "OK" {
+		return fmt.Errorf("Loopia DNS: could not remove record %d from %s.%s: %s", recordID, subdomain, domain, resp.Value)
+	}
+	return nil
+}
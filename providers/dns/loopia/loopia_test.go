@@ -0,0 +1,283 @@
+package loopia
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var regexpMethodName = regexp.MustCompile(`<methodName>([^<]*)</methodName>`)
+
+// TestDNSProvider runs Present and CleanUp against a fake Loopia RPC
+// Server, whose responses are predetermined for particular requests.
+func TestDNSProvider(t *testing.T) {
+	fakeKeyAuth := "XXXX"
+	provider, err := NewDNSProviderCredentials("user@loopiaapi", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var methods []string
+	// start fake RPC server
+	fakeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "text/xml" {
+			t.Fatalf("Content-Type: text/xml header not found")
+		}
+		req, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, ok := serverResponses[string(req)]
+		if !ok {
+			t.Fatalf("Server response for request not found: %s", req)
+		}
+		methods = append(methods, regexpMethodName.FindStringSubmatch(string(req))[1])
+		_, err = io.Copy(w, strings.NewReader(resp))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}))
+	defer fakeServer.Close()
+	// define function to override findZoneByFqdn with
+	fakeFindZoneByFqdn := func(fqdn string, nameserver []string) (string, error) {
+		return "example.com.", nil
+	}
+	// override loopia endpoint and findZoneByFqdn function
+	savedEndpoint, savedFindZoneByFqdn := endpoint, findZoneByFqdn
+	defer func() {
+		endpoint, findZoneByFqdn = savedEndpoint, savedFindZoneByFqdn
+	}()
+	endpoint, findZoneByFqdn = fakeServer.URL+"/RPCSERV", fakeFindZoneByFqdn
+	// run Present
+	err = provider.Present("abc.def.example.com", "", fakeKeyAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// run CleanUp
+	err = provider.CleanUp("abc.def.example.com", "", fakeKeyAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(methods, " ") != "addZoneRecord getZoneRecords removeZoneRecord" {
+		t.Errorf("Unexpected RPC calls %v", methods)
+	}
+}
+
+// TestDNSProviderAuthError checks that status strings returned instead
+// of a result are reported as errors.
+func TestDNSProviderAuthError(t *testing.T) {
+	provider, err := NewDNSProviderCredentials("user@loopiaapi", "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?>
+<methodResponse><params><param><value><string>AUTH_ERROR</string></value></param></params></methodResponse>`)
+	}))
+	defer fakeServer.Close()
+	savedEndpoint, savedFindZoneByFqdn := endpoint, findZoneByFqdn
+	defer func() {
+		endpoint, findZoneByFqdn = savedEndpoint, savedFindZoneByFqdn
+	}()
+	endpoint = fakeServer.URL + "/RPCSERV"
+	findZoneByFqdn = func(fqdn string, nameserver []string) (string, error) {
+		return "example.com.", nil
+	}
+	err = provider.Present("example.com", "", "XXXX")
+	if err == nil || err.Error() != "Loopia DNS: could not add TXT record to _acme-challenge.example.com: AUTH_ERROR" {
+		t.Errorf("Unexpected error %v", err)
+	}
+	err = provider.CleanUp("example.com", "", "XXXX")
+	if err == nil || err.Error() != "Loopia DNS: could not get records of _acme-challenge.example.com: AUTH_ERROR" {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+// TestNewDNSProviderEnv checks that both the API user and password are
+// required.
+func TestNewDNSProviderEnv(t *testing.T) {
+	for _, name := range []string{"LOOPIA_API_USER", "LOOPIA_API_PASSWORD"} {
+		defer os.Setenv(name, os.Getenv(name))
+	}
+	os.Setenv("LOOPIA_API_USER", "user@loopiaapi")
+	os.Setenv("LOOPIA_API_PASSWORD", "")
+	_, err := NewDNSProvider()
+	if err == nil {
+		t.Error("Expected an error without credentials")
+	}
+	os.Setenv("LOOPIA_API_PASSWORD", "secret")
+	provider, err := NewDNSProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.apiUser != "user@loopiaapi" || provider.apiPassword != "secret" {
+		t.Errorf("Unexpected provider configuration %+v", provider)
+	}
+}
+
+// serverResponses is the XML-RPC Responses to be used by the fake RPC
+// server. The map key is the expected request body.
+var serverResponses = map[string]string{
+	// Present Request->Response 1 (addZoneRecord)
+	`<?xml version="1.0"?>
+<methodCall>
+  <methodName>addZoneRecord</methodName>
+  <params>
+    <param>
+      <value>
+        <string>user@loopiaapi</string>
+      </value>
+    </param>
+    <param>
+      <value>
+        <string>secret</string>
+      </value>
+    </param>
+    <param>
+      <value>
+        <string>example.com</string>
+      </value>
+    </param>
+    <param>
+      <value>
+        <string>_acme-challenge.abc.def</string>
+      </value>
+    </param>
+    <param>
+      <value>
+        <struct>
+          <member>
+            <name>type</name>
+            <value>
+              <string>TXT</string>
+            </value>
+          </member>
+          <member>
+            <name>ttl</name>
+            <value>
+              <int>300</int>
+            </value>
+          </member>
+          <member>
+            <name>priority</name>
+            <value>
+              <int>0</int>
+            </value>
+          </member>
+          <member>
+            <name>rdata</name>
+            <value>
+              <string>ezRpBPY8wH8djMLYjX2uCKPwiKDkFZ1SFMJ6ZXGlHrQ</string>
+            </value>
+          </member>
+          <member>
+            <name>record_id</name>
+            <value>
+              <int>0</int>
+            </value>
+          </member>
+        </struct>
+      </value>
+    </param>
+  </params>
+</methodCall>`: `<?xml version="1.0" encoding="UTF-8"?>
+<methodResponse>
+<params>
+<param>
+<value><string>OK</string></value>
+</param>
+</params>
+</methodResponse>`,
+	// CleanUp Request->Response 1 (getZoneRecords)
+	`<?xml version="1.0"?>
+<methodCall>
+  <methodName>getZoneRecords</methodName>
+  <params>
+    <param>
+      <value>
+        <string>user@loopiaapi</string>
+      </value>
+    </param>
+    <param>
+      <value>
+        <string>secret</string>
+      </value>
+    </param>
+    <param>
+      <value>
+        <string>example.com</string>
+      </value>
+    </param>
+    <param>
+      <value>
+        <string>_acme-challenge.abc.def</string>
+      </value>
+    </param>
+  </params>
+</methodCall>`: `<?xml version="1.0" encoding="UTF-8"?>
+<methodResponse>
+<params>
+<param>
+<value><array><data>
+<value><struct>
+<member><name>type</name><value><string>TXT</string></value></member>
+<member><name>ttl</name><value><int>300</int></value></member>
+<member><name>priority</name><value><int>0</int></value></member>
+<member><name>rdata</name><value><string>other-challenge</string></value></member>
+<member><name>record_id</name><value><int>11</int></value></member>
+</struct></value>
+<value><struct>
+<member><name>type</name><value><string>TXT</string></value></member>
+<member><name>ttl</name><value><int>300</int></value></member>
+<member><name>priority</name><value><int>0</int></value></member>
+<member><name>rdata</name><value><string>ezRpBPY8wH8djMLYjX2uCKPwiKDkFZ1SFMJ6ZXGlHrQ</string></value></member>
+<member><name>record_id</name><value><int>12</int></value></member>
+</struct></value>
+</data></array></value>
+</param>
+</params>
+</methodResponse>`,
+	// CleanUp Request->Response 2 (removeZoneRecord)
+	`<?xml version="1.0"?>
+<methodCall>
+  <methodName>removeZoneRecord</methodName>
+  <params>
+    <param>
+      <value>
+        <string>user@loopiaapi</string>
+      </value>
+    </param>
+    <param>
+      <value>
+        <string>secret</string>
+      </value>
+    </param>
+    <param>
+      <value>
+        <string>example.com</string>
+      </value>
+    </param>
+    <param>
+      <value>
+        <string>_acme-challenge.abc.def</string>
+      </value>
+    </param>
+    <param>
+      <value>
+        <int>12</int>
+      </value>
+    </param>
+  </params>
+</methodCall>`: `<?xml version="1.0" encoding="UTF-8"?>
+<methodResponse>
+<params>
+<param>
+<value><string>OK</string></value>
+</param>
+</params>
+</methodResponse>`,
+}
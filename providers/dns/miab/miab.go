@@ -0,0 +1,86 @@
+// Package miab implements a DNS provider for solving the DNS-01 challenge
+// using the custom DNS API of Mail-in-a-Box.
+package miab
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/stangah/lego/acme"
+)
+
+// Mail-in-a-Box API reference: https://mailinabox.email/api-docs.html
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses the custom DNS API of a Mail-in-a-Box to manage TXT records.
+type DNSProvider struct {
+	baseURL  string
+	username string
+	password string
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for
+// Mail-in-a-Box. The URL of the box, e.g. https://box.example.com, and the
+// credentials of an admin user must be passed in the environment variables
+// MIAB_BASE_URL, MIAB_USERNAME and MIAB_PASSWORD.
+func NewDNSProvider() (*DNSProvider, error) {
+	return NewDNSProviderCredentials(os.Getenv("MIAB_BASE_URL"), os.Getenv("MIAB_USERNAME"), os.Getenv("MIAB_PASSWORD"))
+}
+
+// NewDNSProviderCredentials uses the supplied URL and credentials to return
+// a DNSProvider instance configured for Mail-in-a-Box.
+func NewDNSProviderCredentials(baseURL, username, password string) (*DNSProvider, error) {
+	if baseURL == "" || username == "" || password == "" {
+		return nil, fmt.Errorf("Mail-in-a-Box credentials missing")
+	}
+	return &DNSProvider{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		username: username,
+		password: password,
+	}, nil
+}
+
+// Present adds a TXT record to fulfil the dns-01 challenge. Other TXT
+// records of the same name are kept.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	return d.doRequest("POST", fqdn, value)
+}
+
+// CleanUp removes the TXT record with the value of the challenge.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	return d.doRequest("DELETE", fqdn, value)
+}
+
+// doRequest sends a request for the TXT record fqdn with the given value to
+// the custom DNS API.
+func (d *DNSProvider) doRequest(method, fqdn, value string) error {
+	uri := fmt.Sprintf("%s/admin/dns/custom/%s/txt", d.baseURL, url.PathEscape(acme.UnFqdn(fqdn)))
+	body := url.Values{"value": {value}}.Encode()
+
+	req, err := http.NewRequest(method, uri, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(d.username, d.password)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", acme.UserAgentString())
+
+	resp, err := acme.DoWithRetry(req, nil)
+	if err != nil {
+		return fmt.Errorf("Mail-in-a-Box API call failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Mail-in-a-Box API call failed: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
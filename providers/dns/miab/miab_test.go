@@ -0,0 +1,204 @@
+package miab
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stangah/lego/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	miabLiveTest bool
+	miabBaseURL  string
+	miabUsername string
+	miabPassword string
+	miabDomain   string
+)
+
+func init() {
+	miabBaseURL = os.Getenv("MIAB_BASE_URL")
+	miabUsername = os.Getenv("MIAB_USERNAME")
+	miabPassword = os.Getenv("MIAB_PASSWORD")
+	miabDomain = os.Getenv("MIAB_DOMAIN")
+	if len(miabBaseURL) > 0 && len(miabUsername) > 0 && len(miabPassword) > 0 && len(miabDomain) > 0 {
+		miabLiveTest = true
+	}
+}
+
+func restoreMiabEnv() {
+	os.Setenv("MIAB_BASE_URL", miabBaseURL)
+	os.Setenv("MIAB_USERNAME", miabUsername)
+	os.Setenv("MIAB_PASSWORD", miabPassword)
+}
+
+// fakeMiab records the requests to the custom DNS API of Mail-in-a-Box.
+type fakeMiab struct {
+	requests []string
+}
+
+func (f *fakeMiab) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, password, ok := r.BasicAuth(); !ok || user != "admin@example.com" || password != "secret" {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Incorrect username or password"))
+		return
+	}
+	// Mail-in-a-Box reads the form in the body of DELETE requests too,
+	// which Request.ParseForm does not.
+	body, _ := ioutil.ReadAll(r.Body)
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path+" "+form.Get("value"))
+	w.Write([]byte("updated DNS: example.com"))
+}
+
+func TestNewDNSProviderValid(t *testing.T) {
+	os.Setenv("MIAB_BASE_URL", "")
+	os.Setenv("MIAB_USERNAME", "")
+	os.Setenv("MIAB_PASSWORD", "")
+	defer restoreMiabEnv()
+	_, err := NewDNSProviderCredentials("https://box.example.com", "admin@example.com", "secret")
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	os.Setenv("MIAB_BASE_URL", "https://box.example.com/")
+	os.Setenv("MIAB_USERNAME", "admin@example.com")
+	os.Setenv("MIAB_PASSWORD", "secret")
+	defer restoreMiabEnv()
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, "https://box.example.com", provider.baseURL)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	os.Setenv("MIAB_BASE_URL", "https://box.example.com")
+	os.Setenv("MIAB_USERNAME", "admin@example.com")
+	os.Setenv("MIAB_PASSWORD", "")
+	defer restoreMiabEnv()
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "Mail-in-a-Box credentials missing")
+}
+
+func TestMiabPresent(t *testing.T) {
+	var requestReceived bool
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestReceived = true
+
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/admin/dns/custom/_acme-challenge.www.example.com/txt", r.URL.Path)
+		assert.Equal(t, "application/x-www-form-urlencoded", r.Header.Get("Content-Type"))
+		user, password, _ := r.BasicAuth()
+		assert.Equal(t, "admin@example.com", user)
+		assert.Equal(t, "secret", password)
+
+		reqBody, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, url.Values{"value": {value}}.Encode(), string(reqBody))
+
+		w.Write([]byte("updated DNS: example.com"))
+	}))
+	defer server.Close()
+
+	provider, err := NewDNSProviderCredentials(server.URL+"/", "admin@example.com", "secret")
+	assert.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.True(t, requestReceived, "Expected request to be received by mock backend")
+}
+
+func TestMiabCleanUp(t *testing.T) {
+	var requestReceived bool
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestReceived = true
+
+		// Only the record with our value is deleted, not the whole name.
+		assert.Equal(t, "DELETE", r.Method)
+		assert.Equal(t, "/admin/dns/custom/_acme-challenge.www.example.com/txt", r.URL.Path)
+		assert.Equal(t, "application/x-www-form-urlencoded", r.Header.Get("Content-Type"))
+
+		reqBody, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, url.Values{"value": {value}}.Encode(), string(reqBody))
+
+		w.Write([]byte("updated DNS: example.com"))
+	}))
+	defer server.Close()
+
+	provider, err := NewDNSProviderCredentials(server.URL, "admin@example.com", "secret")
+	assert.NoError(t, err)
+
+	err = provider.CleanUp("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.True(t, requestReceived, "Expected request to be received by mock backend")
+}
+
+func TestMiabSharedName(t *testing.T) {
+	fake := &fakeMiab{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	provider, err := NewDNSProviderCredentials(server.URL, "admin@example.com", "secret")
+	assert.NoError(t, err)
+
+	// Each challenge value of a name is added and removed on its own.
+	assert.NoError(t, provider.Present("example.com", "", "key"))
+	assert.NoError(t, provider.Present("example.com", "", "other"))
+	assert.NoError(t, provider.CleanUp("example.com", "", "key"))
+
+	_, value, _ := acme.DNS01Record("example.com", "key")
+	_, other, _ := acme.DNS01Record("example.com", "other")
+	assert.Equal(t, []string{
+		"POST /admin/dns/custom/_acme-challenge.example.com/txt " + value,
+		"POST /admin/dns/custom/_acme-challenge.example.com/txt " + other,
+		"DELETE /admin/dns/custom/_acme-challenge.example.com/txt " + value,
+	}, fake.requests)
+}
+
+func TestMiabPresentFailed(t *testing.T) {
+	server := httptest.NewServer(&fakeMiab{})
+	defer server.Close()
+
+	provider, err := NewDNSProviderCredentials(server.URL, "admin@example.com", "wrong")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.com", "", "key"), "Mail-in-a-Box API call failed: HTTP 403: Incorrect username or password")
+}
+
+func TestLiveMiabPresent(t *testing.T) {
+	if !miabLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+
+	err = provider.Present(miabDomain, "", "123d==")
+	assert.NoError(t, err)
+}
+
+func TestLiveMiabCleanUp(t *testing.T) {
+	if !miabLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	time.Sleep(time.Second * 1)
+
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+
+	err = provider.CleanUp(miabDomain, "", "123d==")
+	assert.NoError(t, err)
+}
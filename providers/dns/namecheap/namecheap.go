@@ -80,6 +80,12 @@ func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
 	return 60 * time.Minute, 15 * time.Second
 }
 
+// Sequential reports that Present and CleanUp must not be called
+// concurrently, since both rewrite the entire list of DNS records.
+func (d *DNSProvider) Sequential() bool {
+	return true
+}
+
 // host describes a DNS record returned by the Namecheap DNS gethosts API.
 // Namecheap uses the term "host" to refer to all DNS records that include
 // a host field (A, AAAA, CNAME, NS, TXT, URL).
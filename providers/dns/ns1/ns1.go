@@ -5,7 +5,9 @@ package ns1
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/stangah/lego/acme"
@@ -15,42 +17,127 @@ import (
 
 // DNSProvider is an implementation of the acme.ChallengeProvider interface.
 type DNSProvider struct {
-	client *rest.Client
+	client    *rest.Client
+	overrides map[string]zoneOverride
+}
+
+// zoneOverride is the location of the TXT record of a challenge whose zone
+// cannot be derived from the domain, e.g. because the challenge is
+// delegated to a separate validation zone by a CNAME record.
+type zoneOverride struct {
+	zone   string
+	record string
 }
 
 // NewDNSProvider returns a DNSProvider instance configured for NS1.
 // Credentials must be passed in the environment variables: NS1_API_KEY.
+// A dedicated or managed NS1 API endpoint can be set in NS1_ENDPOINT.
+// Zone overrides can be set in NS1_ZONE_OVERRIDES as a comma separated list
+// of fqdn=zone or fqdn=zone/record entries, see SetZoneOverride.
 func NewDNSProvider() (*DNSProvider, error) {
 	key := os.Getenv("NS1_API_KEY")
 	if key == "" {
 		return nil, fmt.Errorf("NS1 credentials missing")
 	}
-	return NewDNSProviderCredentials(key)
+	provider, err := NewDNSProviderCredentialsEndpoint(key, os.Getenv("NS1_ENDPOINT"))
+	if err != nil {
+		return nil, err
+	}
+
+	if v := os.Getenv("NS1_ZONE_OVERRIDES"); v != "" {
+		for _, entry := range strings.Split(v, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			parts := strings.SplitN(entry, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("NS1 zone override %q is not of the form fqdn=zone[/record]", entry)
+			}
+			location := strings.SplitN(parts[1], "/", 2)
+			record := ""
+			if len(location) == 2 {
+				record = location[1]
+			}
+			if err := provider.SetZoneOverride(parts[0], location[0], record); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return provider, nil
 }
 
 // NewDNSProviderCredentials uses the supplied credentials to return a
 // DNSProvider instance configured for NS1.
 func NewDNSProviderCredentials(key string) (*DNSProvider, error) {
+	return NewDNSProviderCredentialsEndpoint(key, "")
+}
+
+// NewDNSProviderCredentialsEndpoint uses the supplied credentials to return a
+// DNSProvider instance configured for the NS1 API at endpoint, e.g.
+// https://api.example.net/v1/. An empty endpoint uses the public NS1 API.
+func NewDNSProviderCredentialsEndpoint(key, endpoint string) (*DNSProvider, error) {
 	if key == "" {
 		return nil, fmt.Errorf("NS1 credentials missing")
 	}
 
 	httpClient := &http.Client{Timeout: time.Second * 10}
-	client := rest.NewClient(httpClient, rest.SetAPIKey(key))
+	options := []func(*rest.Client){rest.SetAPIKey(key)}
+
+	if endpoint != "" {
+		endpointURL, err := url.Parse(endpoint)
+		if err != nil || (endpointURL.Scheme != "http" && endpointURL.Scheme != "https") || endpointURL.Host == "" {
+			return nil, fmt.Errorf("NS1 endpoint %q is not a valid http(s) URL", endpoint)
+		}
+
+		// The API paths are resolved relative to the endpoint.
+		if !strings.HasSuffix(endpointURL.Path, "/") {
+			endpointURL.Path += "/"
+		}
+		options = append(options, rest.SetEndpoint(endpointURL.String()))
+	}
 
-	return &DNSProvider{client}, nil
+	client := rest.NewClient(httpClient, options...)
+
+	return &DNSProvider{client: client}, nil
+}
+
+// SetZoneOverride makes the provider create the TXT record for the
+// challenge at fqdn, e.g. "_acme-challenge.example.com", in zone instead of
+// the zone of the domain. The record is named record, or fqdn if record is
+// empty. This is needed when the challenge is delegated to another zone.
+func (c *DNSProvider) SetZoneOverride(fqdn, zone, record string) error {
+	fqdn = strings.ToLower(acme.UnFqdn(strings.TrimSpace(fqdn)))
+	zone = strings.ToLower(acme.UnFqdn(strings.TrimSpace(zone)))
+	record = strings.ToLower(acme.UnFqdn(strings.TrimSpace(record)))
+	if fqdn == "" || zone == "" {
+		return fmt.Errorf("NS1 zone override for %q needs both a fqdn and a zone", fqdn)
+	}
+	if record == "" {
+		record = fqdn
+	}
+	if record != zone && !strings.HasSuffix(record, "."+zone) {
+		return fmt.Errorf("NS1 zone override record %s is not in zone %s", record, zone)
+	}
+
+	if c.overrides == nil {
+		c.overrides = make(map[string]zoneOverride)
+	}
+	c.overrides[fqdn] = zoneOverride{zone: zone, record: record}
+	return nil
 }
 
 // Present creates a TXT record to fulfil the dns-01 challenge.
 func (c *DNSProvider) Present(domain, token, keyAuth string) error {
 	fqdn, value, ttl := acme.DNS01Record(domain, keyAuth)
 
-	zone, err := c.getHostedZone(domain)
+	zone, name, err := c.recordLocation(domain, fqdn)
 	if err != nil {
 		return err
 	}
 
-	record := c.newTxtRecord(zone, fqdn, value, ttl)
+	record := c.newTxtRecord(zone, name, value, ttl)
 	_, err = c.client.Records.Create(record)
 	if err != nil && err != rest.ErrRecordExists {
 		return err
@@ -63,16 +150,29 @@ func (c *DNSProvider) Present(domain, token, keyAuth string) error {
 func (c *DNSProvider) CleanUp(domain, token, keyAuth string) error {
 	fqdn, _, _ := acme.DNS01Record(domain, keyAuth)
 
-	zone, err := c.getHostedZone(domain)
+	zone, name, err := c.recordLocation(domain, fqdn)
 	if err != nil {
 		return err
 	}
 
-	name := acme.UnFqdn(fqdn)
-	_, err = c.client.Records.Delete(zone.Zone, name, "TXT")
+	_, err = c.client.Records.Delete(zone, name, "TXT")
 	return err
 }
 
+// recordLocation returns the zone and the name of the TXT record for the
+// challenge at fqdn, taking the zone overrides into account.
+func (c *DNSProvider) recordLocation(domain, fqdn string) (zone, name string, err error) {
+	if o, ok := c.overrides[strings.ToLower(acme.UnFqdn(fqdn))]; ok {
+		return o.zone, o.record, nil
+	}
+
+	hostedZone, err := c.getHostedZone(domain)
+	if err != nil {
+		return "", "", err
+	}
+	return hostedZone.Zone, acme.UnFqdn(fqdn), nil
+}
+
 func (c *DNSProvider) getHostedZone(domain string) (*dns.Zone, error) {
 	zone, _, err := c.client.Zones.Get(domain)
 	if err != nil {
@@ -82,12 +182,10 @@ func (c *DNSProvider) getHostedZone(domain string) (*dns.Zone, error) {
 	return zone, nil
 }
 
-func (c *DNSProvider) newTxtRecord(zone *dns.Zone, fqdn, value string, ttl int) *dns.Record {
-	name := acme.UnFqdn(fqdn)
-
+func (c *DNSProvider) newTxtRecord(zone, name, value string, ttl int) *dns.Record {
 	return &dns.Record{
 		Type:   "TXT",
-		Zone:   zone.Zone,
+		Zone:   zone,
 		Domain: name,
 		TTL:    ttl,
 		Answers: []*dns.Answer{
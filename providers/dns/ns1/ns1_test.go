@@ -1,6 +1,9 @@
 package ns1
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
@@ -24,6 +27,8 @@ func init() {
 
 func restoreNS1Env() {
 	os.Setenv("NS1_API_KEY", apiKey)
+	os.Unsetenv("NS1_ENDPOINT")
+	os.Unsetenv("NS1_ZONE_OVERRIDES")
 }
 
 func TestNewDNSProviderValid(t *testing.T) {
@@ -40,6 +45,73 @@ func TestNewDNSProviderMissingCredErr(t *testing.T) {
 	restoreNS1Env()
 }
 
+func TestNewDNSProviderEndpoint(t *testing.T) {
+	var requestedPath string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedPath = r.URL.Path
+		assert.Equal(t, "123", r.Header.Get("X-NSONE-Key"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"zone":"example.com","ttl":3600}`)
+	}))
+	defer ts.Close()
+
+	os.Setenv("NS1_API_KEY", "123")
+	os.Setenv("NS1_ENDPOINT", ts.URL+"/v1")
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+
+	zone, err := provider.getHostedZone("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", zone.Zone)
+	assert.Equal(t, "/v1/zones/example.com", requestedPath)
+	restoreNS1Env()
+}
+
+func TestNewDNSProviderInvalidEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"api.example.net/v1", "ftp://api.example.net/v1/", "https://"} {
+		_, err := NewDNSProviderCredentialsEndpoint("123", endpoint)
+		assert.Error(t, err, "Expected an error for endpoint %q", endpoint)
+	}
+}
+
+func TestZoneOverride(t *testing.T) {
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+
+	os.Setenv("NS1_API_KEY", "123")
+	os.Setenv("NS1_ENDPOINT", ts.URL+"/v1")
+	os.Setenv("NS1_ZONE_OVERRIDES", "_acme-challenge.example.com=validation.example.net/example-com.validation.example.net, _acme-challenge.www.example.org.=example.org")
+	defer restoreNS1Env()
+	provider, err := NewDNSProvider()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, provider.Present("example.com", "", "123d=="))
+	assert.NoError(t, provider.CleanUp("example.com", "", "123d=="))
+	assert.NoError(t, provider.Present("www.example.org", "", "123d=="))
+	assert.Equal(t, []string{
+		"PUT /v1/zones/validation.example.net/example-com.validation.example.net/TXT",
+		"DELETE /v1/zones/validation.example.net/example-com.validation.example.net/TXT",
+		"PUT /v1/zones/example.org/_acme-challenge.www.example.org/TXT",
+	}, requests)
+}
+
+func TestZoneOverrideInvalid(t *testing.T) {
+	defer restoreNS1Env()
+	os.Setenv("NS1_API_KEY", "123")
+	for _, overrides := range []string{"_acme-challenge.example.com", "_acme-challenge.example.com=", "_acme-challenge.example.com=example.net/_acme-challenge.example.com"} {
+		os.Setenv("NS1_ZONE_OVERRIDES", overrides)
+		_, err := NewDNSProvider()
+		assert.Error(t, err, "Expected an error for the overrides %q", overrides)
+	}
+}
+
 func TestLivePresent(t *testing.T) {
 	if !liveTest {
 		t.Skip("skipping live test")
@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ovh/go-ovh/ovh"
 	"github.com/stangah/lego/acme"
@@ -15,7 +16,7 @@ import (
 // OVH API reference:       https://eu.api.ovh.com/
 // Create a Token:					https://eu.api.ovh.com/createToken/
 
-// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// DNSProvider is an implementation of the acme.ChallengeProviderTimeout interface
 // that uses OVH's REST API to manage TXT records for a domain.
 type DNSProvider struct {
 	client      *ovh.Client
@@ -57,6 +58,37 @@ func NewDNSProviderCredentials(apiEndpoint, applicationKey, applicationSecret, c
 	}, nil
 }
 
+// RequestConsumerKey requests a new consumer key for the OVH application
+// with the given key and secret, which allows to manage the DNS zones of
+// the account. The key is only valid once the account owner logged in at
+// the returned validation URL, after which it can be passed in
+// OVH_CONSUMER_KEY.
+func RequestConsumerKey(apiEndpoint, applicationKey, applicationSecret string) (consumerKey, validationURL string, err error) {
+	if apiEndpoint == "" || applicationKey == "" || applicationSecret == "" {
+		return "", "", fmt.Errorf("OVH credentials missing")
+	}
+
+	ovhClient, err := ovh.NewClient(apiEndpoint, applicationKey, applicationSecret, "")
+	if err != nil {
+		return "", "", err
+	}
+
+	ckRequest := ovhClient.NewCkRequest()
+	ckRequest.AddRecursiveRules([]string{"GET", "POST", "DELETE"}, "/domain/zone")
+	state, err := ckRequest.Do()
+	if err != nil {
+		return "", "", fmt.Errorf("OVH consumer key request failed: %v", err)
+	}
+	return state.ConsumerKey, state.ValidationURL, nil
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS
+// propagation. OVH refreshes its zones every few minutes, so wait up to
+// 10 minutes for the update to propagate.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return 10 * time.Minute, 20 * time.Second
+}
+
 // Present creates a TXT record to fulfil the dns-01 challenge.
 func (d *DNSProvider) Present(domain, token, keyAuth string) error {
 
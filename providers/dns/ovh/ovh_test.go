@@ -1,6 +1,10 @@
 package ovh
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
@@ -76,6 +80,37 @@ func TestNewDNSProviderMissingCredErr(t *testing.T) {
 	assert.EqualError(t, err, "OVH credentials missing")
 }
 
+func TestRequestConsumerKey(t *testing.T) {
+	var rules []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/auth/credential" || r.Header.Get("X-Ovh-Application") != "1234" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message":"Not found"}`)
+			return
+		}
+		var body struct {
+			AccessRules []map[string]string `json:"accessRules"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		rules = body.AccessRules
+		fmt.Fprint(w, `{"consumerKey":"abcde","state":"pendingValidation","validationUrl":"https://eu.api.ovh.com/auth/?credentialToken=xyz"}`)
+	}))
+	defer server.Close()
+
+	consumerKey, validationURL, err := RequestConsumerKey(server.URL, "1234", "5678")
+	assert.NoError(t, err)
+	assert.Equal(t, "abcde", consumerKey)
+	assert.Equal(t, "https://eu.api.ovh.com/auth/?credentialToken=xyz", validationURL)
+	assert.Contains(t, rules, map[string]string{"method": "POST", "path": "/domain/zone/*"})
+	assert.Contains(t, rules, map[string]string{"method": "DELETE", "path": "/domain/zone/*"})
+
+	_, _, err = RequestConsumerKey(server.URL, "wrong", "5678")
+	assert.Error(t, err)
+
+	_, _, err = RequestConsumerKey(server.URL, "", "5678")
+	assert.EqualError(t, err, "OVH credentials missing")
+}
+
 func TestLivePresent(t *testing.T) {
 	if !liveTest {
 		t.Skip("skipping live test")
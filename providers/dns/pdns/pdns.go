@@ -271,6 +271,7 @@ func (c *DNSProvider) makeRequest(method, uri string, body io.Reader) (json.RawM
 	}
 
 	req.Header.Set("X-API-Key", c.apiKey)
+	req.Header.Set("User-Agent", acme.UserAgentString())
 
 	client := http.Client{Timeout: 30 * time.Second}
 	resp, err := client.Do(req)
@@ -0,0 +1,218 @@
+// Package porkbun implements a DNS provider for solving the DNS-01
+// challenge using Porkbun DNS.
+package porkbun
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/stangah/lego/acme"
+)
+
+// Porkbun API reference: https://porkbun.com/api/json/v3/documentation
+
+var (
+	// porkbunBaseURL is the base URL of the Porkbun API.
+	porkbunBaseURL = "https://porkbun.com/api/json/v3"
+	// findZoneByFqdn determines the domain of an fqdn. It is overridden
+	// during tests.
+	findZoneByFqdn = acme.FindZoneByFqdn
+)
+
+// defaultTTL is the minimum TTL accepted by Porkbun.
+const defaultTTL = 600
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses Porkbun's API to manage TXT records for a domain.
+type DNSProvider struct {
+	apiKey       string
+	secretAPIKey string
+	ttl          int
+
+	// ids maps the fqdn and value of each challenge to the ID Porkbun
+	// assigned to its record, so CleanUp need not retrieve the records of
+	// the name first.
+	ids   map[string]string
+	idsMu sync.Mutex
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Porkbun.
+// The API keys must be passed in the environment variables PORKBUN_API_KEY
+// and PORKBUN_SECRET_API_KEY.
+func NewDNSProvider() (*DNSProvider, error) {
+	return NewDNSProviderCredentials(os.Getenv("PORKBUN_API_KEY"), os.Getenv("PORKBUN_SECRET_API_KEY"))
+}
+
+// NewDNSProviderCredentials uses the supplied API keys to return a
+// DNSProvider instance configured for Porkbun.
+func NewDNSProviderCredentials(apiKey, secretAPIKey string) (*DNSProvider, error) {
+	if apiKey == "" || secretAPIKey == "" {
+		return nil, fmt.Errorf("Porkbun credentials missing")
+	}
+	return &DNSProvider{
+		apiKey:       apiKey,
+		secretAPIKey: secretAPIKey,
+		ttl:          defaultTTL,
+		ids:          make(map[string]string),
+	}, nil
+}
+
+// SetTTL sets the TTL in seconds of the TXT records created by the provider.
+// Porkbun rejects TTLs below its minimum of 600 seconds.
+func (d *DNSProvider) SetTTL(ttl int) error {
+	if ttl <= 0 {
+		return fmt.Errorf("Porkbun TTL must be positive, got %d", ttl)
+	}
+	d.ttl = ttl
+	return nil
+}
+
+// apiRequest is the body of a Porkbun API request. The API keys are sent
+// with every request, the record fields only when creating a record.
+type apiRequest struct {
+	APIKey       string `json:"apikey"`
+	SecretAPIKey string `json:"secretapikey"`
+	Name         string `json:"name,omitempty"`
+	Type         string `json:"type,omitempty"`
+	Content      string `json:"content,omitempty"`
+	TTL          string `json:"ttl,omitempty"`
+}
+
+// apiResponse is the body of a Porkbun API response.
+type apiResponse struct {
+	Status  string      `json:"status"`
+	Message string      `json:"message"`
+	ID      json.Number `json:"id"`
+	Records []struct {
+		ID      json.Number `json:"id"`
+		Content string      `json:"content"`
+	} `json:"records"`
+}
+
+// Present creates a TXT record for the challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	zone, name, err := recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	req := apiRequest{Name: name, Type: "TXT", Content: value, TTL: fmt.Sprint(d.ttl)}
+	resp, err := d.doRequest("/dns/create/"+zone, req)
+	if err != nil {
+		return err
+	}
+
+	d.idsMu.Lock()
+	d.ids[fqdn+" "+value] = resp.ID.String()
+	d.idsMu.Unlock()
+
+	return nil
+}
+
+// CleanUp deletes the TXT record created by Present. Records created by
+// another run are looked up by name and value.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	zone, name, err := recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	d.idsMu.Lock()
+	id, ok := d.ids[fqdn+" "+value]
+	d.idsMu.Unlock()
+
+	ids := []string{id}
+	if !ok {
+		ids, err = d.findTxtRecords(zone, name, value)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return fmt.Errorf("Porkbun: no TXT record found for '%s'", fqdn)
+		}
+	}
+
+	for _, id := range ids {
+		if _, err := d.doRequest(fmt.Sprintf("/dns/delete/%s/%s", zone, id), apiRequest{}); err != nil {
+			return err
+		}
+	}
+
+	d.idsMu.Lock()
+	delete(d.ids, fqdn+" "+value)
+	d.idsMu.Unlock()
+
+	return nil
+}
+
+// findTxtRecords returns the IDs of the TXT records with the given name
+// and value in the domain zone.
+func (d *DNSProvider) findTxtRecords(zone, name, value string) ([]string, error) {
+	resp, err := d.doRequest(fmt.Sprintf("/dns/retrieveByNameType/%s/TXT/%s", zone, name), apiRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, record := range resp.Records {
+		if record.Content == value {
+			ids = append(ids, record.ID.String())
+		}
+	}
+	return ids, nil
+}
+
+// recordLocation splits fqdn into the domain it belongs to and the subdomain
+// expected by the record endpoints of Porkbun, which is empty at the apex.
+func recordLocation(fqdn string) (string, string, error) {
+	authZone, err := findZoneByFqdn(fqdn, acme.RecursiveNameservers)
+	if err != nil {
+		return "", "", fmt.Errorf("Porkbun: could not determine zone for %s: %v", fqdn, err)
+	}
+
+	zone := acme.UnFqdn(authZone)
+	name := acme.UnFqdn(fqdn)
+	if name == zone {
+		return zone, "", nil
+	}
+	return zone, name[:len(name)-len(zone)-1], nil
+}
+
+// doRequest posts body, completed with the API keys of the provider, to
+// the endpoint uri.
+func (d *DNSProvider) doRequest(uri string, body apiRequest) (*apiResponse, error) {
+	body.APIKey = d.apiKey
+	body.SecretAPIKey = d.secretAPIKey
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", porkbunBaseURL+uri, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", acme.UserAgentString())
+
+	resp, err := acme.DoWithRetry(req, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Porkbun API call failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil && resp.StatusCode < 400 {
+		return nil, fmt.Errorf("Porkbun API call failed: %v", err)
+	}
+	if resp.StatusCode >= 400 || result.Status != "SUCCESS" {
+		return nil, fmt.Errorf("Porkbun API call failed: HTTP %d: %s", resp.StatusCode, result.Message)
+	}
+	return &result, nil
+}
@@ -0,0 +1,274 @@
+package porkbun
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stangah/lego/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	porkbunLiveTest     bool
+	porkbunAPIKey       string
+	porkbunSecretAPIKey string
+	porkbunDomain       string
+)
+
+func init() {
+	porkbunAPIKey = os.Getenv("PORKBUN_API_KEY")
+	porkbunSecretAPIKey = os.Getenv("PORKBUN_SECRET_API_KEY")
+	porkbunDomain = os.Getenv("PORKBUN_DOMAIN")
+	if len(porkbunAPIKey) > 0 && len(porkbunSecretAPIKey) > 0 && len(porkbunDomain) > 0 {
+		porkbunLiveTest = true
+	}
+}
+
+func restorePorkbunEnv() {
+	os.Setenv("PORKBUN_API_KEY", porkbunAPIKey)
+	os.Setenv("PORKBUN_SECRET_API_KEY", porkbunSecretAPIKey)
+}
+
+// fakePorkbun serves the DNS records of the domain example.com from memory.
+type fakePorkbun struct {
+	mu      sync.Mutex
+	records map[string]apiRequest
+	deleted []string
+	next    int
+}
+
+func (f *fakePorkbun) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var req apiRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || r.Method != "POST" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"status":"ERROR","message":"Invalid request."}`)
+		return
+	}
+	if req.APIKey != "pk1_key" || req.SecretAPIKey != "sk1_secret" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"status":"ERROR","message":"Invalid API key. (002)"}`)
+		return
+	}
+
+	switch path := r.URL.Path; {
+	case path == "/dns/create/example.com":
+		f.next++
+		id := fmt.Sprint(f.next)
+		f.records[id] = req
+		fmt.Fprintf(w, `{"status":"SUCCESS","id":%s}`, id)
+	case strings.HasPrefix(path, "/dns/retrieveByNameType/example.com/TXT/"):
+		name := strings.TrimPrefix(path, "/dns/retrieveByNameType/example.com/TXT/")
+		var records []map[string]string
+		for id, record := range f.records {
+			if record.Name == name {
+				records = append(records, map[string]string{"id": id, "content": record.Content})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "SUCCESS", "records": records})
+	case strings.HasPrefix(path, "/dns/delete/example.com/"):
+		id := strings.TrimPrefix(path, "/dns/delete/example.com/")
+		if _, ok := f.records[id]; !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"status":"ERROR","message":"Invalid record ID."}`)
+			return
+		}
+		delete(f.records, id)
+		f.deleted = append(f.deleted, id)
+		fmt.Fprint(w, `{"status":"SUCCESS"}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"status":"ERROR","message":"Unknown endpoint."}`)
+	}
+}
+
+// mockPorkbun serves the Porkbun API with handler and makes the zone of
+// every fqdn be example.com until the returned function is called.
+func mockPorkbun(handler http.Handler) func() {
+	server := httptest.NewServer(handler)
+	baseURL, findZone := porkbunBaseURL, findZoneByFqdn
+	porkbunBaseURL = server.URL
+	findZoneByFqdn = func(fqdn string, nameservers []string) (string, error) {
+		return "example.com.", nil
+	}
+	return func() {
+		porkbunBaseURL, findZoneByFqdn = baseURL, findZone
+		server.Close()
+	}
+}
+
+func TestNewDNSProviderValid(t *testing.T) {
+	os.Setenv("PORKBUN_API_KEY", "")
+	os.Setenv("PORKBUN_SECRET_API_KEY", "")
+	defer restorePorkbunEnv()
+	_, err := NewDNSProviderCredentials("pk1_key", "sk1_secret")
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	os.Setenv("PORKBUN_API_KEY", "pk1_key")
+	os.Setenv("PORKBUN_SECRET_API_KEY", "sk1_secret")
+	defer restorePorkbunEnv()
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, "pk1_key", provider.apiKey)
+	assert.Equal(t, "sk1_secret", provider.secretAPIKey)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	os.Setenv("PORKBUN_API_KEY", "pk1_key")
+	os.Setenv("PORKBUN_SECRET_API_KEY", "")
+	defer restorePorkbunEnv()
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "Porkbun credentials missing")
+}
+
+func TestPorkbunPresent(t *testing.T) {
+	var requestReceived bool
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockPorkbun(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestReceived = true
+
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/dns/create/example.com", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		reqBody, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"apikey":"pk1_key","secretapikey":"sk1_secret","name":"_acme-challenge.www","type":"TXT","content":"`+value+`","ttl":"600"}`, string(reqBody))
+
+		fmt.Fprint(w, `{"status":"SUCCESS","id":106926659}`)
+	}))()
+
+	provider, err := NewDNSProviderCredentials("pk1_key", "sk1_secret")
+	assert.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.True(t, requestReceived, "Expected request to be received by mock backend")
+	assert.Equal(t, map[string]string{"_acme-challenge.www.example.com. " + value: "106926659"}, provider.ids)
+}
+
+func TestPorkbunCleanUp(t *testing.T) {
+	var requestReceived bool
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockPorkbun(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestReceived = true
+
+		// The record is deleted by the ID remembered by Present.
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/dns/delete/example.com/106926659", r.URL.Path)
+
+		reqBody, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"apikey":"pk1_key","secretapikey":"sk1_secret"}`, string(reqBody))
+
+		fmt.Fprint(w, `{"status":"SUCCESS"}`)
+	}))()
+
+	provider, err := NewDNSProviderCredentials("pk1_key", "sk1_secret")
+	assert.NoError(t, err)
+	provider.ids["_acme-challenge.www.example.com. "+value] = "106926659"
+
+	err = provider.CleanUp("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.True(t, requestReceived, "Expected request to be received by mock backend")
+	assert.Empty(t, provider.ids)
+}
+
+func TestPorkbunSharedName(t *testing.T) {
+	fake := &fakePorkbun{records: map[string]apiRequest{}}
+	defer mockPorkbun(fake)()
+
+	provider, err := NewDNSProviderCredentials("pk1_key", "sk1_secret")
+	assert.NoError(t, err)
+	assert.NoError(t, provider.SetTTL(900))
+
+	// Two challenges for the same name, e.g. a wildcard and its base domain.
+	assert.NoError(t, provider.Present("www.example.com", "", "key1"))
+	assert.NoError(t, provider.Present("www.example.com", "", "key2"))
+	_, value2, _ := acme.DNS01Record("www.example.com", "key2")
+	if assert.Len(t, fake.records, 2) {
+		assert.Equal(t, apiRequest{
+			APIKey:       "pk1_key",
+			SecretAPIKey: "sk1_secret",
+			Name:         "_acme-challenge.www",
+			Type:         "TXT",
+			Content:      value2,
+			TTL:          "900",
+		}, fake.records["2"])
+	}
+
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "key1"))
+	assert.Equal(t, []string{"1"}, fake.deleted)
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "key2"))
+	assert.Equal(t, []string{"1", "2"}, fake.deleted)
+	assert.Empty(t, fake.records)
+}
+
+func TestPorkbunCleanUpLooksUpRecords(t *testing.T) {
+	fake := &fakePorkbun{records: map[string]apiRequest{}}
+	defer mockPorkbun(fake)()
+
+	first, err := NewDNSProviderCredentials("pk1_key", "sk1_secret")
+	assert.NoError(t, err)
+	assert.NoError(t, first.Present("example.com", "", "key"))
+	assert.NoError(t, first.Present("example.com", "", "other"))
+
+	// A provider which did not create the record looks it up by name and
+	// only deletes the one with its value.
+	second, err := NewDNSProviderCredentials("pk1_key", "sk1_secret")
+	assert.NoError(t, err)
+	assert.NoError(t, second.CleanUp("example.com", "", "key"))
+	assert.Equal(t, []string{"1"}, fake.deleted)
+	assert.EqualError(t, second.CleanUp("example.com", "", "key"), "Porkbun: no TXT record found for '_acme-challenge.example.com.'")
+}
+
+func TestPorkbunPresentFailed(t *testing.T) {
+	fake := &fakePorkbun{records: map[string]apiRequest{}}
+	defer mockPorkbun(fake)()
+
+	provider, err := NewDNSProviderCredentials("pk1_key", "wrong")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.com", "", "key"), "Porkbun API call failed: HTTP 400: Invalid API key. (002)")
+	assert.Empty(t, provider.ids)
+}
+
+func TestLivePorkbunPresent(t *testing.T) {
+	if !porkbunLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	provider, err := NewDNSProviderCredentials(porkbunAPIKey, porkbunSecretAPIKey)
+	assert.NoError(t, err)
+
+	err = provider.Present(porkbunDomain, "", "123d==")
+	assert.NoError(t, err)
+}
+
+func TestLivePorkbunCleanUp(t *testing.T) {
+	if !porkbunLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	time.Sleep(time.Second * 1)
+
+	// A new provider looks the record up by name and value.
+	provider, err := NewDNSProviderCredentials(porkbunAPIKey, porkbunSecretAPIKey)
+	assert.NoError(t, err)
+
+	err = provider.CleanUp(porkbunDomain, "", "123d==")
+	assert.NoError(t, err)
+}
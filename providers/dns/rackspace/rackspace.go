@@ -17,7 +17,7 @@ import (
 // rackspaceAPIURL represents the Identity API endpoint to call
 var rackspaceAPIURL = "https://identity.api.rackspacecloud.com/v2.0/tokens"
 
-// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// DNSProvider is an implementation of the acme.ChallengeProviderTimeout interface
 // used to store the reusable token and DNS API endpoint
 type DNSProvider struct {
 	token            string
@@ -88,6 +88,7 @@ func NewDNSProviderCredentials(user, key string) (*DNSProvider, error) {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", acme.UserAgentString())
 
 	client := http.Client{Timeout: 30 * time.Second}
 	resp, err := client.Do(req)
@@ -124,6 +125,13 @@ func NewDNSProviderCredentials(user, key string) (*DNSProvider, error) {
 	}, nil
 }
 
+// Timeout returns the timeout and interval to use when checking for DNS
+// propagation. Rackspace processes record changes as asynchronous jobs
+// and can take several minutes before they are served.
+func (c *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return 5 * time.Minute, 10 * time.Second
+}
+
 // Present creates a TXT record to fulfil the dns-01 challenge
 func (c *DNSProvider) Present(domain, token, keyAuth string) error {
 	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
@@ -247,6 +255,7 @@ func (c *DNSProvider) makeRequest(method, uri string, body io.Reader) (json.RawM
 
 	req.Header.Set("X-Auth-Token", c.token)
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", acme.UserAgentString())
 
 	client := http.Client{Timeout: 30 * time.Second}
 	resp, err := client.Do(req)
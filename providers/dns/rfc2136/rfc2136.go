@@ -17,6 +17,7 @@ import (
 // uses dynamic DNS updates (RFC 2136) to create TXT records on a nameserver.
 type DNSProvider struct {
 	nameserver    string
+	protocol      string
 	tsigAlgorithm string
 	tsigKey       string
 	tsigSecret    string
@@ -27,13 +28,25 @@ type DNSProvider struct {
 // RFC2136_NAMESERVER, RFC2136_TSIG_ALGORITHM, RFC2136_TSIG_KEY and
 // RFC2136_TSIG_SECRET. To disable TSIG authentication, leave the TSIG
 // variables unset. RFC2136_NAMESERVER must be a network address in the form
-// "host" or "host:port".
+// "host" or "host:port". The updates are sent over UDP unless
+// RFC2136_PROTOCOL is set to tcp.
 func NewDNSProvider() (*DNSProvider, error) {
 	nameserver := os.Getenv("RFC2136_NAMESERVER")
 	tsigAlgorithm := os.Getenv("RFC2136_TSIG_ALGORITHM")
 	tsigKey := os.Getenv("RFC2136_TSIG_KEY")
 	tsigSecret := os.Getenv("RFC2136_TSIG_SECRET")
-	return NewDNSProviderCredentials(nameserver, tsigAlgorithm, tsigKey, tsigSecret)
+	provider, err := NewDNSProviderCredentials(nameserver, tsigAlgorithm, tsigKey, tsigSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	if protocol := os.Getenv("RFC2136_PROTOCOL"); protocol != "" {
+		if err := provider.SetProtocol(protocol); err != nil {
+			return nil, err
+		}
+	}
+
+	return provider, nil
 }
 
 // NewDNSProviderCredentials uses the supplied credentials to return a
@@ -55,6 +68,7 @@ func NewDNSProviderCredentials(nameserver, tsigAlgorithm, tsigKey, tsigSecret st
 	}
 	d := &DNSProvider{
 		nameserver: nameserver,
+		protocol:   "udp",
 	}
 	if tsigAlgorithm == "" {
 		tsigAlgorithm = dns.HmacMD5
@@ -68,6 +82,18 @@ func NewDNSProviderCredentials(nameserver, tsigAlgorithm, tsigKey, tsigSecret st
 	return d, nil
 }
 
+// SetProtocol sets the protocol the updates are sent with, either "udp",
+// the default, or "tcp". Updates sent over UDP are repeated over TCP if the
+// reply of the nameserver is truncated.
+func (r *DNSProvider) SetProtocol(protocol string) error {
+	protocol = strings.ToLower(protocol)
+	if protocol != "udp" && protocol != "tcp" {
+		return fmt.Errorf("RFC2136 protocol must be udp or tcp, got %q", protocol)
+	}
+	r.protocol = protocol
+	return nil
+}
+
 // Present creates a TXT record using the specified parameters
 func (r *DNSProvider) Present(domain, token, keyAuth string) error {
 	fqdn, value, ttl := acme.DNS01Record(domain, keyAuth)
@@ -109,6 +135,7 @@ func (r *DNSProvider) changeRecord(action, fqdn, value string, ttl int) error {
 
 	// Setup client
 	c := new(dns.Client)
+	c.Net = r.protocol
 	c.SingleInflight = true
 	// TSIG authentication / msg signing
 	if len(r.tsigKey) > 0 && len(r.tsigSecret) > 0 {
@@ -118,6 +145,11 @@ func (r *DNSProvider) changeRecord(action, fqdn, value string, ttl int) error {
 
 	// Send the query
 	reply, _, err := c.Exchange(m, r.nameserver)
+	if c.Net == "udp" && (err == dns.ErrTruncated || (err == nil && reply.Truncated)) {
+		// The update or its reply did not fit into a UDP message.
+		c.Net = "tcp"
+		reply, _, err = c.Exchange(m, r.nameserver)
+	}
 	if err != nil {
 		return fmt.Errorf("DNS update failed: %v", err)
 	}
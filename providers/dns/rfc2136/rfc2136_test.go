@@ -242,3 +242,99 @@ func serverHandlerPassBackRequest(w dns.ResponseWriter, req *dns.Msg) {
 		reqChan <- req
 	}
 }
+
+func TestRFC2136RetriesTruncatedUpdateOverTCP(t *testing.T) {
+	acme.ClearFqdnCache()
+	udpUpdates, tcpUpdates, addrstr, shutdown := runTCPOnlyUpdateServer(t)
+	defer shutdown()
+
+	provider, err := NewDNSProviderCredentials(addrstr, "", "", "")
+	if err != nil {
+		t.Fatalf("Expected NewDNSProviderCredentials() to return no error but the error was -> %v", err)
+	}
+	if err := provider.Present(rfc2136TestDomain, "", rfc2136TestKeyAuth); err != nil {
+		t.Fatalf("Expected Present() to return no error but the error was -> %v", err)
+	}
+
+	if got := len(udpUpdates); got != 1 {
+		t.Errorf("Expected one update over UDP but got %d", got)
+	}
+	if got := len(tcpUpdates); got != 1 {
+		t.Errorf("Expected the update to be repeated over TCP but got %d TCP updates", got)
+	}
+}
+
+func TestRFC2136ProtocolTCP(t *testing.T) {
+	acme.ClearFqdnCache()
+	udpUpdates, tcpUpdates, addrstr, shutdown := runTCPOnlyUpdateServer(t)
+	defer shutdown()
+
+	provider, err := NewDNSProviderCredentials(addrstr, "", "", "")
+	if err != nil {
+		t.Fatalf("Expected NewDNSProviderCredentials() to return no error but the error was -> %v", err)
+	}
+	if err := provider.SetProtocol("TCP"); err != nil {
+		t.Fatalf("Expected SetProtocol() to return no error but the error was -> %v", err)
+	}
+	if err := provider.Present(rfc2136TestDomain, "", rfc2136TestKeyAuth); err != nil {
+		t.Fatalf("Expected Present() to return no error but the error was -> %v", err)
+	}
+
+	if got := len(udpUpdates); got != 0 {
+		t.Errorf("Expected no update over UDP but got %d", got)
+	}
+	if got := len(tcpUpdates); got != 1 {
+		t.Errorf("Expected one update over TCP but got %d", got)
+	}
+
+	if err := provider.SetProtocol("sctp"); err == nil {
+		t.Error("Expected SetProtocol() to reject an unknown protocol")
+	}
+}
+
+// runTCPOnlyUpdateServer starts a nameserver answering SOA queries over UDP
+// and TCP, but dynamic updates only over TCP. Updates sent over UDP get an
+// empty reply with the truncated bit set. The returned channels receive the
+// updates per protocol.
+func runTCPOnlyUpdateServer(t *testing.T) (udpUpdates, tcpUpdates chan *dns.Msg, addr string, shutdown func()) {
+	udpUpdates = make(chan *dns.Msg, 10)
+	tcpUpdates = make(chan *dns.Msg, 10)
+
+	handler := func(updates chan *dns.Msg, truncate bool) dns.HandlerFunc {
+		return func(w dns.ResponseWriter, req *dns.Msg) {
+			if req.Opcode == dns.OpcodeUpdate {
+				updates <- req
+				m := new(dns.Msg)
+				m.SetReply(req)
+				m.Truncated = truncate
+				w.WriteMsg(m)
+				return
+			}
+			serverHandlerReturnSuccess(w, req)
+		}
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start test server: %v", err)
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		t.Fatalf("Failed to start test server: %v", err)
+	}
+
+	udpServer := &dns.Server{PacketConn: pc, Handler: handler(udpUpdates, true)}
+	tcpServer := &dns.Server{Listener: l, Handler: handler(tcpUpdates, false)}
+	for _, server := range []*dns.Server{udpServer, tcpServer} {
+		started := make(chan struct{})
+		server.NotifyStartedFunc = func() { close(started) }
+		go server.ActivateAndServe()
+		<-started
+	}
+
+	return udpUpdates, tcpUpdates, pc.LocalAddr().String(), func() {
+		udpServer.Shutdown()
+		tcpServer.Shutdown()
+	}
+}
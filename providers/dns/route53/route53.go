@@ -4,12 +4,15 @@ package route53
 
 import (
 	"fmt"
-	"math/rand"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/route53"
@@ -19,11 +22,44 @@ import (
 const (
 	maxRetries = 5
 	route53TTL = 10
+
+	defaultSyncTimeout  = 120 * time.Second
+	defaultSyncInterval = 4 * time.Second
 )
 
+// findZoneByFqdn determines the DNS zone of an fqdn. It is overridden
+// during tests.
+var findZoneByFqdn = acme.FindZoneByFqdn
+
 // DNSProvider implements the acme.ChallengeProvider interface
 type DNSProvider struct {
 	client *route53.Route53
+
+	// syncTimeout and syncInterval control how long and how often the
+	// status of submitted changes is polled until it is INSYNC.
+	syncTimeout  time.Duration
+	syncInterval time.Duration
+
+	// zones holds the changes waiting to be submitted to each hosted zone
+	// while another change batch is being submitted to it.
+	zonesMu sync.Mutex
+	zones   map[string]*zoneChanges
+}
+
+// zoneChanges holds the record changes to a hosted zone which are waiting
+// for the change batch being submitted to it.
+type zoneChanges struct {
+	pending []*recordChange
+}
+
+// recordChange is a change of a TXT record set requested by Present or
+// CleanUp. done is closed once the change has been submitted and is in
+// sync, err holds its result.
+type recordChange struct {
+	action    string
+	recordSet *route53.ResourceRecordSet
+	done      chan struct{}
+	err       error
 }
 
 // customRetryer implements the client.Retryer interface by composing the
@@ -34,18 +70,13 @@ type customRetryer struct {
 }
 
 // RetryRules overwrites the DefaultRetryer's method.
-// It uses a basic exponential backoff algorithm that returns an initial
-// delay of ~400ms with an upper limit of ~30 seconds which should prevent
-// causing a high number of consecutive throttling errors.
+// It uses the backoff shared by the REST providers, which honors the
+// Retry-After header of throttled requests and otherwise backs off
+// exponentially with jitter. This prevents causing a high number of
+// consecutive throttling errors.
 // For reference: Route 53 enforces an account-wide(!) 5req/s query limit.
 func (d customRetryer) RetryRules(r *request.Request) time.Duration {
-	retryCount := r.RetryCount
-	if retryCount > 7 {
-		retryCount = 7
-	}
-
-	delay := (1 << uint(retryCount)) * (rand.Intn(50) + 200)
-	return time.Duration(delay) * time.Millisecond
+	return acme.RetryDelay(r.RetryCount, r.HTTPResponse)
 }
 
 // NewDNSProvider returns a DNSProvider instance configured for the AWS
@@ -55,17 +86,43 @@ func (d customRetryer) RetryRules(r *request.Request) time.Duration {
 // and prioritized in the following order:
 // 1. Environment variables: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
 //    AWS_REGION, [AWS_SESSION_TOKEN]
-// 2. Shared credentials file (defaults to ~/.aws/credentials)
-// 3. Amazon EC2 IAM role
+// 2. The same variables in the credentials file named by
+//    LEGO_CREDENTIALS_FILE
+// 3. Shared credentials file (defaults to ~/.aws/credentials)
+// 4. Amazon EC2 IAM role
 //
 // See also: https://github.com/aws/aws-sdk-go/wiki/configuring-sdk
+//
+// The time in seconds to wait for changes to be in sync can be set in the
+// environment variable AWS_INSYNC_TIMEOUT, it defaults to 120 seconds.
 func NewDNSProvider() (*DNSProvider, error) {
+	syncTimeout := defaultSyncTimeout
+	if v := os.Getenv("AWS_INSYNC_TIMEOUT"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("Invalid AWS_INSYNC_TIMEOUT %q, expected a positive number of seconds", v)
+		}
+		syncTimeout = time.Duration(seconds) * time.Second
+	}
+
+	values, err := acme.LoadCredentials("AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_REGION")
+	if err != nil {
+		return nil, err
+	}
+
 	r := customRetryer{}
 	r.NumMaxRetries = maxRetries
 	config := request.WithRetryer(aws.NewConfig(), r)
+	if values["AWS_ACCESS_KEY_ID"] != "" && values["AWS_SECRET_ACCESS_KEY"] != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(
+			values["AWS_ACCESS_KEY_ID"], values["AWS_SECRET_ACCESS_KEY"], values["AWS_SESSION_TOKEN"]))
+	}
+	if values["AWS_REGION"] != "" {
+		config = config.WithRegion(values["AWS_REGION"])
+	}
 	client := route53.New(session.New(config))
 
-	return &DNSProvider{client: client}, nil
+	return &DNSProvider{client: client, syncTimeout: syncTimeout, syncInterval: defaultSyncInterval}, nil
 }
 
 // Present creates a TXT record using the specified parameters
@@ -82,34 +139,138 @@ func (r *DNSProvider) CleanUp(domain, token, keyAuth string) error {
 	return r.changeRecord("DELETE", fqdn, value, route53TTL)
 }
 
+// changeRecord submits the change to the hosted zone of fqdn and waits until
+// it is in sync. Changes requested while a change batch is being submitted
+// to the same hosted zone are submitted together as the next batch, which
+// avoids running into the Route 53 rate limits without delaying any change.
 func (r *DNSProvider) changeRecord(action, fqdn, value string, ttl int) error {
 	hostedZoneID, err := getHostedZoneID(fqdn, r.client)
 	if err != nil {
 		return fmt.Errorf("Failed to determine Route 53 hosted zone ID: %v", err)
 	}
 
-	recordSet := newTXTRecordSet(fqdn, value, ttl)
+	change := &recordChange{
+		action:    action,
+		recordSet: newTXTRecordSet(fqdn, value, ttl),
+		done:      make(chan struct{}),
+	}
+
+	r.zonesMu.Lock()
+	if r.zones == nil {
+		r.zones = make(map[string]*zoneChanges)
+	}
+	zone, submitting := r.zones[hostedZoneID]
+	if !submitting {
+		zone = &zoneChanges{}
+		r.zones[hostedZoneID] = zone
+	}
+	zone.pending = append(zone.pending, change)
+	r.zonesMu.Unlock()
+
+	if !submitting {
+		go r.submitChanges(hostedZoneID, zone)
+	}
+
+	<-change.done
+	return change.err
+}
+
+// submitChanges submits the pending changes of zone as change batches until
+// none are left.
+func (r *DNSProvider) submitChanges(hostedZoneID string, zone *zoneChanges) {
+	for {
+		r.zonesMu.Lock()
+		changes := zone.pending
+		zone.pending = nil
+		if len(changes) == 0 {
+			delete(r.zones, hostedZoneID)
+			r.zonesMu.Unlock()
+			return
+		}
+		r.zonesMu.Unlock()
+
+		statusID, err := r.changeRecordSets(hostedZoneID, changes)
+		if err != nil && len(changes) > 1 {
+			// Route 53 rejects a change batch as a whole if any of its
+			// changes is invalid, so submit them one by one to report
+			// the error of each.
+			for _, change := range changes {
+				go r.submitChange(hostedZoneID, change)
+			}
+			continue
+		}
+		go func() {
+			if err == nil {
+				err = r.waitForSync(statusID)
+			}
+			for _, change := range changes {
+				change.err = err
+				close(change.done)
+			}
+		}()
+	}
+}
+
+// submitChange submits change on its own and waits until it is in sync.
+func (r *DNSProvider) submitChange(hostedZoneID string, change *recordChange) {
+	defer close(change.done)
+
+	statusID, err := r.changeRecordSets(hostedZoneID, []*recordChange{change})
+	if err != nil {
+		change.err = err
+		return
+	}
+	change.err = r.waitForSync(statusID)
+}
+
+// changeRecordSets submits changes to the hosted zone as one change batch
+// and returns the ID of its status. Values for a record set which has
+// several changes with the same action are merged into one change.
+func (r *DNSProvider) changeRecordSets(hostedZoneID string, changes []*recordChange) (*string, error) {
+	var batch []*route53.Change
+	merged := make(map[string]*route53.ResourceRecordSet)
+	for _, change := range changes {
+		key := change.action + " " + *change.recordSet.Name
+		if recordSet, ok := merged[key]; ok {
+			recordSet.ResourceRecords = append(recordSet.ResourceRecords, change.recordSet.ResourceRecords...)
+			continue
+		}
+		recordSet := *change.recordSet
+		recordSet.ResourceRecords = append([]*route53.ResourceRecord(nil), recordSet.ResourceRecords...)
+		merged[key] = &recordSet
+		batch = append(batch, &route53.Change{
+			Action:            aws.String(change.action),
+			ResourceRecordSet: &recordSet,
+		})
+	}
+
 	reqParams := &route53.ChangeResourceRecordSetsInput{
 		HostedZoneId: aws.String(hostedZoneID),
 		ChangeBatch: &route53.ChangeBatch{
 			Comment: aws.String("Managed by Lego"),
-			Changes: []*route53.Change{
-				{
-					Action:            aws.String(action),
-					ResourceRecordSet: recordSet,
-				},
-			},
+			Changes: batch,
 		},
 	}
 
 	resp, err := r.client.ChangeResourceRecordSets(reqParams)
 	if err != nil {
-		return fmt.Errorf("Failed to change Route 53 record set: %v", err)
+		return nil, fmt.Errorf("Failed to change Route 53 record set: %v", err)
 	}
+	return resp.ChangeInfo.Id, nil
+}
 
-	statusID := resp.ChangeInfo.Id
+// waitForSync polls the status of the change with the given ID until it is
+// INSYNC or the sync timeout is exceeded.
+func (r *DNSProvider) waitForSync(statusID *string) error {
+	timeout, interval := r.syncTimeout, r.syncInterval
+	if timeout == 0 {
+		timeout = defaultSyncTimeout
+	}
+	if interval == 0 {
+		interval = defaultSyncInterval
+	}
 
-	return acme.WaitFor(120*time.Second, 4*time.Second, func() (bool, error) {
+	return acme.WaitFor(timeout, interval, func() (bool, error) {
 		reqParams := &route53.GetChangeInput{
 			Id: statusID,
 		}
@@ -125,7 +286,7 @@ func (r *DNSProvider) changeRecord(action, fqdn, value string, ttl int) error {
 }
 
 func getHostedZoneID(fqdn string, client *route53.Route53) (string, error) {
-	authZone, err := acme.FindZoneByFqdn(fqdn, acme.RecursiveNameservers)
+	authZone, err := findZoneByFqdn(fqdn, acme.RecursiveNameservers)
 	if err != nil {
 		return "", err
 	}
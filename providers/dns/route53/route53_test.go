@@ -1,12 +1,18 @@
 package route53
 
 import (
+	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/route53"
 	"github.com/stretchr/testify/assert"
@@ -67,6 +73,114 @@ func TestRegionFromEnv(t *testing.T) {
 	restoreRoute53Env()
 }
 
+func TestCredentialsFromFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "lego-route53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`{"AWS_ACCESS_KEY_ID": "file-key", "AWS_SECRET_ACCESS_KEY": "file-secret", "AWS_REGION": "eu-west-1"}`)
+	file.Close()
+
+	defer os.Unsetenv("LEGO_CREDENTIALS_FILE")
+	os.Setenv("LEGO_CREDENTIALS_FILE", file.Name())
+	os.Setenv("AWS_ACCESS_KEY_ID", "")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	os.Setenv("AWS_REGION", "")
+	defer restoreRoute53Env()
+
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+
+	creds, err := provider.client.Config.Credentials.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "file-key", creds.AccessKeyID)
+	assert.Equal(t, "file-secret", creds.SecretAccessKey)
+	assert.Equal(t, "eu-west-1", *provider.client.Config.Region)
+}
+
+func TestSyncTimeoutFromEnv(t *testing.T) {
+	defer os.Unsetenv("AWS_INSYNC_TIMEOUT")
+
+	os.Setenv("AWS_INSYNC_TIMEOUT", "300")
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, 300*time.Second, provider.syncTimeout)
+
+	os.Setenv("AWS_INSYNC_TIMEOUT", "soon")
+	_, err = NewDNSProvider()
+	assert.Error(t, err)
+}
+
+func TestRoute53WaitForSync(t *testing.T) {
+	var getChangeRequests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/2013-04-01/change/123456", r.URL.Path)
+
+		getChangeRequests++
+		w.Header().Set("Content-Type", "application/xml")
+		if getChangeRequests == 1 {
+			w.Write([]byte(GetChangePendingResponse))
+			return
+		}
+		w.Write([]byte(GetChangeResponse))
+	}))
+	defer ts.Close()
+
+	provider := makeRoute53Provider(ts)
+	provider.syncInterval = 10 * time.Millisecond
+
+	err := provider.waitForSync(aws.String("123456"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, getChangeRequests, "Expected the change to be polled until INSYNC")
+}
+
+func TestRoute53WaitForSyncTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(GetChangePendingResponse))
+	}))
+	defer ts.Close()
+
+	provider := makeRoute53Provider(ts)
+	provider.syncTimeout = 50 * time.Millisecond
+	provider.syncInterval = 10 * time.Millisecond
+
+	err := provider.waitForSync(aws.String("123456"))
+	assert.Error(t, err)
+}
+
+func TestRoute53RetriesThrottledRequests(t *testing.T) {
+	var getChangeRequests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		getChangeRequests++
+		w.Header().Set("Content-Type", "application/xml")
+		if getChangeRequests == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(ThrottlingErrorResponse))
+			return
+		}
+		w.Write([]byte(GetChangeResponse))
+	}))
+	defer ts.Close()
+
+	r := customRetryer{}
+	r.NumMaxRetries = maxRetries
+	config := request.WithRetryer(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("abc", "123", " "),
+		Endpoint:    aws.String(ts.URL),
+		Region:      aws.String("mock-region"),
+	}, r)
+	client := route53.New(session.New(config))
+
+	start := time.Now()
+	_, err := client.GetChange(&route53.GetChangeInput{Id: aws.String("123456")})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, getChangeRequests, "Expected the throttled request to be retried")
+	assert.True(t, time.Since(start) < time.Second, "Expected Retry-After to be honored")
+}
+
 func TestRoute53Present(t *testing.T) {
 	mockResponses := MockResponseMap{
 		"/2013-04-01/hostedzonesbyname":         MockResponse{StatusCode: 200, Body: ListHostedZonesByNameResponse},
@@ -76,6 +190,7 @@ func TestRoute53Present(t *testing.T) {
 
 	ts := newMockServer(t, mockResponses)
 	defer ts.Close()
+	defer fakeHostedZone()()
 
 	provider := makeRoute53Provider(ts)
 
@@ -85,3 +200,120 @@ func TestRoute53Present(t *testing.T) {
 	err := provider.Present(domain, "", keyAuth)
 	assert.NoError(t, err, "Expected Present to return no error")
 }
+
+// blockingRoute53Server is a fake Route 53 API whose first change batch
+// is held back until release is closed, so that further changes pile up
+// meanwhile. Change batches containing a record of bad.example.com are
+// rejected.
+type blockingRoute53Server struct {
+	started chan struct{}
+	release chan struct{}
+
+	mu                sync.Mutex
+	changeRequests    []string
+	getChangeRequests int
+}
+
+func (s *blockingRoute53Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/xml")
+	switch r.URL.Path {
+	case "/2013-04-01/hostedzone/ABCDEFG/rrset/":
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mu.Lock()
+		s.changeRequests = append(s.changeRequests, string(body))
+		first := len(s.changeRequests) == 1
+		s.mu.Unlock()
+		if first {
+			close(s.started)
+			<-s.release
+		}
+		if strings.Contains(string(body), "<Name>_acme-challenge.bad.example.com.</Name>") {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(InvalidChangeBatchErrorResponse))
+			return
+		}
+		w.Write([]byte(ChangeResourceRecordSetsResponse))
+	case "/2013-04-01/change/123456":
+		s.mu.Lock()
+		s.getChangeRequests++
+		s.mu.Unlock()
+		w.Write([]byte(GetChangeResponse))
+	case "/2013-04-01/hostedzonesbyname":
+		w.Write([]byte(ListHostedZonesByNameResponse))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// presentWhileSubmitting runs Present for example.com and, while its change
+// batch is being submitted, for each of domains. It returns the errors of
+// Present by domain.
+func presentWhileSubmitting(t *testing.T, domains ...string) (*blockingRoute53Server, map[string]error) {
+	defer fakeHostedZone()()
+
+	server := &blockingRoute53Server{started: make(chan struct{}), release: make(chan struct{})}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	provider := makeRoute53Provider(ts)
+
+	var mu sync.Mutex
+	errs := make(map[string]error)
+	var wg sync.WaitGroup
+	present := func(domain string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := provider.Present(domain, "", "123456d==")
+			mu.Lock()
+			errs[domain] = err
+			mu.Unlock()
+		}()
+	}
+
+	present("example.com")
+	<-server.started
+	for _, domain := range domains {
+		present(domain)
+	}
+	for {
+		provider.zonesMu.Lock()
+		pending := len(provider.zones["ABCDEFG"].pending)
+		provider.zonesMu.Unlock()
+		if pending == len(domains) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(server.release)
+	wg.Wait()
+
+	return server, errs
+}
+
+func TestRoute53PresentCoalescesPendingChanges(t *testing.T) {
+	server, errs := presentWhileSubmitting(t, "www.example.com", "mail.example.com")
+
+	for domain, err := range errs {
+		assert.NoError(t, err, "Expected Present for %s to return no error", domain)
+	}
+	if assert.Len(t, server.changeRequests, 2, "Expected the pending changes to be submitted in a single batch") {
+		assert.Equal(t, 1, strings.Count(server.changeRequests[0], "<Action>UPSERT</Action>"))
+		assert.Contains(t, server.changeRequests[0], "<Name>_acme-challenge.example.com.</Name>")
+		assert.Equal(t, 2, strings.Count(server.changeRequests[1], "<Action>UPSERT</Action>"))
+		assert.Contains(t, server.changeRequests[1], "<Name>_acme-challenge.www.example.com.</Name>")
+		assert.Contains(t, server.changeRequests[1], "<Name>_acme-challenge.mail.example.com.</Name>")
+	}
+	assert.Equal(t, 2, server.getChangeRequests, "Expected each batch to be waited on once")
+}
+
+func TestRoute53PresentReportsErrorsPerChange(t *testing.T) {
+	server, errs := presentWhileSubmitting(t, "www.example.com", "bad.example.com")
+
+	assert.NoError(t, errs["example.com"])
+	assert.NoError(t, errs["www.example.com"], "Expected a valid change not to fail along with an invalid one")
+	if assert.Error(t, errs["bad.example.com"]) {
+		assert.Contains(t, errs["bad.example.com"].Error(), "InvalidChangeBatch")
+	}
+	assert.Len(t, server.changeRequests, 4, "Expected the rejected batch to be submitted again change by change")
+}
@@ -36,3 +36,14 @@ func newMockServer(t *testing.T, responses MockResponseMap) *httptest.Server {
 	time.Sleep(100 * time.Millisecond)
 	return ts
 }
+
+// fakeHostedZone makes getHostedZoneID look up every fqdn in the zone
+// example.com without querying DNS. The returned function restores the
+// lookup.
+func fakeHostedZone() func() {
+	saved := findZoneByFqdn
+	findZoneByFqdn = func(fqdn string, nameservers []string) (string, error) {
+		return "example.com.", nil
+	}
+	return func() { findZoneByFqdn = saved }
+}
@@ -0,0 +1,176 @@
+// Package scaleway implements a DNS provider for solving the DNS-01
+// challenge using Scaleway DNS.
+package scaleway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/stangah/lego/acme"
+)
+
+// Scaleway API reference: https://developers.scaleway.com/en/products/domain/dns/api/
+
+var (
+	// scalewayBaseURL is the base URL of the Scaleway DNS API.
+	scalewayBaseURL = "https://api.scaleway.com/domain/v2beta1"
+	// findZoneByFqdn determines the DNS zone of an fqdn. It is overridden
+	// during tests.
+	findZoneByFqdn = acme.FindZoneByFqdn
+)
+
+// defaultTTL is the TTL of the TXT records created by the provider.
+const defaultTTL = 60
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses Scaleway's DNS API to manage TXT records for a domain.
+type DNSProvider struct {
+	token string
+	ttl   int
+}
+
+// record is a DNS record of the Scaleway API.
+type record struct {
+	Name string `json:"name"`
+	Data string `json:"data"`
+	Type string `json:"type"`
+	TTL  int    `json:"ttl"`
+}
+
+// recordIDFields identifies the records to delete.
+type recordIDFields struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
+// recordChange is a change of the records of a zone. Exactly one of Add
+// and Delete is set.
+type recordChange struct {
+	Add *struct {
+		Records []record `json:"records"`
+	} `json:"add,omitempty"`
+	Delete *struct {
+		IDFields recordIDFields `json:"id_fields"`
+	} `json:"delete,omitempty"`
+}
+
+type updateRecordsRequest struct {
+	Changes          []recordChange `json:"changes"`
+	ReturnAllRecords bool           `json:"return_all_records"`
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Scaleway.
+// The API token must be passed in the environment variable
+// SCALEWAY_API_TOKEN, or the secret key of an API key in
+// SCALEWAY_SECRET_KEY. The access key of the API key, SCALEWAY_ACCESS_KEY,
+// is not needed for authentication.
+func NewDNSProvider() (*DNSProvider, error) {
+	token := os.Getenv("SCALEWAY_API_TOKEN")
+	if token == "" {
+		token = os.Getenv("SCALEWAY_SECRET_KEY")
+	}
+	return NewDNSProviderCredentials(token)
+}
+
+// NewDNSProviderCredentials uses the supplied token to return a DNSProvider
+// instance configured for Scaleway.
+func NewDNSProviderCredentials(token string) (*DNSProvider, error) {
+	if token == "" {
+		return nil, fmt.Errorf("Scaleway credentials missing")
+	}
+	return &DNSProvider{token: token, ttl: defaultTTL}, nil
+}
+
+// SetTTL sets the TTL in seconds of the TXT records created by the provider.
+func (d *DNSProvider) SetTTL(ttl int) error {
+	if ttl <= 0 {
+		return fmt.Errorf("Scaleway TTL must be positive, got %d", ttl)
+	}
+	d.ttl = ttl
+	return nil
+}
+
+// Present adds a TXT record with the challenge value to the zone of the
+// challenge name.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	zone, name, err := recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	change := recordChange{Add: &struct {
+		Records []record `json:"records"`
+	}{
+		Records: []record{{Name: name, Data: fmt.Sprintf("%q", value), Type: "TXT", TTL: d.ttl}},
+	}}
+	return d.updateRecords(zone, change)
+}
+
+// CleanUp deletes the TXT record with the challenge value, leaving other
+// values of the challenge name alone.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	zone, name, err := recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	change := recordChange{Delete: &struct {
+		IDFields recordIDFields `json:"id_fields"`
+	}{
+		IDFields: recordIDFields{Name: name, Type: "TXT", Data: fmt.Sprintf("%q", value)},
+	}}
+	return d.updateRecords(zone, change)
+}
+
+// recordLocation returns the zone containing fqdn and the name of fqdn
+// relative to it.
+func recordLocation(fqdn string) (string, string, error) {
+	authZone, err := findZoneByFqdn(fqdn, acme.RecursiveNameservers)
+	if err != nil {
+		return "", "", fmt.Errorf("Scaleway: could not determine zone for %s: %v", fqdn, err)
+	}
+
+	zone := acme.UnFqdn(authZone)
+	name := acme.UnFqdn(fqdn)
+	if name == zone {
+		return zone, "", nil
+	}
+	return zone, name[:len(name)-len(zone)-1], nil
+}
+
+// updateRecords applies change to the records of zone.
+func (d *DNSProvider) updateRecords(zone string, change recordChange) error {
+	body, err := json.Marshal(updateRecordsRequest{Changes: []recordChange{change}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PATCH", fmt.Sprintf("%s/dns-zones/%s/records", scalewayBaseURL, zone), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Auth-Token", d.token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", acme.UserAgentString())
+
+	resp, err := acme.DoWithRetry(req, nil)
+	if err != nil {
+		return fmt.Errorf("Scaleway API call failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var errInfo struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&errInfo)
+		return fmt.Errorf("Scaleway API call failed: HTTP %d: %s", resp.StatusCode, errInfo.Message)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package scaleway
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stangah/lego/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	scalewayLiveTest bool
+	scalewayAPIToken string
+	scalewaySecret   string
+	scalewayDomain   string
+)
+
+func init() {
+	scalewayAPIToken = os.Getenv("SCALEWAY_API_TOKEN")
+	scalewaySecret = os.Getenv("SCALEWAY_SECRET_KEY")
+	scalewayDomain = os.Getenv("SCALEWAY_DOMAIN")
+	if (len(scalewayAPIToken) > 0 || len(scalewaySecret) > 0) && len(scalewayDomain) > 0 {
+		scalewayLiveTest = true
+	}
+}
+
+func restoreScalewayEnv() {
+	os.Setenv("SCALEWAY_API_TOKEN", scalewayAPIToken)
+	os.Setenv("SCALEWAY_SECRET_KEY", scalewaySecret)
+}
+
+// mockScaleway serves the Scaleway API with handler and makes the zone of
+// every fqdn be zone until the returned function is called.
+func mockScaleway(zone string, handler http.HandlerFunc) func() {
+	server := httptest.NewServer(handler)
+	baseURL, findZone := scalewayBaseURL, findZoneByFqdn
+	scalewayBaseURL = server.URL
+	findZoneByFqdn = func(fqdn string, nameservers []string) (string, error) {
+		return zone, nil
+	}
+	return func() {
+		scalewayBaseURL, findZoneByFqdn = baseURL, findZone
+		server.Close()
+	}
+}
+
+func TestNewDNSProviderValid(t *testing.T) {
+	os.Setenv("SCALEWAY_API_TOKEN", "")
+	os.Setenv("SCALEWAY_SECRET_KEY", "")
+	defer restoreScalewayEnv()
+	_, err := NewDNSProviderCredentials("123")
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	defer restoreScalewayEnv()
+
+	os.Setenv("SCALEWAY_API_TOKEN", "")
+	os.Setenv("SCALEWAY_SECRET_KEY", "secret")
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", provider.token)
+
+	// An API token takes precedence over the secret key of an API key.
+	os.Setenv("SCALEWAY_API_TOKEN", "token")
+	provider, err = NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, "token", provider.token)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	os.Setenv("SCALEWAY_API_TOKEN", "")
+	os.Setenv("SCALEWAY_SECRET_KEY", "")
+	defer restoreScalewayEnv()
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "Scaleway credentials missing")
+}
+
+func TestScalewayPresent(t *testing.T) {
+	var requestReceived bool
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockScaleway("example.com.", func(w http.ResponseWriter, r *http.Request) {
+		requestReceived = true
+
+		assert.Equal(t, "PATCH", r.Method)
+		assert.Equal(t, "/dns-zones/example.com/records", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "asdf1234", r.Header.Get("X-Auth-Token"))
+
+		reqBody, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"changes":[{"add":{"records":[{"name":"_acme-challenge.www","data":"\"`+value+`\"","type":"TXT","ttl":60}]}}],"return_all_records":false}`, string(reqBody))
+
+		w.Write([]byte(`{"records":[]}`))
+	})()
+
+	provider, err := NewDNSProviderCredentials("asdf1234")
+	assert.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.True(t, requestReceived, "Expected request to be received by mock backend")
+}
+
+func TestScalewayCleanUp(t *testing.T) {
+	var requestReceived bool
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockScaleway("example.com.", func(w http.ResponseWriter, r *http.Request) {
+		requestReceived = true
+
+		assert.Equal(t, "PATCH", r.Method)
+		assert.Equal(t, "/dns-zones/example.com/records", r.URL.Path)
+		assert.Equal(t, "asdf1234", r.Header.Get("X-Auth-Token"))
+
+		// Only the record with our value is deleted, not the whole name.
+		reqBody, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"changes":[{"delete":{"id_fields":{"name":"_acme-challenge.www","type":"TXT","data":"\"`+value+`\""}}}],"return_all_records":false}`, string(reqBody))
+
+		w.Write([]byte(`{"records":[]}`))
+	})()
+
+	provider, err := NewDNSProviderCredentials("asdf1234")
+	assert.NoError(t, err)
+
+	err = provider.CleanUp("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.True(t, requestReceived, "Expected request to be received by mock backend")
+}
+
+func TestScalewaySubZone(t *testing.T) {
+	var paths, bodies []string
+	defer mockScaleway("sub.example.com.", func(w http.ResponseWriter, r *http.Request) {
+		reqBody, _ := ioutil.ReadAll(r.Body)
+		paths = append(paths, r.URL.Path)
+		bodies = append(bodies, string(reqBody))
+		w.Write([]byte(`{"records":[]}`))
+	})()
+
+	provider, err := NewDNSProviderCredentials("asdf1234")
+	assert.NoError(t, err)
+
+	// The name is relative to the delegated zone, not its parent.
+	assert.NoError(t, provider.Present("www.sub.example.com", "", "foobar"))
+	assert.Equal(t, []string{"/dns-zones/sub.example.com/records"}, paths)
+	assert.Contains(t, bodies[0], `"name":"_acme-challenge.www"`)
+}
+
+func TestScalewayApexAndTTL(t *testing.T) {
+	var bodies []string
+	defer mockScaleway("example.com.", func(w http.ResponseWriter, r *http.Request) {
+		reqBody, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(reqBody))
+		w.Write([]byte(`{"records":[]}`))
+	})()
+
+	acme.DNS01RecordName = func(domain, fqdn string) string { return "example.com." }
+	defer func() { acme.DNS01RecordName = nil }()
+
+	provider, err := NewDNSProviderCredentials("asdf1234")
+	assert.NoError(t, err)
+	assert.Error(t, provider.SetTTL(0))
+	assert.NoError(t, provider.SetTTL(300))
+
+	// Records at the apex of the zone have an empty name.
+	assert.NoError(t, provider.Present("example.com", "", "foobar"))
+	_, value, _ := acme.DNS01Record("example.com", "foobar")
+	assert.Equal(t, []string{
+		`{"changes":[{"add":{"records":[{"name":"","data":"\"` + value + `\"","type":"TXT","ttl":300}]}}],"return_all_records":false}`,
+	}, bodies)
+}
+
+func TestScalewayPresentFailed(t *testing.T) {
+	defer mockScaleway("example.com.", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message":"authentication is denied","type":"denied_authentication"}`))
+	})()
+
+	provider, err := NewDNSProviderCredentials("wrong")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.com", "", "foobar"), "Scaleway API call failed: HTTP 401: authentication is denied")
+}
+
+func TestLiveScalewayPresent(t *testing.T) {
+	if !scalewayLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+
+	err = provider.Present(scalewayDomain, "", "123d==")
+	assert.NoError(t, err)
+}
+
+func TestLiveScalewayCleanUp(t *testing.T) {
+	if !scalewayLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	time.Sleep(time.Second * 1)
+
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+
+	err = provider.CleanUp(scalewayDomain, "", "123d==")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,236 @@
+// Package simply implements a DNS provider for solving the DNS-01
+// challenge using Simply.com DNS.
+package simply
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+
+	"github.com/stangah/lego/acme"
+)
+
+// Simply.com API reference: https://www.simply.com/en/docs/api/
+
+var (
+	// simplyBaseURL is the base URL of the Simply.com API.
+	simplyBaseURL = "https://api.simply.com/1"
+	// findZoneByFqdn determines the domain of an fqdn. It is overridden
+	// during tests.
+	findZoneByFqdn = acme.FindZoneByFqdn
+)
+
+// defaultTTL is the TTL of the TXT records created by the provider.
+const defaultTTL = 120
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses Simply.com's API to manage TXT records for a domain.
+type DNSProvider struct {
+	accountName string
+	apiKey      string
+	ttl         int
+
+	// ids maps the fqdn and value of each challenge to the numeric record ID
+	// returned by Simply.com. CleanUp looks up records missing from it by
+	// name.
+	ids   map[string]int
+	idsMu sync.Mutex
+}
+
+// dnsRecord is a DNS record of the Simply.com API.
+type dnsRecord struct {
+	ID   int    `json:"record_id,omitempty"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Data string `json:"data"`
+	TTL  int    `json:"ttl,omitempty"`
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Simply.com.
+// The account name and API key must be passed in the environment
+// variables SIMPLY_ACCOUNT_NAME and SIMPLY_API_KEY.
+func NewDNSProvider() (*DNSProvider, error) {
+	return NewDNSProviderCredentials(os.Getenv("SIMPLY_ACCOUNT_NAME"), os.Getenv("SIMPLY_API_KEY"))
+}
+
+// NewDNSProviderCredentials uses the supplied account name and API key to
+// return a DNSProvider instance configured for Simply.com.
+func NewDNSProviderCredentials(accountName, apiKey string) (*DNSProvider, error) {
+	if accountName == "" || apiKey == "" {
+		return nil, fmt.Errorf("Simply.com credentials missing")
+	}
+	return &DNSProvider{
+		accountName: accountName,
+		apiKey:      apiKey,
+		ttl:         defaultTTL,
+		ids:         make(map[string]int),
+	}, nil
+}
+
+// SetTTL sets the TTL in seconds of the TXT records created by the provider.
+func (d *DNSProvider) SetTTL(ttl int) error {
+	if ttl <= 0 {
+		return fmt.Errorf("Simply.com TTL must be positive, got %d", ttl)
+	}
+	d.ttl = ttl
+	return nil
+}
+
+// Present creates a TXT record for the challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	zone, name, err := recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Record struct {
+			ID int `json:"id"`
+		} `json:"record"`
+	}
+	record := dnsRecord{Name: name, Type: "TXT", Data: value, TTL: d.ttl}
+	if err := d.doRequest("POST", zone, "", record, &result); err != nil {
+		return err
+	}
+
+	d.idsMu.Lock()
+	d.ids[fqdn+" "+value] = result.Record.ID
+	d.idsMu.Unlock()
+
+	return nil
+}
+
+// CleanUp deletes the TXT record created by Present. Records created by
+// another run are looked up by name and value.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	zone, name, err := recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	d.idsMu.Lock()
+	id, ok := d.ids[fqdn+" "+value]
+	d.idsMu.Unlock()
+
+	ids := []int{id}
+	if !ok {
+		ids, err = d.findTxtRecords(zone, name, value)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return fmt.Errorf("Simply.com: no TXT record found for '%s'", fqdn)
+		}
+	}
+
+	for _, id := range ids {
+		if err := d.doRequest("DELETE", zone, fmt.Sprintf("%d/", id), nil, nil); err != nil {
+			return err
+		}
+	}
+
+	d.idsMu.Lock()
+	delete(d.ids, fqdn+" "+value)
+	d.idsMu.Unlock()
+
+	return nil
+}
+
+// findTxtRecords returns the IDs of the TXT records with the given name and
+// value in the domain zone.
+func (d *DNSProvider) findTxtRecords(zone, name, value string) ([]int, error) {
+	var result struct {
+		Records []dnsRecord `json:"records"`
+	}
+	if err := d.doRequest("GET", zone, "", nil, &result); err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for _, record := range result.Records {
+		if record.Type == "TXT" && record.Name == name && record.Data == value {
+			ids = append(ids, record.ID)
+		}
+	}
+	return ids, nil
+}
+
+// recordLocation returns the product of the Simply.com account holding fqdn,
+// which is named after its domain, and the record name within it. Simply.com
+// names the apex "@".
+func recordLocation(fqdn string) (string, string, error) {
+	authZone, err := findZoneByFqdn(fqdn, acme.RecursiveNameservers)
+	if err != nil {
+		return "", "", fmt.Errorf("Simply.com: could not determine zone for %s: %v", fqdn, err)
+	}
+
+	zone := acme.UnFqdn(authZone)
+	name := acme.UnFqdn(fqdn)
+	if name == zone {
+		return zone, "@", nil
+	}
+	return zone, name[:len(name)-len(zone)-1], nil
+}
+
+// doRequest sends a request to the DNS records of zone, or to the record
+// below them named by uri.
+func (d *DNSProvider) doRequest(method, zone, uri string, body, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	endpoint := fmt.Sprintf("%s/%s/%s/my/products/%s/dns/records/%s", simplyBaseURL,
+		url.PathEscape(d.accountName), url.PathEscape(d.apiKey), zone, uri)
+	req, err := http.NewRequest(method, endpoint, reqBody)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(d.accountName, d.apiKey)
+	req.Header.Set("User-Agent", acme.UserAgentString())
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := acme.DoWithRetry(req, nil)
+	if err != nil {
+		return fmt.Errorf("Simply.com API call failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var data json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil && resp.StatusCode < 400 {
+		return fmt.Errorf("Simply.com API call failed: %v", err)
+	}
+
+	// The status of the call is repeated in the body, which is the only
+	// place reporting some errors.
+	var status struct {
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+	}
+	json.Unmarshal(data, &status)
+	if resp.StatusCode >= 400 || status.Status >= 400 {
+		code := resp.StatusCode
+		if code < 400 {
+			code = status.Status
+		}
+		return fmt.Errorf("Simply.com API call failed: HTTP %d: %s", code, status.Message)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
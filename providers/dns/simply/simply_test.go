@@ -0,0 +1,287 @@
+package simply
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stangah/lego/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	simplyLiveTest    bool
+	simplyAccountName string
+	simplyAPIKey      string
+	simplyDomain      string
+)
+
+func init() {
+	simplyAccountName = os.Getenv("SIMPLY_ACCOUNT_NAME")
+	simplyAPIKey = os.Getenv("SIMPLY_API_KEY")
+	simplyDomain = os.Getenv("SIMPLY_DOMAIN")
+	if len(simplyAccountName) > 0 && len(simplyAPIKey) > 0 && len(simplyDomain) > 0 {
+		simplyLiveTest = true
+	}
+}
+
+func restoreSimplyEnv() {
+	os.Setenv("SIMPLY_ACCOUNT_NAME", simplyAccountName)
+	os.Setenv("SIMPLY_API_KEY", simplyAPIKey)
+}
+
+// fakeSimply serves the DNS records of the domain example.com from memory.
+type fakeSimply struct {
+	mu      sync.Mutex
+	records map[int]dnsRecord
+	deleted []int
+	next    int
+}
+
+func (f *fakeSimply) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	const prefix = "/1/S123456/secret/my/products/example.com/dns/records/"
+	if user, pass, ok := r.BasicAuth(); !ok || user != "S123456" || pass != "secret" || !strings.HasPrefix(r.URL.Path, prefix) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"status":401,"message":"Access denied"}`)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, prefix)
+	switch {
+	case r.Method == "POST" && rest == "":
+		var record dnsRecord
+		json.NewDecoder(r.Body).Decode(&record)
+		f.next++
+		record.ID = f.next
+		f.records[record.ID] = record
+		fmt.Fprintf(w, `{"record":{"id":%d},"status":200,"message":"OK"}`, record.ID)
+	case r.Method == "GET" && rest == "":
+		records := []dnsRecord{}
+		for _, record := range f.records {
+			records = append(records, record)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"records": records, "status": 200, "message": "OK"})
+	case r.Method == "DELETE":
+		id, _ := strconv.Atoi(strings.TrimSuffix(rest, "/"))
+		if _, ok := f.records[id]; !ok {
+			fmt.Fprint(w, `{"status":400,"message":"Invalid record ID"}`)
+			return
+		}
+		delete(f.records, id)
+		f.deleted = append(f.deleted, id)
+		fmt.Fprint(w, `{"status":200,"message":"Record removed"}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// mockSimply serves the Simply.com API with handler and makes the zone of
+// every fqdn be example.com until the returned function is called.
+func mockSimply(handler http.Handler) func() {
+	server := httptest.NewServer(handler)
+	baseURL, findZone := simplyBaseURL, findZoneByFqdn
+	simplyBaseURL = server.URL + "/1"
+	findZoneByFqdn = func(fqdn string, nameservers []string) (string, error) {
+		return "example.com.", nil
+	}
+	return func() {
+		simplyBaseURL, findZoneByFqdn = baseURL, findZone
+		server.Close()
+	}
+}
+
+func newFakeSimply() (*fakeSimply, func()) {
+	fake := &fakeSimply{records: map[int]dnsRecord{}}
+	return fake, mockSimply(fake)
+}
+
+func TestNewDNSProviderValid(t *testing.T) {
+	os.Setenv("SIMPLY_ACCOUNT_NAME", "")
+	os.Setenv("SIMPLY_API_KEY", "")
+	defer restoreSimplyEnv()
+	_, err := NewDNSProviderCredentials("S123456", "secret")
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	os.Setenv("SIMPLY_ACCOUNT_NAME", "S123456")
+	os.Setenv("SIMPLY_API_KEY", "secret")
+	defer restoreSimplyEnv()
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, "S123456", provider.accountName)
+	assert.Equal(t, "secret", provider.apiKey)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	os.Setenv("SIMPLY_ACCOUNT_NAME", "S123456")
+	os.Setenv("SIMPLY_API_KEY", "")
+	defer restoreSimplyEnv()
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "Simply.com credentials missing")
+}
+
+func TestSimplyPresent(t *testing.T) {
+	var requestReceived bool
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockSimply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestReceived = true
+
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/1/S123456/secret/my/products/example.com/dns/records/", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		user, pass, _ := r.BasicAuth()
+		assert.Equal(t, "S123456", user)
+		assert.Equal(t, "secret", pass)
+
+		reqBody, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"name":"_acme-challenge.www","type":"TXT","data":"`+value+`","ttl":120}`, string(reqBody))
+
+		fmt.Fprint(w, `{"record":{"id":1234},"status":200,"message":"OK"}`)
+	}))()
+
+	provider, err := NewDNSProviderCredentials("S123456", "secret")
+	assert.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.True(t, requestReceived, "Expected request to be received by mock backend")
+	assert.Equal(t, map[string]int{"_acme-challenge.www.example.com. " + value: 1234}, provider.ids)
+}
+
+func TestSimplyCleanUp(t *testing.T) {
+	var requestReceived bool
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockSimply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestReceived = true
+
+		// The record is deleted by the ID remembered by Present.
+		assert.Equal(t, "DELETE", r.Method)
+		assert.Equal(t, "/1/S123456/secret/my/products/example.com/dns/records/1234/", r.URL.Path)
+
+		fmt.Fprint(w, `{"status":200,"message":"Record removed"}`)
+	}))()
+
+	provider, err := NewDNSProviderCredentials("S123456", "secret")
+	assert.NoError(t, err)
+	provider.ids["_acme-challenge.www.example.com. "+value] = 1234
+
+	err = provider.CleanUp("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.True(t, requestReceived, "Expected request to be received by mock backend")
+	assert.Empty(t, provider.ids)
+}
+
+func TestSimplySharedName(t *testing.T) {
+	fake, done := newFakeSimply()
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("S123456", "secret")
+	assert.NoError(t, err)
+
+	assert.NoError(t, provider.Present("www.example.com", "", "key"))
+	assert.NoError(t, provider.Present("www.example.com", "", "other"))
+	_, value, _ := acme.DNS01Record("www.example.com", "key")
+	assert.Equal(t, dnsRecord{ID: 1, Name: "_acme-challenge.www", Type: "TXT", Data: value, TTL: 120}, fake.records[1])
+
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "other"))
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "key"))
+	assert.Equal(t, []int{2, 1}, fake.deleted)
+	assert.Empty(t, fake.records)
+}
+
+func TestSimplyApex(t *testing.T) {
+	fake, done := newFakeSimply()
+	defer done()
+
+	acme.DNS01RecordName = func(domain, fqdn string) string { return "example.com." }
+	defer func() { acme.DNS01RecordName = nil }()
+
+	provider, err := NewDNSProviderCredentials("S123456", "secret")
+	assert.NoError(t, err)
+
+	// Simply.com names the apex of the domain "@".
+	assert.NoError(t, provider.Present("example.com", "", "key"))
+	assert.Equal(t, "@", fake.records[1].Name)
+
+	// Records created by another run are looked up at the apex too.
+	other, err := NewDNSProviderCredentials("S123456", "secret")
+	assert.NoError(t, err)
+	assert.NoError(t, other.CleanUp("example.com", "", "key"))
+	assert.Equal(t, []int{1}, fake.deleted)
+}
+
+func TestSimplyCleanUpLooksUpRecords(t *testing.T) {
+	fake, done := newFakeSimply()
+	defer done()
+
+	first, err := NewDNSProviderCredentials("S123456", "secret")
+	assert.NoError(t, err)
+	assert.NoError(t, first.Present("example.com", "", "key"))
+	assert.NoError(t, first.Present("example.com", "", "other"))
+
+	// A provider which did not create the record looks it up by name and
+	// only deletes the one with its value.
+	second, err := NewDNSProviderCredentials("S123456", "secret")
+	assert.NoError(t, err)
+	assert.NoError(t, second.CleanUp("example.com", "", "key"))
+	assert.Equal(t, []int{1}, fake.deleted)
+	assert.EqualError(t, second.CleanUp("example.com", "", "key"), "Simply.com: no TXT record found for '_acme-challenge.example.com.'")
+}
+
+func TestSimplyPresentFailed(t *testing.T) {
+	_, done := newFakeSimply()
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("S123456", "wrong")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.com", "", "key"), "Simply.com API call failed: HTTP 401: Access denied")
+
+	// Errors reported with HTTP 200 are detected from the body.
+	provider, err = NewDNSProviderCredentials("S123456", "secret")
+	assert.NoError(t, err)
+	_, value, _ := acme.DNS01Record("example.com", "key")
+	provider.ids["_acme-challenge.example.com. "+value] = 42
+	assert.EqualError(t, provider.CleanUp("example.com", "", "key"), "Simply.com API call failed: HTTP 400: Invalid record ID")
+}
+
+func TestLiveSimplyPresent(t *testing.T) {
+	if !simplyLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	provider, err := NewDNSProviderCredentials(simplyAccountName, simplyAPIKey)
+	assert.NoError(t, err)
+
+	err = provider.Present(simplyDomain, "", "123d==")
+	assert.NoError(t, err)
+}
+
+func TestLiveSimplyCleanUp(t *testing.T) {
+	if !simplyLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	time.Sleep(time.Second * 1)
+
+	// A new provider looks the record up by name and value.
+	provider, err := NewDNSProviderCredentials(simplyAccountName, simplyAPIKey)
+	assert.NoError(t, err)
+
+	err = provider.CleanUp(simplyDomain, "", "123d==")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,263 @@
+// Package variomedia implements a DNS provider for solving the DNS-01
+// challenge using Variomedia DNS.
+package variomedia
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stangah/lego/acme"
+)
+
+// Variomedia API reference: https://api.variomedia.de/docs/
+
+var (
+	// variomediaBaseURL is the base URL of the Variomedia API.
+	variomediaBaseURL = "https://api.variomedia.de"
+	// findZoneByFqdn determines the domain of an fqdn. It is overridden
+	// during tests.
+	findZoneByFqdn = acme.FindZoneByFqdn
+	// jobPollInterval is the time between checks of the status of a queued
+	// job. It is shortened during tests.
+	jobPollInterval = 2 * time.Second
+)
+
+const (
+	// apiVersion selects the version of the API in the Accept header.
+	apiVersion = "application/vnd.variomedia.v1+json"
+	// defaultTTL is the TTL of the TXT records created by the provider.
+	defaultTTL = 300
+	// jobTimeout is how long to wait for a queued job to finish.
+	jobTimeout = 2 * time.Minute
+)
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses Variomedia's API to manage TXT records for a domain.
+type DNSProvider struct {
+	token string
+	ttl   int
+
+	// ids maps the fqdn and value of each challenge to the ID of the
+	// dns-record linked from the job which created it. The API cannot look
+	// records up by value, so CleanUp depends on it.
+	ids   map[string]string
+	idsMu sync.Mutex
+}
+
+// resource is a JSON:API resource object.
+type resource struct {
+	Type       string            `json:"type"`
+	ID         string            `json:"id,omitempty"`
+	Attributes json.RawMessage   `json:"attributes,omitempty"`
+	Links      map[string]string `json:"links,omitempty"`
+}
+
+// document is a JSON:API document holding a single resource, the body of
+// all requests and successful responses.
+type document struct {
+	Data resource `json:"data"`
+}
+
+// dnsRecordAttributes are the attributes of a dns-record resource.
+type dnsRecordAttributes struct {
+	RecordType string `json:"record_type"`
+	Name       string `json:"name"`
+	Domain     string `json:"domain"`
+	Data       string `json:"data"`
+	TTL        int    `json:"ttl"`
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Variomedia.
+// The API token must be passed in the environment variable
+// VARIOMEDIA_API_TOKEN.
+func NewDNSProvider() (*DNSProvider, error) {
+	return NewDNSProviderCredentials(os.Getenv("VARIOMEDIA_API_TOKEN"))
+}
+
+// NewDNSProviderCredentials uses the supplied API token to return a
+// DNSProvider instance configured for Variomedia.
+func NewDNSProviderCredentials(token string) (*DNSProvider, error) {
+	if token == "" {
+		return nil, fmt.Errorf("Variomedia credentials missing")
+	}
+	return &DNSProvider{token: token, ttl: defaultTTL, ids: make(map[string]string)}, nil
+}
+
+// SetTTL sets the TTL in seconds of the TXT records created by the provider.
+func (d *DNSProvider) SetTTL(ttl int) error {
+	if ttl <= 0 {
+		return fmt.Errorf("Variomedia TTL must be positive, got %d", ttl)
+	}
+	d.ttl = ttl
+	return nil
+}
+
+// Present creates a TXT record for the challenge and waits until the job
+// creating it has finished.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	authZone, err := findZoneByFqdn(fqdn, acme.RecursiveNameservers)
+	if err != nil {
+		return fmt.Errorf("Variomedia: could not determine zone for %s: %v", fqdn, err)
+	}
+	zone := acme.UnFqdn(authZone)
+	name := strings.TrimSuffix(strings.TrimSuffix(acme.UnFqdn(fqdn), zone), ".")
+
+	attributes, err := json.Marshal(dnsRecordAttributes{
+		RecordType: "TXT",
+		Name:       name,
+		Domain:     zone,
+		Data:       value,
+		TTL:        d.ttl,
+	})
+	if err != nil {
+		return err
+	}
+
+	job, err := d.doRequest("POST", "/dns-records", &document{Data: resource{Type: "dns-record", Attributes: attributes}})
+	if err != nil {
+		return err
+	}
+	if err := d.waitForJob(job); err != nil {
+		return err
+	}
+
+	id := path.Base(job.Data.Links["dns-record"])
+	if id == "." || id == "/" {
+		return fmt.Errorf("Variomedia: no record ID returned for '%s'", fqdn)
+	}
+
+	d.idsMu.Lock()
+	d.ids[fqdn+" "+value] = id
+	d.idsMu.Unlock()
+
+	return nil
+}
+
+// CleanUp deletes the TXT record created by Present and waits until the
+// job deleting it has finished.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	d.idsMu.Lock()
+	id, ok := d.ids[fqdn+" "+value]
+	d.idsMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("Variomedia: unknown record ID for '%s'", fqdn)
+	}
+
+	job, err := d.doRequest("DELETE", "/dns-records/"+id, nil)
+	if err != nil {
+		return err
+	}
+	if err := d.waitForJob(job); err != nil {
+		return err
+	}
+
+	d.idsMu.Lock()
+	delete(d.ids, fqdn+" "+value)
+	d.idsMu.Unlock()
+
+	return nil
+}
+
+// waitForJob polls the queue-job returned by a modifying request until it
+// is done. Other resources are returned by requests which completed
+// immediately.
+func (d *DNSProvider) waitForJob(job *document) error {
+	deadline := time.Now().Add(jobTimeout)
+	for job.Data.Type == "queue-job" {
+		var attributes struct {
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal(job.Data.Attributes, &attributes); err != nil {
+			return fmt.Errorf("Variomedia: could not decode job %s: %v", job.Data.ID, err)
+		}
+		switch attributes.Status {
+		case "done":
+			return nil
+		case "failed":
+			return fmt.Errorf("Variomedia: job %s failed", job.Data.ID)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Variomedia: job %s did not finish within %v", job.Data.ID, jobTimeout)
+		}
+		time.Sleep(jobPollInterval)
+
+		links := job.Data.Links
+		var err error
+		job, err = d.doRequest("GET", "/queue-jobs/"+job.Data.ID, nil)
+		if err != nil {
+			return err
+		}
+		// The record link is only returned with the job created by the
+		// request, so keep it.
+		if job.Data.Links == nil {
+			job.Data.Links = links
+		}
+	}
+	return nil
+}
+
+func (d *DNSProvider) doRequest(method, uri string, body *document) (*document, error) {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, variomediaBaseURL+uri, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "token "+d.token)
+	req.Header.Set("Accept", apiVersion)
+	req.Header.Set("User-Agent", acme.UserAgentString())
+	if body != nil {
+		req.Header.Set("Content-Type", "application/vnd.api+json")
+	}
+
+	resp, err := acme.DoWithRetry(req, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Variomedia API call failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var errInfo struct {
+			Errors []struct {
+				Title  string `json:"title"`
+				Detail string `json:"detail"`
+			} `json:"errors"`
+		}
+		json.NewDecoder(resp.Body).Decode(&errInfo)
+		var msgs []string
+		for _, e := range errInfo.Errors {
+			msg := e.Title
+			if e.Detail != "" {
+				msg += ": " + e.Detail
+			}
+			msgs = append(msgs, msg)
+		}
+		return nil, fmt.Errorf("Variomedia API call failed: HTTP %d: %s", resp.StatusCode, strings.Join(msgs, "; "))
+	}
+
+	var result document
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("Variomedia API call failed: %v", err)
+	}
+	return &result, nil
+}
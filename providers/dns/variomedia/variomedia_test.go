@@ -0,0 +1,266 @@
+package variomedia
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stangah/lego/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	variomediaLiveTest bool
+	variomediaAPIToken string
+	variomediaDomain   string
+)
+
+func init() {
+	variomediaAPIToken = os.Getenv("VARIOMEDIA_API_TOKEN")
+	variomediaDomain = os.Getenv("VARIOMEDIA_DOMAIN")
+	if len(variomediaAPIToken) > 0 && len(variomediaDomain) > 0 {
+		variomediaLiveTest = true
+	}
+}
+
+func restoreVariomediaEnv() {
+	os.Setenv("VARIOMEDIA_API_TOKEN", variomediaAPIToken)
+}
+
+// fakeVariomedia records the requests it receives and answers modifying
+// requests with a job which is pending until it is polled once.
+type fakeVariomedia struct {
+	mu       sync.Mutex
+	url      string
+	requests []string
+	bodies   []string
+}
+
+func (f *fakeVariomedia) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "token secret" || r.Header.Get("Accept") != "application/vnd.variomedia.v1+json" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"errors":[{"status":"401","title":"Unauthorized","detail":"invalid token"}]}`)
+		return
+	}
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+
+	w.Header().Set("Content-Type", "application/vnd.api+json")
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/dns-records":
+		if r.Header.Get("Content-Type") != "application/vnd.api+json" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			fmt.Fprint(w, `{"errors":[{"status":"415","title":"Unsupported Media Type"}]}`)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		f.bodies = append(f.bodies, string(body))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"data":{"type":"queue-job","id":"17","attributes":{"status":"pending"},"links":{"queue-job":"%s/queue-jobs/17","dns-record":"%s/dns-records/42"}}}`, f.url, f.url)
+	case r.Method == "GET" && r.URL.Path == "/queue-jobs/17":
+		fmt.Fprint(w, `{"data":{"type":"queue-job","id":"17","attributes":{"status":"done"}}}`)
+	case r.Method == "DELETE" && r.URL.Path == "/dns-records/42":
+		fmt.Fprint(w, `{"data":{"type":"queue-job","id":"18","attributes":{"status":"done"}}}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"errors":[{"status":"404","title":"Not Found"}]}`)
+	}
+}
+
+// mockVariomedia serves the Variomedia API with handler, polls jobs without
+// delay and makes the zone of every fqdn be example.com until the returned
+// function is called.
+func mockVariomedia(handler http.Handler) (string, func()) {
+	server := httptest.NewServer(handler)
+	baseURL, findZone, interval := variomediaBaseURL, findZoneByFqdn, jobPollInterval
+	variomediaBaseURL, jobPollInterval = server.URL, 0
+	findZoneByFqdn = func(fqdn string, nameservers []string) (string, error) {
+		return "example.com.", nil
+	}
+	return server.URL, func() {
+		variomediaBaseURL, findZoneByFqdn, jobPollInterval = baseURL, findZone, interval
+		server.Close()
+	}
+}
+
+func newFakeVariomedia() (*fakeVariomedia, func()) {
+	fake := &fakeVariomedia{}
+	var done func()
+	fake.url, done = mockVariomedia(fake)
+	return fake, done
+}
+
+func TestNewDNSProviderValid(t *testing.T) {
+	os.Setenv("VARIOMEDIA_API_TOKEN", "")
+	defer restoreVariomediaEnv()
+	_, err := NewDNSProviderCredentials("123")
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	os.Setenv("VARIOMEDIA_API_TOKEN", "secret")
+	defer restoreVariomediaEnv()
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", provider.token)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	os.Setenv("VARIOMEDIA_API_TOKEN", "")
+	defer restoreVariomediaEnv()
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "Variomedia credentials missing")
+}
+
+func TestVariomediaPresent(t *testing.T) {
+	var requestReceived bool
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	_, done := mockVariomedia(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestReceived = true
+
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/dns-records", r.URL.Path)
+		assert.Equal(t, "application/vnd.api+json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "application/vnd.variomedia.v1+json", r.Header.Get("Accept"))
+		assert.Equal(t, "token asdf1234", r.Header.Get("Authorization"))
+
+		// The record is wrapped in a JSON:API document.
+		reqBody, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"data":{"type":"dns-record","attributes":{"record_type":"TXT","name":"_acme-challenge.www","domain":"example.com","data":"`+value+`","ttl":300}}}`, string(reqBody))
+
+		// A job which already finished is not polled.
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"data":{"type":"queue-job","id":"17","attributes":{"status":"done"},"links":{"dns-record":"https://api.variomedia.de/dns-records/42"}}}`)
+	}))
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("asdf1234")
+	assert.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.True(t, requestReceived, "Expected request to be received by mock backend")
+	assert.Equal(t, map[string]string{"_acme-challenge.www.example.com. " + value: "42"}, provider.ids)
+}
+
+func TestVariomediaCleanUp(t *testing.T) {
+	var requestReceived bool
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	_, done := mockVariomedia(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestReceived = true
+
+		// The record is deleted by the ID remembered by Present.
+		assert.Equal(t, "DELETE", r.Method)
+		assert.Equal(t, "/dns-records/42", r.URL.Path)
+		assert.Equal(t, "token asdf1234", r.Header.Get("Authorization"))
+		assert.Empty(t, r.Header.Get("Content-Type"))
+
+		fmt.Fprint(w, `{"data":{"type":"queue-job","id":"18","attributes":{"status":"done"}}}`)
+	}))
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("asdf1234")
+	assert.NoError(t, err)
+	provider.ids["_acme-challenge.www.example.com. "+value] = "42"
+
+	err = provider.CleanUp("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.True(t, requestReceived, "Expected request to be received by mock backend")
+	assert.Empty(t, provider.ids)
+}
+
+func TestVariomediaPendingJob(t *testing.T) {
+	fake, done := newFakeVariomedia()
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+
+	// The record link of the created job is kept while the job is polled.
+	assert.NoError(t, provider.Present("www.example.com", "", "key"))
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "key"))
+	assert.Equal(t, []string{
+		"POST /dns-records",
+		"GET /queue-jobs/17",
+		"DELETE /dns-records/42",
+	}, fake.requests)
+}
+
+func TestVariomediaApexAndTTL(t *testing.T) {
+	fake, done := newFakeVariomedia()
+	defer done()
+
+	acme.DNS01RecordName = func(domain, fqdn string) string { return "example.com." }
+	defer func() { acme.DNS01RecordName = nil }()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+	assert.Error(t, provider.SetTTL(0))
+	assert.NoError(t, provider.SetTTL(600))
+
+	// Records at the apex of the domain have an empty name.
+	assert.NoError(t, provider.Present("example.com", "", "key"))
+	_, value, _ := acme.DNS01Record("example.com", "key")
+	assert.Equal(t, []string{
+		`{"data":{"type":"dns-record","attributes":{"record_type":"TXT","name":"","domain":"example.com","data":"` + value + `","ttl":600}}}`,
+	}, fake.bodies)
+}
+
+func TestVariomediaJobFailed(t *testing.T) {
+	for _, response := range []string{
+		`{"data":{"type":"queue-job","id":"17","attributes":{"status":"failed"},"links":{"dns-record":"https://api.variomedia.de/dns-records/42"}}}`,
+		`{"data":{"type":"queue-job","id":"17","attributes":{"status":"done"}}}`,
+	} {
+		_, done := mockVariomedia(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, response)
+		}))
+
+		provider, err := NewDNSProviderCredentials("secret")
+		assert.NoError(t, err)
+		err = provider.Present("example.com", "", "key")
+		done()
+
+		assert.Error(t, err)
+		assert.Empty(t, provider.ids)
+	}
+}
+
+func TestVariomediaPresentFailed(t *testing.T) {
+	_, done := newFakeVariomedia()
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("wrong")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.com", "", "key"), "Variomedia API call failed: HTTP 401: Unauthorized: invalid token")
+	assert.EqualError(t, provider.CleanUp("example.com", "", "key"), "Variomedia: unknown record ID for '_acme-challenge.example.com.'")
+}
+
+// The IDs of the records are only known to the provider which created them,
+// so both steps run in one test.
+func TestLiveVariomediaPresentAndCleanUp(t *testing.T) {
+	if !variomediaLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	provider, err := NewDNSProviderCredentials(variomediaAPIToken)
+	assert.NoError(t, err)
+
+	err = provider.Present(variomediaDomain, "", "123d==")
+	assert.NoError(t, err)
+
+	time.Sleep(time.Second * 1)
+
+	err = provider.CleanUp(variomediaDomain, "", "123d==")
+	assert.NoError(t, err)
+}
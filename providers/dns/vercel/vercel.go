@@ -0,0 +1,197 @@
+// Package vercel implements a DNS provider for solving the DNS-01 challenge
+// using Vercel DNS.
+package vercel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+
+	"github.com/stangah/lego/acme"
+)
+
+// Vercel API reference: https://vercel.com/docs/rest-api#endpoints/dns
+
+var (
+	// vercelBaseURL is the base URL of the Vercel API.
+	vercelBaseURL = "https://api.vercel.com"
+	// findZoneByFqdn determines the domain of an fqdn. It is overridden
+	// during tests.
+	findZoneByFqdn = acme.FindZoneByFqdn
+)
+
+// defaultTTL is the TTL of the TXT records created by the provider.
+const defaultTTL = 60
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses Vercel's API to manage TXT records for a domain.
+type DNSProvider struct {
+	token  string
+	teamID string
+	ttl    int
+
+	// ids maps the fqdn and value of each challenge to the UID Vercel
+	// assigned to its record. Vercel deletes records only by UID, so records
+	// created by another provider cannot be cleaned up.
+	ids   map[string]string
+	idsMu sync.Mutex
+}
+
+// dnsRecord is a DNS record of the Vercel API.
+type dnsRecord struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	TTL   int    `json:"ttl,omitempty"`
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Vercel. The
+// API token must be passed in the environment variable VERCEL_API_TOKEN.
+// Domains owned by a team additionally need the team ID in VERCEL_TEAM_ID.
+func NewDNSProvider() (*DNSProvider, error) {
+	provider, err := NewDNSProviderCredentials(os.Getenv("VERCEL_API_TOKEN"))
+	if err != nil {
+		return nil, err
+	}
+	provider.SetTeamID(os.Getenv("VERCEL_TEAM_ID"))
+	return provider, nil
+}
+
+// NewDNSProviderCredentials uses the supplied API token to return a
+// DNSProvider instance configured for Vercel.
+func NewDNSProviderCredentials(token string) (*DNSProvider, error) {
+	if token == "" {
+		return nil, fmt.Errorf("Vercel credentials missing")
+	}
+	return &DNSProvider{token: token, ttl: defaultTTL, ids: make(map[string]string)}, nil
+}
+
+// SetTeamID makes the provider manage the domains of the team with the
+// given ID instead of those of the owner of the token.
+func (d *DNSProvider) SetTeamID(teamID string) {
+	d.teamID = teamID
+}
+
+// SetTTL sets the TTL in seconds of the TXT records created by the provider.
+func (d *DNSProvider) SetTTL(ttl int) error {
+	if ttl <= 0 {
+		return fmt.Errorf("Vercel TTL must be positive, got %d", ttl)
+	}
+	d.ttl = ttl
+	return nil
+}
+
+// Present creates a TXT record for the challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	zone, name, err := recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	var created struct {
+		UID string `json:"uid"`
+	}
+	record := dnsRecord{Name: name, Type: "TXT", Value: value, TTL: d.ttl}
+	if err := d.doRequest("POST", fmt.Sprintf("/v2/domains/%s/records", zone), record, &created); err != nil {
+		return err
+	}
+
+	d.idsMu.Lock()
+	d.ids[fqdn+" "+value] = created.UID
+	d.idsMu.Unlock()
+
+	return nil
+}
+
+// CleanUp deletes the TXT record created by Present.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	d.idsMu.Lock()
+	id, ok := d.ids[fqdn+" "+value]
+	d.idsMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("Vercel: unknown record ID for '%s'", fqdn)
+	}
+
+	if err := d.doRequest("DELETE", "/v2/domains/records/"+id, nil, nil); err != nil {
+		return err
+	}
+
+	d.idsMu.Lock()
+	delete(d.ids, fqdn+" "+value)
+	d.idsMu.Unlock()
+
+	return nil
+}
+
+// recordLocation returns the Vercel domain of fqdn, as used in the path of the
+// records endpoint, and the record name within it, which is empty at the
+// apex.
+func recordLocation(fqdn string) (string, string, error) {
+	authZone, err := findZoneByFqdn(fqdn, acme.RecursiveNameservers)
+	if err != nil {
+		return "", "", fmt.Errorf("Vercel: could not determine zone for %s: %v", fqdn, err)
+	}
+
+	zone := acme.UnFqdn(authZone)
+	name := acme.UnFqdn(fqdn)
+	if name == zone {
+		return zone, "", nil
+	}
+	return zone, name[:len(name)-len(zone)-1], nil
+}
+
+func (d *DNSProvider) doRequest(method, uri string, body, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	if d.teamID != "" {
+		uri += "?teamId=" + url.QueryEscape(d.teamID)
+	}
+
+	req, err := http.NewRequest(method, vercelBaseURL+uri, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+d.token)
+	req.Header.Set("User-Agent", acme.UserAgentString())
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := acme.DoWithRetry(req, nil)
+	if err != nil {
+		return fmt.Errorf("Vercel API call failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var errInfo struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&errInfo)
+		return fmt.Errorf("Vercel API call failed: HTTP %d: %s", resp.StatusCode, errInfo.Error.Message)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
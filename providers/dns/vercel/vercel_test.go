@@ -0,0 +1,262 @@
+package vercel
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stangah/lego/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	vercelLiveTest bool
+	vercelToken    string
+	vercelTeamID   string
+	vercelDomain   string
+)
+
+func init() {
+	vercelToken = os.Getenv("VERCEL_API_TOKEN")
+	vercelTeamID = os.Getenv("VERCEL_TEAM_ID")
+	vercelDomain = os.Getenv("VERCEL_DOMAIN")
+	if len(vercelToken) > 0 && len(vercelDomain) > 0 {
+		vercelLiveTest = true
+	}
+}
+
+func restoreVercelEnv() {
+	os.Setenv("VERCEL_API_TOKEN", vercelToken)
+	os.Setenv("VERCEL_TEAM_ID", vercelTeamID)
+}
+
+// fakeVercel serves the DNS records of the domain example.com from memory.
+type fakeVercel struct {
+	mu       sync.Mutex
+	records  map[string]dnsRecord
+	requests []string
+	next     int
+}
+
+func (f *fakeVercel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"error":{"code":"forbidden","message":"Not authorized"}}`)
+		return
+	}
+	f.requests = append(f.requests, r.Method+" "+r.URL.RequestURI())
+
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/v2/domains/example.com/records":
+		var record dnsRecord
+		json.NewDecoder(r.Body).Decode(&record)
+		f.next++
+		uid := fmt.Sprintf("rec_%d", f.next)
+		f.records[uid] = record
+		fmt.Fprintf(w, `{"uid":%q}`, uid)
+	case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/v2/domains/records/"):
+		uid := strings.TrimPrefix(r.URL.Path, "/v2/domains/records/")
+		if _, ok := f.records[uid]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":"not_found","message":"The DNS record was not found"}}`)
+			return
+		}
+		delete(f.records, uid)
+		fmt.Fprint(w, `{}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// mockVercel serves the Vercel API with handler and makes the zone of
+// every fqdn be example.com until the returned function is called.
+func mockVercel(handler http.Handler) func() {
+	server := httptest.NewServer(handler)
+	baseURL, findZone := vercelBaseURL, findZoneByFqdn
+	vercelBaseURL = server.URL
+	findZoneByFqdn = func(fqdn string, nameservers []string) (string, error) {
+		return "example.com.", nil
+	}
+	return func() {
+		vercelBaseURL, findZoneByFqdn = baseURL, findZone
+		server.Close()
+	}
+}
+
+func newFakeVercel() (*fakeVercel, func()) {
+	fake := &fakeVercel{records: map[string]dnsRecord{}}
+	return fake, mockVercel(fake)
+}
+
+func TestNewDNSProviderValid(t *testing.T) {
+	os.Setenv("VERCEL_API_TOKEN", "")
+	defer restoreVercelEnv()
+	_, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	os.Setenv("VERCEL_API_TOKEN", "secret")
+	os.Setenv("VERCEL_TEAM_ID", "team_1")
+	defer restoreVercelEnv()
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", provider.token)
+	assert.Equal(t, "team_1", provider.teamID)
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	os.Setenv("VERCEL_API_TOKEN", "")
+	defer restoreVercelEnv()
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "Vercel credentials missing")
+}
+
+func TestVercelPresent(t *testing.T) {
+	var requestReceived bool
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockVercel(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestReceived = true
+
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/v2/domains/example.com/records", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+		reqBody, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"name":"_acme-challenge.www","type":"TXT","value":"`+value+`","ttl":60}`, string(reqBody))
+
+		fmt.Fprint(w, `{"uid":"rec_38OGpD4LQ4KqSSZF5JWqGtKy"}`)
+	}))()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.True(t, requestReceived, "Expected request to be received by mock backend")
+	assert.Equal(t, map[string]string{"_acme-challenge.www.example.com. " + value: "rec_38OGpD4LQ4KqSSZF5JWqGtKy"}, provider.ids)
+}
+
+func TestVercelCleanUp(t *testing.T) {
+	var requestReceived bool
+	_, value, _ := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockVercel(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestReceived = true
+
+		assert.Equal(t, "DELETE", r.Method)
+		assert.Equal(t, "/v2/domains/records/rec_38OGpD4LQ4KqSSZF5JWqGtKy", r.URL.Path)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+		fmt.Fprint(w, `{}`)
+	}))()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+	provider.ids["_acme-challenge.www.example.com. "+value] = "rec_38OGpD4LQ4KqSSZF5JWqGtKy"
+
+	err = provider.CleanUp("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.True(t, requestReceived, "Expected request to be received by mock backend")
+	assert.Empty(t, provider.ids)
+}
+
+func TestVercelSharedName(t *testing.T) {
+	fake, done := newFakeVercel()
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+
+	// Two challenges for the same name get their own records, and each
+	// CleanUp deletes its own by ID.
+	assert.NoError(t, provider.Present("www.example.com", "", "key1"))
+	assert.NoError(t, provider.Present("www.example.com", "", "key2"))
+	_, value2, _ := acme.DNS01Record("www.example.com", "key2")
+
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "key1"))
+	assert.Equal(t, map[string]dnsRecord{
+		"rec_2": {Name: "_acme-challenge.www", Type: "TXT", Value: value2, TTL: 60},
+	}, fake.records)
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "key2"))
+	assert.Empty(t, fake.records)
+
+	// Vercel cannot list the records by value, so unknown IDs are an error.
+	assert.EqualError(t, provider.CleanUp("www.example.com", "", "key1"), "Vercel: unknown record ID for '_acme-challenge.www.example.com.'")
+}
+
+func TestVercelApex(t *testing.T) {
+	fake, done := newFakeVercel()
+	defer done()
+
+	acme.DNS01RecordName = func(domain, fqdn string) string { return "example.com." }
+	defer func() { acme.DNS01RecordName = nil }()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+	assert.NoError(t, provider.SetTTL(300))
+
+	// Records at the apex of the domain have an empty name.
+	assert.NoError(t, provider.Present("example.com", "", "key"))
+	_, value, _ := acme.DNS01Record("example.com", "key")
+	assert.Equal(t, map[string]dnsRecord{
+		"rec_1": {Name: "", Type: "TXT", Value: value, TTL: 300},
+	}, fake.records)
+}
+
+func TestVercelTeamID(t *testing.T) {
+	fake, done := newFakeVercel()
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("secret")
+	assert.NoError(t, err)
+	provider.SetTeamID("team 1")
+
+	assert.NoError(t, provider.Present("example.com", "", "key"))
+	assert.NoError(t, provider.CleanUp("example.com", "", "key"))
+	assert.Equal(t, []string{
+		"POST /v2/domains/example.com/records?teamId=team+1",
+		"DELETE /v2/domains/records/rec_1?teamId=team+1",
+	}, fake.requests)
+}
+
+func TestVercelPresentFailed(t *testing.T) {
+	_, done := newFakeVercel()
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("wrong")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.com", "", "key"), "Vercel API call failed: HTTP 403: Not authorized")
+	assert.Empty(t, provider.ids)
+}
+
+func TestLiveVercelPresentAndCleanUp(t *testing.T) {
+	if !vercelLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+
+	err = provider.Present(vercelDomain, "", "123d==")
+	assert.NoError(t, err)
+
+	time.Sleep(time.Second * 1)
+
+	// The record can only be deleted by the provider which created it.
+	err = provider.CleanUp(vercelDomain, "", "123d==")
+	assert.NoError(t, err)
+}
@@ -57,11 +57,14 @@ func (c *DNSProvider) Present(domain, token, keyAuth string) error {
 	return nil
 }
 
-// CleanUp removes the TXT record matching the specified parameters.
+// CleanUp removes the TXT record matching the specified parameters. Other
+// records sharing the name, including the TXT records of concurrent
+// challenges, are left alone. A record which is already gone is not an
+// error.
 func (c *DNSProvider) CleanUp(domain, token, keyAuth string) error {
-	fqdn, _, _ := acme.DNS01Record(domain, keyAuth)
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
 
-	zoneDomain, records, err := c.findTxtRecords(domain, fqdn)
+	zoneDomain, records, err := c.findTxtRecords(domain, fqdn, value)
 	if err != nil {
 		return err
 	}
@@ -69,7 +72,7 @@ func (c *DNSProvider) CleanUp(domain, token, keyAuth string) error {
 	for _, rec := range records {
 		err := c.client.DeleteDNSRecord(zoneDomain, rec.RecordID)
 		if err != nil {
-			return err
+			return fmt.Errorf("Vultr API call failed: %v", err)
 		}
 	}
 	return nil
@@ -96,7 +99,9 @@ func (c *DNSProvider) getHostedZone(domain string) (string, error) {
 	return hostedDomain.Domain, nil
 }
 
-func (c *DNSProvider) findTxtRecords(domain, fqdn string) (string, []vultr.DNSRecord, error) {
+// findTxtRecords returns the zone of domain and the TXT records at fqdn
+// containing value.
+func (c *DNSProvider) findTxtRecords(domain, fqdn, value string) (string, []vultr.DNSRecord, error) {
 	zoneDomain, err := c.getHostedZone(domain)
 	if err != nil {
 		return "", nil, err
@@ -110,7 +115,7 @@ func (c *DNSProvider) findTxtRecords(domain, fqdn string) (string, []vultr.DNSRe
 
 	recordName := c.extractRecordName(fqdn, zoneDomain)
 	for _, record := range result {
-		if record.Type == "TXT" && record.Name == recordName {
+		if record.Type == "TXT" && record.Name == recordName && strings.Trim(record.Data, `"`) == value {
 			records = append(records, record)
 		}
 	}
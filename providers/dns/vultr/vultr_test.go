@@ -1,10 +1,18 @@
 package vultr
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	vultr "github.com/JamesClonk/vultr/lib"
+	"github.com/stangah/lego/acme"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -63,3 +71,63 @@ func TestLiveCleanUp(t *testing.T) {
 	err = provider.CleanUp(domain, "", "123d==")
 	assert.NoError(t, err)
 }
+
+// mockVultr serves the DNS endpoints of the Vultr API from records and
+// removes the records which are deleted.
+type mockVultr struct {
+	mu      sync.Mutex
+	records []vultr.DNSRecord
+	deleted []string
+}
+
+func (m *mockVultr) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/dns/list"):
+		fmt.Fprint(w, `[{"domain":"example.com","date_created":"2017-01-01 00:00:00"}]`)
+	case strings.HasSuffix(r.URL.Path, "/dns/records"):
+		json.NewEncoder(w).Encode(m.records)
+	case strings.HasSuffix(r.URL.Path, "/dns/delete_record"):
+		id := r.FormValue("RECORDID")
+		m.deleted = append(m.deleted, id)
+		var kept []vultr.DNSRecord
+		for _, rec := range m.records {
+			if fmt.Sprint(rec.RecordID) != id {
+				kept = append(kept, rec)
+			}
+		}
+		m.records = kept
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestCleanUpOnlyRemovesMatchingTXTRecord(t *testing.T) {
+	_, value, _ := acme.DNS01Record("example.com", "keyAuth")
+	_, otherValue, _ := acme.DNS01Record("example.com", "otherKeyAuth")
+
+	mock := &mockVultr{records: []vultr.DNSRecord{
+		{RecordID: 1, Type: "CNAME", Name: "_acme-challenge", Data: "validation.example.org"},
+		{RecordID: 2, Type: "TXT", Name: "_acme-challenge", Data: `"` + value + `"`},
+		{RecordID: 3, Type: "TXT", Name: "_acme-challenge", Data: `"` + otherValue + `"`},
+		{RecordID: 4, Type: "TXT", Name: "www", Data: `"` + value + `"`},
+	}}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	provider := &DNSProvider{
+		client: vultr.NewClient("123", &vultr.Options{Endpoint: server.URL + "/"}),
+	}
+
+	err := provider.CleanUp("example.com", "", "keyAuth")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2"}, mock.deleted)
+	assert.Len(t, mock.records, 3)
+
+	// The record is gone now, so cleaning up again does nothing.
+	err = provider.CleanUp("example.com", "", "keyAuth")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2"}, mock.deleted)
+}
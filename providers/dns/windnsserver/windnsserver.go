@@ -0,0 +1,172 @@
+// Package windnsserver implements a DNS provider for solving the DNS-01
+// challenge using a Windows DNS server managed over PowerShell remoting.
+package windnsserver
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/stangah/lego/acme"
+)
+
+// CommandRunner runs PowerShell scripts on the Windows DNS server.
+type CommandRunner interface {
+	// RunPowerShell runs script and returns its output and exit code. The
+	// error is only set if the script could not be run at all.
+	RunPowerShell(script string) (stdout, stderr string, exitCode int, err error)
+}
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that manages TXT records of a Windows DNS server with the DnsServer
+// PowerShell module.
+type DNSProvider struct {
+	runner CommandRunner
+	zone   string
+	ttl    int
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for a Windows DNS
+// server. The server and credentials must be passed in the environment
+// variables WINDNS_HOST, WINDNS_USERNAME and WINDNS_PASSWORD. WinRM over
+// HTTPS is used if WINDNS_HTTPS is true, WINDNS_INSECURE skips the
+// certificate check and WINDNS_PORT overrides the default WinRM port. The
+// zone is looked up in DNS unless it is set in WINDNS_ZONE.
+func NewDNSProvider() (*DNSProvider, error) {
+	https, _ := strconv.ParseBool(os.Getenv("WINDNS_HTTPS"))
+	insecure, _ := strconv.ParseBool(os.Getenv("WINDNS_INSECURE"))
+
+	var port int
+	if v := os.Getenv("WINDNS_PORT"); v != "" {
+		var err error
+		if port, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("Windows DNS port %q is not a number", v)
+		}
+	}
+
+	provider, err := NewDNSProviderCredentials(os.Getenv("WINDNS_HOST"), port,
+		os.Getenv("WINDNS_USERNAME"), os.Getenv("WINDNS_PASSWORD"), https, insecure)
+	if err != nil {
+		return nil, err
+	}
+	provider.SetZone(os.Getenv("WINDNS_ZONE"))
+	return provider, nil
+}
+
+// NewDNSProviderCredentials uses the supplied WinRM endpoint and credentials
+// to return a DNSProvider instance configured for a Windows DNS server. A
+// port of zero selects the WinRM default, 5985 for HTTP and 5986 for HTTPS.
+func NewDNSProviderCredentials(host string, port int, username, password string, https, insecure bool) (*DNSProvider, error) {
+	if host == "" || username == "" || password == "" {
+		return nil, fmt.Errorf("Windows DNS credentials missing")
+	}
+	if port == 0 {
+		port = 5985
+		if https {
+			port = 5986
+		}
+	}
+
+	runner, err := newWinRMRunner(host, port, username, password, https, insecure)
+	if err != nil {
+		return nil, fmt.Errorf("Windows DNS: could not create WinRM client: %v", err)
+	}
+	return NewDNSProviderRunner(runner), nil
+}
+
+// NewDNSProviderRunner returns a DNSProvider instance that runs its commands
+// with runner.
+func NewDNSProviderRunner(runner CommandRunner) *DNSProvider {
+	return &DNSProvider{runner: runner}
+}
+
+// SetZone sets the zone the TXT records are created in. An empty zone is
+// looked up in DNS for every challenge.
+func (d *DNSProvider) SetZone(zone string) {
+	d.zone = acme.UnFqdn(zone)
+}
+
+// SetTTL sets the TTL in seconds of the TXT records created by the provider.
+// A TTL of zero uses the default TTL of the zone.
+func (d *DNSProvider) SetTTL(ttl int) error {
+	if ttl < 0 {
+		return fmt.Errorf("Windows DNS TTL must not be negative, got %d", ttl)
+	}
+	d.ttl = ttl
+	return nil
+}
+
+// Present adds a TXT record for the challenge to the zone.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	zone, name, err := d.recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	script := fmt.Sprintf("Add-DnsServerResourceRecord -ZoneName %s -Name %s -Txt -DescriptiveText %s",
+		quote(zone), quote(name), quote(value))
+	if d.ttl > 0 {
+		script += fmt.Sprintf(" -TimeToLive (New-TimeSpan -Seconds %d)", d.ttl)
+	}
+	return d.run(script)
+}
+
+// CleanUp removes the TXT record for the challenge from the zone.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+
+	zone, name, err := d.recordLocation(fqdn)
+	if err != nil {
+		return err
+	}
+
+	script := fmt.Sprintf("Remove-DnsServerResourceRecord -ZoneName %s -Name %s -RRType Txt -RecordData %s -Force",
+		quote(zone), quote(name), quote(value))
+	return d.run(script)
+}
+
+// recordLocation returns the zone containing fqdn and the name of fqdn
+// relative to it, "@" for the apex of the zone.
+func (d *DNSProvider) recordLocation(fqdn string) (string, string, error) {
+	zone := d.zone
+	if zone == "" {
+		authZone, err := acme.FindZoneByFqdn(fqdn, acme.RecursiveNameservers)
+		if err != nil {
+			return "", "", fmt.Errorf("Could not determine zone for '%s': %v", fqdn, err)
+		}
+		zone = acme.UnFqdn(authZone)
+	}
+
+	name := strings.ToLower(acme.UnFqdn(fqdn))
+	zone = strings.ToLower(zone)
+	if name == zone {
+		return zone, "@", nil
+	}
+	if !strings.HasSuffix(name, "."+zone) {
+		return "", "", fmt.Errorf("Windows DNS: '%s' is not in zone '%s'", fqdn, zone)
+	}
+	return zone, strings.TrimSuffix(name, "."+zone), nil
+}
+
+// run runs the DnsServer command on the server. Errors of the command stop
+// the script, and any output on stderr or a non-zero exit code is reported
+// as a failure.
+func (d *DNSProvider) run(command string) error {
+	script := "$ErrorActionPreference = 'Stop'; " + command
+	_, stderr, exitCode, err := d.runner.RunPowerShell(script)
+	if err != nil {
+		return fmt.Errorf("Windows DNS command failed: %v", err)
+	}
+	if stderr = strings.TrimSpace(stderr); exitCode != 0 || stderr != "" {
+		return fmt.Errorf("Windows DNS command failed with exit code %d: %s", exitCode, stderr)
+	}
+	return nil
+}
+
+// quote returns s as a single-quoted PowerShell string literal.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
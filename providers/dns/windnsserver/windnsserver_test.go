@@ -0,0 +1,83 @@
+package windnsserver
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stangah/lego/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubRunner records the scripts it is asked to run and returns canned
+// results.
+type stubRunner struct {
+	scripts  []string
+	stderr   string
+	exitCode int
+	err      error
+}
+
+func (r *stubRunner) RunPowerShell(script string) (string, string, int, error) {
+	r.scripts = append(r.scripts, script)
+	return "", r.stderr, r.exitCode, r.err
+}
+
+func TestWinDNSPresentAndCleanUp(t *testing.T) {
+	runner := &stubRunner{}
+	provider := NewDNSProviderRunner(runner)
+	provider.SetZone("example.com.")
+	assert.NoError(t, provider.SetTTL(120))
+
+	_, value, _ := acme.DNS01Record("www.example.com", "key")
+
+	assert.NoError(t, provider.Present("www.example.com", "", "key"))
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "key"))
+
+	assert.Equal(t, []string{
+		"$ErrorActionPreference = 'Stop'; Add-DnsServerResourceRecord -ZoneName 'example.com' -Name '_acme-challenge.www' -Txt -DescriptiveText '" + value + "' -TimeToLive (New-TimeSpan -Seconds 120)",
+		"$ErrorActionPreference = 'Stop'; Remove-DnsServerResourceRecord -ZoneName 'example.com' -Name '_acme-challenge.www' -RRType Txt -RecordData '" + value + "' -Force",
+	}, runner.scripts)
+}
+
+func TestWinDNSRecordLocation(t *testing.T) {
+	provider := NewDNSProviderRunner(&stubRunner{})
+	provider.SetZone("_acme-challenge.Example.com")
+
+	zone, name, err := provider.recordLocation("_acme-challenge.example.com.")
+	assert.NoError(t, err)
+	assert.Equal(t, "_acme-challenge.example.com", zone)
+	assert.Equal(t, "@", name)
+
+	provider.SetZone("example.org")
+	_, _, err = provider.recordLocation("_acme-challenge.example.com.")
+	assert.EqualError(t, err, "Windows DNS: '_acme-challenge.example.com.' is not in zone 'example.org'")
+}
+
+func TestWinDNSCommandFailure(t *testing.T) {
+	runner := &stubRunner{
+		stderr:   "Add-DnsServerResourceRecord : Failed to find zone example.com on server DC01.\r\n",
+		exitCode: 1,
+	}
+	provider := NewDNSProviderRunner(runner)
+	provider.SetZone("example.com")
+
+	err := provider.Present("example.com", "", "key")
+	assert.EqualError(t, err, "Windows DNS command failed with exit code 1: Add-DnsServerResourceRecord : Failed to find zone example.com on server DC01.")
+
+	// Errors on stderr fail the command even if the exit code is zero.
+	runner.exitCode = 0
+	assert.Error(t, provider.CleanUp("example.com", "", "key"))
+
+	runner.stderr, runner.err = "", errors.New("connection refused")
+	assert.EqualError(t, provider.Present("example.com", "", "key"), "Windows DNS command failed: connection refused")
+}
+
+func TestWinDNSQuote(t *testing.T) {
+	assert.Equal(t, "'it''s'", quote("it's"))
+	assert.Equal(t, `'a''; Remove-Item x; '''`, quote(`a'; Remove-Item x; '`))
+}
+
+func TestNewDNSProviderCredentialsMissing(t *testing.T) {
+	_, err := NewDNSProviderCredentials("", 0, "user", "pass", false, false)
+	assert.EqualError(t, err, "Windows DNS credentials missing")
+}
@@ -0,0 +1,26 @@
+package windnsserver
+
+import (
+	"time"
+
+	"github.com/masterzen/winrm"
+)
+
+// winrmRunner runs PowerShell scripts on a remote host over WinRM.
+type winrmRunner struct {
+	client *winrm.Client
+}
+
+func newWinRMRunner(host string, port int, username, password string, https, insecure bool) (*winrmRunner, error) {
+	endpoint := winrm.NewEndpoint(host, port, https, insecure, nil, nil, nil, 60*time.Second)
+	client, err := winrm.NewClient(endpoint, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return &winrmRunner{client: client}, nil
+}
+
+// RunPowerShell implements CommandRunner.
+func (r *winrmRunner) RunPowerShell(script string) (string, string, int, error) {
+	return r.client.RunWithString(winrm.Powershell(script), "")
+}
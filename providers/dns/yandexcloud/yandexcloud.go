@@ -0,0 +1,334 @@
+// Package yandexcloud implements a DNS provider for solving the DNS-01
+// challenge using Yandex Cloud DNS. It does not support the legacy
+// Yandex PDD API.
+package yandexcloud
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stangah/lego/acme"
+)
+
+// Yandex Cloud DNS API reference: https://yandex.cloud/en/docs/dns/api-ref/
+// IAM token reference:            https://yandex.cloud/en/docs/iam/operations/iam-token/create-for-sa
+
+var (
+	// dnsBaseURL is the base URL of the Yandex Cloud DNS API.
+	dnsBaseURL = "https://dns.api.cloud.yandex.net/dns/v1"
+	// iamTokenURL is the URL at which service account keys are exchanged
+	// for IAM tokens.
+	iamTokenURL = "https://iam.api.cloud.yandex.net/iam/v1/tokens"
+)
+
+// iamTokenAudience is the audience of the JWTs exchanged for IAM tokens.
+const iamTokenAudience = "https://iam.api.cloud.yandex.net/iam/v1/tokens"
+
+// DNSProvider is an implementation of the acme.ChallengeProvider interface
+// that uses the Yandex Cloud DNS API to manage TXT records for a domain.
+type DNSProvider struct {
+	folderID string
+
+	// key is the service account key IAM tokens are requested with. It is
+	// nil if the provider was created with a fixed IAM token.
+	key         *serviceAccountKey
+	token       string
+	tokenExpiry time.Time
+	tokenMu     sync.Mutex
+}
+
+// serviceAccountKey is an authorized key of a service account, as created
+// by "yc iam key create".
+type serviceAccountKey struct {
+	ID               string `json:"id"`
+	ServiceAccountID string `json:"service_account_id"`
+	PrivateKey       string `json:"private_key"`
+
+	signer *rsa.PrivateKey
+}
+
+// recordSet is a record set of the DNS API.
+type recordSet struct {
+	Name string   `json:"name"`
+	Type string   `json:"type"`
+	TTL  string   `json:"ttl"`
+	Data []string `json:"data"`
+}
+
+// upsertRecordSetsRequest changes the record sets of a zone. Merges add
+// their data to the record set of the same name and type, and deletions
+// remove only their data from it.
+type upsertRecordSetsRequest struct {
+	Deletions []recordSet `json:"deletions,omitempty"`
+	Merges    []recordSet `json:"merges,omitempty"`
+}
+
+// dnsZone is a DNS zone of the DNS API.
+type dnsZone struct {
+	ID   string `json:"id"`
+	Zone string `json:"zone"`
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Yandex
+// Cloud DNS. The ID of the folder containing the zones must be passed in
+// the environment variable YANDEX_CLOUD_FOLDER_ID, and either an IAM
+// token in YANDEX_CLOUD_IAM_TOKEN or the path of an authorized key of a
+// service account in YANDEX_CLOUD_SERVICE_ACCOUNT_KEY_FILE.
+func NewDNSProvider() (*DNSProvider, error) {
+	folderID := os.Getenv("YANDEX_CLOUD_FOLDER_ID")
+	if file := os.Getenv("YANDEX_CLOUD_SERVICE_ACCOUNT_KEY_FILE"); file != "" {
+		key, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Yandex Cloud: could not read service account key: %v", err)
+		}
+		return NewDNSProviderServiceAccountKey(key, folderID)
+	}
+	return NewDNSProviderCredentials(os.Getenv("YANDEX_CLOUD_IAM_TOKEN"), folderID)
+}
+
+// NewDNSProviderCredentials uses the supplied IAM token to return a
+// DNSProvider instance configured for Yandex Cloud DNS. IAM tokens expire
+// after at most 12 hours, use NewDNSProviderServiceAccountKey for a
+// provider which renews them.
+func NewDNSProviderCredentials(iamToken, folderID string) (*DNSProvider, error) {
+	if iamToken == "" || folderID == "" {
+		return nil, fmt.Errorf("Yandex Cloud credentials missing")
+	}
+	return &DNSProvider{folderID: folderID, token: iamToken}, nil
+}
+
+// NewDNSProviderServiceAccountKey returns a DNSProvider instance
+// configured for Yandex Cloud DNS which requests IAM tokens with the
+// given authorized key of a service account, in the JSON format created
+// by "yc iam key create".
+func NewDNSProviderServiceAccountKey(key []byte, folderID string) (*DNSProvider, error) {
+	if len(key) == 0 || folderID == "" {
+		return nil, fmt.Errorf("Yandex Cloud credentials missing")
+	}
+
+	var saKey serviceAccountKey
+	if err := json.Unmarshal(key, &saKey); err != nil {
+		return nil, fmt.Errorf("Yandex Cloud: invalid service account key: %v", err)
+	}
+	if saKey.ID == "" || saKey.ServiceAccountID == "" {
+		return nil, fmt.Errorf("Yandex Cloud: service account key lacks its ID or the ID of the service account")
+	}
+	privateKey, err := acme.LoadPrivateKeyPEM([]byte(saKey.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("Yandex Cloud: invalid private key of service account key: %v", err)
+	}
+	signer, ok := privateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("Yandex Cloud: private key of service account key is not an RSA key")
+	}
+	saKey.signer = signer
+
+	return &DNSProvider{folderID: folderID, key: &saKey}, nil
+}
+
+// Present merges a TXT record with the challenge value into the record
+// set of the challenge name, keeping its other values.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	fqdn, value, ttl := acme.DNS01Record(domain, keyAuth)
+	zoneID, err := d.findZoneID(fqdn)
+	if err != nil {
+		return err
+	}
+
+	return d.upsertRecordSets(zoneID, upsertRecordSetsRequest{
+		Merges: []recordSet{{Name: fqdn, Type: "TXT", TTL: fmt.Sprint(ttl), Data: []string{value}}},
+	})
+}
+
+// CleanUp deletes the challenge value from the record set of the
+// challenge name, leaving its other values alone.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	fqdn, value, ttl := acme.DNS01Record(domain, keyAuth)
+	zoneID, err := d.findZoneID(fqdn)
+	if err != nil {
+		return err
+	}
+
+	return d.upsertRecordSets(zoneID, upsertRecordSetsRequest{
+		Deletions: []recordSet{{Name: fqdn, Type: "TXT", TTL: fmt.Sprint(ttl), Data: []string{value}}},
+	})
+}
+
+// findZoneID returns the ID of the most specific zone of the folder which
+// contains fqdn.
+func (d *DNSProvider) findZoneID(fqdn string) (string, error) {
+	var zoneID, zoneName string
+	query := url.Values{"folderId": {d.folderID}}
+	for {
+		var page struct {
+			DNSZones      []dnsZone `json:"dnsZones"`
+			NextPageToken string    `json:"nextPageToken"`
+		}
+		if err := d.doRequest("GET", "/zones?"+query.Encode(), nil, &page); err != nil {
+			return "", err
+		}
+		for _, zone := range page.DNSZones {
+			name := acme.ToFqdn(zone.Zone)
+			if (fqdn == name || strings.HasSuffix(fqdn, "."+name)) && len(name) > len(zoneName) {
+				zoneID, zoneName = zone.ID, name
+			}
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+
+	if zoneID == "" {
+		return "", fmt.Errorf("Yandex Cloud: no zone of folder %s contains %s", d.folderID, fqdn)
+	}
+	return zoneID, nil
+}
+
+// upsertRecordSets applies the changes of upsert to the record sets of
+// the zone with the given ID. It does not wait for the operation to
+// finish, the propagation check does.
+func (d *DNSProvider) upsertRecordSets(zoneID string, upsert upsertRecordSetsRequest) error {
+	var operation struct {
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := d.doRequest("POST", "/zones/"+zoneID+":upsertRecordSets", upsert, &operation); err != nil {
+		return err
+	}
+	if operation.Error != nil {
+		return fmt.Errorf("Yandex Cloud: updating the record sets failed: %s", operation.Error.Message)
+	}
+	return nil
+}
+
+// doRequest sends a request with the JSON encoding of body, if any, to the
+// DNS API and decodes the response into result.
+func (d *DNSProvider) doRequest(method, path string, body, result interface{}) error {
+	token, err := d.iamToken()
+	if err != nil {
+		return err
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, dnsBaseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", acme.UserAgentString())
+
+	return doJSON(req, result)
+}
+
+// doJSON sends req and decodes the response into result.
+func doJSON(req *http.Request, result interface{}) error {
+	resp, err := acme.DoWithRetry(req, nil)
+	if err != nil {
+		return fmt.Errorf("Yandex Cloud API call failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var errInfo struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&errInfo)
+		return fmt.Errorf("Yandex Cloud API call failed: HTTP %d: %s", resp.StatusCode, errInfo.Message)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("Yandex Cloud API call failed: could not decode response: %v", err)
+	}
+	return nil
+}
+
+// iamToken returns the IAM token to authenticate with. If the provider has
+// a service account key, it requests a new token when the current one
+// expires within 5 minutes.
+func (d *DNSProvider) iamToken() (string, error) {
+	if d.key == nil {
+		return d.token, nil
+	}
+
+	d.tokenMu.Lock()
+	defer d.tokenMu.Unlock()
+
+	if d.token != "" && time.Now().Add(5*time.Minute).Before(d.tokenExpiry) {
+		return d.token, nil
+	}
+
+	jwt, err := d.key.jwt(time.Now())
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(map[string]string{"jwt": jwt})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", iamTokenURL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", acme.UserAgentString())
+
+	var token struct {
+		IAMToken  string    `json:"iamToken"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+	if err := doJSON(req, &token); err != nil {
+		return "", err
+	}
+	d.token, d.tokenExpiry = token.IAMToken, token.ExpiresAt
+	return d.token, nil
+}
+
+// jwt returns a JWT, signed with PS256 by the key, which can be exchanged
+// for an IAM token of the service account within the next hour.
+func (k *serviceAccountKey) jwt(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"typ": "JWT", "alg": "PS256", "kid": k.ID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss": k.ServiceAccountID,
+		"aud": iamTokenAudience,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPSS(rand.Reader, k.signer, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
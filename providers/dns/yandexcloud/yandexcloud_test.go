@@ -0,0 +1,442 @@
+package yandexcloud
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stangah/lego/acme"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	yandexCloudLiveTest       bool
+	yandexCloudFolderID       string
+	yandexCloudIAMToken       string
+	yandexCloudServiceKeyFile string
+	yandexCloudDomain         string
+)
+
+func init() {
+	yandexCloudFolderID = os.Getenv("YANDEX_CLOUD_FOLDER_ID")
+	yandexCloudIAMToken = os.Getenv("YANDEX_CLOUD_IAM_TOKEN")
+	yandexCloudServiceKeyFile = os.Getenv("YANDEX_CLOUD_SERVICE_ACCOUNT_KEY_FILE")
+	yandexCloudDomain = os.Getenv("YANDEX_CLOUD_DOMAIN")
+	if len(yandexCloudFolderID) > 0 && (len(yandexCloudIAMToken) > 0 || len(yandexCloudServiceKeyFile) > 0) && len(yandexCloudDomain) > 0 {
+		yandexCloudLiveTest = true
+	}
+}
+
+func restoreYandexCloudEnv() {
+	os.Setenv("YANDEX_CLOUD_FOLDER_ID", yandexCloudFolderID)
+	os.Setenv("YANDEX_CLOUD_IAM_TOKEN", yandexCloudIAMToken)
+	os.Setenv("YANDEX_CLOUD_SERVICE_ACCOUNT_KEY_FILE", yandexCloudServiceKeyFile)
+}
+
+// fakeYandexCloud serves the zones example.com and sub.example.com of the
+// folder "folder" and their TXT record sets from memory. It hands out IAM
+// tokens for JWTs signed by key.
+type fakeYandexCloud struct {
+	key *rsa.PrivateKey
+
+	mu            sync.Mutex
+	records       map[string][]string
+	tokenRequests int
+}
+
+func (f *fakeYandexCloud) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Method == "POST" && r.URL.Path == "/iam/v1/tokens" {
+		f.tokenRequests++
+		var body struct {
+			JWT string `json:"jwt"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if err := f.verifyJWT(body.JWT); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, `{"code":16,"message":%q}`, err.Error())
+			return
+		}
+		fmt.Fprintf(w, `{"iamToken":"iam-token","expiresAt":%q}`, time.Now().Add(12*time.Hour).Format(time.RFC3339))
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer iam-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"code":16,"message":"The token is invalid"}`)
+		return
+	}
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/dns/v1/zones" && r.URL.Query().Get("folderId") == "folder":
+		if r.URL.Query().Get("pageToken") == "" {
+			fmt.Fprint(w, `{"dnsZones":[{"id":"zone1","folderId":"folder","zone":"example.com."}],"nextPageToken":"page2"}`)
+		} else {
+			fmt.Fprint(w, `{"dnsZones":[{"id":"zone2","folderId":"folder","zone":"sub.example.com."}]}`)
+		}
+	case r.Method == "POST" && strings.HasSuffix(r.URL.Path, ":upsertRecordSets"):
+		zoneID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/dns/v1/zones/"), ":upsertRecordSets")
+		var req upsertRecordSetsRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		for _, set := range req.Merges {
+			key := zoneID + " " + set.Name + " " + set.Type
+			for _, value := range set.Data {
+				if !contains(f.records[key], value) {
+					f.records[key] = append(f.records[key], value)
+				}
+			}
+		}
+		for _, set := range req.Deletions {
+			key := zoneID + " " + set.Name + " " + set.Type
+			var kept []string
+			for _, value := range f.records[key] {
+				if !contains(set.Data, value) {
+					kept = append(kept, value)
+				}
+			}
+			if kept == nil {
+				delete(f.records, key)
+			} else {
+				f.records[key] = kept
+			}
+		}
+		fmt.Fprint(w, `{"id":"op1","done":false}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"code":5,"message":"Not found"}`)
+	}
+}
+
+func (f *fakeYandexCloud) verifyJWT(jwt string) error {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed JWT")
+	}
+	var header, claims map[string]interface{}
+	for i, v := range []*map[string]interface{}{&header, &claims} {
+		data, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			return err
+		}
+	}
+	if header["alg"] != "PS256" || header["kid"] != "key-id" {
+		return fmt.Errorf("unexpected header %v", header)
+	}
+	if claims["iss"] != "service-account-id" || claims["aud"] != iamTokenAudience {
+		return fmt.Errorf("unexpected claims %v", claims)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	return rsa.VerifyPSS(&f.key.PublicKey, crypto.SHA256, digest[:], signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// mockYandexCloud serves the DNS and IAM APIs of Yandex Cloud with handler
+// until the returned function is called.
+func mockYandexCloud(handler http.Handler) func() {
+	server := httptest.NewServer(handler)
+	baseURL, tokenURL := dnsBaseURL, iamTokenURL
+	dnsBaseURL, iamTokenURL = server.URL+"/dns/v1", server.URL+"/iam/v1/tokens"
+	return func() {
+		dnsBaseURL, iamTokenURL = baseURL, tokenURL
+		server.Close()
+	}
+}
+
+func newFakeYandexCloud(t *testing.T) (*fakeYandexCloud, func()) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeYandexCloud{key: key, records: make(map[string][]string)}
+	return fake, mockYandexCloud(fake)
+}
+
+// serviceAccountKeyJSON returns the authorized key of the service account
+// in the format of "yc iam key create".
+func serviceAccountKeyJSON(t *testing.T, key *rsa.PrivateKey) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey := "PLEASE DO NOT REMOVE THIS LINE! Yandex.Cloud SA Key ID <key-id>\n" +
+		string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	data, _ := json.Marshal(map[string]string{
+		"id":                 "key-id",
+		"service_account_id": "service-account-id",
+		"key_algorithm":      "RSA_2048",
+		"private_key":        privateKey,
+	})
+	return data
+}
+
+func TestNewDNSProviderValid(t *testing.T) {
+	os.Setenv("YANDEX_CLOUD_FOLDER_ID", "")
+	os.Setenv("YANDEX_CLOUD_IAM_TOKEN", "")
+	os.Setenv("YANDEX_CLOUD_SERVICE_ACCOUNT_KEY_FILE", "")
+	defer restoreYandexCloudEnv()
+	_, err := NewDNSProviderCredentials("iam-token", "folder")
+	assert.NoError(t, err)
+}
+
+func TestNewDNSProviderValidEnv(t *testing.T) {
+	defer restoreYandexCloudEnv()
+
+	os.Setenv("YANDEX_CLOUD_FOLDER_ID", "folder")
+	os.Setenv("YANDEX_CLOUD_IAM_TOKEN", "iam-token")
+	os.Setenv("YANDEX_CLOUD_SERVICE_ACCOUNT_KEY_FILE", "")
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Equal(t, "iam-token", provider.token)
+	assert.Equal(t, "folder", provider.folderID)
+
+	// A service account key file takes precedence over an IAM token.
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.NoError(t, err)
+	file, err := ioutil.TempFile("", "yandexcloud")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	file.Write(serviceAccountKeyJSON(t, key))
+	file.Close()
+
+	os.Setenv("YANDEX_CLOUD_SERVICE_ACCOUNT_KEY_FILE", file.Name())
+	provider, err = NewDNSProvider()
+	assert.NoError(t, err)
+	assert.Empty(t, provider.token)
+	if assert.NotNil(t, provider.key) {
+		assert.Equal(t, "key-id", provider.key.ID)
+		assert.Equal(t, "service-account-id", provider.key.ServiceAccountID)
+	}
+}
+
+func TestNewDNSProviderMissingCredErr(t *testing.T) {
+	os.Setenv("YANDEX_CLOUD_FOLDER_ID", "folder")
+	os.Setenv("YANDEX_CLOUD_IAM_TOKEN", "")
+	os.Setenv("YANDEX_CLOUD_SERVICE_ACCOUNT_KEY_FILE", "")
+	defer restoreYandexCloudEnv()
+	_, err := NewDNSProvider()
+	assert.EqualError(t, err, "Yandex Cloud credentials missing")
+}
+
+func TestNewDNSProviderInvalidServiceAccountKey(t *testing.T) {
+	_, err := NewDNSProviderServiceAccountKey([]byte(`{"id":"key-id","service_account_id":"sa","private_key":"none"}`), "folder")
+	assert.Error(t, err)
+
+	_, err = NewDNSProviderServiceAccountKey([]byte(`{"service_account_id":"sa"}`), "folder")
+	assert.EqualError(t, err, "Yandex Cloud: service account key lacks its ID or the ID of the service account")
+}
+
+func TestYandexCloudPresent(t *testing.T) {
+	var requests []string
+	_, value, ttl := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockYandexCloud(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.String())
+		assert.Equal(t, "Bearer asdf1234", r.Header.Get("Authorization"))
+
+		if r.Method == "GET" {
+			fmt.Fprint(w, `{"dnsZones":[{"id":"zone1","folderId":"folder","zone":"example.com."}]}`)
+			return
+		}
+
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		reqBody, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf(`{"merges":[{"name":"_acme-challenge.www.example.com.","type":"TXT","ttl":"%d","data":["%s"]}]}`, ttl, value), string(reqBody))
+
+		fmt.Fprint(w, `{"id":"op1","done":false}`)
+	}))()
+
+	provider, err := NewDNSProviderCredentials("asdf1234", "folder")
+	assert.NoError(t, err)
+
+	err = provider.Present("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"GET /dns/v1/zones?folderId=folder",
+		"POST /dns/v1/zones/zone1:upsertRecordSets",
+	}, requests)
+}
+
+func TestYandexCloudCleanUp(t *testing.T) {
+	var requests []string
+	_, value, ttl := acme.DNS01Record("www.example.com", "foobar")
+
+	defer mockYandexCloud(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.String())
+		assert.Equal(t, "Bearer asdf1234", r.Header.Get("Authorization"))
+
+		if r.Method == "GET" {
+			fmt.Fprint(w, `{"dnsZones":[{"id":"zone1","folderId":"folder","zone":"example.com."}]}`)
+			return
+		}
+
+		// Only the challenge value is deleted from the record set.
+		reqBody, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf(`{"deletions":[{"name":"_acme-challenge.www.example.com.","type":"TXT","ttl":"%d","data":["%s"]}]}`, ttl, value), string(reqBody))
+
+		fmt.Fprint(w, `{"id":"op1","done":false}`)
+	}))()
+
+	provider, err := NewDNSProviderCredentials("asdf1234", "folder")
+	assert.NoError(t, err)
+
+	err = provider.CleanUp("www.example.com", "", "foobar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"GET /dns/v1/zones?folderId=folder",
+		"POST /dns/v1/zones/zone1:upsertRecordSets",
+	}, requests)
+}
+
+func TestYandexCloudSharedName(t *testing.T) {
+	fake, done := newFakeYandexCloud(t)
+	defer done()
+
+	fake.records["zone1 _acme-challenge.www.example.com. TXT"] = []string{"other"}
+
+	provider, err := NewDNSProviderCredentials("iam-token", "folder")
+	assert.NoError(t, err)
+
+	_, value1, _ := acme.DNS01Record("www.example.com", "key1")
+	_, value2, _ := acme.DNS01Record("www.example.com", "key2")
+	assert.NoError(t, provider.Present("www.example.com", "", "key1"))
+	assert.NoError(t, provider.Present("www.example.com", "", "key2"))
+	assert.Equal(t, map[string][]string{
+		"zone1 _acme-challenge.www.example.com. TXT": {"other", value1, value2},
+	}, fake.records)
+
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "key1"))
+	assert.Equal(t, map[string][]string{
+		"zone1 _acme-challenge.www.example.com. TXT": {"other", value2},
+	}, fake.records)
+
+	// A record set is removed along with its last value.
+	assert.NoError(t, provider.Present("example.com", "", "key1"))
+	assert.Contains(t, fake.records, "zone1 _acme-challenge.example.com. TXT")
+	assert.NoError(t, provider.CleanUp("example.com", "", "key1"))
+	assert.NotContains(t, fake.records, "zone1 _acme-challenge.example.com. TXT")
+}
+
+func TestYandexCloudZonePagination(t *testing.T) {
+	fake, done := newFakeYandexCloud(t)
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("iam-token", "folder")
+	assert.NoError(t, err)
+
+	// The most specific zone is on the second page of zones.
+	_, value, _ := acme.DNS01Record("www.sub.example.com", "key")
+	assert.NoError(t, provider.Present("www.sub.example.com", "", "key"))
+	assert.Equal(t, map[string][]string{
+		"zone2 _acme-challenge.www.sub.example.com. TXT": {value},
+	}, fake.records)
+}
+
+func TestYandexCloudServiceAccountKey(t *testing.T) {
+	fake, done := newFakeYandexCloud(t)
+	defer done()
+
+	provider, err := NewDNSProviderServiceAccountKey(serviceAccountKeyJSON(t, fake.key), "folder")
+	assert.NoError(t, err)
+
+	assert.NoError(t, provider.Present("www.example.com", "", "key"))
+	assert.NoError(t, provider.CleanUp("www.example.com", "", "key"))
+	assert.Equal(t, 1, fake.tokenRequests, "Expected the IAM token to be reused")
+
+	// A token which is about to expire is renewed.
+	provider.tokenExpiry = time.Now().Add(time.Minute)
+	assert.NoError(t, provider.Present("www.example.com", "", "key"))
+	assert.Equal(t, 2, fake.tokenRequests)
+
+	other, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.NoError(t, err)
+	provider, err = NewDNSProviderServiceAccountKey(serviceAccountKeyJSON(t, other), "folder")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("www.example.com", "", "key"), "Yandex Cloud API call failed: HTTP 401: crypto/rsa: verification error")
+}
+
+func TestYandexCloudOperationFailed(t *testing.T) {
+	defer mockYandexCloud(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			fmt.Fprint(w, `{"dnsZones":[{"id":"zone1","folderId":"folder","zone":"example.com."}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"op1","done":true,"error":{"code":3,"message":"Invalid record set"}}`)
+	}))()
+
+	provider, err := NewDNSProviderCredentials("iam-token", "folder")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.com", "", "key"), "Yandex Cloud: updating the record sets failed: Invalid record set")
+}
+
+func TestYandexCloudPresentFailed(t *testing.T) {
+	_, done := newFakeYandexCloud(t)
+	defer done()
+
+	provider, err := NewDNSProviderCredentials("wrong", "folder")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.com", "", "key"), "Yandex Cloud API call failed: HTTP 401: The token is invalid")
+
+	provider, err = NewDNSProviderCredentials("iam-token", "folder")
+	assert.NoError(t, err)
+	assert.EqualError(t, provider.Present("example.org", "", "key"), "Yandex Cloud: no zone of folder folder contains _acme-challenge.example.org.")
+}
+
+func TestLiveYandexCloudPresent(t *testing.T) {
+	if !yandexCloudLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+
+	err = provider.Present(yandexCloudDomain, "", "123d==")
+	assert.NoError(t, err)
+}
+
+func TestLiveYandexCloudCleanUp(t *testing.T) {
+	if !yandexCloudLiveTest {
+		t.Skip("skipping live test")
+	}
+
+	time.Sleep(time.Second * 1)
+
+	provider, err := NewDNSProvider()
+	assert.NoError(t, err)
+
+	err = provider.CleanUp(yandexCloudDomain, "", "123d==")
+	assert.NoError(t, err)
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/rainycape/memcache"
 	"github.com/stretchr/testify/assert"
 	"github.com/stangah/lego/acme"
+	"github.com/stangah/lego/providers/http/store"
 )
 
 var (
@@ -109,3 +110,28 @@ func TestMemcachedCleanup(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NoError(t, p.CleanUp(domain, token, keyAuth))
 }
+
+func TestNewMemcachedStoreEmpty(t *testing.T) {
+	_, err := NewMemcachedStore(nil)
+	assert.EqualError(t, err, "No memcached hosts provided")
+}
+
+func TestMemcachedStore(t *testing.T) {
+	if len(memcachedHosts) == 0 {
+		t.Skip("Skipping memcached tests")
+	}
+	s, err := NewMemcachedStore(memcachedHosts)
+	assert.NoError(t, err)
+
+	challengePath := path.Join("/", acme.HTTP01ChallengePath(token))
+
+	_, err = s.Get(challengePath)
+	assert.Equal(t, store.ErrNotFound, err)
+	assert.NoError(t, s.Set(challengePath, keyAuth))
+	value, err := s.Get(challengePath)
+	assert.NoError(t, err)
+	assert.Equal(t, keyAuth, value)
+	assert.NoError(t, s.Delete(challengePath))
+	_, err = s.Get(challengePath)
+	assert.Equal(t, store.ErrNotFound, err)
+}
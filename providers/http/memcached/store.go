@@ -0,0 +1,95 @@
+package memcached
+
+import (
+	"fmt"
+
+	"github.com/rainycape/memcache"
+	"github.com/stangah/lego/providers/http/store"
+)
+
+// MemcachedStore implements store.ChallengeStore by publishing the key
+// authorizations to all of the given memcached hosts.
+type MemcachedStore struct {
+	hosts []string
+}
+
+// NewMemcachedStore returns a MemcachedStore instance for the given hosts.
+func NewMemcachedStore(hosts []string) (*MemcachedStore, error) {
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("No memcached hosts provided")
+	}
+	return &MemcachedStore{hosts: hosts}, nil
+}
+
+// Set stores keyAuth for path on every host. It only fails if none of the
+// hosts could store it.
+func (s *MemcachedStore) Set(path, keyAuth string) error {
+	var errs []error
+	for _, host := range s.hosts {
+		mc, err := memcache.New(host)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		err = mc.Set(&memcache.Item{
+			Key:        path,
+			Value:      []byte(keyAuth),
+			Expiration: 60,
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == len(s.hosts) {
+		return fmt.Errorf("Unable to store key in any of the memcache hosts -> %v", errs)
+	}
+	return nil
+}
+
+// Get returns the key authorization stored for path on the first host which
+// has it.
+func (s *MemcachedStore) Get(path string) (string, error) {
+	var errs []error
+	for _, host := range s.hosts {
+		mc, err := memcache.New(host)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		item, err := mc.Get(path)
+		if err == memcache.ErrCacheMiss {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return string(item.Value), nil
+	}
+
+	if len(errs) == len(s.hosts) {
+		return "", fmt.Errorf("Unable to get key from any of the memcache hosts -> %v", errs)
+	}
+	return "", store.ErrNotFound
+}
+
+// Delete removes the key authorization stored for path from every host.
+func (s *MemcachedStore) Delete(path string) error {
+	var errs []error
+	for _, host := range s.hosts {
+		mc, err := memcache.New(host)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := mc.Delete(path); err != nil && err != memcache.ErrCacheMiss {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == len(s.hosts) {
+		return fmt.Errorf("Unable to delete key from any of the memcache hosts -> %v", errs)
+	}
+	return nil
+}
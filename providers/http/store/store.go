@@ -0,0 +1,137 @@
+// Package store implements a HTTP provider for solving the HTTP-01 challenge
+// by publishing the key authorizations into a ChallengeStore, from which the
+// web servers answering the validation requests read them. This allows the
+// validation to hit any node of a load balanced cluster.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/stangah/lego/acme"
+)
+
+// ErrNotFound is returned by ChallengeStore.Get if no key authorization is
+// stored for a path.
+var ErrNotFound = errors.New("No key authorization stored for this path")
+
+// ChallengeStore is a key-value store shared by the web servers of a cluster.
+// Keys are the URL paths of the challenges, e.g.
+// "/.well-known/acme-challenge/<token>", values the key authorizations.
+type ChallengeStore interface {
+	// Set stores keyAuth for path, replacing any previous value.
+	Set(path, keyAuth string) error
+	// Get returns the key authorization stored for path, or ErrNotFound.
+	Get(path string) (string, error)
+	// Delete removes the key authorization stored for path. Deleting a
+	// path which is not stored is not an error.
+	Delete(path string) error
+}
+
+// MemoryStore is a ChallengeStore which keeps the key authorizations in
+// memory. It is only shared by web servers running in the same process.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	keyAuths map[string]string
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keyAuths: make(map[string]string)}
+}
+
+// Set stores keyAuth for path.
+func (s *MemoryStore) Set(path, keyAuth string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keyAuths[path] = keyAuth
+	return nil
+}
+
+// Get returns the key authorization stored for path.
+func (s *MemoryStore) Get(path string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keyAuth, ok := s.keyAuths[path]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return keyAuth, nil
+}
+
+// Delete removes the key authorization stored for path.
+func (s *MemoryStore) Delete(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keyAuths, path)
+	return nil
+}
+
+// HTTPProvider implements ChallengeProvider for `http-01` challenge
+type HTTPProvider struct {
+	store ChallengeStore
+}
+
+// NewHTTPProvider returns a HTTPProvider instance publishing into store. If
+// store is nil, a new MemoryStore is used.
+func NewHTTPProvider(store ChallengeStore) *HTTPProvider {
+	if store == nil {
+		store = NewMemoryStore()
+	}
+	return &HTTPProvider{store: store}
+}
+
+// Store returns the ChallengeStore the provider publishes into.
+func (p *HTTPProvider) Store() ChallengeStore {
+	return p.store
+}
+
+// Present makes the token available at `HTTP01ChallengePath(token)` by storing the key authorization
+func (p *HTTPProvider) Present(domain, token, keyAuth string) error {
+	if err := p.store.Set(acme.HTTP01ChallengePath(token), keyAuth); err != nil {
+		return fmt.Errorf("Could not store key authorization for HTTP challenge -> %v", err)
+	}
+	return nil
+}
+
+// CleanUp removes the key authorization stored for the challenge
+func (p *HTTPProvider) CleanUp(domain, token, keyAuth string) error {
+	if err := p.store.Delete(acme.HTTP01ChallengePath(token)); err != nil {
+		return fmt.Errorf("Could not remove key authorization after HTTP challenge -> %v", err)
+	}
+	return nil
+}
+
+// NewHandler returns a http.Handler serving the key authorizations in store
+// below "/.well-known/acme-challenge/", for web servers written in Go.
+func NewHandler(store ChallengeStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, acme.HTTP01ChallengePath("")) {
+			http.NotFound(w, r)
+			return
+		}
+
+		keyAuth, err := store.Get(r.URL.Path)
+		if err == ErrNotFound {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		if r.Method == http.MethodGet {
+			w.Write([]byte(keyAuth))
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stangah/lego/acme"
+)
+
+// testChallengeStore checks that s fulfills the ChallengeStore contract.
+func testChallengeStore(t *testing.T, s ChallengeStore) {
+	path := acme.HTTP01ChallengePath("token")
+
+	if _, err := s.Get(path); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a missing path but got %v", err)
+	}
+	if err := s.Delete(path); err != nil {
+		t.Errorf("Expected deleting a missing path to succeed but got %v", err)
+	}
+
+	for _, keyAuth := range []string{"keyAuth1", "keyAuth2"} {
+		if err := s.Set(path, keyAuth); err != nil {
+			t.Fatalf("Could not set key authorization: %v", err)
+		}
+		got, err := s.Get(path)
+		if err != nil {
+			t.Fatalf("Could not get key authorization: %v", err)
+		}
+		if got != keyAuth {
+			t.Errorf("Expected key authorization %q but got %q", keyAuth, got)
+		}
+	}
+
+	if err := s.Delete(path); err != nil {
+		t.Errorf("Could not delete key authorization: %v", err)
+	}
+	if _, err := s.Get(path); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound after Delete but got %v", err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testChallengeStore(t, NewMemoryStore())
+}
+
+func TestHTTPProvider(t *testing.T) {
+	provider := NewHTTPProvider(nil)
+	handler := NewHandler(provider.Store())
+	path := acme.HTTP01ChallengePath("token")
+
+	get := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	if w := get(http.MethodGet, path); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d before Present but got %d", http.StatusNotFound, w.Code)
+	}
+
+	if err := provider.Present("example.com", "token", "keyAuth"); err != nil {
+		t.Fatalf("Could not present challenge: %v", err)
+	}
+	w := get(http.MethodGet, path)
+	body, _ := ioutil.ReadAll(w.Body)
+	if w.Code != http.StatusOK || string(body) != "keyAuth" {
+		t.Errorf("Expected status %d and body %q but got %d and %q", http.StatusOK, "keyAuth", w.Code, body)
+	}
+	if w := get(http.MethodHead, path); w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("Expected status %d and no body for HEAD but got %d and %q", http.StatusOK, w.Code, w.Body)
+	}
+	if w := get(http.MethodPost, path); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d for POST but got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+	if w := get(http.MethodGet, "/token"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d outside the challenge path but got %d", http.StatusNotFound, w.Code)
+	}
+
+	if err := provider.CleanUp("example.com", "token", "keyAuth"); err != nil {
+		t.Fatalf("Could not clean up challenge: %v", err)
+	}
+	if w := get(http.MethodGet, path); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d after CleanUp but got %d", http.StatusNotFound, w.Code)
+	}
+}